@@ -0,0 +1,91 @@
+package Handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// 複数シーズンの上位1000位のランキング
+// キーはシーズン番号
+type BatchResponse struct {
+	Seasons map[string]BatchSeason `json:"seasons"`
+}
+
+// シーズンごとの結果
+// 取得に失敗したシーズンはErrorにその理由を入れる
+type BatchSeason struct {
+	SeasonData *SeasonData           `json:"season_data,omitempty"`
+	Top1000    []RankResponseRawData `json:"top_1000,omitempty"`
+	Error      string                `json:"error,omitempty"`
+}
+
+// 複数シーズンのランキングを同時に取得する数
+const batchFetchConcurrency = 4
+
+// endpoint handler
+func BatchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	seasons, err := parseSeasonNumbers(r.URL.Query().Get("seasons"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid seasons parameter: %v", err))
+		return
+	}
+	if len(seasons) > MaxBulkSeasons {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Too many seasons: at most %d can be requested at once", MaxBulkSeasons))
+		return
+	}
+
+	maxAge := cacheTTL("/rankings/batch")
+	// シーズンごとに最低1件は上流へのリクエストが必要なため、その分を上限に足す
+	budget := newRetryBudget(RetryBudget + len(seasons))
+	seasonList, _, err := cachedSeasonList(r.Context(), defaultSoft, maxAge, budget)
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), fmt.Sprintf("Error fetching ranking data: %v", err))
+		return
+	}
+
+	results := make([]BatchSeason, len(seasons))
+	sem := make(chan struct{}, batchFetchConcurrency)
+	var wg sync.WaitGroup
+	for i, seasonNumber := range seasons {
+		wg.Add(1)
+		go func(i, seasonNumber int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			seasonData, err := findSeasonData(seasonList.Seasons, seasonNumber, nil, "")
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			seasonData.Soft = defaultSoft
+			rankingData, _, err := cachedSeasonRanking(r.Context(), seasonData, maxAge, budget)
+			if err != nil {
+				results[i].Error = fmt.Sprintf("Error fetching top 1000 ranking data: %v", err)
+				return
+			}
+			rankingData, _ = convertEmptyNames(rankingData, EmptyNameMode)
+			results[i] = BatchSeason{SeasonData: &seasonData, Top1000: rankingData}
+		}(i, seasonNumber)
+	}
+	wg.Wait()
+
+	response := BatchResponse{Seasons: make(map[string]BatchSeason, len(seasons))}
+	for i, seasonNumber := range seasons {
+		response.Seasons[strconv.Itoa(seasonNumber)] = results[i]
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
@@ -0,0 +1,98 @@
+package Handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 一部のシーズンの取得に失敗しても、取得できたシーズンとシーズンごとのエラーを返す
+func TestBatchHandler(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantOK     []string
+		wantErrors []string
+	}{
+		{target: "/rankings/batch?seasons=1,2", wantStatus: http.StatusOK, wantOK: []string{"1", "2"}},
+		{target: "/rankings/batch?seasons=2,3", wantStatus: http.StatusOK, wantOK: []string{"2"}, wantErrors: []string{"3"}},
+		{target: "/rankings/batch?seasons=1,99", wantStatus: http.StatusOK, wantOK: []string{"1"}, wantErrors: []string{"99"}},
+		{target: "/rankings/batch?seasons=1,1", wantStatus: http.StatusOK, wantOK: []string{"1"}},
+		{target: "/rankings/batch?seasons=x", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/batch", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			addPastSeason(upstream, 2, "10002", 5)
+			// シーズンリストにはあるがランキングファイルがない
+			upstream.addSeason("3", fixtureSeason(3, "10003", 0, time.Now()))
+
+			rec := get(t, BatchHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response BatchResponse
+			decodeBody(t, rec, &response)
+			if len(response.Seasons) != len(tt.wantOK)+len(tt.wantErrors) {
+				t.Errorf("got %d seasons, want %d", len(response.Seasons), len(tt.wantOK)+len(tt.wantErrors))
+			}
+			for _, key := range tt.wantOK {
+				season := response.Seasons[key]
+				if season.Error != "" || season.SeasonData == nil || season.SeasonData.Season != atoiOrZero(key) || len(season.Top1000) != 1000 {
+					t.Errorf("season %s = error %q with %d rows, want 1000 rows", key, season.Error, len(season.Top1000))
+				}
+			}
+			for _, key := range tt.wantErrors {
+				if season := response.Seasons[key]; season.Error == "" || season.Top1000 != nil {
+					t.Errorf("season %s has %d rows and error %q, want only an error", key, len(season.Top1000), season.Error)
+				}
+			}
+		})
+	}
+}
+
+// 上流へのランキングファイルの取得はbatchFetchConcurrencyまでしか同時に行わない
+func TestBatchHandlerConcurrency(t *testing.T) {
+	saved := MaxBulkSeasons
+	t.Cleanup(func() { MaxBulkSeasons = saved })
+	MaxBulkSeasons = 8
+
+	upstream := newFakeUpstream(t)
+	seasons := []string{"1"}
+	for season := 2; season <= 8; season++ {
+		addPastSeason(upstream, season, fmt.Sprintf("1000%d", season), 0)
+		seasons = append(seasons, fmt.Sprint(season))
+	}
+	started, release := upstream.holdRankings()
+	t.Cleanup(release)
+
+	done := make(chan int, 1)
+	go func() {
+		done <- get(t, BatchHandler, "/rankings/batch?seasons="+strings.Join(seasons, ",")).Code
+	}()
+	for i := 0; i < batchFetchConcurrency; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d ranking requests started, want %d", i, batchFetchConcurrency)
+		}
+	}
+	select {
+	case <-started:
+		t.Fatalf("more than %d ranking requests in flight", batchFetchConcurrency)
+	case <-time.After(100 * time.Millisecond):
+	}
+	release()
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	if n := upstream.rankingCalls.Load(); n != 8 {
+		t.Errorf("ranking calls = %d, want 8", n)
+	}
+}
@@ -0,0 +1,304 @@
+package Handler
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// キャッシュの有効期間のデフォルト
+var DefaultCacheTTL = envDuration("CACHE_TTL", 5*time.Minute)
+
+// エンドポイントごとのキャッシュの有効期間
+// CACHE_TTLS="/seasons=1h,/rankings=5m" のように指定し、指定がなければDefaultCacheTTLを使う
+var EndpointCacheTTLs = parseEndpointTTLs(os.Getenv("CACHE_TTLS"))
+
+func parseEndpointTTLs(v string) map[string]time.Duration {
+	ttls := map[string]time.Duration{}
+	if v == "" {
+		return ttls
+	}
+	for _, pair := range strings.Split(v, ",") {
+		endpoint, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Printf("invalid CACHE_TTLS entry %q", pair)
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("invalid CACHE_TTLS entry %q: %v", pair, err)
+			continue
+		}
+		ttls[endpoint] = d
+	}
+	return ttls
+}
+
+// エンドポイントのキャッシュの有効期間
+func cacheTTL(endpoint string) time.Duration {
+	if ttl, ok := EndpointCacheTTLs[endpoint]; ok {
+		return ttl
+	}
+	return DefaultCacheTTL
+}
+
+// キャッシュに保持するエントリ数の上限
+// 超えた場合は最も長く使われていないものから破棄する
+var CacheMaxEntries = envInt("CACHE_MAX_ENTRIES", 64)
+
+type cacheEntry struct {
+	key       string
+	value     interface{}
+	fetchedAt time.Time
+}
+
+// 取得日時付きのキャッシュ
+// 有効期間は読み出す側が指定するため、エンドポイントごとに異なる期間で同じデータを共有できる
+type ttlCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	// 最近使ったものが先頭
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newTTLCache(maxEntries int) *ttlCache {
+	return &ttlCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    map[string]*list.Element{},
+	}
+}
+
+func (c *ttlCache) get(key string, maxAge time.Duration, now time.Time) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(elem)
+	entry := elem.Value.(cacheEntry)
+	if now.Sub(entry.fetchedAt) >= maxAge {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// 有効期間を過ぎていても保持していれば返す
+func (c *ttlCache) getStale(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(cacheEntry), true
+}
+
+func (c *ttlCache) set(key string, value interface{}, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := cacheEntry{key: key, value: value, fetchedAt: now}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cacheEntry).key)
+	}
+}
+
+// trueなら上流からランキングデータを取得できなかった場合に、有効期間を過ぎたキャッシュがあればそれを返す
+var CacheStaleIfError = os.Getenv("CACHE_STALE_IF_ERROR") == "true"
+
+var (
+	seasonListCache  = newTTLCache(CacheMaxEntries)
+	rankingDataCache = newTTLCache(CacheMaxEntries)
+)
+
+// 同じキーの取得が同時に走った場合に上流へのリクエストを1回にまとめる
+// バックグラウンドでの取得し直しも同じキーを使うため、その間にキャッシュが切れたリクエストも同じ取得を待つ
+var (
+	seasonListFlight  singleflight.Group
+	rankingDataFlight singleflight.Group
+	rankingPageFlight singleflight.Group
+)
+
+// まとめた取得1回あたりのタイムアウト
+var SharedFetchTimeout = envDuration("UPSTREAM_SHARED_FETCH_TIMEOUT", time.Minute)
+
+// 取得中のものがあればその結果を待ち、なければ取得を始める
+// 取得は呼び出し元がキャンセルしても他の呼び出しに影響しないように、キャンセルを引き継がずSharedFetchTimeoutで打ち切るctxで行う
+// 呼び出し元は自分のctxがキャンセルされたら待つのをやめる
+func sharedFetch(ctx context.Context, group *singleflight.Group, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	ch := group.DoChan(key, func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), SharedFetchTimeout)
+		defer cancel()
+		return fn(fetchCtx)
+	})
+	select {
+	case result := <-ch:
+		return result.Val, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 上流からソフトのシーズンリストを取得し直してキャッシュする
+// 上流へのリクエストは取得を始めた呼び出しのbudgetで数える
+func refreshSeasonList(ctx context.Context, soft string, budget *retryBudget) (*SeasonList, error) {
+	value, err := sharedFetch(ctx, &seasonListFlight, soft, func(ctx context.Context) (interface{}, error) {
+		seasonList, err := DefaultClient.fetchRankingData(ctx, soft, budget)
+		seasonListHealth.record(err, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		seasonListCache.set(soft, seasonList, time.Now())
+		return seasonList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*SeasonList), nil
+}
+
+// キャッシュを使ってソフトのシーズンリストを取得
+func cachedSeasonList(ctx context.Context, soft string, maxAge time.Duration, budget *retryBudget) (*SeasonList, bool, error) {
+	entry, ok := seasonListCache.get(soft, maxAge, time.Now())
+	observeCache(upstreamCallSeasonList, ok)
+	if ok {
+		return entry.value.(*SeasonList), true, nil
+	}
+	seasonList, err := refreshSeasonList(ctx, soft, budget)
+	if err != nil {
+		return nil, false, err
+	}
+	return seasonList, false, nil
+}
+
+// キャッシュから取得したか
+// versionは上流から取得し直すたびに変わる
+type cacheInfo struct {
+	hit     bool
+	version string
+	// 上流から取得したときのレスポンスヘッダー
+	source UpstreamSource
+	// 上流から取得できず、有効期間を過ぎたキャッシュを返した
+	stale     bool
+	fetchedAt time.Time
+}
+
+// キャッシュするランキングデータ
+type cachedRanking struct {
+	rows   []RankResponseRawData
+	source UpstreamSource
+}
+
+// ランキングデータのキャッシュのキー
+func seasonRankingKey(seasonData SeasonData) string {
+	return fmt.Sprintf("%s/%s/%d/%d/%d", softOrDefault(seasonData.Soft), seasonData.CID, seasonData.Rst, seasonData.Ts1, seasonData.Ts2)
+}
+
+// 上流から指定シーズンの上位1000位のランキングデータを取得し直してキャッシュする
+// 上流へのリクエストは取得を始めた呼び出しのbudgetで数える
+func refreshSeasonRanking(ctx context.Context, seasonData SeasonData, budget *retryBudget) (cacheEntry, error) {
+	key := seasonRankingKey(seasonData)
+	value, err := sharedFetch(ctx, &rankingDataFlight, key, func(ctx context.Context) (interface{}, error) {
+		rankingData, source, err := DefaultClient.fetchSeasonRanking(ctx, seasonData, budget)
+		if err != nil {
+			return nil, err
+		}
+		entry := cacheEntry{key: key, value: cachedRanking{rows: rankingData, source: source}, fetchedAt: time.Now()}
+		rankingDataCache.set(key, entry.value, entry.fetchedAt)
+		return entry, nil
+	})
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	return value.(cacheEntry), nil
+}
+
+// キャッシュを使って指定シーズンの上位1000位のランキングデータを取得
+// 呼び出し側で並べ替えなどをしてもキャッシュが壊れないようにコピーを返す
+func cachedSeasonRanking(ctx context.Context, seasonData SeasonData, maxAge time.Duration, budget *retryBudget) ([]RankResponseRawData, cacheInfo, error) {
+	key := seasonRankingKey(seasonData)
+	entry, ok := rankingDataCache.get(key, maxAge, time.Now())
+	observeCache(upstreamCallRanking, ok)
+	if ok {
+		cached := entry.value.(cachedRanking)
+		info := cacheInfo{hit: true, version: fmt.Sprintf("%s@%d", key, entry.fetchedAt.UnixNano()), source: cached.source, fetchedAt: entry.fetchedAt}
+		return append([]RankResponseRawData(nil), cached.rows...), info, nil
+	}
+	entry, err := refreshSeasonRanking(ctx, seasonData, budget)
+	if err != nil {
+		if !CacheStaleIfError {
+			return nil, cacheInfo{}, err
+		}
+		entry, ok := rankingDataCache.getStale(key)
+		if !ok {
+			return nil, cacheInfo{}, err
+		}
+		log.Printf("serving stale ranking data for %s: %v", key, err)
+		cached := entry.value.(cachedRanking)
+		// エンコード済みのレスポンスを新しいデータのものと分けるため版を変える
+		info := cacheInfo{hit: true, stale: true, version: fmt.Sprintf("%s@%d/stale", key, entry.fetchedAt.UnixNano()), source: cached.source, fetchedAt: entry.fetchedAt}
+		return append([]RankResponseRawData(nil), cached.rows...), info, nil
+	}
+	cached := entry.value.(cachedRanking)
+	info := cacheInfo{version: fmt.Sprintf("%s@%d", key, entry.fetchedAt.UnixNano()), source: cached.source, fetchedAt: entry.fetchedAt}
+	return append([]RankResponseRawData(nil), cached.rows...), info, nil
+}
+
+// キャッシュを取得し直す間隔で、0以下なら取得し直さない
+// キャッシュの有効期間より短くすれば、現在のシーズンのリクエストは上流からの取得を待たずに済む
+var CacheRefreshInterval = envDuration("CACHE_REFRESH_INTERVAL", 0)
+
+// 一定間隔で現在のシーズンのシーズンリストとランキングデータを上流から取得し直してキャッシュする
+type CacheRefresher struct {
+	Interval time.Duration
+}
+
+// ctxがキャンセルされるまで、起動時とInterval経過ごとに取得し直す
+func (c *CacheRefresher) Run(ctx context.Context) {
+	c.refresh(ctx, time.Now())
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.refresh(ctx, now)
+		}
+	}
+}
+
+func (c *CacheRefresher) refresh(ctx context.Context, now time.Time) {
+	seasonList, err := refreshSeasonList(ctx, defaultSoft, newRetryBudget(RetryBudget))
+	if err != nil {
+		log.Printf("cache refresh failed: %v", err)
+		return
+	}
+	seasonData, err := getLatestSeasonData(seasonList.Seasons, defaultSoft, nil, "", now)
+	if err != nil {
+		log.Printf("cache refresh failed: %v", err)
+		return
+	}
+	seasonData.Soft = defaultSoft
+	if _, err := refreshSeasonRanking(ctx, seasonData, newRetryBudget(RetryBudget)); err != nil {
+		log.Printf("cache refresh failed: %v", err)
+	}
+}
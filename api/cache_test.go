@@ -0,0 +1,383 @@
+package Handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// バックグラウンドでの取得し直しの最中にキャッシュが切れたリクエストは、同じ取得を待つ
+func TestForegroundRequestJoinsBackgroundRefresh(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	started, release := upstream.holdRankings()
+	defer release()
+
+	refresher := &CacheRefresher{Interval: time.Minute}
+	refreshed := make(chan struct{})
+	go func() {
+		refresher.refresh(context.Background(), time.Now())
+		close(refreshed)
+	}()
+	<-started
+
+	done := make(chan int)
+	go func() {
+		done <- get(t, RankingHandler, "/rankings").Code
+	}()
+	// リクエストが取得を待ち始めるまで待ってから上流を返す
+	time.Sleep(50 * time.Millisecond)
+	release()
+
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+	<-refreshed
+	if n := upstream.rankingCalls.Load(); n != 1 {
+		t.Errorf("ranking calls = %d, want 1", n)
+	}
+	if n := upstream.seasonListCalls.Load(); n != 1 {
+		t.Errorf("season list calls = %d, want 1", n)
+	}
+}
+
+// 待っていたリクエストの1つがキャンセルしても、他のリクエストは取得の結果を受け取る
+func TestSharedFetchIgnoresWaiterCancel(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	started, release := upstream.holdRankings()
+	defer release()
+
+	season := upstream.seasons["1"]["10001"]
+	season.Soft = defaultSoft
+
+	canceled, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, _, err := cachedSeasonRanking(canceled, season, time.Minute, newRetryBudget(RetryBudget))
+		first <- err
+	}()
+	<-started
+
+	second := make(chan error)
+	go func() {
+		_, _, err := cachedSeasonRanking(context.Background(), season, time.Minute, newRetryBudget(RetryBudget))
+		second <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("canceled caller err = %v, want %v", err, context.Canceled)
+	}
+	release()
+	if err := <-second; err != nil {
+		t.Fatalf("other caller err = %v, want nil", err)
+	}
+	if n := upstream.rankingCalls.Load(); n != 1 {
+		t.Errorf("ranking calls = %d, want 1", n)
+	}
+}
+
+func TestCachedSeasonListWithinTTL(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	budget := newRetryBudget(RetryBudget)
+
+	tests := []struct {
+		name       string
+		maxAge     time.Duration
+		wantCached bool
+		wantCalls  int32
+	}{
+		{name: "miss", maxAge: time.Minute, wantCached: false, wantCalls: 1},
+		{name: "hit within ttl", maxAge: time.Minute, wantCached: true, wantCalls: 1},
+		{name: "expired", maxAge: 0, wantCached: false, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cached, err := cachedSeasonList(context.Background(), defaultSoft, tt.maxAge, budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cached != tt.wantCached {
+				t.Errorf("cached = %v, want %v", cached, tt.wantCached)
+			}
+			if n := upstream.seasonListCalls.Load(); n != tt.wantCalls {
+				t.Errorf("season list calls = %d, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// キャッシュが無いときに同時に来たリクエストは、上流へのリクエストを1回にまとめる
+func TestConcurrentRankingRequestsShareUpstreamCalls(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	started, release := upstream.holdRankings()
+	defer release()
+
+	const n = 50
+	codes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			codes <- get(t, RankingHandler, "/rankings").Code
+		}()
+	}
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	release()
+	for i := 0; i < n; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("status = %d, want %d", code, http.StatusOK)
+		}
+	}
+	if got := upstream.seasonListCalls.Load(); got != 1 {
+		t.Errorf("season list calls = %d, want 1", got)
+	}
+	if got := upstream.rankingCalls.Load(); got != 1 {
+		t.Errorf("ranking calls = %d, want 1", got)
+	}
+}
+
+// 同時に同じ2ページ目を取得する場合は上流へのリクエストを1回にまとめる
+func TestSharedRankingPage(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	season := upstream.seasons["1"]["10001"]
+	upstream.setPage(season, 2, fixtureRows(1001, 500))
+	started, release := upstream.holdRankings()
+	defer release()
+
+	const n = 10
+	results := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			rows, err := DefaultClient.sharedRankingPage(context.Background(), season, "1700000000", 2, newRetryBudget(RetryBudget))
+			if err == nil && len(rows) != 500 {
+				t.Errorf("rows = %d, want 500", len(rows))
+			}
+			results <- err
+		}()
+	}
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	release()
+	for i := 0; i < n; i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := upstream.rankingCalls.Load(); got != 1 {
+		t.Errorf("ranking calls = %d, want 1", got)
+	}
+}
+
+// 有効期間内の2回目のリクエストは上流にリクエストしない
+func TestRankingHandlerWithinTTL(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	ttls := EndpointCacheTTLs
+	t.Cleanup(func() { EndpointCacheTTLs = ttls })
+
+	tests := []struct {
+		name             string
+		ttl              time.Duration
+		wantSeasonCalls  int32
+		wantRankingCalls int32
+	}{
+		{name: "miss", ttl: time.Minute, wantSeasonCalls: 1, wantRankingCalls: 1},
+		{name: "hit within ttl", ttl: time.Minute, wantSeasonCalls: 1, wantRankingCalls: 1},
+		{name: "expired", ttl: 0, wantSeasonCalls: 2, wantRankingCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			EndpointCacheTTLs = map[string]time.Duration{"/rankings": tt.ttl}
+			rec := get(t, RankingHandler, "/rankings")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			var ranking RankingResponse
+			decodeBody(t, rec, &ranking)
+			if len(ranking.Top1000) != 1000 {
+				t.Errorf("top_1000 = %d rows, want 1000", len(ranking.Top1000))
+			}
+			if n := upstream.seasonListCalls.Load(); n != tt.wantSeasonCalls {
+				t.Errorf("season list calls = %d, want %d", n, tt.wantSeasonCalls)
+			}
+			if n := upstream.rankingCalls.Load(); n != tt.wantRankingCalls {
+				t.Errorf("ranking calls = %d, want %d", n, tt.wantRankingCalls)
+			}
+		})
+	}
+}
+
+func TestParseEndpointTTLs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]time.Duration
+	}{
+		{value: "", want: map[string]time.Duration{}},
+		{value: "/seasons=1h,/rankings=5m", want: map[string]time.Duration{"/seasons": time.Hour, "/rankings": 5 * time.Minute}},
+		{value: " /seasons=1h , /rankings/stats=30s", want: map[string]time.Duration{"/seasons": time.Hour, "/rankings/stats": 30 * time.Second}},
+		// 読めない指定は飛ばす
+		{value: "/seasons,/rankings=x,/icon=1h", want: map[string]time.Duration{"/icon": time.Hour}},
+	}
+	for _, tt := range tests {
+		got := parseEndpointTTLs(tt.value)
+		if len(got) != len(tt.want) {
+			t.Errorf("parseEndpointTTLs(%q) = %v, want %v", tt.value, got, tt.want)
+			continue
+		}
+		for endpoint, ttl := range tt.want {
+			if got[endpoint] != ttl {
+				t.Errorf("parseEndpointTTLs(%q)[%s] = %v, want %v", tt.value, endpoint, got[endpoint], ttl)
+			}
+		}
+	}
+}
+
+// 同じキャッシュを使うエンドポイントでも、それぞれの有効期間で取得し直す
+func TestEndpointCacheTTLs(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	ttls := EndpointCacheTTLs
+	t.Cleanup(func() { EndpointCacheTTLs = ttls })
+	EndpointCacheTTLs = map[string]time.Duration{"/rankings": 0, "/rankings/stats": time.Hour}
+
+	if got := cacheTTL("/rankings/cutoff"); got != DefaultCacheTTL {
+		t.Errorf("ttl of an unconfigured endpoint = %v, want the default %v", got, DefaultCacheTTL)
+	}
+
+	tests := []struct {
+		handler      http.HandlerFunc
+		target       string
+		wantRankings int32
+	}{
+		{handler: StatsHandler, target: "/rankings/stats", wantRankings: 1},
+		{handler: StatsHandler, target: "/rankings/stats", wantRankings: 1},
+		{handler: RankingHandler, target: "/rankings", wantRankings: 2},
+		{handler: RankingHandler, target: "/rankings", wantRankings: 3},
+		{handler: StatsHandler, target: "/rankings/stats", wantRankings: 3},
+	}
+	for i, tt := range tests {
+		if rec := get(t, tt.handler, tt.target); rec.Code != http.StatusOK {
+			t.Fatalf("request %d %s: status = %d, want %d", i, tt.target, rec.Code, http.StatusOK)
+		}
+		if n := upstream.rankingCalls.Load(); n != tt.wantRankings {
+			t.Errorf("request %d %s: ranking calls = %d, want %d", i, tt.target, n, tt.wantRankings)
+		}
+	}
+}
+
+// 上限を超えたら最も長く使われていないものから破棄する
+func TestTTLCacheEviction(t *testing.T) {
+	now := time.Now()
+	cache := newTTLCache(3)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.set(key, key, now)
+	}
+	// 読み出したaは最近使ったものになる
+	if _, ok := cache.get("a", time.Minute, now); !ok {
+		t.Fatal("a is not cached")
+	}
+	cache.set("d", "d", now)
+	cache.set("e", "e", now)
+
+	tests := []struct {
+		key      string
+		wantKept bool
+	}{
+		{key: "a", wantKept: true},
+		{key: "b"},
+		{key: "c"},
+		{key: "d", wantKept: true},
+		{key: "e", wantKept: true},
+	}
+	for _, tt := range tests {
+		if _, ok := cache.getStale(tt.key); ok != tt.wantKept {
+			t.Errorf("%s cached = %v, want %v", tt.key, ok, tt.wantKept)
+		}
+	}
+	if n := cache.order.Len(); n != 3 || len(cache.entries) != 3 {
+		t.Errorf("entries = %d (order %d), want 3", len(cache.entries), n)
+	}
+}
+
+// 同時に書き込んでも上限を超えない
+func TestTTLCacheConcurrentEviction(t *testing.T) {
+	now := time.Now()
+	cache := newTTLCache(8)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("%d-%d", i, j)
+				cache.set(key, j, now)
+				cache.get(key, time.Minute, now)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := cache.order.Len(); n != 8 || len(cache.entries) != 8 {
+		t.Errorf("entries = %d (order %d), want 8", len(cache.entries), n)
+	}
+}
+
+// 上流から取得できなければ、有効期間を過ぎたキャッシュをSTALEとして返す
+func TestRankingStaleIfError(t *testing.T) {
+	tests := []struct {
+		name         string
+		staleIfError bool
+		prime        bool
+		wantStatus   int
+	}{
+		{name: "stale", staleIfError: true, prime: true, wantStatus: http.StatusOK},
+		{name: "nothing cached", staleIfError: true, wantStatus: http.StatusBadGateway},
+		{name: "disabled", prime: true, wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			withoutRetryDelay(t)
+			captureLog(t)
+			saved, ttls := CacheStaleIfError, EndpointCacheTTLs
+			t.Cleanup(func() { CacheStaleIfError, EndpointCacheTTLs = saved, ttls })
+			CacheStaleIfError = tt.staleIfError
+			// 毎回上流から取得し直す
+			EndpointCacheTTLs = map[string]time.Duration{"/rankings": 0}
+
+			if tt.prime {
+				rec := get(t, RankingHandler, "/rankings")
+				if rec.Code != http.StatusOK {
+					t.Fatalf("priming status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+				}
+				if got := rec.Header().Get("X-Cache"); got == "STALE" {
+					t.Errorf("fresh response X-Cache = %q", got)
+				}
+			}
+			upstream.failPage(upstream.seasons["1"]["10001"], 1, http.StatusInternalServerError)
+
+			rec := get(t, RankingHandler, "/rankings")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if got := rec.Header().Get("X-Cache"); got != "" {
+					t.Errorf("X-Cache = %q, want none", got)
+				}
+				return
+			}
+			if got := rec.Header().Get("X-Cache"); got != "STALE" {
+				t.Errorf("X-Cache = %q, want STALE", got)
+			}
+			if age, err := strconv.Atoi(rec.Header().Get("Age")); err != nil || age < 0 {
+				t.Errorf("Age = %q, want a non-negative number of seconds", rec.Header().Get("Age"))
+			}
+			var ranking RankingResponse
+			decodeBody(t, rec, &ranking)
+			if !ranking.Stale || ranking.AsOf == nil || len(ranking.Top1000) != 1000 {
+				t.Errorf("got stale = %v as_of = %v with %d rows, want the 1000 cached rows marked stale", ranking.Stale, ranking.AsOf, len(ranking.Top1000))
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package Handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// iCalendarのテキストの特殊文字をエスケープ
+func escapeICSText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// シーズンの期間をiCalendarのVEVENTにする
+func buildSeasonCalendar(seasonData SeasonData, now time.Time) (string, error) {
+	start, err := parseSeasonTime(seasonData.Start)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse start time: %v", err)
+	}
+	end, err := parseSeasonTime(seasonData.End)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse end time: %v", err)
+	}
+	// 日本時間の日時をUTCで出力する
+	const layout = "20060102T150405Z"
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//go-rank-battle-tracker//season//JA",
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:%s@go-rank-battle-tracker", seasonData.CID),
+		"DTSTAMP:" + now.UTC().Format(layout),
+		"DTSTART:" + start.UTC().Format(layout),
+		"DTEND:" + end.UTC().Format(layout),
+		"SUMMARY:" + escapeICSText(seasonData.Name),
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}
+	return strings.Join(lines, "\r\n") + "\r\n", nil
+}
+
+// endpoint handler
+func SeasonCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := rankingQuery{maxAge: cacheTTL("/season/current.ics"), budget: newRetryBudget(RetryBudget)}
+	seasonData, err := selectLatestSeason(r.Context(), query, &fetchStatus{})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	calendar, err := buildSeasonCalendar(seasonData, time.Now())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Error building calendar: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="current.ics"`)
+	fmt.Fprint(w, calendar)
+}
@@ -0,0 +1,101 @@
+package Handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// iCalendarの行を項目名ごとの値にする
+func parseICS(t *testing.T, body string) map[string]string {
+	t.Helper()
+	if !strings.HasSuffix(body, "\r\n") {
+		t.Fatalf("calendar does not end with CRLF: %q", body)
+	}
+	properties := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			t.Fatalf("invalid line %q", line)
+		}
+		if name == "BEGIN" || name == "END" {
+			name += ":" + value
+		}
+		properties[name] = value
+	}
+	return properties
+}
+
+func TestSeasonCalendarHandler(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	season := upstream.seasons["1"]["10001"]
+	season.Name = "シーズン1, ランクマッチ; 前期"
+	season.Start = "2024/05/01 09:00"
+	season.End = time.Now().Add(24 * time.Hour).In(jst).Format(fixtureTimeLayout)
+	upstream.addSeason("1", season)
+
+	rec := get(t, SeasonCalendarHandler, "/season/current.ics")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/calendar; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/calendar", got)
+	}
+
+	properties := parseICS(t, rec.Body.String())
+	end, err := parseSeasonTime(season.End)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "BEGIN:VCALENDAR", want: "VCALENDAR"},
+		{name: "BEGIN:VEVENT", want: "VEVENT"},
+		{name: "END:VEVENT", want: "VEVENT"},
+		{name: "END:VCALENDAR", want: "VCALENDAR"},
+		{name: "VERSION", want: "2.0"},
+		{name: "UID", want: "10001@go-rank-battle-tracker"},
+		// 日本時間の9時はUTCの0時
+		{name: "DTSTART", want: "20240501T000000Z"},
+		{name: "DTEND", want: end.UTC().Format("20060102T150405Z")},
+		{name: "SUMMARY", want: `シーズン1\, ランクマッチ\; 前期`},
+	}
+	for _, tt := range tests {
+		got, ok := properties[tt.name]
+		if !ok {
+			t.Errorf("%s is missing", tt.name)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := time.Parse("20060102T150405Z", properties["DTSTAMP"]); err != nil {
+		t.Errorf("DTSTAMP = %q: %v", properties["DTSTAMP"], err)
+	}
+}
+
+func TestBuildSeasonCalendarInvalidTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		end   string
+	}{
+		{name: "start", start: "soon", end: "2024/06/01 09:00"},
+		{name: "end", start: "2024/05/01 09:00", end: ""},
+	}
+	for _, tt := range tests {
+		if _, err := buildSeasonCalendar(SeasonData{Start: tt.start, End: tt.end}, time.Now()); err == nil {
+			t.Errorf("%s: built a calendar from an invalid time", tt.name)
+		}
+	}
+}
+
+func TestEscapeICSText(t *testing.T) {
+	if got, want := escapeICSText("a\\b;c,d\ne"), `a\\b\;c\,d\ne`; got != want {
+		t.Errorf("escapeICSText = %q, want %q", got, want)
+	}
+}
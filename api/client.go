@@ -0,0 +1,60 @@
+package Handler
+
+import (
+	"context"
+	"time"
+)
+
+// 上流からシーズンリストとランキングデータを取得するクライアント
+// サーバーを立てずにCLIや定期実行の処理からも使える
+type Client struct {
+	// 上流へのリクエストに使うDoer
+	HTTPClient Doer
+	// シーズンリストを取得するAPIのホスト
+	APIBaseURL string
+	// ランキングファイルを取得するリソースのホスト
+	ResourceBaseURL string
+	// 取得するソフト。空ならdefaultSoft
+	Soft string
+}
+
+// 環境変数の設定を使うクライアント
+func NewClient() *Client {
+	return &Client{
+		HTTPClient:      HTTPClient,
+		APIBaseURL:      APIBaseURL,
+		ResourceBaseURL: ResourceBaseURL,
+		Soft:            defaultSoft,
+	}
+}
+
+// ハンドラーが上流からの取得に使うクライアント
+var DefaultClient = NewClient()
+
+// シーズンリストを取得
+func (c *Client) FetchSeasons(ctx context.Context) (*SeasonList, error) {
+	return c.fetchRankingData(ctx, softOrDefault(c.Soft), nil)
+}
+
+// 指定したランキングファイルの1000位までのランキングデータを取得
+// レートは表示用の値に変換し、アイコンはURLにして返す
+func (c *Client) FetchTop1000(ctx context.Context, cId string, rst int, ts1 string) ([]RankResponseRawData, error) {
+	rankingData, _, err := c.fetchTop1000RankingData(ctx, softOrDefault(c.Soft), cId, rst, ts1, 0, nil)
+	return rankingData, err
+}
+
+// 開催中のシーズンを取得
+// 複数のルールのシーズンが開催中の場合はシングルを返す
+func (c *Client) LatestSeason(ctx context.Context) (SeasonData, error) {
+	seasonList, err := c.FetchSeasons(ctx)
+	if err != nil {
+		return SeasonData{}, err
+	}
+	soft := softOrDefault(c.Soft)
+	seasonData, err := getLatestSeasonData(seasonList.Seasons, soft, nil, "", time.Now())
+	if err != nil {
+		return SeasonData{}, err
+	}
+	seasonData.Soft = soft
+	return seasonData, nil
+}
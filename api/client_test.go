@@ -0,0 +1,166 @@
+package Handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewClient(t *testing.T) {
+	client := NewClient()
+	if client.APIBaseURL != APIBaseURL || client.ResourceBaseURL != ResourceBaseURL {
+		t.Errorf("hosts = %q, %q, want %q, %q", client.APIBaseURL, client.ResourceBaseURL, APIBaseURL, ResourceBaseURL)
+	}
+	if client.Soft != defaultSoft {
+		t.Errorf("soft = %q, want %q", client.Soft, defaultSoft)
+	}
+}
+
+// シーズンリストはAPIのホストから、ランキングファイルとアイコンはリソースのホストから取得する
+func TestClientSeparateHosts(t *testing.T) {
+	api := newFakeUpstream(t)
+	resource := newFakeUpstream(t)
+	// APIのホストにはランキングファイルを置かない
+	api.pages = map[string][]RankResponseRawData{}
+	DefaultClient = &Client{HTTPClient: http.DefaultClient, APIBaseURL: api.URL, ResourceBaseURL: resource.URL, Soft: defaultSoft}
+
+	rec, ranking := getRanking(t, "/rankings")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := [4]int32{api.seasonListCalls.Load(), api.rankingCalls.Load(), resource.seasonListCalls.Load(), resource.rankingCalls.Load()}; got != [4]int32{1, 0, 0, 1} {
+		t.Errorf("calls (api season list, api ranking, resource season list, resource ranking) = %v, want [1 0 0 1]", got)
+	}
+	if icon := ranking.Top1000[0].Icon; !strings.HasPrefix(icon, resource.URL+"/") {
+		t.Errorf("icon = %q, want a URL on %s", icon, resource.URL)
+	}
+}
+
+// 取得中にキャンセルされたら上流の応答を待たずにctx.Err()を包んだエラーを返す
+func TestClientCancel(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	started, release := upstream.holdRankings()
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := DefaultClient.FetchTop1000(ctx, "10001", 0, "1700000000")
+		done <- err
+	}()
+	<-started
+	cancel()
+	// 上流はまだ応答していない
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+
+	// キャンセル済みならリトライもしない
+	if _, err := DefaultClient.FetchSeasons(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if n := upstream.seasonListCalls.Load(); n > 1 {
+		t.Errorf("season list calls = %d, want at most 1", n)
+	}
+}
+
+// 既定のクライアントはDefaultTimeoutで打ち切る
+func TestDefaultHTTPClientTimeout(t *testing.T) {
+	client, ok := HTTPClient.(*http.Client)
+	if !ok {
+		t.Fatalf("HTTPClient is %T, want *http.Client", HTTPClient)
+	}
+	if client.Timeout != DefaultTimeout || DefaultTimeout <= 0 {
+		t.Errorf("timeout = %v, want DefaultTimeout (%v)", client.Timeout, DefaultTimeout)
+	}
+}
+
+// 上流の応答がタイムアウトしたら504を返す
+func TestRankingUpstreamTimeout(t *testing.T) {
+	withoutRetryDelay(t)
+	upstream := newFakeUpstream(t)
+	_, release := upstream.holdRankings()
+	defer release()
+	DefaultClient = &Client{HTTPClient: &http.Client{Timeout: 50 * time.Millisecond}, APIBaseURL: upstream.URL, ResourceBaseURL: upstream.URL, Soft: defaultSoft}
+
+	rec, _ := getRanking(t, "/rankings")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusGatewayTimeout, rec.Body)
+	}
+	if n := upstream.rankingCalls.Load(); n != int32(MaxAttempts) {
+		t.Errorf("ranking calls = %d, want %d", n, MaxAttempts)
+	}
+}
+
+func TestRankingErrorStatusTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "deadline", err: fmt.Errorf("failed to fetch: %w", context.DeadlineExceeded), want: http.StatusGatewayTimeout},
+		{name: "net timeout", err: &url.Error{Op: "Get", URL: "http://example.com", Err: timeoutError{}}, want: http.StatusGatewayTimeout},
+		{name: "cancelled", err: fmt.Errorf("request cancelled: %w", context.Canceled), want: http.StatusInternalServerError},
+		{name: "other", err: errors.New("boom"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := rankingErrorStatus(tt.err); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Timeoutがtrueのnet.Error
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// ハンドラーのキャッシュを通さずに、Clientだけでシーズンとランキングを取得できる
+func TestClientLibrary(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	client := &Client{HTTPClient: upstream.Client(), APIBaseURL: upstream.URL, ResourceBaseURL: upstream.URL}
+	ctx := context.Background()
+
+	seasonList, err := client.FetchSeasons(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seasonList.Seasons["1"]["10001"]; got.Season != 1 || got.Participants == nil {
+		t.Errorf("season list entry = %+v, want season 1 with participants", got)
+	}
+
+	seasonData, err := client.LatestSeason(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seasonData.CID != "10001" || seasonData.Soft != defaultSoft {
+		t.Errorf("latest season = %s (%q), want 10001 (%q)", seasonData.CID, seasonData.Soft, defaultSoft)
+	}
+
+	rows, err := client.FetchTop1000(ctx, seasonData.CID, seasonData.Rst, fmt.Sprint(seasonData.Ts1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1000 || rows[0].RatingValue != 2099 || rows[0].Icon != iconURL(upstream.URL, "icon_1.png") {
+		t.Errorf("got %d rows starting with %+v, want 1000 converted rows", len(rows), rows[0])
+	}
+	// キャッシュを使わないため、呼ぶたびに上流に問い合わせる
+	if got := upstream.seasonListCalls.Load(); got != 2 {
+		t.Errorf("season list calls = %d, want 2", got)
+	}
+
+	if _, err := client.FetchTop1000(ctx, "99999", 0, "1"); err == nil {
+		t.Error("FetchTop1000 for a missing ranking file succeeded")
+	}
+	upstream.seasons = map[string]map[string]SeasonData{}
+	if _, err := client.LatestSeason(ctx); !errors.Is(err, ErrNoActiveSeason) {
+		t.Errorf("LatestSeason without seasons = %v, want %v", err, ErrNoActiveSeason)
+	}
+}
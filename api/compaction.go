@@ -0,0 +1,246 @@
+package Handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// スナップショットを間引く規則
+// Ageより古いスナップショットはIntervalごとに最も新しい1件だけ残す
+type RetentionRule struct {
+	Age      time.Duration
+	Interval time.Duration
+}
+
+// 7日より古いものは1時間ごと、90日より古いものは1日ごとに残す
+var defaultRetentionPolicy = []RetentionRule{
+	{Age: 7 * 24 * time.Hour, Interval: time.Hour},
+	{Age: 90 * 24 * time.Hour, Interval: 24 * time.Hour},
+}
+
+// SNAPSHOT_RETENTION="168h=1h,2160h=24h" のように指定し、指定がなければdefaultRetentionPolicyを使う
+var SnapshotRetentionPolicy = parseRetentionPolicy(os.Getenv("SNAPSHOT_RETENTION"))
+
+// スナップショットを間引く間隔で、0以下なら間引かない
+var SnapshotCompactionInterval = envDuration("SNAPSHOT_COMPACTION_INTERVAL", time.Hour)
+
+// 間引くときにボーダーを集計して残す順位
+var defaultSnapshotCutoffRanks = []int{1, 10, 100, 500, 1000}
+
+// SNAPSHOT_CUTOFF_RANKS="1,100,1000" のように指定し、指定がなければdefaultSnapshotCutoffRanksを使う
+var SnapshotCutoffRanks = parseCutoffRanks(os.Getenv("SNAPSHOT_CUTOFF_RANKS"))
+
+// 間引いた区間の指定順位のボーダーの集計
+type CutoffAggregate struct {
+	Rank int `json:"rank"`
+	// 集計したスナップショットの中で最も低いボーダーと最も高いボーダー
+	MinRating float64 `json:"min_rating"`
+	MaxRating float64 `json:"max_rating"`
+	// 集計したスナップショットの数と最も古いものの時刻
+	Samples int       `json:"samples"`
+	Since   time.Time `json:"since"`
+}
+
+func parseCutoffRanks(v string) []int {
+	if v == "" {
+		return defaultSnapshotCutoffRanks
+	}
+	var ranks []int
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			log.Printf("invalid SNAPSHOT_CUTOFF_RANKS entry %q", s)
+			continue
+		}
+		ranks = append(ranks, n)
+	}
+	sort.Ints(ranks)
+	return ranks
+}
+
+func parseRetentionPolicy(v string) []RetentionRule {
+	if v == "" {
+		return defaultRetentionPolicy
+	}
+	var policy []RetentionRule
+	for _, pair := range strings.Split(v, ",") {
+		age, interval, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Printf("invalid SNAPSHOT_RETENTION entry %q", pair)
+			continue
+		}
+		a, err := time.ParseDuration(age)
+		if err != nil {
+			log.Printf("invalid SNAPSHOT_RETENTION entry %q: %v", pair, err)
+			continue
+		}
+		i, err := time.ParseDuration(interval)
+		if err != nil || i <= 0 {
+			log.Printf("invalid SNAPSHOT_RETENTION entry %q: interval must be positive", pair)
+			continue
+		}
+		policy = append(policy, RetentionRule{Age: a, Interval: i})
+	}
+	sort.Slice(policy, func(i, j int) bool { return policy[i].Age < policy[j].Age })
+	return policy
+}
+
+// スナップショットの経過時間に当てはまる規則
+// 最も長いAgeを超えたものを使い、どれにも当てはまらなければfalse
+func retentionRuleFor(policy []RetentionRule, age time.Duration) (RetentionRule, bool) {
+	var rule RetentionRule
+	found := false
+	for _, r := range policy {
+		if age > r.Age {
+			rule, found = r, true
+		}
+	}
+	return rule, found
+}
+
+// 規則に従ってスナップショットを間引き、削除した件数を返す
+// 区間はシーズンごとに分けて最も新しいものを残すため、各シーズンの最後のスナップショットは必ず残る
+// 削除する前に区間内のボーダーを集計して残すものに書き込む
+func compactSnapshots(ctx context.Context, store SnapshotStore, policy []RetentionRule, now time.Time) (int, error) {
+	// 区間を決めるには時刻とシーズンしか使わないため中身は読み込まない
+	infos, err := store.Index(ctx, SnapshotFilter{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+
+	// 区間ごとのスナップショットの時刻
+	type bucket struct {
+		season   int
+		interval time.Duration
+		start    time.Time
+	}
+	var order []bucket
+	members := map[bucket][]time.Time{}
+	for _, info := range infos {
+		rule, ok := retentionRuleFor(policy, now.Sub(info.Timestamp))
+		if !ok {
+			continue
+		}
+		b := bucket{season: info.Season, interval: rule.Interval, start: info.Timestamp.UTC().Truncate(rule.Interval)}
+		if _, ok := members[b]; !ok {
+			order = append(order, b)
+		}
+		members[b] = append(members[b], info.Timestamp)
+	}
+
+	deleted := 0
+	for _, b := range order {
+		timestamps := members[b]
+		if len(timestamps) < 2 {
+			continue
+		}
+		// 古い順に並んでいるので最後のものを残す
+		kept, dropped := timestamps[len(timestamps)-1], timestamps[:len(timestamps)-1]
+		if err := aggregateCutoffs(ctx, store, kept, dropped); err != nil {
+			return deleted, err
+		}
+		for _, ts := range dropped {
+			err := store.Delete(ctx, ts)
+			if errors.Is(err, ErrSnapshotNotFound) {
+				continue
+			}
+			if err != nil {
+				return deleted, fmt.Errorf("failed to delete snapshot %s: %v", ts.Format(time.RFC3339), err)
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// 区間内のスナップショットのボーダーを集計して、残すスナップショットに保存する
+// 前回の間引きで集計したものはその集計に含める
+func aggregateCutoffs(ctx context.Context, store SnapshotStore, kept time.Time, dropped []time.Time) error {
+	snapshots, err := loadSnapshots(ctx, store, append(append([]time.Time{}, dropped...), kept))
+	if err != nil {
+		return fmt.Errorf("failed to load snapshots: %v", err)
+	}
+	if len(snapshots) == 0 || !snapshots[len(snapshots)-1].Timestamp.Equal(kept) {
+		return fmt.Errorf("failed to load snapshot %s: %v", kept.Format(time.RFC3339), ErrSnapshotNotFound)
+	}
+
+	aggregates := map[int]*CutoffAggregate{}
+	for _, snapshot := range snapshots {
+		for _, a := range snapshotCutoffs(snapshot) {
+			if current, ok := aggregates[a.Rank]; ok {
+				current.MinRating = math.Min(current.MinRating, a.MinRating)
+				current.MaxRating = math.Max(current.MaxRating, a.MaxRating)
+				current.Samples += a.Samples
+				if a.Since.Before(current.Since) {
+					current.Since = a.Since
+				}
+				continue
+			}
+			a := a
+			aggregates[a.Rank] = &a
+		}
+	}
+
+	snapshot := snapshots[len(snapshots)-1]
+	snapshot.Cutoffs = make([]CutoffAggregate, 0, len(aggregates))
+	for _, a := range aggregates {
+		snapshot.Cutoffs = append(snapshot.Cutoffs, *a)
+	}
+	sort.Slice(snapshot.Cutoffs, func(i, j int) bool { return snapshot.Cutoffs[i].Rank < snapshot.Cutoffs[j].Rank })
+	if err := store.Save(ctx, snapshot); err != nil {
+		return fmt.Errorf("failed to save snapshot %s: %v", kept.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// スナップショットのボーダーの集計
+// 間引いたことのないものはランキングから求める
+func snapshotCutoffs(snapshot Snapshot) []CutoffAggregate {
+	if len(snapshot.Cutoffs) > 0 {
+		return snapshot.Cutoffs
+	}
+	var result []CutoffAggregate
+	for _, rank := range SnapshotCutoffRanks {
+		cutoff, err := computeCutoff(snapshot.Ranking.Top1000, rank, false)
+		if err != nil {
+			continue
+		}
+		result = append(result, CutoffAggregate{
+			Rank:      rank,
+			MinRating: cutoff.RatingValue,
+			MaxRating: cutoff.RatingValue,
+			Samples:   1,
+			Since:     snapshot.Timestamp,
+		})
+	}
+	return result
+}
+
+// 一定間隔でスナップショットを間引く
+func runSnapshotCompaction(ctx context.Context, store SnapshotStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := compactSnapshots(ctx, store, SnapshotRetentionPolicy, now)
+			if err != nil {
+				log.Printf("snapshot compaction failed: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("snapshot compaction deleted %d snapshots", deleted)
+			}
+		}
+	}
+}
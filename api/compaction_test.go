@@ -0,0 +1,165 @@
+package Handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []RetentionRule
+	}{
+		{value: "", want: defaultRetentionPolicy},
+		{value: "2160h=24h, 168h=1h", want: []RetentionRule{{Age: 168 * time.Hour, Interval: time.Hour}, {Age: 2160 * time.Hour, Interval: 24 * time.Hour}}},
+		{value: "24h=10m,bad,48h=0s,72h=x", want: []RetentionRule{{Age: 24 * time.Hour, Interval: 10 * time.Minute}}},
+	}
+	for _, tt := range tests {
+		got := parseRetentionPolicy(tt.value)
+		if len(got) != len(tt.want) {
+			t.Errorf("parseRetentionPolicy(%q) = %v, want %v", tt.value, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseRetentionPolicy(%q) = %v, want %v", tt.value, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// 7日以内は全て、7日より古いものは1時間ごと、90日より古いものは1日ごとに最も新しいものが残る
+func TestCompactSnapshots(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	ago := func(days int, offset time.Duration) time.Time {
+		return now.Add(-time.Duration(days) * 24 * time.Hour).Add(offset)
+	}
+
+	var seeded []Snapshot
+	var want []time.Time
+	add := func(ts time.Time, season int) {
+		snapshot := fixtureSnapshot(ts, season)
+		// 1位のボーダーをスナップショットごとに変える
+		snapshot.Ranking.Top1000[0].RatingValue = float64(2000000 + len(seeded))
+		seeded = append(seeded, snapshot)
+	}
+	// 100日前の1日分は6時間ごと
+	for _, hour := range []int{0, 6, 12, 18} {
+		add(ago(100, time.Duration(hour)*time.Hour), 1)
+	}
+	want = append(want, ago(100, 18*time.Hour))
+	// 10日前の2時間分は15分ごと
+	for i := 0; i < 8; i++ {
+		add(ago(10, time.Duration(i)*15*time.Minute), 2)
+	}
+	want = append(want, ago(10, 45*time.Minute), ago(10, 105*time.Minute))
+	// 9日前の1時間の途中でシーズンが変わった場合は、それぞれのシーズンの最後のものが残る
+	for i, season := range []int{2, 2, 3, 3} {
+		add(ago(9, time.Duration(i)*15*time.Minute), season)
+	}
+	want = append(want, ago(9, 15*time.Minute), ago(9, 45*time.Minute))
+	// 1日前の2時間分は15分ごと
+	for i := 0; i < 8; i++ {
+		ts := ago(1, time.Duration(i)*15*time.Minute)
+		add(ts, 3)
+		want = append(want, ts)
+	}
+
+	for _, store := range snapshotStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.new(t)
+			for _, snapshot := range seeded {
+				if err := s.Save(ctx, snapshot); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deleted, err := compactSnapshots(ctx, s, defaultRetentionPolicy, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantDeleted := len(seeded) - len(want); deleted != wantDeleted {
+				t.Errorf("deleted = %d, want %d", deleted, wantDeleted)
+			}
+			got, err := s.Index(ctx, SnapshotFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertTimes(t, snapshotTimestamps(got), want)
+
+			// 残ったスナップショットのランキングは変わらない
+			kept, err := s.Get(ctx, ago(100, 18*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept.Ranking.SeasonData.Season != 1 || len(kept.Ranking.Top1000) != 3 {
+				t.Errorf("kept snapshot = season %d with %d rows, want season 1 with 3 rows", kept.Ranking.SeasonData.Season, len(kept.Ranking.Top1000))
+			}
+			// 間引いたものを含めた区間のボーダーが残る。3件しかないため1000位などは無い
+			assertCutoffs(t, kept.Cutoffs, []CutoffAggregate{
+				{Rank: 1, MinRating: 2000000, MaxRating: 2000003, Samples: 4, Since: ago(100, 0)},
+			})
+
+			// 間引かなかったものには集計を書き込まない
+			recent, err := s.Get(ctx, ago(1, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recent.Cutoffs) != 0 {
+				t.Errorf("recent snapshot cutoffs = %v, want none", recent.Cutoffs)
+			}
+
+			// 間引いた後にもう一度間引いても何も消えない
+			if deleted, err := compactSnapshots(ctx, s, defaultRetentionPolicy, now); err != nil || deleted != 0 {
+				t.Errorf("second compaction deleted %d (%v), want 0", deleted, err)
+			}
+
+			// 1時間ごとに残したものを後で1日ごとに間引くと、前回の集計も含める
+			later := now.Add(90 * 24 * time.Hour)
+			if _, err := compactSnapshots(ctx, s, defaultRetentionPolicy, later); err != nil {
+				t.Fatal(err)
+			}
+			kept, err = s.Get(ctx, ago(10, 105*time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertCutoffs(t, kept.Cutoffs, []CutoffAggregate{
+				{Rank: 1, MinRating: 2000004, MaxRating: 2000011, Samples: 8, Since: ago(10, 0)},
+			})
+		})
+	}
+}
+
+func assertCutoffs(t *testing.T, got, want []CutoffAggregate) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("cutoffs = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i].Rank != want[i].Rank || got[i].MinRating != want[i].MinRating || got[i].MaxRating != want[i].MaxRating ||
+			got[i].Samples != want[i].Samples || !got[i].Since.Equal(want[i].Since) {
+			t.Errorf("cutoffs[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseCutoffRanks(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []int
+	}{
+		{value: "", want: defaultSnapshotCutoffRanks},
+		{value: "100, 1", want: []int{1, 100}},
+		{value: "10,x,0,-1", want: []int{10}},
+	}
+	for _, tt := range tests {
+		got := parseCutoffRanks(tt.value)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseCutoffRanks(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package Handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipで圧縮するレスポンスの大きさの下限（バイト）
+// 小さいレスポンスは圧縮してもほとんど小さくならず、かえって大きくなることもあるためそのまま返す
+var CompressionMinSize = envInt("COMPRESSION_MIN_SIZE", 1024)
+
+// Accept-Encodingにgzipが含まれているか
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(v), ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		// q=0は受け付けないという意味
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(v, 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// 書き込まれた大きさがminSizeに達するまでためておき、達したらgzipで圧縮して返すResponseWriter
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     bytes.Buffer
+	// 圧縮するかどうかを決めたか
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+	w.status = status
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf.Write(p)
+	if w.buf.Len() < w.minSize {
+		return len(p), nil
+	}
+	if err := w.decide(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// 圧縮するかどうかを決め、ためておいたものを書き込む
+func (w *gzipResponseWriter) decide() error {
+	// ハンドラーが既に圧縮している場合や、圧縮済みの形式の画像はそのまま返す
+	if w.Header().Get("Content-Encoding") != "" || strings.HasPrefix(w.Header().Get("Content-Type"), "image/") {
+		return w.flushPlain()
+	}
+	w.decided = true
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	if _, err := w.gz.Write(w.buf.Bytes()); err != nil {
+		return err
+	}
+	w.buf.Reset()
+	return nil
+}
+
+// ためておいたものを圧縮せずに書き込む
+func (w *gzipResponseWriter) flushPlain() error {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// 書き込んだ分をクライアントに送り出す
+// 少しずつ書き込んで送り出すレスポンスは全体の大きさが分からないため、CompressionMinSizeに達していなくてもここで圧縮を始める
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// ハンドラーが書き終えた後に呼ぶ
+func (w *gzipResponseWriter) close() error {
+	if !w.decided {
+		return w.flushPlain()
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// クライアントがgzipを受け付ける場合にCompressionMinSize以上のレスポンスを圧縮するミドルウェア
+func withCompression(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 圧縮するかどうかはAccept-Encodingで変わるため、どちらの場合もキャッシュに伝える
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: CompressionMinSize, status: http.StatusOK}
+		next(gw, r)
+		gw.close()
+	}
+}
@@ -0,0 +1,207 @@
+package Handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Accept-Encodingの有無にかかわらず、デコードしたレスポンスは同じランキングになる
+func TestRankingCompression(t *testing.T) {
+	newFakeUpstream(t)
+	handler := withCompression(RankingHandler)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{name: "gzip", acceptEncoding: "gzip", wantGzip: true},
+		{name: "gzip among others", acceptEncoding: "br, gzip;q=0.8", wantGzip: true},
+		{name: "no header", acceptEncoding: ""},
+		{name: "gzip refused", acceptEncoding: "gzip;q=0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/rankings", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			gotGzip := rec.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tt.wantGzip {
+				t.Fatalf("gzip = %v, want %v", gotGzip, tt.wantGzip)
+			}
+
+			if gotGzip {
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err := io.ReadAll(gz)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rec.Body.Reset()
+				rec.Body.Write(body)
+			}
+			var ranking RankingResponse
+			decodeBody(t, rec, &ranking)
+			if len(ranking.Top1000) != 1000 {
+				t.Errorf("top_1000 = %d rows, want 1000", len(ranking.Top1000))
+			}
+			if ranking.Top1000[0].Name != "trainer1" {
+				t.Errorf("top_1000[0].name = %q, want trainer1", ranking.Top1000[0].Name)
+			}
+		})
+	}
+}
+
+// CompressionMinSizeより小さいレスポンスは圧縮しない
+func TestCompressionMinSize(t *testing.T) {
+	saved := CompressionMinSize
+	t.Cleanup(func() { CompressionMinSize = saved })
+	CompressionMinSize = 1024
+
+	tests := []struct {
+		name        string
+		chunks      []string
+		contentType string
+		status      int
+		wantGzip    bool
+	}{
+		{name: "small", chunks: []string{`{"status":"ok"}`}, contentType: "application/json", status: http.StatusOK},
+		{name: "just below", chunks: []string{strings.Repeat("a", 1023)}, contentType: "application/json", status: http.StatusOK},
+		{name: "at threshold", chunks: []string{strings.Repeat("a", 1024)}, contentType: "application/json", status: http.StatusOK, wantGzip: true},
+		{name: "large", chunks: []string{strings.Repeat("a", 64*1024)}, contentType: "application/json", status: http.StatusOK, wantGzip: true},
+		{name: "small chunks", chunks: []string{strings.Repeat("a", 600), strings.Repeat("b", 600)}, contentType: "application/json", status: http.StatusOK, wantGzip: true},
+		{name: "small error", chunks: []string{`{"error":"not found"}`}, contentType: "application/json", status: http.StatusNotFound},
+		{name: "large error", chunks: []string{strings.Repeat("a", 2048)}, contentType: "application/json", status: http.StatusServiceUnavailable, wantGzip: true},
+		{name: "large image", chunks: []string{strings.Repeat("a", 2048)}, contentType: "image/png", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := withCompression(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				for _, chunk := range tt.chunks {
+					io.WriteString(w, chunk)
+				}
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			gotGzip := rec.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tt.wantGzip {
+				t.Fatalf("gzip = %v, want %v", gotGzip, tt.wantGzip)
+			}
+			body := rec.Body.String()
+			if gotGzip {
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := io.ReadAll(gz)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(decoded)
+			}
+			if want := strings.Join(tt.chunks, ""); body != want {
+				t.Errorf("body has %d bytes, want %d", len(body), len(want))
+			}
+		})
+	}
+}
+
+// 明示的に送り出す場合はCompressionMinSizeに達していなくても圧縮するかを決め、書き込んだ分を送り出す
+func TestCompressionFlush(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		wantGzip    bool
+	}{
+		{name: "stream", contentType: "application/x-ndjson", wantGzip: true},
+		{name: "image", contentType: "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const first = "first line\n"
+			rec := httptest.NewRecorder()
+			handler := withCompression(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				io.WriteString(w, first)
+				http.NewResponseController(w).Flush()
+
+				// ハンドラーが書き終える前に、最初の行がクライアントに届いている
+				if !rec.Flushed {
+					t.Error("response is not flushed")
+				}
+				if gotGzip := rec.Header().Get("Content-Encoding") == "gzip"; gotGzip != tt.wantGzip {
+					t.Fatalf("gzip = %v, want %v", gotGzip, tt.wantGzip)
+				}
+				got := rec.Body.String()
+				if tt.wantGzip {
+					gz, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+					if err != nil {
+						t.Fatal(err)
+					}
+					buf := make([]byte, len(first))
+					if _, err := io.ReadFull(gz, buf); err != nil {
+						t.Fatalf("failed to read the flushed line: %v", err)
+					}
+					got = string(buf)
+				}
+				if got != first {
+					t.Errorf("flushed %q, want %q", got, first)
+				}
+				io.WriteString(w, "second line\n")
+			})
+			req := httptest.NewRequest(http.MethodGet, "/rankings?format=jsonl", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			handler(rec, req)
+
+			body := rec.Body.String()
+			if tt.wantGzip {
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(gz)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(data)
+			}
+			if body != first+"second line\n" {
+				t.Errorf("body = %q, want both lines", body)
+			}
+		})
+	}
+}
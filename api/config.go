@@ -0,0 +1,85 @@
+package Handler
+
+import (
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 待ち受けるポート
+var Port = envString("PORT", "8080")
+
+// シーズンリストを取得するAPIのホスト
+var APIBaseURL = envString("API_BASE_URL", "https://api.battle.pokemon-home.com")
+
+// ランキングファイルとアイコンを取得するリソースのホスト
+var ResourceBaseURL = envString("RESOURCE_BASE_URL", "https://resource.pokemon-home.com")
+
+// 環境変数から文字列を取得
+// 末尾の/は取り除く
+func envString(key, fallback string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	return strings.TrimSuffix(v, "/")
+}
+
+// 環境変数から期間を取得
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using %s: %v", key, v, fallback, err)
+		return fallback
+	}
+	return d
+}
+
+// 環境変数から整数を取得
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using %d: %v", key, v, fallback, err)
+		return fallback
+	}
+	return n
+}
+
+// 環境変数から小数を取得
+func envFloat(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("invalid %s %q, using %g: %v", key, v, fallback, err)
+		return fallback
+	}
+	return f
+}
+
+// 環境変数からログのレベルを取得
+func envLogLevel(key string, fallback slog.Level) slog.Level {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(v)); err != nil {
+		log.Printf("invalid %s %q, using %s: %v", key, v, fallback, err)
+		return fallback
+	}
+	return level
+}
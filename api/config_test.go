@@ -0,0 +1,81 @@
+package Handler
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// 環境変数が無ければ既定値、読めない値なら既定値を使う
+func TestEnvConfig(t *testing.T) {
+	const key = "GO_RANK_BATTLE_TRACKER_TEST_VALUE"
+
+	tests := []struct {
+		name  string
+		value string
+		check func(t *testing.T)
+	}{
+		{name: "string unset", check: func(t *testing.T) {
+			if got := envString(key, "8080"); got != "8080" {
+				t.Errorf("envString = %q, want 8080", got)
+			}
+		}},
+		{name: "string", value: "9090", check: func(t *testing.T) {
+			if got := envString(key, "8080"); got != "9090" {
+				t.Errorf("envString = %q, want 9090", got)
+			}
+		}},
+		{name: "string trailing slash", value: "http://127.0.0.1:1234/", check: func(t *testing.T) {
+			if got := envString(key, APIBaseURL); got != "http://127.0.0.1:1234" {
+				t.Errorf("envString = %q, want http://127.0.0.1:1234", got)
+			}
+		}},
+		{name: "duration", value: "1m30s", check: func(t *testing.T) {
+			if got := envDuration(key, time.Second); got != 90*time.Second {
+				t.Errorf("envDuration = %s, want 1m30s", got)
+			}
+		}},
+		{name: "invalid duration", value: "90", check: func(t *testing.T) {
+			if got := envDuration(key, time.Second); got != time.Second {
+				t.Errorf("envDuration = %s, want 1s", got)
+			}
+		}},
+		{name: "int", value: "42", check: func(t *testing.T) {
+			if got := envInt(key, 3); got != 42 {
+				t.Errorf("envInt = %d, want 42", got)
+			}
+		}},
+		{name: "invalid int", value: "4.2", check: func(t *testing.T) {
+			if got := envInt(key, 3); got != 3 {
+				t.Errorf("envInt = %d, want 3", got)
+			}
+		}},
+		{name: "float", value: "0.75", check: func(t *testing.T) {
+			if got := envFloat(key, 0); got != 0.75 {
+				t.Errorf("envFloat = %v, want 0.75", got)
+			}
+		}},
+		{name: "invalid float", value: "x", check: func(t *testing.T) {
+			if got := envFloat(key, 0.5); got != 0.5 {
+				t.Errorf("envFloat = %v, want 0.5", got)
+			}
+		}},
+		{name: "log level", value: "debug", check: func(t *testing.T) {
+			if got := envLogLevel(key, slog.LevelInfo); got != slog.LevelDebug {
+				t.Errorf("envLogLevel = %s, want DEBUG", got)
+			}
+		}},
+		{name: "invalid log level", value: "verbose", check: func(t *testing.T) {
+			if got := envLogLevel(key, slog.LevelInfo); got != slog.LevelInfo {
+				t.Errorf("envLogLevel = %s, want INFO", got)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(key, tt.value)
+			captureLog(t)
+			tt.check(t)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package Handler
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// レスポンスを読めるようにするオリジン
+// CORS_ALLOWED_ORIGINS="https://example.com,https://app.example.com" のように指定し、指定がなければすべてのオリジンに許可する
+var CORSAllowedOrigins = parseAllowedOrigins(os.Getenv("CORS_ALLOWED_ORIGINS"))
+
+// trueなら許可したオリジンからのCookieなどの資格情報付きのリクエストも許可する
+// すべてのオリジンに許可する場合は資格情報付きにはできないため使わない
+var CORSAllowCredentials = os.Getenv("CORS_ALLOW_CREDENTIALS") == "true"
+
+// プリフライトリクエストに返す値
+const (
+	corsAllowMethods = "GET, POST, OPTIONS"
+	corsAllowHeaders = "Authorization, Content-Type, If-None-Match"
+	// ブラウザのスクリプトからknown_hashに使うETagを読めるようにする
+	corsExposeHeaders = "ETag"
+)
+
+func parseAllowedOrigins(v string) map[string]bool {
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(v, ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+// CORSのヘッダーを付けるミドルウェア
+// 許可するオリジンの指定があれば、一致するOriginのリクエストにだけそのオリジンを返す
+// プリフライトリクエストにはハンドラーを呼ばずに204を返す
+func withCORS(next http.Handler, allowed map[string]bool, credentials bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowOrigin := ""
+		if len(allowed) == 0 {
+			allowOrigin = "*"
+		} else {
+			// オリジンによって返すヘッダーが変わるため、キャッシュに伝える
+			w.Header().Add("Vary", "Origin")
+			if allowed[origin] {
+				allowOrigin = origin
+			}
+		}
+		if allowOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			if credentials && allowOrigin != "*" {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowOrigin != "" {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
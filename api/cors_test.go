@@ -0,0 +1,102 @@
+package Handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseAllowedOrigins(t *testing.T) {
+	got := parseAllowedOrigins(" https://example.com/, ,https://app.example.com")
+	if len(got) != 2 || !got["https://example.com"] || !got["https://app.example.com"] {
+		t.Errorf("origins = %v, want example.com and app.example.com", got)
+	}
+	if got := parseAllowedOrigins(""); len(got) != 0 {
+		t.Errorf("origins = %v, want none", got)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	allowed := parseAllowedOrigins("https://example.com")
+	tests := []struct {
+		name            string
+		allowed         map[string]bool
+		credentials     bool
+		method          string
+		origin          string
+		requestMethod   string
+		wantStatus      int
+		wantOrigin      string
+		wantCredentials string
+		wantMethods     string
+		wantCalled      bool
+	}{
+		{name: "wildcard", method: http.MethodGet, origin: "https://other.example", wantStatus: http.StatusOK, wantOrigin: "*", wantCalled: true},
+		{name: "wildcard without credentials", credentials: true, method: http.MethodGet, origin: "https://other.example", wantStatus: http.StatusOK, wantOrigin: "*", wantCalled: true},
+		{name: "allowed", allowed: allowed, method: http.MethodGet, origin: "https://example.com", wantStatus: http.StatusOK, wantOrigin: "https://example.com", wantCalled: true},
+		{name: "allowed with credentials", allowed: allowed, credentials: true, method: http.MethodGet, origin: "https://example.com", wantStatus: http.StatusOK, wantOrigin: "https://example.com", wantCredentials: "true", wantCalled: true},
+		// 許可しないオリジンでもレスポンスは返すが、ブラウザは読めない
+		{name: "disallowed", allowed: allowed, credentials: true, method: http.MethodGet, origin: "https://evil.example", wantStatus: http.StatusOK, wantCalled: true},
+		{name: "preflight", allowed: allowed, method: http.MethodOptions, origin: "https://example.com", requestMethod: http.MethodPost, wantStatus: http.StatusNoContent, wantOrigin: "https://example.com", wantMethods: corsAllowMethods},
+		{name: "disallowed preflight", allowed: allowed, method: http.MethodOptions, origin: "https://evil.example", requestMethod: http.MethodGet, wantStatus: http.StatusNoContent},
+		{name: "wildcard preflight", method: http.MethodOptions, origin: "https://other.example", requestMethod: http.MethodGet, wantStatus: http.StatusNoContent, wantOrigin: "*", wantMethods: corsAllowMethods},
+		// プリフライトでないOPTIONSはハンドラーに渡す
+		{name: "plain options", allowed: allowed, method: http.MethodOptions, origin: "https://example.com", wantStatus: http.StatusOK, wantOrigin: "https://example.com", wantCalled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }), tt.allowed, tt.credentials)
+			req := httptest.NewRequest(tt.method, "/rankings", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.requestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+			headers := []struct{ name, want string }{
+				{"Access-Control-Allow-Origin", tt.wantOrigin},
+				{"Access-Control-Allow-Credentials", tt.wantCredentials},
+				{"Access-Control-Allow-Methods", tt.wantMethods},
+			}
+			for _, h := range headers {
+				if got := rec.Header().Get(h.name); got != h.want {
+					t.Errorf("%s = %q, want %q", h.name, got, h.want)
+				}
+			}
+			// 許可するオリジンを指定した場合はオリジンごとにキャッシュを分ける
+			if gotVary := rec.Header().Get("Vary") == "Origin"; gotVary != (len(tt.allowed) != 0) {
+				t.Errorf("Vary = %q", rec.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+// レート制限の429にもCORSのヘッダーが付き、ブラウザからエラーを読める
+func TestCORSOnRateLimitedResponse(t *testing.T) {
+	newFakeUpstream(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux, "")
+	handler := withCORS(withRateLimit(mux, newIPRateLimiter(1, 1, time.Minute)), parseAllowedOrigins("https://example.com"), false)
+
+	for i, wantStatus := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/rankings", nil)
+		req.Header.Set("Origin", "https://example.com")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != wantStatus {
+			t.Fatalf("request %d: status = %d, want %d", i+1, rec.Code, wantStatus)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://example.com" {
+			t.Errorf("request %d: Access-Control-Allow-Origin = %q, want the request origin", i+1, got)
+		}
+	}
+}
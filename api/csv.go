@@ -0,0 +1,61 @@
+package Handler
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// /rankingsのformatに指定できる形式
+const (
+	rankingFormatJSON = "json"
+	rankingFormatCSV  = "csv"
+	// 1行に1つのJSONを書くJSON Lines
+	rankingFormatJSONLines = "jsonl"
+)
+
+// レスポンスの形式
+// formatの指定がなければAcceptにtext/csvかapplication/x-ndjsonが含まれる場合のみその形式にする
+func rankingFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(v), ";")
+		switch strings.TrimSpace(mediaType) {
+		case "text/csv":
+			return rankingFormatCSV
+		case "application/x-ndjson":
+			return rankingFormatJSONLines
+		}
+	}
+	return rankingFormatJSON
+}
+
+// CSVの列
+var rankingCSVHeader = []string{"rank", "name", "rating_value", "lng", "icon"}
+
+// ランキングを見出し行付きのCSVで書き込む
+// シーズンの情報は表計算ソフトで読み込んだときに行として混ざらないよう含めない
+func writeRankingCSV(w io.Writer, rankingData []RankResponseRawData) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(rankingCSVHeader); err != nil {
+		return err
+	}
+	for _, data := range rankingData {
+		record := []string{
+			strconv.Itoa(data.Rank),
+			data.Name,
+			strconv.FormatFloat(data.RatingValue, 'f', -1, 64),
+			data.Lng,
+			data.Icon,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,93 @@
+package Handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRankingCSV(t *testing.T) {
+	tests := []struct {
+		name            string
+		target          string
+		accept          string
+		wantStatus      int
+		wantContentType string
+	}{
+		{name: "format", target: "/rankings?format=csv", wantStatus: http.StatusOK, wantContentType: "text/csv; charset=utf-8"},
+		{name: "accept", target: "/rankings", accept: "text/csv", wantStatus: http.StatusOK, wantContentType: "text/csv; charset=utf-8"},
+		{name: "accept among others", target: "/rankings", accept: "application/json;q=0.5, text/csv;q=0.9", wantStatus: http.StatusOK, wantContentType: "text/csv; charset=utf-8"},
+		{name: "format over accept", target: "/rankings?format=json", accept: "text/csv", wantStatus: http.StatusOK, wantContentType: "application/json"},
+		{name: "default", target: "/rankings", wantStatus: http.StatusOK, wantContentType: "application/json"},
+		{name: "unknown format", target: "/rankings?format=xml", wantStatus: http.StatusBadRequest, wantContentType: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeUpstream(t)
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			RankingHandler(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Fatalf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if !strings.HasPrefix(tt.wantContentType, "text/csv") {
+				return
+			}
+
+			records, err := csv.NewReader(rec.Body).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1001 {
+				t.Fatalf("got %d records, want a header and 1000 rows", len(records))
+			}
+			if got := strings.Join(records[0], ","); got != strings.Join(rankingCSVHeader, ",") {
+				t.Errorf("header = %q, want %q", got, strings.Join(rankingCSVHeader, ","))
+			}
+			want := []string{"1", "trainer1", "2099", "2", iconURL(DefaultClient.ResourceBaseURL, "icon_1.png")}
+			if got := records[1]; strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("first row = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// カンマや引用符、改行を含む名前も読み戻すと元の名前になる
+func TestWriteRankingCSVEscaping(t *testing.T) {
+	names := []string{`plain`, `a,b`, `say "hi"`, "two\nlines", `"`, ` spaced `, `トレーナー，全角`}
+	rows := fixtureRows(1, len(names))
+	for i := range rows {
+		rows[i].Name = names[i]
+	}
+
+	var buf bytes.Buffer
+	if err := writeRankingCSV(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(names)+1 {
+		t.Fatalf("got %d records, want %d", len(records), len(names)+1)
+	}
+	for i, name := range names {
+		record := records[i+1]
+		if len(record) != len(rankingCSVHeader) {
+			t.Errorf("row %d has %d columns, want %d", i+1, len(record), len(rankingCSVHeader))
+			continue
+		}
+		if record[1] != name {
+			t.Errorf("row %d name = %q, want %q", i+1, record[1], name)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package Handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指定順位のボーダー
+type CutoffResponse struct {
+	SeasonData  SeasonData  `json:"season_data"`
+	Rank        int         `json:"rank"`
+	RatingValue float64     `json:"rating_value"`
+	Ties        *CutoffTies `json:"ties,omitempty"`
+	// 指定順位がランキングの範囲外で、最下位の順位に置き換えた
+	Clamped bool `json:"clamped,omitempty"`
+}
+
+// ボーダーのレートと同じレートのトレーナーの情報
+type CutoffTies struct {
+	Count    int `json:"count"`
+	FromRank int `json:"from_rank"`
+	ToRank   int `json:"to_rank"`
+}
+
+// 2つのシーズンのボーダーの比較
+type CutoffCompareResponse struct {
+	Rank       int                 `json:"rank"`
+	A          CutoffCompareSeason `json:"a"`
+	B          CutoffCompareSeason `json:"b"`
+	Difference *float64            `json:"difference,omitempty"`
+}
+
+// 比較するシーズンごとの結果
+// 取得に失敗したシーズンはErrorにその理由を入れる
+type CutoffCompareSeason struct {
+	Season int             `json:"season"`
+	Cutoff *CutoffResponse `json:"cutoff,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// 複数シーズンのボーダー
+type CutoffSeasonsResponse struct {
+	Rank    int                   `json:"rank"`
+	Seasons []CutoffCompareSeason `json:"seasons"`
+}
+
+// 1回のリクエストで指定できるシーズン数の上限
+// 1回のリクエストで上流へのリクエストが大量に発生しないようにする
+var MaxBulkSeasons = envInt("MAX_BULK_SEASONS", 12)
+
+// 複数シーズンのボーダーを同時に取得する数
+const cutoffFetchConcurrency = 4
+
+// 指定レート以上のトレーナーがいる最も低い順位
+// 該当者がいない場合はRankが0でFoundがfalse
+type ThresholdResponse struct {
+	SeasonData  SeasonData `json:"season_data"`
+	RatingValue float64    `json:"rating_value"`
+	Rank        int        `json:"rank"`
+	Found       bool       `json:"found"`
+}
+
+// 指定順位のボーダーを計算
+func computeCutoff(rankingData []RankResponseRawData, rank int, withTies bool) (CutoffResponse, error) {
+	if rank < 1 || rank > len(rankingData) {
+		return CutoffResponse{}, fmt.Errorf("rank %d is out of range (1-%d)", rank, len(rankingData))
+	}
+
+	cutoff := rankingData[rank-1]
+	result := CutoffResponse{
+		Rank:        cutoff.Rank,
+		RatingValue: cutoff.RatingValue,
+	}
+	if !withTies {
+		return result, nil
+	}
+
+	// 同じレートが並んでいる範囲を前後に探す
+	from, to := rank-1, rank-1
+	for from > 0 && rankingData[from-1].RatingValue == cutoff.RatingValue {
+		from--
+	}
+	for to < len(rankingData)-1 && rankingData[to+1].RatingValue == cutoff.RatingValue {
+		to++
+	}
+	result.Ties = &CutoffTies{
+		Count:    to - from + 1,
+		FromRank: rankingData[from].Rank,
+		ToRank:   rankingData[to].Rank,
+	}
+	return result, nil
+}
+
+// endpoint handler
+func CutoffHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	rank, err := strconv.Atoi(r.URL.Query().Get("rank"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid rank parameter")
+		return
+	}
+	withTies := r.URL.Query().Get("ties") == "true"
+	clamp := r.URL.Query().Get("clamp") == "true"
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/cutoff")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	// clampなら範囲外の順位は取得できた最下位の順位のボーダーを返す
+	clamped := clamp && rank > len(ranking.Top1000) && len(ranking.Top1000) > 0
+	if clamped {
+		rank = len(ranking.Top1000)
+	}
+	cutoff, err := computeCutoff(ranking.Top1000, rank, withTies)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	cutoff.SeasonData = ranking.SeasonData
+	cutoff.Clamped = clamped
+
+	if err := json.NewEncoder(w).Encode(cutoff); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// 指定順位のトレーナー
+type RankLookupResponse struct {
+	SeasonData SeasonData          `json:"season_data"`
+	Entry      RankResponseRawData `json:"entry"`
+}
+
+// endpoint handler
+func RankLookupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	rank, err := strconv.Atoi(r.URL.Query().Get("rank"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid rank parameter")
+		return
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/rank")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	// ボーダーと同じく、同率がある場合も先頭からrank番目の行を返す
+	if rank < 1 || rank > len(ranking.Top1000) {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("rank %d is out of range (1-%d)", rank, len(ranking.Top1000)))
+		return
+	}
+	response := RankLookupResponse{
+		SeasonData: ranking.SeasonData,
+		Entry:      ranking.Top1000[rank-1],
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// シーズンのボーダーを取得
+func fetchSeasonCutoff(ctx context.Context, seasonList *SeasonList, seasonNumber, rank int, maxAge time.Duration, budget *retryBudget) CutoffCompareSeason {
+	result := CutoffCompareSeason{Season: seasonNumber}
+	seasonData, err := findSeasonData(seasonList.Seasons, seasonNumber, nil, "")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	rankingData, _, err := cachedSeasonRanking(ctx, seasonData, maxAge, budget)
+	if err != nil {
+		result.Error = fmt.Sprintf("Error fetching top 1000 ranking data: %v", err)
+		return result
+	}
+	cutoff, err := computeCutoff(rankingData, rank, false)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	cutoff.SeasonData = seasonData
+	result.Cutoff = &cutoff
+	return result
+}
+
+// endpoint handler
+func CutoffCompareHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	rank, err := strconv.Atoi(query.Get("rank"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid rank parameter")
+		return
+	}
+	seasonA, err := strconv.Atoi(query.Get("a"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid a parameter")
+		return
+	}
+	seasonB, err := strconv.Atoi(query.Get("b"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid b parameter")
+		return
+	}
+
+	maxAge := cacheTTL("/rankings/cutoff/compare")
+	budget := newRetryBudget(RetryBudget)
+	seasonList, _, err := cachedSeasonList(r.Context(), defaultSoft, maxAge, budget)
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), fmt.Sprintf("Error fetching ranking data: %v", err))
+		return
+	}
+
+	// 2シーズン分を並行して取得
+	response := CutoffCompareResponse{Rank: rank}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		response.A = fetchSeasonCutoff(r.Context(), seasonList, seasonA, rank, maxAge, budget)
+	}()
+	go func() {
+		defer wg.Done()
+		response.B = fetchSeasonCutoff(r.Context(), seasonList, seasonB, rank, maxAge, budget)
+	}()
+	wg.Wait()
+
+	if response.A.Cutoff != nil && response.B.Cutoff != nil {
+		difference := response.B.Cutoff.RatingValue - response.A.Cutoff.RatingValue
+		response.Difference = &difference
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// カンマ区切りのシーズン番号を解析
+func parseSeasonNumbers(v string) ([]int, error) {
+	if v == "" {
+		return nil, fmt.Errorf("seasons is required")
+	}
+	var seasons []int
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid season %q", s)
+		}
+		seasons = append(seasons, n)
+	}
+	return seasons, nil
+}
+
+// endpoint handler
+func CutoffSeasonsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	rank, err := strconv.Atoi(query.Get("rank"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid rank parameter")
+		return
+	}
+	seasons, err := parseSeasonNumbers(query.Get("seasons"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid seasons parameter: %v", err))
+		return
+	}
+	if len(seasons) > MaxBulkSeasons {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Too many seasons: at most %d can be requested at once", MaxBulkSeasons))
+		return
+	}
+
+	maxAge := cacheTTL("/rankings/cutoff/seasons")
+	// シーズンごとに最低1件は上流へのリクエストが必要なため、その分を上限に足す
+	budget := newRetryBudget(RetryBudget + len(seasons))
+	seasonList, _, err := cachedSeasonList(r.Context(), defaultSoft, maxAge, budget)
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), fmt.Sprintf("Error fetching ranking data: %v", err))
+		return
+	}
+
+	response := CutoffSeasonsResponse{Rank: rank, Seasons: make([]CutoffCompareSeason, len(seasons))}
+	sem := make(chan struct{}, cutoffFetchConcurrency)
+	var wg sync.WaitGroup
+	for i, seasonNumber := range seasons {
+		wg.Add(1)
+		go func(i, seasonNumber int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			response.Seasons[i] = fetchSeasonCutoff(r.Context(), seasonList, seasonNumber, rank, maxAge, budget)
+		}(i, seasonNumber)
+	}
+	wg.Wait()
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// 指定順位から指定した数だけ順位を上げるのに必要なレート
+type ClimbResponse struct {
+	SeasonData        SeasonData `json:"season_data"`
+	Rank              int        `json:"rank"`
+	RatingValue       float64    `json:"rating_value"`
+	TargetRank        int        `json:"target_rank"`
+	TargetRatingValue float64    `json:"target_rating_value"`
+	Difference        float64    `json:"difference"`
+}
+
+// rankからspots分上の順位とのレートの差を計算
+func computeClimb(rankingData []RankResponseRawData, rank, spots int) (ClimbResponse, error) {
+	if rank < 1 || rank > len(rankingData) {
+		return ClimbResponse{}, fmt.Errorf("rank %d is out of range (1-%d)", rank, len(rankingData))
+	}
+	target := rank - spots
+	if target < 1 {
+		return ClimbResponse{}, fmt.Errorf("target rank %d is out of range: cannot climb %d spots from rank %d", target, spots, rank)
+	}
+	current, goal := rankingData[rank-1], rankingData[target-1]
+	return ClimbResponse{
+		Rank:              current.Rank,
+		RatingValue:       current.RatingValue,
+		TargetRank:        goal.Rank,
+		TargetRatingValue: goal.RatingValue,
+		Difference:        goal.RatingValue - current.RatingValue,
+	}, nil
+}
+
+// endpoint handler
+func ClimbHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	rank, err := strconv.Atoi(query.Get("rank"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid rank parameter")
+		return
+	}
+	spots, err := strconv.Atoi(query.Get("spots"))
+	if err != nil || spots < 1 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid spots parameter: must be a positive integer")
+		return
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/climb")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	climb, err := computeClimb(ranking.Top1000, rank, spots)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	climb.SeasonData = ranking.SeasonData
+
+	if err := json.NewEncoder(w).Encode(climb); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// 順位順に並んだランキングから指定レート以上の最も低い順位を二分探索で取得
+func computeThreshold(rankingData []RankResponseRawData, rating float64) (int, bool) {
+	i := sort.Search(len(rankingData), func(i int) bool {
+		return rankingData[i].RatingValue < rating
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return rankingData[i-1].Rank, true
+}
+
+// endpoint handler
+func ThresholdHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	rating, err := strconv.ParseFloat(r.URL.Query().Get("rating"), 64)
+	if err != nil || !isFinite(rating) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid rating parameter")
+		return
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/threshold")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	rank, found := computeThreshold(ranking.Top1000, rating)
+	response := ThresholdResponse{
+		SeasonData:  ranking.SeasonData,
+		RatingValue: rating,
+		Rank:        rank,
+		Found:       found,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
@@ -0,0 +1,414 @@
+package Handler
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 順位ごとのレートを指定したランキング
+func rowsWithRatings(ratings ...float64) []RankResponseRawData {
+	rows := make([]RankResponseRawData, len(ratings))
+	for i, rating := range ratings {
+		rows[i] = RankResponseRawData{Rank: i + 1, RatingValue: rating}
+	}
+	return rows
+}
+
+func TestComputeCutoffTies(t *testing.T) {
+	// 2位から4位まで同じレート
+	rows := rowsWithRatings(2000, 1990, 1990, 1990, 1980)
+
+	tests := []struct {
+		name      string
+		rank      int
+		withTies  bool
+		want      float64
+		wantTies  *CutoffTies
+		wantError bool
+	}{
+		{name: "tie straddling the cutoff", rank: 3, withTies: true, want: 1990, wantTies: &CutoffTies{Count: 3, FromRank: 2, ToRank: 4}},
+		{name: "first of the tie", rank: 2, withTies: true, want: 1990, wantTies: &CutoffTies{Count: 3, FromRank: 2, ToRank: 4}},
+		{name: "no tie", rank: 1, withTies: true, want: 2000, wantTies: &CutoffTies{Count: 1, FromRank: 1, ToRank: 1}},
+		{name: "last rank", rank: 5, withTies: true, want: 1980, wantTies: &CutoffTies{Count: 1, FromRank: 5, ToRank: 5}},
+		{name: "ties not requested", rank: 3, want: 1990},
+		{name: "out of range", rank: 6, wantError: true},
+		{name: "zero", rank: 0, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := computeCutoff(rows, tt.rank, tt.withTies)
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("computeCutoff(%d) = %+v, want error", tt.rank, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.RatingValue != tt.want {
+				t.Errorf("rating = %v, want %v", got.RatingValue, tt.want)
+			}
+			switch {
+			case tt.wantTies == nil && got.Ties != nil:
+				t.Errorf("ties = %+v, want nil", *got.Ties)
+			case tt.wantTies != nil && (got.Ties == nil || *got.Ties != *tt.wantTies):
+				t.Errorf("ties = %+v, want %+v", got.Ties, *tt.wantTies)
+			}
+		})
+	}
+}
+
+func TestCutoffHandler(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	season := upstream.seasons["1"]["10001"]
+	// 100位と101位が同じレート
+	rows := fixtureRows(1, 1000)
+	rows[100].RatingValue = rows[99].RatingValue
+	upstream.setPage(season, 1, rows)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantRank   int
+		wantTies   *CutoffTies
+		wantClamp  bool
+	}{
+		{target: "/rankings/cutoff?rank=100", wantStatus: http.StatusOK, wantRank: 100},
+		{target: "/rankings/cutoff?rank=100&ties=true", wantStatus: http.StatusOK, wantRank: 100, wantTies: &CutoffTies{Count: 2, FromRank: 100, ToRank: 101}},
+		{target: "/rankings/cutoff?rank=101&ties=true", wantStatus: http.StatusOK, wantRank: 101, wantTies: &CutoffTies{Count: 2, FromRank: 100, ToRank: 101}},
+		{target: "/rankings/cutoff?rank=5000&clamp=true", wantStatus: http.StatusOK, wantRank: 1000, wantClamp: true},
+		{target: "/rankings/cutoff?rank=5000", wantStatus: http.StatusNotFound},
+		{target: "/rankings/cutoff?rank=abc", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, CutoffHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var cutoff CutoffResponse
+			decodeBody(t, rec, &cutoff)
+			if cutoff.Rank != tt.wantRank {
+				t.Errorf("rank = %d, want %d", cutoff.Rank, tt.wantRank)
+			}
+			if cutoff.Clamped != tt.wantClamp {
+				t.Errorf("clamped = %v, want %v", cutoff.Clamped, tt.wantClamp)
+			}
+			switch {
+			case tt.wantTies == nil && cutoff.Ties != nil:
+				t.Errorf("ties = %+v, want nil", *cutoff.Ties)
+			case tt.wantTies != nil && (cutoff.Ties == nil || *cutoff.Ties != *tt.wantTies):
+				t.Errorf("ties = %+v, want %+v", cutoff.Ties, *tt.wantTies)
+			}
+		})
+	}
+}
+
+// 過去のシーズンをシーズンリストに追加し、レートをratingOffsetだけずらしたランキングファイルを設定する
+// ratingOffsetは表示用のレートの差
+func addPastSeason(upstream *fakeUpstream, season int, cId string, ratingOffset float64) SeasonData {
+	seasonData := fixtureSeason(season, cId, 0, time.Now().Add(-time.Duration(season)*30*24*time.Hour))
+	upstream.addSeason(fmt.Sprint(season), seasonData)
+	rows := fixtureRows(1, 1000)
+	for i := range rows {
+		rows[i].RatingValue += ratingOffset * 1000
+	}
+	upstream.setPage(seasonData, 1, rows)
+	return seasonData
+}
+
+func TestCutoffCompareHandler(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	addPastSeason(upstream, 2, "10002", 5)
+	// シーズンリストにはあるがランキングファイルがない
+	upstream.addSeason("3", fixtureSeason(3, "10003", 0, time.Now()))
+
+	tests := []struct {
+		target         string
+		wantStatus     int
+		wantA          float64
+		wantB          float64
+		wantDifference *float64
+		wantErrorA     bool
+		wantErrorB     bool
+	}{
+		{target: "/rankings/cutoff/compare?rank=100&a=1&b=2", wantStatus: http.StatusOK, wantA: 2000, wantB: 2005, wantDifference: floatPtr(5)},
+		{target: "/rankings/cutoff/compare?rank=100&a=2&b=1", wantStatus: http.StatusOK, wantA: 2005, wantB: 2000, wantDifference: floatPtr(-5)},
+		{target: "/rankings/cutoff/compare?rank=100&a=1&b=3", wantStatus: http.StatusOK, wantA: 2000, wantErrorB: true},
+		{target: "/rankings/cutoff/compare?rank=100&a=9&b=2", wantStatus: http.StatusOK, wantErrorA: true, wantB: 2005},
+		{target: "/rankings/cutoff/compare?rank=1001&a=1&b=2", wantStatus: http.StatusOK, wantErrorA: true, wantErrorB: true},
+		{target: "/rankings/cutoff/compare?rank=100&a=1", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/cutoff/compare?a=1&b=2", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, CutoffCompareHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response CutoffCompareResponse
+			decodeBody(t, rec, &response)
+			assertCompareSeason(t, "a", response.A, tt.wantA, tt.wantErrorA)
+			assertCompareSeason(t, "b", response.B, tt.wantB, tt.wantErrorB)
+			switch {
+			case tt.wantDifference == nil && response.Difference != nil:
+				t.Errorf("difference = %v, want none", *response.Difference)
+			case tt.wantDifference != nil && (response.Difference == nil || *response.Difference != *tt.wantDifference):
+				t.Errorf("difference = %v, want %v", response.Difference, *tt.wantDifference)
+			}
+		})
+	}
+}
+
+func assertCompareSeason(t *testing.T, name string, got CutoffCompareSeason, want float64, wantError bool) {
+	t.Helper()
+	if wantError {
+		if got.Error == "" || got.Cutoff != nil {
+			t.Errorf("%s = %+v, want an error", name, got)
+		}
+		return
+	}
+	if got.Cutoff == nil {
+		t.Fatalf("%s error = %q, want a cutoff", name, got.Error)
+	}
+	if got.Cutoff.RatingValue != want {
+		t.Errorf("%s rating = %v, want %v", name, got.Cutoff.RatingValue, want)
+	}
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// シーズンリストの取得に失敗した場合は/rankingsと同じステータスコードで返す
+func TestCutoffSeasonListError(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{name: "compare", handler: CutoffCompareHandler, target: "/rankings/cutoff/compare?rank=100&a=1&b=2"},
+		{name: "seasons", handler: CutoffSeasonsHandler, target: "/rankings/cutoff/seasons?rank=100&seasons=1,2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.seasonListBody = "not json"
+			rec := get(t, tt.handler, tt.target)
+			if rec.Code != http.StatusBadGateway {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadGateway, rec.Body)
+			}
+		})
+	}
+}
+
+func TestThresholdHandler(t *testing.T) {
+	// r位のレートは2100-r
+	newFakeUpstream(t)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantRank   int
+		wantFound  bool
+	}{
+		{target: "/rankings/threshold?rating=1850", wantStatus: http.StatusOK, wantRank: 250, wantFound: true},
+		{target: "/rankings/threshold?rating=1850.5", wantStatus: http.StatusOK, wantRank: 249, wantFound: true},
+		{target: "/rankings/threshold?rating=2099", wantStatus: http.StatusOK, wantRank: 1, wantFound: true},
+		{target: "/rankings/threshold?rating=1000", wantStatus: http.StatusOK, wantRank: 1000, wantFound: true},
+		{target: "/rankings/threshold?rating=2100", wantStatus: http.StatusOK, wantRank: 0, wantFound: false},
+		{target: "/rankings/threshold?rating=Inf", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/threshold?rating=NaN", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/threshold", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, ThresholdHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response ThresholdResponse
+			decodeBody(t, rec, &response)
+			if response.Rank != tt.wantRank || response.Found != tt.wantFound {
+				t.Errorf("got rank %d found %v, want rank %d found %v", response.Rank, response.Found, tt.wantRank, tt.wantFound)
+			}
+		})
+	}
+}
+
+// 500位までしかないランキングで範囲外の順位を求める
+func TestCutoffClamped(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.setPage(upstream.seasons["1"]["10001"], 1, fixtureRows(1, 500))
+
+	tests := []struct {
+		target      string
+		wantStatus  int
+		wantRank    int
+		wantRating  float64
+		wantClamped bool
+	}{
+		{target: "/rankings/cutoff?rank=1500&clamp=true", wantStatus: http.StatusOK, wantRank: 500, wantRating: 1600, wantClamped: true},
+		{target: "/rankings/cutoff?rank=501&clamp=true", wantStatus: http.StatusOK, wantRank: 500, wantRating: 1600, wantClamped: true},
+		{target: "/rankings/cutoff?rank=500&clamp=true", wantStatus: http.StatusOK, wantRank: 500, wantRating: 1600},
+		{target: "/rankings/cutoff?rank=100&clamp=true", wantStatus: http.StatusOK, wantRank: 100, wantRating: 2000},
+		{target: "/rankings/cutoff?rank=1500", wantStatus: http.StatusNotFound},
+		{target: "/rankings/cutoff?rank=1500&clamp=false", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, CutoffHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var cutoff CutoffResponse
+			decodeBody(t, rec, &cutoff)
+			if cutoff.Rank != tt.wantRank || cutoff.RatingValue != tt.wantRating || cutoff.Clamped != tt.wantClamped {
+				t.Errorf("got rank %d rating %v clamped %v, want rank %d rating %v clamped %v",
+					cutoff.Rank, cutoff.RatingValue, cutoff.Clamped, tt.wantRank, tt.wantRating, tt.wantClamped)
+			}
+		})
+	}
+}
+
+// 一度に指定できるシーズン数を超えたら上流に問い合わせずに400を返す
+func TestMaxBulkSeasons(t *testing.T) {
+	saved := MaxBulkSeasons
+	t.Cleanup(func() { MaxBulkSeasons = saved })
+	MaxBulkSeasons = 3
+
+	tests := []struct {
+		target     string
+		handler    http.HandlerFunc
+		wantStatus int
+	}{
+		{target: "/rankings/batch?seasons=1,2,3", handler: BatchHandler, wantStatus: http.StatusOK},
+		{target: "/rankings/batch?seasons=1,2,3,4", handler: BatchHandler, wantStatus: http.StatusBadRequest},
+		{target: "/rankings/batch?seasons=1,1,1,1", handler: BatchHandler, wantStatus: http.StatusBadRequest},
+		{target: "/rankings/cutoff/seasons?rank=100&seasons=1,2,3", handler: CutoffSeasonsHandler, wantStatus: http.StatusOK},
+		{target: "/rankings/cutoff/seasons?rank=100&seasons=1,2,3,4", handler: CutoffSeasonsHandler, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			addPastSeason(upstream, 2, "10002", 5)
+			rec := get(t, tt.handler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				if n := upstream.seasonListCalls.Load() + upstream.rankingCalls.Load(); n != 0 {
+					t.Errorf("upstream calls = %d, want 0", n)
+				}
+			}
+		})
+	}
+}
+
+func TestClimbHandler(t *testing.T) {
+	tests := []struct {
+		target         string
+		wantStatus     int
+		wantTarget     int
+		wantDifference float64
+	}{
+		{target: "/rankings/climb?rank=300&spots=50", wantStatus: http.StatusOK, wantTarget: 250, wantDifference: 50},
+		{target: "/rankings/climb?rank=1000&spots=999", wantStatus: http.StatusOK, wantTarget: 1, wantDifference: 999},
+		{target: "/rankings/climb?rank=2&spots=1", wantStatus: http.StatusOK, wantTarget: 1, wantDifference: 1},
+		{target: "/rankings/climb?rank=50&spots=50", wantStatus: http.StatusNotFound},
+		{target: "/rankings/climb?rank=1001&spots=1", wantStatus: http.StatusNotFound},
+		{target: "/rankings/climb?rank=0&spots=1", wantStatus: http.StatusNotFound},
+		{target: "/rankings/climb?rank=300&spots=0", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/climb?rank=300", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/climb?rank=x&spots=50", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			newFakeUpstream(t)
+			rec := get(t, ClimbHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var body ErrorResponse
+				decodeBody(t, rec, &body)
+				if body.Error == "" {
+					t.Error("error message is empty")
+				}
+				return
+			}
+			var climb ClimbResponse
+			decodeBody(t, rec, &climb)
+			if climb.TargetRank != tt.wantTarget || climb.Difference != tt.wantDifference {
+				t.Errorf("got target rank %d difference %v, want target rank %d difference %v",
+					climb.TargetRank, climb.Difference, tt.wantTarget, tt.wantDifference)
+			}
+			if climb.TargetRatingValue-climb.RatingValue != climb.Difference {
+				t.Errorf("difference %v does not match ratings %v and %v", climb.Difference, climb.RatingValue, climb.TargetRatingValue)
+			}
+			if climb.SeasonData.Season != 1 {
+				t.Errorf("season = %d, want 1", climb.SeasonData.Season)
+			}
+		})
+	}
+}
+
+func TestRankLookupHandler(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantRank   int
+	}{
+		{target: "/rankings/rank?rank=1", wantStatus: http.StatusOK, wantRank: 1},
+		{target: "/rankings/rank?rank=500", wantStatus: http.StatusOK, wantRank: 500},
+		{target: "/rankings/rank?rank=1000", wantStatus: http.StatusOK, wantRank: 1000},
+		{target: "/rankings/rank?rank=0", wantStatus: http.StatusNotFound},
+		{target: "/rankings/rank?rank=1001", wantStatus: http.StatusNotFound},
+		{target: "/rankings/rank?rank=x", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/rank", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			newFakeUpstream(t)
+			rec := get(t, RankLookupHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response RankLookupResponse
+			decodeBody(t, rec, &response)
+			// /rankingsと同じく1000で割ったレートと展開したアイコンのURLを返す
+			want := RankResponseRawData{
+				Rank:        tt.wantRank,
+				RatingValue: float64(2100 - tt.wantRank),
+				RatingRaw:   float64(2100000 - tt.wantRank*1000),
+				Icon:        iconURL(DefaultClient.ResourceBaseURL, fmt.Sprintf("icon_%d.png", tt.wantRank)),
+				Name:        fmt.Sprintf("trainer%d", tt.wantRank),
+				Lng:         []string{"1", "2"}[tt.wantRank%2],
+			}
+			if response.Entry != want {
+				t.Errorf("entry = %+v, want %+v", response.Entry, want)
+			}
+			if response.SeasonData.Season != 1 {
+				t.Errorf("season = %d, want 1", response.SeasonData.Season)
+			}
+		})
+	}
+}
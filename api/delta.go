@@ -0,0 +1,96 @@
+package Handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 差分を返すために保持する過去のランキングデータ
+// キーはランキングデータのハッシュ
+var rankingHistory = newTTLCache(CacheMaxEntries)
+
+// 過去のランキングデータを差分の基準に使える期間
+const rankingHistoryMaxAge = 24 * time.Hour
+
+// クライアントが持っているランキングデータからの差分
+// Top1000には追加または変更された行だけを入れ、Removedには無くなった行を入れる
+type RankingDelta struct {
+	BaseHash string                `json:"base_hash"`
+	Removed  []RankResponseRawData `json:"removed"`
+}
+
+// ランキングデータと正規化した条件のハッシュ
+// ETagと?known_hash=に使う。同じデータでも条件によって返す行や形式が変わるため条件も含める
+func rankingHash(rankingData []RankResponseRawData, query string) (string, error) {
+	b, err := json.Marshal(rankingData)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode ranking data: %v", err)
+	}
+	h := sha256.New()
+	h.Write([]byte(query))
+	h.Write([]byte{'\n'})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// If-None-Matchのいずれかのエンティティタグがhashと一致するか
+// 本文の比較ではないため弱いエンティティタグ（W/"..."）も一致とみなす
+func etagMatches(ifNoneMatch, hash string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		tag = strings.TrimPrefix(tag, "W/")
+		if strings.Trim(tag, `"`) == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// 差分の基準にできるようにランキングデータを保持
+func rememberRanking(hash string, rankingData []RankResponseRawData, now time.Time) {
+	if _, ok := rankingHistory.get(hash, rankingHistoryMaxAge, now); ok {
+		return
+	}
+	rankingHistory.set(hash, append([]RankResponseRawData(nil), rankingData...), now)
+}
+
+// 保持している過去のランキングデータを取得
+func previousRanking(hash string, now time.Time) ([]RankResponseRawData, bool) {
+	entry, ok := rankingHistory.get(hash, rankingHistoryMaxAge, now)
+	if !ok {
+		return nil, false
+	}
+	return entry.value.([]RankResponseRawData), true
+}
+
+// 過去のランキングデータからの変更を求める
+// 同じ内容の行は同じものとして扱うため、同率の行が複数あっても件数で比べる
+func diffRankingData(previous, current []RankResponseRawData) (changed, removed []RankResponseRawData) {
+	counts := map[RankResponseRawData]int{}
+	for _, row := range previous {
+		counts[row]++
+	}
+	changed = []RankResponseRawData{}
+	for _, row := range current {
+		if counts[row] > 0 {
+			counts[row]--
+			continue
+		}
+		changed = append(changed, row)
+	}
+	removed = []RankResponseRawData{}
+	for _, row := range previous {
+		if counts[row] > 0 {
+			counts[row]--
+			removed = append(removed, row)
+		}
+	}
+	return changed, removed
+}
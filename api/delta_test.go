@@ -0,0 +1,105 @@
+package Handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ETagは条件ごとに異なり、同じ条件なら同じになる
+func TestRankingETagDependsOnQuery(t *testing.T) {
+	newFakeUpstream(t)
+	etag := func(target string) string {
+		t.Helper()
+		rec := get(t, RankingHandler, target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d", target, rec.Code, http.StatusOK)
+		}
+		return rec.Header().Get("ETag")
+	}
+	base := etag("/rankings")
+
+	tests := []struct {
+		target   string
+		wantSame bool
+	}{
+		{target: "/rankings?_=1", wantSame: true},
+		{target: "/rankings?fill_gaps=false", wantSame: true},
+		{target: "/rankings?delta=true", wantSame: true},
+		{target: "/rankings?lng=1", wantSame: false},
+		{target: "/rankings?min_rating=1500", wantSame: false},
+		{target: "/rankings?from=1&to=10", wantSame: false},
+		{target: "/rankings?sample=10", wantSame: false},
+		{target: "/rankings?sort=name", wantSame: false},
+		{target: "/rankings?format=csv", wantSame: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := etag(tt.target); (got == base) != tt.wantSame {
+				t.Errorf("ETag = %s, base %s, want same %v", got, base, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestRankingNotModified(t *testing.T) {
+	newFakeUpstream(t)
+	plain := get(t, RankingHandler, "/rankings").Header().Get("ETag")
+	filtered := get(t, RankingHandler, "/rankings?lng=1").Header().Get("ETag")
+
+	tests := []struct {
+		name        string
+		target      string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{name: "same query", target: "/rankings", ifNoneMatch: plain, wantStatus: http.StatusNotModified},
+		{name: "known_hash", target: "/rankings?known_hash=" + plain[1:len(plain)-1], wantStatus: http.StatusNotModified},
+		{name: "delta with known_hash", target: "/rankings?delta=true&known_hash=" + plain[1:len(plain)-1], wantStatus: http.StatusNotModified},
+		{name: "filtered etag on another filter", target: "/rankings?lng=2", ifNoneMatch: filtered, wantStatus: http.StatusOK},
+		{name: "filtered etag on unfiltered", target: "/rankings", ifNoneMatch: filtered, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			RankingHandler(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRankingSinceTs1(t *testing.T) {
+	tests := []struct {
+		target           string
+		wantStatus       int
+		wantRankingCalls int32
+	}{
+		{target: "/rankings?since_ts1=1700000000", wantStatus: http.StatusNotModified, wantRankingCalls: 0},
+		{target: "/rankings?since_ts1=1699999999", wantStatus: http.StatusOK, wantRankingCalls: 1},
+		{target: "/rankings?since_ts1=", wantStatus: http.StatusOK, wantRankingCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("304 has a body: %s", rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK && len(ranking.Top1000) != 1000 {
+				t.Errorf("rows = %d, want 1000", len(ranking.Top1000))
+			}
+			if n := upstream.rankingCalls.Load(); n != tt.wantRankingCalls {
+				t.Errorf("ranking calls = %d, want %d", n, tt.wantRankingCalls)
+			}
+		})
+	}
+}
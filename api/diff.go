@@ -0,0 +1,181 @@
+package Handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 2つのランキングの間のトレーナーの出入りと順位の変動
+type RankingDiff struct {
+	// 新しくランキングに入ったトレーナー
+	Entered []RankingMovement `json:"entered"`
+	// ランキングから外れたトレーナー
+	Exited []RankingMovement `json:"exited"`
+	// 順位かレートが変わったトレーナー
+	Moved []RankingMovement `json:"moved"`
+}
+
+// トレーナーの前後の順位とレート
+// 前後のどちらかにいない場合はその側の値を含めない
+type RankingMovement struct {
+	Name           string   `json:"name"`
+	PreviousRank   int      `json:"previous_rank,omitempty"`
+	Rank           int      `json:"rank,omitempty"`
+	PreviousRating *float64 `json:"previous_rating,omitempty"`
+	RatingValue    *float64 `json:"rating_value,omitempty"`
+	// 順位が上がった場合は正の値。出入りしたトレーナーは0
+	RankDelta   int     `json:"rank_delta"`
+	RatingDelta float64 `json:"rating_delta"`
+}
+
+// 名前ごとの行を順位順に並べる
+func rowsByName(rankingData []RankResponseRawData) map[string][]RankResponseRawData {
+	result := map[string][]RankResponseRawData{}
+	for _, data := range rankingData {
+		result[data.Name] = append(result[data.Name], data)
+	}
+	for _, rows := range result {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Rank < rows[j].Rank })
+	}
+	return result
+}
+
+// prevからcurrへのトレーナーの出入りと順位の変動を求める
+// トレーナーは名前で対応させる。同じ名前が複数いる場合は前後それぞれの順位の高い順に組にし、余った分を出入りとする
+// 結果はそれぞれ順位順（外れたトレーナーは前の順位順）に並べる
+func DiffRankings(prev, curr []RankResponseRawData) RankingDiff {
+	diff := RankingDiff{Entered: []RankingMovement{}, Exited: []RankingMovement{}, Moved: []RankingMovement{}}
+	previous := rowsByName(prev)
+	current := rowsByName(curr)
+	for name, currRows := range current {
+		prevRows := previous[name]
+		for i, c := range currRows {
+			rating := c.RatingValue
+			if i >= len(prevRows) {
+				diff.Entered = append(diff.Entered, RankingMovement{Name: name, Rank: c.Rank, RatingValue: &rating})
+				continue
+			}
+			p := prevRows[i]
+			if p.Rank == c.Rank && p.RatingValue == c.RatingValue {
+				continue
+			}
+			previousRating := p.RatingValue
+			diff.Moved = append(diff.Moved, RankingMovement{
+				Name:           name,
+				PreviousRank:   p.Rank,
+				Rank:           c.Rank,
+				PreviousRating: &previousRating,
+				RatingValue:    &rating,
+				RankDelta:      p.Rank - c.Rank,
+				RatingDelta:    c.RatingValue - p.RatingValue,
+			})
+		}
+	}
+	for name, prevRows := range previous {
+		for _, p := range prevRows[min(len(prevRows), len(current[name])):] {
+			previousRating := p.RatingValue
+			diff.Exited = append(diff.Exited, RankingMovement{Name: name, PreviousRank: p.Rank, PreviousRating: &previousRating})
+		}
+	}
+	sort.SliceStable(diff.Entered, func(i, j int) bool { return diff.Entered[i].Rank < diff.Entered[j].Rank })
+	sort.SliceStable(diff.Moved, func(i, j int) bool { return diff.Moved[i].Rank < diff.Moved[j].Rank })
+	sort.SliceStable(diff.Exited, func(i, j int) bool { return diff.Exited[i].PreviousRank < diff.Exited[j].PreviousRank })
+	return diff
+}
+
+// 比べたランキングの時点
+type RankingDiffSide struct {
+	Timestamp  time.Time  `json:"timestamp"`
+	SeasonData SeasonData `json:"season_data"`
+}
+
+type RankingDiffResponse struct {
+	Previous RankingDiffSide `json:"previous"`
+	Current  RankingDiffSide `json:"current"`
+	RankingDiff
+}
+
+// endpoint handler
+func RankingDiffHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var season int
+	if v := r.URL.Query().Get("season"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid season parameter")
+			return
+		}
+		season = n
+	}
+	v := r.URL.Query().Get("prev_at")
+	if v == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing prev_at parameter")
+		return
+	}
+	prevAt, err := parseHistoryTime(v)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid prev_at parameter: %v", err))
+		return
+	}
+	var currAt *time.Time
+	if v := r.URL.Query().Get("curr_at"); v != "" {
+		t, err := parseHistoryTime(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid curr_at parameter: %v", err))
+			return
+		}
+		currAt = &t
+	}
+
+	previous, ok, err := nearestStoredSnapshot(r.Context(), Snapshots, season, prevAt)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Error loading snapshots: %v", err))
+		return
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, ErrSnapshotNotFound.Error())
+		return
+	}
+	// curr_atの指定がなければ現在のランキングと比べる
+	var current Snapshot
+	if currAt != nil {
+		current, ok, err = nearestStoredSnapshot(r.Context(), Snapshots, season, *currAt)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Error loading snapshots: %v", err))
+			return
+		}
+		// prev_atの後に間引かれた場合
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, ErrSnapshotNotFound.Error())
+			return
+		}
+	} else {
+		ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/diff")})
+		if err != nil {
+			writeJSONError(w, rankingErrorStatus(err), err.Error())
+			return
+		}
+		current = Snapshot{Timestamp: time.Now().UTC(), Ranking: ranking}
+	}
+
+	response := RankingDiffResponse{
+		Previous:    RankingDiffSide{Timestamp: previous.Timestamp, SeasonData: previous.Ranking.SeasonData},
+		Current:     RankingDiffSide{Timestamp: current.Timestamp, SeasonData: current.Ranking.SeasonData},
+		RankingDiff: DiffRankings(previous.Ranking.Top1000, current.Ranking.Top1000),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
@@ -0,0 +1,148 @@
+package Handler
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 名前と順位とレートを指定したランキングの行
+func diffRow(name string, rank int, rating float64) RankResponseRawData {
+	return RankResponseRawData{Name: name, Rank: rank, RatingValue: rating}
+}
+
+// 変動を読みやすい文字列にする
+func movementsString(movements []RankingMovement) string {
+	s := ""
+	for _, m := range movements {
+		var prev, curr float64
+		if m.PreviousRating != nil {
+			prev = *m.PreviousRating
+		}
+		if m.RatingValue != nil {
+			curr = *m.RatingValue
+		}
+		s += fmt.Sprintf("%s %d->%d (%v->%v, %+d, %+g) ", m.Name, m.PreviousRank, m.Rank, prev, curr, m.RankDelta, m.RatingDelta)
+	}
+	return s
+}
+
+func TestDiffRankings(t *testing.T) {
+	tests := []struct {
+		name        string
+		prev, curr  []RankResponseRawData
+		wantEntered string
+		wantExited  string
+		wantMoved   string
+	}{
+		{
+			name:      "all categories",
+			prev:      []RankResponseRawData{diffRow("alice", 1, 2000), diffRow("bob", 2, 1990), diffRow("carol", 3, 1980), diffRow("dave", 4, 1970)},
+			curr:      []RankResponseRawData{diffRow("bob", 1, 2005), diffRow("alice", 2, 2000), diffRow("carol", 3, 1980), diffRow("erin", 4, 1975)},
+			wantMoved: "bob 2->1 (1990->2005, +1, +15) alice 1->2 (2000->2000, -1, +0) ", wantEntered: "erin 0->4 (0->1975, +0, +0) ", wantExited: "dave 4->0 (1970->0, +0, +0) ",
+		},
+		{
+			name:      "rating only",
+			prev:      []RankResponseRawData{diffRow("alice", 1, 2000)},
+			curr:      []RankResponseRawData{diffRow("alice", 1, 1995)},
+			wantMoved: "alice 1->1 (2000->1995, +0, -5) ",
+		},
+		// 同じ名前は前後それぞれの順位の高い順に組にし、余った分を出入りとする
+		{
+			name:       "duplicate names exit",
+			prev:       []RankResponseRawData{diffRow("x", 5, 1900), diffRow("x", 2, 1950)},
+			curr:       []RankResponseRawData{diffRow("x", 3, 1940)},
+			wantMoved:  "x 2->3 (1950->1940, -1, -10) ",
+			wantExited: "x 5->0 (1900->0, +0, +0) ",
+		},
+		{
+			name:        "duplicate names enter",
+			prev:        []RankResponseRawData{diffRow("x", 2, 1950)},
+			curr:        []RankResponseRawData{diffRow("x", 4, 1930), diffRow("x", 2, 1950)},
+			wantEntered: "x 0->4 (0->1930, +0, +0) ",
+		},
+		{name: "empty", prev: nil, curr: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffRankings(tt.prev, tt.curr)
+			if diff.Entered == nil || diff.Exited == nil || diff.Moved == nil {
+				t.Fatalf("diff = %+v, want empty arrays instead of nil", diff)
+			}
+			if got := movementsString(diff.Entered); got != tt.wantEntered {
+				t.Errorf("entered = %s, want %s", got, tt.wantEntered)
+			}
+			if got := movementsString(diff.Exited); got != tt.wantExited {
+				t.Errorf("exited = %s, want %s", got, tt.wantExited)
+			}
+			if got := movementsString(diff.Moved); got != tt.wantMoved {
+				t.Errorf("moved = %s, want %s", got, tt.wantMoved)
+			}
+		})
+	}
+}
+
+func TestRankingDiffHandler(t *testing.T) {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	previous := Snapshot{Timestamp: base, Ranking: RankingResponse{
+		SeasonData: SeasonData{Season: 1},
+		Top1000:    []RankResponseRawData{diffRow("trainer1", 1, 2099), diffRow("trainer2", 2, 2098), diffRow("gone", 3, 2097)},
+	}}
+	current := Snapshot{Timestamp: base.Add(2 * time.Hour), Ranking: RankingResponse{
+		SeasonData: SeasonData{Season: 1},
+		Top1000:    []RankResponseRawData{diffRow("trainer2", 1, 2100), diffRow("trainer1", 2, 2099), diffRow("new", 3, 2090)},
+	}}
+	other := Snapshot{Timestamp: base.Add(time.Hour), Ranking: RankingResponse{SeasonData: SeasonData{Season: 2}, Top1000: fixtureRows(1, 3)}}
+
+	tests := []struct {
+		target       string
+		wantStatus   int
+		wantEntered  int
+		wantExited   int
+		wantMoved    int
+		wantPrevious time.Time
+		wantCurrent  time.Time
+	}{
+		{target: "/rankings/diff?prev_at=2024-05-01T00:00:00Z&curr_at=2024-05-01T02:00:00Z", wantStatus: http.StatusOK, wantEntered: 1, wantExited: 1, wantMoved: 2, wantPrevious: previous.Timestamp, wantCurrent: current.Timestamp},
+		// 日時はタイムゾーンが無ければ日本時間として読み、最も近いスナップショットを使う
+		{target: "/rankings/diff?prev_at=2024-05-01T09:10&curr_at=2024-05-01T10:50&season=1", wantStatus: http.StatusOK, wantEntered: 1, wantExited: 1, wantMoved: 2, wantPrevious: previous.Timestamp, wantCurrent: current.Timestamp},
+		// 最も近いスナップショットのシーズンが違えば、シーズンが一致するものの中で最も近いものを使う
+		{target: "/rankings/diff?prev_at=2024-05-01T01:00:00Z&curr_at=2024-05-01T01:10:00Z&season=1", wantStatus: http.StatusOK, wantEntered: 1, wantExited: 1, wantMoved: 2, wantPrevious: previous.Timestamp, wantCurrent: current.Timestamp},
+		// curr_atが無ければ現在のランキングと比べる
+		{target: "/rankings/diff?prev_at=2024-05-01T00:00:00Z&season=1", wantStatus: http.StatusOK, wantEntered: 998, wantExited: 1, wantPrevious: previous.Timestamp},
+		{target: "/rankings/diff?prev_at=2024-05-01T00:00:00Z&curr_at=2024-05-01T02:00:00Z&season=3", wantStatus: http.StatusNotFound},
+		{target: "/rankings/diff", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/diff?prev_at=yesterday", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/diff?prev_at=2024-05-01&curr_at=x", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/diff?prev_at=2024-05-01&season=x", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			newFakeUpstream(t)
+			store := withSnapshots(t, previous, other, current)
+			rec := get(t, RankingDiffHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response RankingDiffResponse
+			decodeBody(t, rec, &response)
+			if len(response.Entered) != tt.wantEntered || len(response.Exited) != tt.wantExited || len(response.Moved) != tt.wantMoved {
+				t.Errorf("got %d entered, %d exited and %d moved, want %d, %d and %d", len(response.Entered), len(response.Exited), len(response.Moved), tt.wantEntered, tt.wantExited, tt.wantMoved)
+			}
+			if !response.Previous.Timestamp.Equal(tt.wantPrevious) {
+				t.Errorf("previous timestamp = %v, want %v", response.Previous.Timestamp, tt.wantPrevious)
+			}
+			if !tt.wantCurrent.IsZero() && !response.Current.Timestamp.Equal(tt.wantCurrent) {
+				t.Errorf("current timestamp = %v, want %v", response.Current.Timestamp, tt.wantCurrent)
+			}
+			// 比べる2件のスナップショットしか読み込まない
+			if n := store.gets.Load(); n > 2 {
+				t.Errorf("loaded %d snapshots, want at most 2", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package Handler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// ロケールごとの桁区切りと小数点の記号
+type numberSeparators struct {
+	group   string
+	decimal string
+}
+
+// 指定がない場合のロケール
+const defaultDisplayLocale = "en"
+
+var localeSeparators = map[string]numberSeparators{
+	"en": {group: ",", decimal: "."},
+	"ja": {group: ",", decimal: "."},
+	"ko": {group: ",", decimal: "."},
+	"zh": {group: ",", decimal: "."},
+	"de": {group: ".", decimal: ","},
+	"es": {group: ".", decimal: ","},
+	"it": {group: ".", decimal: ","},
+	"fr": {group: " ", decimal: ","},
+}
+
+// ロケールの区切り記号を取得
+// en-US のように地域が付いている場合は言語のみで探す
+func separatorsFor(locale string) (numberSeparators, error) {
+	if locale == "" {
+		locale = defaultDisplayLocale
+	}
+	lang, _, _ := strings.Cut(strings.ToLower(strings.ReplaceAll(locale, "_", "-")), "-")
+	separators, ok := localeSeparators[lang]
+	if !ok {
+		return numberSeparators{}, fmt.Errorf("unsupported locale %q", locale)
+	}
+	return separators, nil
+}
+
+// レートを桁区切り付きの文字列にする
+func formatRating(value float64, separators numberSeparators) string {
+	s := strconv.FormatFloat(value, 'f', -1, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	integer, fraction, hasFraction := strings.Cut(s, ".")
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, c := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteString(separators.group)
+		}
+		b.WriteRune(c)
+	}
+	if hasFraction {
+		b.WriteString(separators.decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
+
+// ランキングデータに表示用のレートを付ける
+func addRatingDisplay(rankingData []RankResponseRawData, separators numberSeparators) {
+	for i := range rankingData {
+		rankingData[i].RatingDisplay = formatRating(rankingData[i].RatingValue, separators)
+	}
+}
+
+// /rankingsのsortに指定できる並び順
+const (
+	rankingSortRank   = "rank"
+	rankingSortRating = "rating"
+	rankingSortName   = "name"
+	rankingSortLng    = "lng"
+)
+
+// /rankingsのorderに指定できる向き
+const (
+	rankingOrderAsc  = "asc"
+	rankingOrderDesc = "desc"
+)
+
+// orderの指定がない場合の向き
+// レートは高い順、それ以外は昇順
+func defaultRankingOrder(sortBy string) string {
+	if sortBy == rankingSortRating {
+		return rankingOrderDesc
+	}
+	return rankingOrderAsc
+}
+
+// sortByの項目で並べ替える。各行の順位はそのまま
+// レートは同じレートをtagの照合順序で名前順、名前も同じなら元の順位順にする
+// 名前はtagの照合順序で比べる。名前と言語が同じ行は元の並びのまま
+func sortRankingData(rankingData []RankResponseRawData, sortBy string, desc bool, tag language.Tag) {
+	switch sortBy {
+	case rankingSortRating:
+		sortByRating(rankingData, tag, desc)
+	case rankingSortName:
+		collator := collate.New(tag)
+		sort.SliceStable(rankingData, func(i, j int) bool {
+			c := collator.CompareString(rankingData[i].Name, rankingData[j].Name)
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		})
+	case rankingSortLng:
+		sort.SliceStable(rankingData, func(i, j int) bool {
+			if desc {
+				return rankingData[i].Lng > rankingData[j].Lng
+			}
+			return rankingData[i].Lng < rankingData[j].Lng
+		})
+	case rankingSortRank:
+		// 取得したデータは順位順のため、昇順なら並べ替えない
+		if desc {
+			sort.SliceStable(rankingData, func(i, j int) bool { return rankingData[i].Rank > rankingData[j].Rank })
+		}
+	}
+}
+
+// 名前の照合順序に使うロケール
+func collationTag(locale string) (language.Tag, error) {
+	if locale == "" {
+		locale = defaultDisplayLocale
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return language.Und, fmt.Errorf("unsupported locale %q", locale)
+	}
+	return tag, nil
+}
+
+// レートの高い順（descがfalseなら低い順）に並べる
+// 同じレートはtagの照合順序で名前順、名前も同じなら元の順位順にして、同率の行の順序が取得ごとに変わらないようにする
+func sortByRating(rankingData []RankResponseRawData, tag language.Tag, desc bool) {
+	// Collatorは並行して使えないため呼び出しごとに作る
+	collator := collate.New(tag)
+	sort.SliceStable(rankingData, func(i, j int) bool {
+		a, b := rankingData[i], rankingData[j]
+		if a.RatingValue != b.RatingValue {
+			return (a.RatingValue > b.RatingValue) == desc
+		}
+		if c := collator.CompareString(a.Name, b.Name); c != 0 {
+			return c < 0
+		}
+		return a.Rank < b.Rank
+	})
+}
+
+// /rankingsのtiesに指定できる同率の行の並び順
+// rankは上流の順位順で、同じ順位は上流の並びのまま
+const (
+	rankingTiesRank = "rank"
+	rankingTiesName = "name"
+)
+
+// 順位順に並んだランキングの同じレートが続く行を、tagの照合順序で名前順、名前も同じなら元の順位順に並べる
+func sortTiesByName(rankingData []RankResponseRawData, tag language.Tag) {
+	collator := collate.New(tag)
+	for start := 0; start < len(rankingData); {
+		end := start + 1
+		for end < len(rankingData) && rankingData[end].RatingValue == rankingData[start].RatingValue {
+			end++
+		}
+		ties := rankingData[start:end]
+		sort.SliceStable(ties, func(i, j int) bool {
+			if c := collator.CompareString(ties[i].Name, ties[j].Name); c != 0 {
+				return c < 0
+			}
+			return ties[i].Rank < ties[j].Rank
+		})
+		start = end
+	}
+}
+
+// 並び順のままレートが変わるごとに1つずつ上がる順位（同率は同じ順位で、次の順位は飛ばない）を付け直す
+// 元の順位から変わった行があればtrueを返す
+func denseRanks(rankingData []RankResponseRawData) bool {
+	adjusted := false
+	rank := 0
+	for i := range rankingData {
+		if i == 0 || rankingData[i].RatingValue != rankingData[i-1].RatingValue {
+			rank++
+		}
+		if rankingData[i].Rank != rank {
+			rankingData[i].Rank = rank
+			adjusted = true
+		}
+	}
+	return adjusted
+}
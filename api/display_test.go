@@ -0,0 +1,278 @@
+package Handler
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestFormatRating(t *testing.T) {
+	tests := []struct {
+		value  float64
+		locale string
+		want   string
+	}{
+		{value: 1847, locale: "en", want: "1,847"},
+		{value: 1847.5, locale: "en-US", want: "1,847.5"},
+		{value: 1847.5, locale: "de", want: "1.847,5"},
+		{value: 1847.5, locale: "fr_FR", want: "1\u202f847,5"},
+		{value: 1234567, locale: "ja", want: "1,234,567"},
+		{value: 999, locale: "en", want: "999"},
+		{value: -1847, locale: "en", want: "-1,847"},
+		{value: 1847, locale: "", want: "1,847"},
+	}
+	for _, tt := range tests {
+		separators, err := separatorsFor(tt.locale)
+		if err != nil {
+			t.Errorf("separatorsFor(%q): %v", tt.locale, err)
+			continue
+		}
+		if got := formatRating(tt.value, separators); got != tt.want {
+			t.Errorf("formatRating(%v, %q) = %q, want %q", tt.value, tt.locale, got, tt.want)
+		}
+	}
+	if _, err := separatorsFor("xx"); err == nil {
+		t.Error("separatorsFor(\"xx\") succeeded, want error")
+	}
+}
+
+func TestRankingRatingDisplay(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       string
+	}{
+		{target: "/rankings?rating_display=true", wantStatus: http.StatusOK, want: "2,099"},
+		{target: "/rankings?rating_display=true&locale=de-DE", wantStatus: http.StatusOK, want: "2.099"},
+		{target: "/rankings?rating_display=true&locale=fr", wantStatus: http.StatusOK, want: "2\u202f099"},
+		{target: "/rankings", wantStatus: http.StatusOK, want: ""},
+		{target: "/rankings?rating_display=true&locale=xx", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			newFakeUpstream(t)
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			first := ranking.Top1000[0]
+			if first.RatingDisplay != tt.want {
+				t.Errorf("rating_display = %q, want %q", first.RatingDisplay, tt.want)
+			}
+			// 数値のレートも残す
+			if first.RatingValue != 2099 {
+				t.Errorf("rating = %v, want 2099", first.RatingValue)
+			}
+		})
+	}
+}
+
+// 同じレートの行を含むランキング
+// 2000は5人が同率で、aliceは2人いる
+func tiedRatingRows() []RankResponseRawData {
+	rows := fixtureRows(1, 7)
+	names := []string{"zz", "bob", "alice", "Özil", "zoe", "alice", "aa"}
+	ratings := []float64{2010, 2000, 2000, 2000, 2000, 2000, 1990}
+	for i := range rows {
+		rows[i].Name, rows[i].RatingValue = names[i], ratings[i]
+	}
+	return rows
+}
+
+// 行の名前と元の順位
+func namesAndRanks(rows []RankResponseRawData) string {
+	s := ""
+	for _, row := range rows {
+		s += fmt.Sprintf("%s/%d ", row.Name, row.Rank)
+	}
+	return s
+}
+
+// 同率の行は名前の照合順序、名前も同じなら元の順位順になり、元の並びにかかわらず同じ順序になる
+func TestSortByRatingTies(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  language.Tag
+		desc bool
+		want string
+	}{
+		{name: "desc", tag: language.Japanese, desc: true, want: "zz/1 alice/3 alice/6 bob/2 Özil/4 zoe/5 aa/7 "},
+		{name: "asc", tag: language.Japanese, want: "aa/7 alice/3 alice/6 bob/2 Özil/4 zoe/5 zz/1 "},
+		// スウェーデン語ではÖはZの後
+		{name: "swedish", tag: language.Swedish, desc: true, want: "zz/1 alice/3 alice/6 bob/2 zoe/5 Özil/4 aa/7 "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			for run := 0; run < 20; run++ {
+				rows := tiedRatingRows()
+				r.Shuffle(len(rows), func(i, j int) { rows[i], rows[j] = rows[j], rows[i] })
+				sortRankingData(rows, rankingSortRating, tt.desc, tt.tag)
+				if got := namesAndRanks(rows); got != tt.want {
+					t.Fatalf("run %d: got %s, want %s", run, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRankingSortRatingTies(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "/rankings?sort=rating", want: "zz/1 alice/3 alice/6 bob/2 Özil/4 zoe/5 aa/7 "},
+		{target: "/rankings?sort=rating&order=asc", want: "aa/7 alice/3 alice/6 bob/2 Özil/4 zoe/5 zz/1 "},
+		{target: "/rankings?sort=rating&locale=sv", want: "zz/1 alice/3 alice/6 bob/2 zoe/5 Özil/4 aa/7 "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			rows := tiedRatingRows()
+			for i := range rows {
+				rows[i].RatingValue *= 1000
+			}
+			// 上流が同率の行を順位順に並べていなくても同じ順序で返す
+			rows[2], rows[5] = rows[5], rows[2]
+			upstream.setPage(upstream.seasons["1"]["10001"], 1, rows)
+
+			for run := 0; run < 2; run++ {
+				rec, ranking := getRanking(t, tt.target)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+				}
+				if got := namesAndRanks(ranking.Top1000); got != tt.want {
+					t.Errorf("run %d: got %s, want %s", run, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// 3人が同じレートで、上流の順位が重なったり飛んだりしている
+func sharedRatingRows() []RankResponseRawData {
+	rows := fixtureRows(1, 5)
+	for i, row := range []struct {
+		rank   int
+		name   string
+		rating float64
+	}{
+		{1, "alpha", 2100}, {2, "carol", 2050}, {2, "alice", 2050}, {4, "bob", 2050}, {5, "dave", 2000},
+	} {
+		rows[i].Rank, rows[i].Name, rows[i].RatingValue = row.rank, row.name, row.rating*1000
+	}
+	return rows
+}
+
+func TestRankingTiesAndDenseRank(t *testing.T) {
+	tests := []struct {
+		target       string
+		wantStatus   int
+		want         string
+		wantAdjusted bool
+	}{
+		{target: "/rankings", wantStatus: http.StatusOK, want: "alpha/1 carol/2 alice/2 bob/4 dave/5 "},
+		{target: "/rankings?ties=rank", wantStatus: http.StatusOK, want: "alpha/1 carol/2 alice/2 bob/4 dave/5 "},
+		{target: "/rankings?ties=name", wantStatus: http.StatusOK, want: "alpha/1 alice/2 bob/4 carol/2 dave/5 "},
+		{target: "/rankings?dense_rank=true", wantStatus: http.StatusOK, want: "alpha/1 carol/2 alice/2 bob/2 dave/3 ", wantAdjusted: true},
+		{target: "/rankings?ties=name&dense_rank=true", wantStatus: http.StatusOK, want: "alpha/1 alice/2 bob/2 carol/2 dave/3 ", wantAdjusted: true},
+		{target: "/rankings?ties=rating", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?dense_rank=true&fill_gaps=true", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.setPage(upstream.seasons["1"]["10001"], 1, sharedRatingRows())
+
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := namesAndRanks(ranking.Top1000); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if ranking.RanksAdjusted != tt.wantAdjusted {
+				t.Errorf("ranks_adjusted = %v, want %v", ranking.RanksAdjusted, tt.wantAdjusted)
+			}
+		})
+	}
+}
+
+// 同率も重なりも無ければ付け直しても順位は変わらない
+func TestDenseRanksUnchanged(t *testing.T) {
+	rows := fixtureRows(1, 10)
+	if denseRanks(rows) {
+		t.Error("denseRanks adjusted ranks without ties")
+	}
+	if got := ranksOf(rows); !equalInts(got, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) {
+		t.Errorf("ranks = %v, want 1 to 10", got)
+	}
+}
+
+// 名前・言語・レートが順位の順に並んでいないランキング
+func unsortedRows() []RankResponseRawData {
+	rows := fixtureRows(1, 6)
+	for i, row := range []struct {
+		name   string
+		lng    string
+		rating float64
+	}{
+		{"carol", "2", 2000}, {"alice", "1", 2050}, {"bob", "2", 1990}, {"Dave", "1", 2100}, {"alice", "8", 1980}, {"erin", "1", 2010},
+	} {
+		rows[i].Name, rows[i].Lng, rows[i].RatingValue = row.name, row.lng, row.rating*1000
+	}
+	return rows
+}
+
+// 並べ替えても各行の順位は元のまま
+func TestRankingSort(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       string
+	}{
+		{target: "/rankings", wantStatus: http.StatusOK, want: "carol/1 alice/2 bob/3 Dave/4 alice/5 erin/6 "},
+		{target: "/rankings?sort=rank&order=desc", wantStatus: http.StatusOK, want: "erin/6 alice/5 Dave/4 bob/3 alice/2 carol/1 "},
+		{target: "/rankings?sort=name", wantStatus: http.StatusOK, want: "alice/2 alice/5 bob/3 carol/1 Dave/4 erin/6 "},
+		{target: "/rankings?sort=name&order=desc", wantStatus: http.StatusOK, want: "erin/6 Dave/4 carol/1 bob/3 alice/2 alice/5 "},
+		{target: "/rankings?sort=lng", wantStatus: http.StatusOK, want: "alice/2 Dave/4 erin/6 carol/1 bob/3 alice/5 "},
+		{target: "/rankings?sort=lng&order=desc", wantStatus: http.StatusOK, want: "alice/5 carol/1 bob/3 alice/2 Dave/4 erin/6 "},
+		{target: "/rankings?sort=rating", wantStatus: http.StatusOK, want: "Dave/4 alice/2 erin/6 carol/1 bob/3 alice/5 "},
+		{target: "/rankings?sort=rating&order=asc", wantStatus: http.StatusOK, want: "alice/5 bob/3 carol/1 erin/6 alice/2 Dave/4 "},
+		{target: "/rankings?sort=name&lng=1", wantStatus: http.StatusOK, want: "alice/2 Dave/4 erin/6 "},
+		{target: "/rankings?sort=x", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?sort=Name", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?order=up", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.setPage(upstream.seasons["1"]["10001"], 1, unsortedRows())
+
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var body ErrorResponse
+				decodeBody(t, rec, &body)
+				if body.Status != tt.wantStatus || body.Error == "" {
+					t.Errorf("body = %+v, want a JSON error", body)
+				}
+				return
+			}
+			if got := namesAndRanks(ranking.Top1000); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package Handler
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// エンコード済みのランキングの行のキャッシュ
+// キーは元のデータの版と正規化した条件とgzipで圧縮するかで、CacheMaxEntriesを超えたら最も長く使われていないものから破棄する
+type encodedCache struct {
+	mu      sync.Mutex
+	entries *ttlCache
+}
+
+type encodedEntry struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+func newEncodedCache(maxEntries int) *encodedCache {
+	return &encodedCache{entries: newTTLCache(maxEntries)}
+}
+
+var rankingEncodedCache = newEncodedCache(CacheMaxEntries)
+
+// 版とキーに対応するエンコード済みのデータを取得
+// 版ごとに別のエントリにするため、有効期間は見ない
+// 同時に同じキーで呼ばれてもencodeは1回しか実行しない
+func (c *encodedCache) get(version, key string, encode func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	var entry *encodedEntry
+	cached, ok := c.entries.getStale(version + "|" + key)
+	if ok {
+		entry = cached.value.(*encodedEntry)
+	} else {
+		entry = &encodedEntry{}
+		c.entries.set(version+"|"+key, entry, time.Now())
+	}
+	c.mu.Unlock()
+	observeCache("encoded", ok)
+
+	entry.once.Do(func() {
+		entry.data, entry.err = encode()
+	})
+	return entry.data, entry.err
+}
+
+// レスポンスの内容に関係する/rankingsのパラメータ
+// 差分（delta、known_hash）、取得時間（include_timing）、since_ts1は同じ条件でもリクエストごとに結果が変わるため含めない
+var rankingRepresentationParams = []string{
+	"avg_top", "dense_rank", "depth", "fill_gaps", "from", "include_source", "lang", "lng", "locale",
+	"min_rating", "order", "rating_display", "rst", "rule", "sample", "season", "soft", "sort", "strict", "ties", "to", "top",
+}
+
+// "true"のときだけ意味のある真偽値のパラメータ
+var rankingBoolParams = map[string]bool{
+	"dense_rank": true, "fill_gaps": true, "include_source": true, "rating_display": true, "strict": true,
+}
+
+// キャッシュのキーやETagに使う正規化した条件
+// 知らないパラメータや真偽値の書き方の違いで別の条件にならないように、関係するパラメータだけを名前順に並べる
+func normalizedRankingQuery(query url.Values, format string) string {
+	normalized := url.Values{"format": {format}}
+	for _, name := range rankingRepresentationParams {
+		v := query.Get(name)
+		if v == "" || rankingBoolParams[name] && v != "true" {
+			continue
+		}
+		normalized.Set(name, v)
+	}
+	return normalized.Encode()
+}
+
+// 行だけをエンコード済みのものに差し替えたレスポンス
+// 残り時間などリクエストごとに変わる値はキャッシュせずに毎回エンコードする
+type encodedRankingResponse struct {
+	RankingResponse
+	Top1000 json.RawMessage `json:"top_1000"`
+}
+
+// 行の位置に置いておく値
+var encodedRowsPlaceholder = []byte(`"top_1000":0`)
+
+// 行を除いたレスポンスをエンコードし、行の前と後の部分に分ける
+// bodyはencodedRankingResponseか、それを包んだものでTop1000を空にしておく
+func encodeAroundRows(body interface{}) (prefix, suffix []byte, err error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 文字列の中の"はエスケープされるため、キー以外で一致することはない
+	i := bytes.Index(data, encodedRowsPlaceholder)
+	if i < 0 {
+		return nil, nil, fmt.Errorf("response has no top_1000 field")
+	}
+	i += len(encodedRowsPlaceholder) - 1
+	// json.Encoderと同じく末尾に改行を付ける
+	return data[:i], append(data[i+1:], '\n'), nil
+}
+
+// 前後の部分とエンコード済みの行を書き込む
+// gzipを受け付けるクライアントには、大きさがCompressionMinSize以上ならキャッシュした圧縮済みの行を使ってgzipで返す
+func writeEncodedRanking(w http.ResponseWriter, r *http.Request, version, key string, prefix, suffix []byte, encode func() ([]byte, error)) error {
+	rows, err := rankingEncodedCache.get(version, key, encode)
+	if err != nil {
+		return err
+	}
+	if !acceptsGzip(r) || w.Header().Get("Content-Encoding") != "" || len(prefix)+len(rows)+len(suffix) < CompressionMinSize {
+		for _, data := range [][]byte{prefix, rows, suffix} {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	deflated, err := rankingEncodedCache.get(version, key+"|gzip", func() ([]byte, error) {
+		return deflateBlock(rows, false)
+	})
+	if err != nil {
+		return err
+	}
+	head, err := deflateBlock(prefix, false)
+	if err != nil {
+		return err
+	}
+	tail, err := deflateBlock(suffix, true)
+	if err != nil {
+		return err
+	}
+	crc := crc32.ChecksumIEEE(prefix)
+	crc = crc32.Update(crc, crc32.IEEETable, rows)
+	crc = crc32.Update(crc, crc32.IEEETable, suffix)
+	size := uint32(len(prefix) + len(rows) + len(suffix))
+
+	// 圧縮したことをgzipのミドルウェアに伝え、二重に圧縮されないようにする
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Encoding", "gzip")
+	// 更新時刻なし、OS不明のgzipのヘッダー
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff}
+	trailer := binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, crc), size)
+	for _, data := range [][]byte{header, head, deflated, tail, trailer} {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataをdeflateで圧縮する
+// 最後でなければ同期フラッシュで終え、後ろに別に圧縮したブロックを続けられるようにする
+func deflateBlock(data []byte, last bool) ([]byte, error) {
+	var buf bytes.Buffer
+	fw := deflateWriters.Get().(*flate.Writer)
+	defer deflateWriters.Put(fw)
+	fw.Reset(&buf)
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	var err error
+	if last {
+		err = fw.Close()
+	} else {
+		err = fw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 圧縮器は作るたびに大きなメモリを確保するため使い回す
+var deflateWriters = sync.Pool{New: func() interface{} {
+	fw, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+	return fw
+}}
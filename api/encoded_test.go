@@ -0,0 +1,200 @@
+package Handler
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestNormalizedRankingQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		format string
+		want   string
+	}{
+		{name: "empty", query: "", format: "json", want: "format=json"},
+		{name: "sorted", query: "sort=rating&lng=1", format: "json", want: "format=json&lng=1&sort=rating"},
+		{name: "unknown params", query: "_=123&cachebuster=x&sample=10", format: "json", want: "format=json&sample=10"},
+		{name: "false booleans", query: "fill_gaps=TRUE&dense_rank=false&include_source=true", format: "json", want: "format=json&include_source=true"},
+		{name: "per request params", query: "delta=true&known_hash=abc&include_timing=true&since_ts1=1", format: "json", want: "format=json"},
+		{name: "format", query: "top=5", format: "csv", want: "format=csv&top=5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := normalizedRankingQuery(query, tt.format); got != tt.want {
+				t.Errorf("normalizedRankingQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+// 上限を超えたら最も長く使われていないものから破棄する
+func TestEncodedCacheIsBounded(t *testing.T) {
+	cache := newEncodedCache(2)
+	encodes := 0
+	get := func(version, key string) {
+		cache.get(version, key, func() ([]byte, error) {
+			encodes++
+			return []byte(key), nil
+		})
+	}
+	get("v1", "a")
+	get("v1", "b")
+	get("v2", "a")
+	if encodes != 3 {
+		t.Fatalf("encodes = %d, want 3", encodes)
+	}
+	// 版の違うエントリは破棄し合わない
+	get("v2", "a")
+	get("v1", "b")
+	if encodes != 3 {
+		t.Errorf("encodes = %d, want 3", encodes)
+	}
+	// 最も長く使われていないv1/aは破棄されている
+	get("v1", "a")
+	if encodes != 4 {
+		t.Errorf("encodes = %d, want 4", encodes)
+	}
+}
+
+// エンコード済みのものを使い回すのは行だけで、残り時間などはリクエストごとに求める
+func TestRankingHandlerCachesOnlyRows(t *testing.T) {
+	newFakeUpstream(t)
+
+	tests := []struct {
+		target      string
+		wantEntries int
+	}{
+		{target: "/rankings", wantEntries: 1},
+		{target: "/rankings?_=1", wantEntries: 1},
+		{target: "/rankings?include_timing=true", wantEntries: 1},
+		{target: "/rankings?sample=10", wantEntries: 2},
+		{target: "/rankings?delta=true", wantEntries: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, RankingHandler, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			var response RankingResponse
+			decodeBody(t, rec, &response)
+			if response.RemainingSeconds <= 0 || len(response.Top1000) == 0 {
+				t.Errorf("response has remaining_seconds %d and %d rows", response.RemainingSeconds, len(response.Top1000))
+			}
+			if n := rankingEncodedCache.entries.order.Len(); n != tt.wantEntries {
+				t.Errorf("entries = %d, want %d", n, tt.wantEntries)
+			}
+		})
+	}
+
+	// キャッシュしたものは行の配列
+	for elem := rankingEncodedCache.entries.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(cacheEntry).value.(*encodedEntry)
+		var rows []RankResponseRawData
+		if err := json.Unmarshal(entry.data, &rows); err != nil {
+			t.Errorf("cached entry is not a list of rows: %v", err)
+		}
+	}
+}
+
+// 同じ条件のリクエストが同時に来ても、形式とgzipで圧縮するかごとにエンコードは1回だけ
+func TestRankingHandlerEncodesOnce(t *testing.T) {
+	newFakeUpstream(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux, "")
+	const misses = `rankbattle_cache_requests_total{cache="encoded",result="miss"}`
+	before := scrapeMetric(t, mux, misses)
+
+	tests := []struct {
+		target         string
+		acceptEncoding string
+	}{
+		{target: "/rankings"},
+		{target: "/rankings", acceptEncoding: "gzip"},
+		{target: "/rankings?format=csv"},
+		{target: "/rankings?format=csv", acceptEncoding: "gzip"},
+	}
+	const requests = 20
+	bodies := make([][]byte, len(tests)*requests)
+	var wg sync.WaitGroup
+	for i := range bodies {
+		tt := tests[i%len(tests)]
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s status = %d, want %d", tt.target, rec.Code, http.StatusOK)
+				return
+			}
+			if gotGzip := rec.Header().Get("Content-Encoding") == "gzip"; gotGzip != (tt.acceptEncoding != "") {
+				t.Errorf("%s gzip = %v, want %v", tt.target, gotGzip, tt.acceptEncoding != "")
+				return
+			}
+			if tt.acceptEncoding == "" {
+				bodies[i] = rec.Body.Bytes()
+				return
+			}
+			// 前後と行を別に圧縮しても1つのgzipのストリームになる
+			gz, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			gz.Multistream(false)
+			body, err := io.ReadAll(gz)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("%s has %d bytes after the gzip stream", tt.target, rec.Body.Len())
+			}
+			bodies[i] = body
+		}(i)
+	}
+	wg.Wait()
+
+	if got := scrapeMetric(t, mux, misses) - before; got != float64(len(tests)) {
+		t.Errorf("encodes = %v, want %d", got, len(tests))
+	}
+	// 圧縮してもしなくても、どのリクエストでも同じ行になる
+	rows := func(i int) string {
+		if i%len(tests) >= 2 {
+			return string(bodies[i])
+		}
+		// 残り時間はリクエストごとに変わるため行だけを比べる
+		var response struct {
+			Top1000 json.RawMessage `json:"top_1000"`
+		}
+		if err := json.Unmarshal(bodies[i], &response); err != nil {
+			t.Fatalf("failed to decode response %q: %v", bodies[i], err)
+		}
+		return string(response.Top1000)
+	}
+	for i := range bodies {
+		if want := 2 * (i % len(tests) / 2); rows(i) != rows(want) {
+			t.Errorf("%s (Accept-Encoding %q) rows differ from the plain response", tests[i%len(tests)].target, tests[i%len(tests)].acceptEncoding)
+		}
+	}
+	var ranking RankingResponse
+	if err := json.Unmarshal(bodies[1], &ranking); err != nil || len(ranking.Top1000) != 1000 {
+		t.Errorf("gzip body has %d rows (%v), want 1000", len(ranking.Top1000), err)
+	}
+}
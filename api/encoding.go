@@ -0,0 +1,135 @@
+package Handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// 上流のレスポンスがUTF-8でなかった場合に変換を試みる文字コード
+// 空の場合は変換せずにエラーにする
+var UpstreamFallbackEncoding = os.Getenv("UPSTREAM_FALLBACK_ENCODING")
+
+// UPSTREAM_FALLBACK_ENCODINGに指定できる文字コード
+var fallbackEncodings = map[string]encoding.Encoding{
+	"shift_jis": japanese.ShiftJIS,
+	"euc-jp":    japanese.EUCJP,
+}
+
+// 上流のレスポンスをUTF-8として読める形にしたバイト列を返す
+// 先頭のBOMと空白を読み飛ばし、UTF-8として不正なバイト列を含む場合はUpstreamFallbackEncodingから変換する
+// 読み込みにはbufを使うため、返したバイト列はbufを再利用するまでしか使えない
+func readUpstreamBody(r io.Reader, buf *bytes.Buffer) ([]byte, error) {
+	if _, err := buf.ReadFrom(skipLeadingBOM(r)); err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	body := buf.Bytes()
+	if utf8.Valid(body) {
+		return body, nil
+	}
+
+	if UpstreamFallbackEncoding == "" {
+		return nil, fmt.Errorf("%w: response body is not valid UTF-8", ErrDecode)
+	}
+	enc, ok := fallbackEncodings[UpstreamFallbackEncoding]
+	if !ok {
+		return nil, fmt.Errorf("response body is not valid UTF-8 and UPSTREAM_FALLBACK_ENCODING %q is not supported", UpstreamFallbackEncoding)
+	}
+	log.Printf("response body is not valid UTF-8, transcoding from %s", UpstreamFallbackEncoding)
+	decoded, _, err := transform.Bytes(enc.NewDecoder(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transcode response body from %s: %v", UpstreamFallbackEncoding, err)
+	}
+	return decoded, nil
+}
+
+// 上流のレスポンスがJSONとして読めない
+// パーサーのエラーはログにだけ出し、クライアントにはこのエラーを返す
+var ErrDecode = errors.New("malformed upstream response")
+
+// ログに出す読めなかったレスポンスの長さの上限
+const maxLoggedBodySize = 512
+
+// 読めなかった上流のレスポンスを調査用に先頭だけログに出す
+func logMalformedBody(what string, body []byte, err error) {
+	if len(body) > maxLoggedBodySize {
+		log.Printf("failed to decode %s: %v: %q... (%d bytes)", what, err, body[:maxLoggedBodySize], len(body))
+		return
+	}
+	log.Printf("failed to decode %s: %v: %q", what, err, body)
+}
+
+// ランキングファイルの行をrowsにデコードする
+// 全体をデコードできなかった場合は行ごとにデコードし直し、型の合わない値を含む行だけを飛ばす
+// 行の配列として読めない場合はErrDecodeを返す
+func decodeRankingRows(body []byte, rows *[]RankResponseRawData) error {
+	err := json.Unmarshal(body, rows)
+	if err == nil {
+		return nil
+	}
+	var elements []json.RawMessage
+	if err := json.Unmarshal(body, &elements); err != nil {
+		logMalformedBody("ranking data", body, err)
+		return ErrDecode
+	}
+	// 失敗したデコードで途中まで書き込まれた値は行ごとに上書きする
+	result := (*rows)[:0]
+	skipped := 0
+	for _, element := range elements {
+		var row RankResponseRawData
+		if err := json.Unmarshal(element, &row); err != nil {
+			skipped++
+			continue
+		}
+		result = append(result, row)
+	}
+	log.Printf("skipped %d of %d ranking rows that could not be decoded: %v", skipped, len(elements), err)
+	*rows = result
+	return nil
+}
+
+// これより大きくなった読み込み用のバッファはプールに戻さない
+// 一度だけ大きなレスポンスが来た場合にそのメモリを持ち続けないようにする
+const maxPooledBodySize = 4 << 20
+
+// ランキングファイルの読み込みとデコードに使うバッファ
+// デコードした行は変換してから返すため、リクエストをまたいで使い回せる
+type rankingDecodeBuffer struct {
+	body bytes.Buffer
+	rows []RankResponseRawData
+}
+
+var rankingDecodePool = sync.Pool{
+	New: func() interface{} {
+		return &rankingDecodeBuffer{rows: make([]RankResponseRawData, 0, 1000)}
+	},
+}
+
+func getRankingDecodeBuffer() *rankingDecodeBuffer {
+	return rankingDecodePool.Get().(*rankingDecodeBuffer)
+}
+
+// encoding/jsonは既存の要素に上書きでデコードし、JSONに無い項目は前の値のまま残すため、
+// 前のリクエストの値が混ざらないように要素をゼロ値に戻してからプールに戻す
+func putRankingDecodeBuffer(b *rankingDecodeBuffer) {
+	if b.body.Cap() > maxPooledBodySize {
+		return
+	}
+	rows := b.rows[:cap(b.rows)]
+	for i := range rows {
+		rows[i] = RankResponseRawData{}
+	}
+	b.rows = rows[:0]
+	b.body.Reset()
+	rankingDecodePool.Put(b)
+}
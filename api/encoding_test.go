@@ -0,0 +1,330 @@
+package Handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+func TestReadUpstreamBodySkipsBOM(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "plain", body: `{"a":1}`},
+		{name: "bom", body: "\xEF\xBB\xBF" + `{"a":1}`},
+		{name: "leading whitespace", body: " \r\n\t" + `{"a":1}`},
+		{name: "bom and whitespace", body: "\n\xEF\xBB\xBF " + `{"a":1}`},
+		{name: "repeated bom", body: "\xEF\xBB\xBF\xEF\xBB\xBF" + `{"a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := readUpstreamBody(strings.NewReader(tt.body), &bytes.Buffer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != `{"a":1}` {
+				t.Errorf("body = %q, want %q", body, `{"a":1}`)
+			}
+		})
+	}
+}
+
+// シーズンリストとランキングファイルのどちらに先頭のBOMが付いていても読める
+func TestUpstreamBodyWithBOM(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+	}{
+		{name: "bom", prefix: "\xEF\xBB\xBF"},
+		{name: "bom and newline", prefix: "\xEF\xBB\xBF\r\n"},
+		{name: "whitespace", prefix: "  \n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.bodyPrefix = tt.prefix
+
+			rec, ranking := getRanking(t, "/rankings")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if ranking.SeasonData.Season != 1 || len(ranking.Top1000) != 1000 {
+				t.Errorf("got season %d with %d rows, want season 1 with 1000 rows", ranking.SeasonData.Season, len(ranking.Top1000))
+			}
+		})
+	}
+}
+
+// Shift_JISに変換した文字列
+func shiftJIS(t *testing.T, s string) string {
+	t.Helper()
+	encoded, _, err := transform.String(japanese.ShiftJIS.NewEncoder(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+// 文字コードの変換を設定する
+func withFallbackEncoding(t *testing.T, encoding string) {
+	t.Helper()
+	saved := UpstreamFallbackEncoding
+	t.Cleanup(func() { UpstreamFallbackEncoding = saved })
+	UpstreamFallbackEncoding = encoding
+}
+
+func TestReadUpstreamBodyFallbackEncoding(t *testing.T) {
+	body := shiftJIS(t, `{"name":"シーズン1"}`)
+
+	tests := []struct {
+		encoding string
+		want     string
+		wantErr  string
+	}{
+		{encoding: "shift_jis", want: `{"name":"シーズン1"}`},
+		{encoding: "", wantErr: "not valid UTF-8"},
+		{encoding: "latin1", wantErr: "not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			withFallbackEncoding(t, tt.encoding)
+			got, err := readUpstreamBody(strings.NewReader(body), &bytes.Buffer{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// UTF-8として正しければ変換しない
+	withFallbackEncoding(t, "shift_jis")
+	if got, err := readUpstreamBody(strings.NewReader(`{"name":"シーズン1"}`), &bytes.Buffer{}); err != nil || string(got) != `{"name":"シーズン1"}` {
+		t.Errorf("got %q (%v), want the UTF-8 body unchanged", got, err)
+	}
+}
+
+// シーズンリストがShift_JISで返ってきても、変換を有効にしていれば読める
+func TestSeasonListShiftJIS(t *testing.T) {
+	tests := []struct {
+		encoding   string
+		wantStatus int
+	}{
+		{encoding: "shift_jis", wantStatus: http.StatusOK},
+		{encoding: "", wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			body, err := json.Marshal(map[string]interface{}{"list": upstream.seasons})
+			if err != nil {
+				t.Fatal(err)
+			}
+			upstream.seasonListBody = shiftJIS(t, string(body))
+			withFallbackEncoding(t, tt.encoding)
+
+			rec := get(t, CurrentSeasonHandler, "/season/current")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var seasonData SeasonData
+			decodeBody(t, rec, &seasonData)
+			if seasonData.Name != "シーズン1" {
+				t.Errorf("name = %q, want %q", seasonData.Name, "シーズン1")
+			}
+		})
+	}
+}
+
+// プールに戻したバッファには前のリクエストの行が残らない
+func TestPutRankingDecodeBuffer(t *testing.T) {
+	buf := getRankingDecodeBuffer()
+	buf.body.WriteString("[]")
+	buf.rows = append(buf.rows[:0], fixtureRows(1, 1000)...)
+	putRankingDecodeBuffer(buf)
+
+	if buf.body.Len() != 0 || len(buf.rows) != 0 {
+		t.Fatalf("buffer has %d bytes and %d rows, want none", buf.body.Len(), len(buf.rows))
+	}
+	for i, row := range buf.rows[:cap(buf.rows)] {
+		if row != (RankResponseRawData{}) {
+			t.Fatalf("rows[%d] = %+v, want zero value", i, row)
+		}
+	}
+}
+
+// ページごとのランキングファイルのJSON
+// ページpは(p-1)*1000+1位からp*50行で、偶数ページは上流がiconとlngを省いた行を返す
+func pooledFixturePage(page int) []byte {
+	rows := make([]map[string]interface{}, page*50)
+	for i := range rows {
+		rank := (page-1)*1000 + i + 1
+		rows[i] = map[string]interface{}{"rank": rank, "rating_value": 2100000 - rank, "name": fmt.Sprintf("trainer%d", rank)}
+		if page%2 == 1 {
+			rows[i]["icon"] = fmt.Sprintf("icon_%d.png", rank)
+			rows[i]["lng"] = "1"
+		}
+	}
+	body, _ := json.Marshal(rows)
+	return body
+}
+
+func newPooledFixtureServer(t testing.TB, pages int) *Client {
+	bodies := map[string][]byte{}
+	for page := 1; page <= pages; page++ {
+		bodies[fmt.Sprintf("/battledata/ranking/scvi/10001/0/1/traner-%d", page)] = pooledFixturePage(page)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := bodies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return &Client{HTTPClient: server.Client(), APIBaseURL: server.URL, ResourceBaseURL: server.URL, Soft: defaultSoft}
+}
+
+// 同時にデコードしても、使い回したバッファから他のページの行が混ざらない
+func TestFetchRankingPageConcurrentDecode(t *testing.T) {
+	const pages, rounds = 8, 20
+	client := newPooledFixtureServer(t, pages)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, pages*rounds)
+	for round := 0; round < rounds; round++ {
+		for page := 1; page <= pages; page++ {
+			wg.Add(1)
+			go func(page int) {
+				defer wg.Done()
+				rows, _, err := client.fetchRankingPage(context.Background(), "Sc", "10001", 0, "1", page, newRetryBudget(RetryBudget))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(rows) != page*50 {
+					errs <- fmt.Errorf("page %d has %d rows, want %d", page, len(rows), page*50)
+					return
+				}
+				for i, row := range rows {
+					rank := (page-1)*1000 + i + 1
+					wantIcon, wantLng := "", ""
+					if page%2 == 1 {
+						wantIcon, wantLng = iconURL(client.ResourceBaseURL, fmt.Sprintf("icon_%d.png", rank)), "1"
+					}
+					if row.Rank != rank || row.Name != fmt.Sprintf("trainer%d", rank) || row.Icon != wantIcon || row.Lng != wantLng {
+						errs <- fmt.Errorf("page %d row %d = %+v, want rank %d icon %q lng %q", page, i, row, rank, wantIcon, wantLng)
+						return
+					}
+				}
+			}(page)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func BenchmarkFetchRankingPage(b *testing.B) {
+	// 奇数ページは省かれた項目の無い行を返す
+	client := newPooledFixtureServer(b, 19)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := client.fetchRankingPage(context.Background(), "Sc", "10001", 0, "1", 19, newRetryBudget(RetryBudget)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 上流のJSONが読めない場合は502を返し、パーサーのエラーはレスポンスに含めずログにだけ出す
+func TestMalformedUpstreamJSON(t *testing.T) {
+	long := `[{"rank":1,"name":"` + strings.Repeat("a", 1000)
+	tests := []struct {
+		name           string
+		seasonListBody string
+		rankingBody    string
+		wantLog        string
+	}{
+		{name: "truncated season list", seasonListBody: `{"list":{"1":{"10001":`, wantLog: "failed to decode season list"},
+		{name: "season list not an object", seasonListBody: `[1,2,3]`, wantLog: "failed to decode season list"},
+		{name: "truncated ranking", rankingBody: `[{"rank":1,"rating_value":2099000,"name":"trai`, wantLog: "failed to decode ranking data"},
+		{name: "ranking not an array", rankingBody: `{"rank":1}`, wantLog: "failed to decode ranking data"},
+		{name: "long ranking", rankingBody: long, wantLog: fmt.Sprintf("... (%d bytes)", len(long))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			withoutRetryDelay(t)
+			logs := captureLog(t)
+			upstream.seasonListBody = tt.seasonListBody
+			upstream.rankingBody = tt.rankingBody
+
+			rec := get(t, RankingHandler, "/rankings")
+			if rec.Code != http.StatusBadGateway {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadGateway, rec.Body)
+			}
+			var body ErrorResponse
+			decodeBody(t, rec, &body)
+			if body.Status != http.StatusBadGateway || !strings.Contains(body.Error, ErrDecode.Error()) {
+				t.Errorf("body = %+v, want the sanitized decode error", body)
+			}
+			for _, leaked := range []string{"unexpected end of JSON input", "cannot unmarshal", "invalid character"} {
+				if strings.Contains(body.Error, leaked) {
+					t.Errorf("error %q exposes the parser error", body.Error)
+				}
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("log = %q, want it to contain %q", logs.String(), tt.wantLog)
+			}
+			if strings.Contains(logs.String(), strings.Repeat("a", maxLoggedBodySize+1)) {
+				t.Error("log contains more of the body than maxLoggedBodySize")
+			}
+		})
+	}
+}
+
+// 型の合わない値を含む行だけを飛ばし、余計な項目や足りない項目は無視する
+func TestDecodeRankingRowsTolerant(t *testing.T) {
+	captureLog(t)
+	body := `[
+		{"rank":1,"rating_value":2099000,"name":"a","extra":{"nested":[1,2]}},
+		{"rank":"2","rating_value":2098000,"name":"b"},
+		{"rank":3,"name":"c"},
+		{"rank":4,"rating_value":2096000,"name":4}
+	]`
+	rows := fixtureRows(1, 4)
+	if err := decodeRankingRows([]byte(body), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if got := namesAndRanks(rows); got != "a/1 c/3 " {
+		t.Errorf("got %s, want a/1 c/3", got)
+	}
+	// 上書き前の値は残らない
+	if rows[1].RatingValue != 0 || rows[1].Icon != "" {
+		t.Errorf("row without rating_value = %+v, want zero values for the missing fields", rows[1])
+	}
+}
@@ -0,0 +1,109 @@
+package Handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+// trueにすると/rankingsのレスポンスを{"data":...,"meta":...}で包む
+var UseResponseEnvelope = os.Getenv("RESPONSE_ENVELOPE") == "true"
+
+// 取得処理の付随情報
+type fetchStatus struct {
+	selectionCached   bool
+	seasonListCached  bool
+	seasonListElapsed time.Duration
+	rankingCached     bool
+	rankingElapsed    time.Duration
+	// キャッシュしたランキングデータの版で、キャッシュを使わない取得では空
+	rankingVersion string
+	// 上流から取得できず、有効期間を過ぎたキャッシュを返した
+	rankingStale     bool
+	rankingFetchedAt time.Time
+	// ランキングデータを返した上流のレスポンスヘッダー
+	source UpstreamSource
+}
+
+// JSONのエラーのレスポンス
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// http.Errorと同じくステータスコードとメッセージを返すが、本文はJSONにする
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Status: status})
+}
+
+// ランキングデータを返した上流（CDN）のレスポンスヘッダー
+type UpstreamSource struct {
+	Server string `json:"server,omitempty"`
+	XCache string `json:"x_cache,omitempty"`
+	Age    string `json:"age,omitempty"`
+}
+
+func newUpstreamSource(header http.Header) UpstreamSource {
+	return UpstreamSource{
+		Server: header.Get("Server"),
+		XCache: header.Get("X-Cache"),
+		Age:    header.Get("Age"),
+	}
+}
+
+// 上流からの取得にかかった時間
+type ResponseTiming struct {
+	SeasonList FetchTiming `json:"season_list"`
+	Top1000    FetchTiming `json:"top_1000"`
+}
+
+type FetchTiming struct {
+	DurationMs float64 `json:"duration_ms"`
+	CacheHit   bool    `json:"cache_hit"`
+}
+
+func newResponseTiming(status fetchStatus) *ResponseTiming {
+	return &ResponseTiming{
+		SeasonList: FetchTiming{
+			DurationMs: float64(status.seasonListElapsed.Microseconds()) / 1000,
+			CacheHit:   status.seasonListCached,
+		},
+		Top1000: FetchTiming{
+			DurationMs: float64(status.rankingElapsed.Microseconds()) / 1000,
+			CacheHit:   status.rankingCached,
+		},
+	}
+}
+
+// レスポンスを包むエンベロープ
+type ResponseEnvelope struct {
+	Data interface{}  `json:"data"`
+	Meta ResponseMeta `json:"meta"`
+}
+
+// エンベロープのメタ情報
+type ResponseMeta struct {
+	ElapsedMs float64 `json:"elapsed_ms"`
+	Cache     string  `json:"cache"`
+	Season    int     `json:"season"`
+	Rule      int     `json:"rule"`
+	CID       string  `json:"cId"`
+}
+
+func newResponseMeta(seasonData SeasonData, status fetchStatus, elapsed time.Duration) ResponseMeta {
+	cache := "miss"
+	if status.rankingCached {
+		cache = "hit"
+	}
+	return ResponseMeta{
+		ElapsedMs: float64(elapsed.Microseconds()) / 1000,
+		Cache:     cache,
+		Season:    seasonData.Season,
+		Rule:      seasonData.Rule,
+		CID:       seasonData.CID,
+	}
+}
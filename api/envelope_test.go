@@ -0,0 +1,198 @@
+package Handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// すべてのエンドポイントのエラーはJSONで返す
+func TestErrorsAreJSON(t *testing.T) {
+	newFakeUpstream(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux, "")
+
+	tests := []struct {
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{method: http.MethodDelete, target: "/rankings", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/rankings/overview", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/rankings/batch", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/rankings/history", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/rankings/diff", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/rankings/stats", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/rankings/cutoff", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/rankings/estimate", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/seasons", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/rankings/query", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/icon", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/season/current.ics", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/openapi.json", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, target: "/rankings?sample=0", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/rankings/overview?top=0", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/rankings/batch", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/rankings/diff", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/rankings/cutoff?rank=x", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/rankings/estimate?rating=NaN", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/rankings/histogram?bucket=0", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/seasons/schedule?year=x", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/trainer/sparkline", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/icon", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/rankings/history", wantStatus: http.StatusNotFound},
+		{method: http.MethodPost, target: "/admin/maintenance", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body ErrorResponse
+			decodeBody(t, rec, &body)
+			if body.Status != tt.wantStatus || body.Error == "" {
+				t.Errorf("body = %+v, want status %d and a message", body, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRankingEnvelope(t *testing.T) {
+	newFakeUpstream(t)
+	envelope := UseResponseEnvelope
+	t.Cleanup(func() { UseResponseEnvelope = envelope })
+
+	tests := []struct {
+		name            string
+		envelope        bool
+		target          string
+		wantContentType string
+	}{
+		{name: "bare", target: "/rankings", wantContentType: "application/json"},
+		{name: "envelope", envelope: true, target: "/rankings", wantContentType: "application/json"},
+		{name: "csv in envelope mode", envelope: true, target: "/rankings?format=csv", wantContentType: "text/csv; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			UseResponseEnvelope = tt.envelope
+			rec := get(t, RankingHandler, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Fatalf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if tt.wantContentType != "application/json" {
+				return
+			}
+
+			var body map[string]json.RawMessage
+			decodeBody(t, rec, &body)
+			var ranking RankingResponse
+			if !tt.envelope {
+				if _, ok := body["data"]; ok {
+					t.Fatal("bare response has data")
+				}
+				decodeBody(t, rec, &ranking)
+			} else {
+				var envelope struct {
+					Data RankingResponse `json:"data"`
+					Meta ResponseMeta    `json:"meta"`
+				}
+				decodeBody(t, rec, &envelope)
+				ranking = envelope.Data
+				if envelope.Meta.Season != 1 || envelope.Meta.CID != "10001" || envelope.Meta.Rule != 0 {
+					t.Errorf("meta = %+v, want season 1 cId 10001 rule 0", envelope.Meta)
+				}
+				if envelope.Meta.Cache != "miss" && envelope.Meta.Cache != "hit" {
+					t.Errorf("meta cache = %q, want hit or miss", envelope.Meta.Cache)
+				}
+			}
+			if ranking.SeasonData.Season != 1 || len(ranking.Top1000) != 1000 {
+				t.Errorf("got season %d with %d rows, want season 1 with 1000 rows", ranking.SeasonData.Season, len(ranking.Top1000))
+			}
+		})
+	}
+}
+
+func TestNewResponseMeta(t *testing.T) {
+	seasonData := SeasonData{Season: 12, Rule: 1, CID: "10012"}
+	tests := []struct {
+		cached    bool
+		wantCache string
+	}{
+		{cached: false, wantCache: "miss"},
+		{cached: true, wantCache: "hit"},
+	}
+	for _, tt := range tests {
+		meta := newResponseMeta(seasonData, fetchStatus{rankingCached: tt.cached}, 1500*time.Microsecond)
+		want := ResponseMeta{ElapsedMs: 1.5, Cache: tt.wantCache, Season: 12, Rule: 1, CID: "10012"}
+		if meta != want {
+			t.Errorf("meta = %+v, want %+v", meta, want)
+		}
+	}
+}
+
+func TestRankingTiming(t *testing.T) {
+	newFakeUpstream(t)
+
+	tests := []struct {
+		target        string
+		wantTiming    bool
+		wantCacheHits bool
+	}{
+		{target: "/rankings"},
+		{target: "/rankings?include_timing=true", wantTiming: true, wantCacheHits: true},
+		{target: "/rankings?include_timing=false"},
+	}
+	// 1回目の取得はキャッシュに載せるだけにする
+	if rec, _ := getRanking(t, "/rankings?include_timing=true"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if !tt.wantTiming {
+				if ranking.Timing != nil {
+					t.Errorf("timing = %+v, want none", *ranking.Timing)
+				}
+				return
+			}
+			if ranking.Timing == nil {
+				t.Fatal("timing is missing")
+			}
+			if ranking.Timing.SeasonList.DurationMs < 0 || ranking.Timing.Top1000.DurationMs < 0 {
+				t.Errorf("timing = %+v, want non-negative durations", *ranking.Timing)
+			}
+			if ranking.Timing.SeasonList.CacheHit != tt.wantCacheHits || ranking.Timing.Top1000.CacheHit != tt.wantCacheHits {
+				t.Errorf("timing = %+v, want cache hits %v", *ranking.Timing, tt.wantCacheHits)
+			}
+		})
+	}
+}
+
+func TestNewResponseTiming(t *testing.T) {
+	timing := newResponseTiming(fetchStatus{
+		seasonListCached:  true,
+		seasonListElapsed: 2500 * time.Microsecond,
+		rankingElapsed:    40 * time.Millisecond,
+	})
+	want := ResponseTiming{
+		SeasonList: FetchTiming{DurationMs: 2.5, CacheHit: true},
+		Top1000:    FetchTiming{DurationMs: 40},
+	}
+	if *timing != want {
+		t.Errorf("timing = %+v, want %+v", *timing, want)
+	}
+}
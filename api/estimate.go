@@ -0,0 +1,89 @@
+package Handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// 外挿に使うランキングの下位の割合
+const estimateTailFraction = 0.2
+
+// 指定レートの順位
+// ランキングの範囲外のレートはEstimatedがtrueで、下位の傾きから外挿した推定値
+type EstimateResponse struct {
+	SeasonData  SeasonData `json:"season_data"`
+	RatingValue float64    `json:"rating_value"`
+	Rank        int        `json:"rank"`
+	Estimated   bool       `json:"estimated"`
+}
+
+// 順位順に並んだランキングから指定レートの順位を求める
+// 最下位のレートより低い場合は下位の傾きから外挿し、rankCntが分かればそれを超えないようにする
+func estimateRank(rankingData []RankResponseRawData, rating float64, rankCnt int) (int, bool, error) {
+	if len(rankingData) == 0 {
+		return 0, false, fmt.Errorf("no ranking data available")
+	}
+	last := rankingData[len(rankingData)-1]
+	if rating >= last.RatingValue {
+		rank, found := computeThreshold(rankingData, rating)
+		if !found {
+			// 1位より高いレート
+			return 1, false, nil
+		}
+		return rank, false, nil
+	}
+
+	// 下位の一定割合の区間でレート1あたりの順位の増え方を求める
+	from := rankingData[int(float64(len(rankingData)-1)*(1-estimateTailFraction))]
+	rank := last.Rank + 1
+	if diff := from.RatingValue - last.RatingValue; diff > 0 {
+		slope := float64(last.Rank-from.Rank) / diff
+		rank = last.Rank + int(math.Ceil((last.RatingValue-rating)*slope))
+	}
+	if rankCnt > 0 && rank > rankCnt {
+		rank = rankCnt
+	}
+	return rank, true, nil
+}
+
+// endpoint handler
+func EstimateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	rating, err := strconv.ParseFloat(r.URL.Query().Get("rating"), 64)
+	if err != nil || !isFinite(rating) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid rating parameter")
+		return
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/estimate")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	rank, estimated, err := estimateRank(ranking.Top1000, rating, ranking.SeasonData.RankCnt)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	response := EstimateResponse{
+		SeasonData:  ranking.SeasonData,
+		RatingValue: rating,
+		Rank:        rank,
+		Estimated:   estimated,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
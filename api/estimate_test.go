@@ -0,0 +1,75 @@
+package Handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEstimateRank(t *testing.T) {
+	// 下位の20%ではレートが1下がるごとに1つ順位が下がる
+	rows := rowsWithRatings(2000, 1990, 1980, 1970, 1960, 1950)
+
+	tests := []struct {
+		name          string
+		rating        float64
+		rankCnt       int
+		want          int
+		wantEstimated bool
+	}{
+		{name: "in range", rating: 1980, want: 3},
+		{name: "between ranks", rating: 1975, want: 3},
+		{name: "above first", rating: 2100, want: 1},
+		{name: "extrapolated", rating: 1930, want: 8, wantEstimated: true},
+		{name: "capped by rank count", rating: 1000, rankCnt: 50, want: 50, wantEstimated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rank, estimated, err := estimateRank(rows, tt.rating, tt.rankCnt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rank != tt.want || estimated != tt.wantEstimated {
+				t.Errorf("got rank %d estimated %v, want rank %d estimated %v", rank, estimated, tt.want, tt.wantEstimated)
+			}
+		})
+	}
+	if _, _, err := estimateRank(nil, 1500, 0); err == nil {
+		t.Error("estimateRank with no rows succeeded, want error")
+	}
+}
+
+func TestEstimateHandler(t *testing.T) {
+	// r位のレートは2100-rで、参加者は3000人
+	newFakeUpstream(t)
+
+	tests := []struct {
+		target        string
+		wantStatus    int
+		wantRank      int
+		wantEstimated bool
+	}{
+		{target: "/rankings/estimate?rating=1850", wantStatus: http.StatusOK, wantRank: 250},
+		{target: "/rankings/estimate?rating=1100", wantStatus: http.StatusOK, wantRank: 1000},
+		{target: "/rankings/estimate?rating=1050", wantStatus: http.StatusOK, wantRank: 1050, wantEstimated: true},
+		{target: "/rankings/estimate?rating=1049.5", wantStatus: http.StatusOK, wantRank: 1051, wantEstimated: true},
+		{target: "/rankings/estimate?rating=-5000", wantStatus: http.StatusOK, wantRank: 3000, wantEstimated: true},
+		{target: "/rankings/estimate?rating=NaN", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/estimate", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, EstimateHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response EstimateResponse
+			decodeBody(t, rec, &response)
+			if response.Rank != tt.wantRank || response.Estimated != tt.wantEstimated {
+				t.Errorf("got rank %d estimated %v, want rank %d estimated %v", response.Rank, response.Estimated, tt.wantRank, tt.wantEstimated)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package Handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// テスト用の上流のシーズンの日時の形式
+const fixtureTimeLayout = "2006/01/02 15:04"
+
+// テスト用の開催中のシーズン
+func fixtureSeason(season int, cId string, rule int, now time.Time) SeasonData {
+	return SeasonData{
+		CID:     cId,
+		Cnt:     5000,
+		Name:    fmt.Sprintf("シーズン%d", season),
+		RankCnt: 3000,
+		Rst:     0,
+		Rule:    rule,
+		Season:  season,
+		Start:   now.Add(-24 * time.Hour).In(jst).Format(fixtureTimeLayout),
+		End:     now.Add(24 * time.Hour).In(jst).Format(fixtureTimeLayout),
+		Ts1:     1700000000,
+		Ts2:     1700000001,
+	}
+}
+
+// テスト用のランキングファイルの行
+// レートは上流と同じく1000倍した値で、順位ごとに1ずつ下がる
+func fixtureRows(first, n int) []RankResponseRawData {
+	rows := make([]RankResponseRawData, n)
+	for i := range rows {
+		rank := first + i
+		rows[i] = RankResponseRawData{
+			Rank:        rank,
+			RatingValue: float64(2100000 - rank*1000),
+			Icon:        fmt.Sprintf("icon_%d.png", rank),
+			Name:        fmt.Sprintf("trainer%d", rank),
+			Lng:         []string{"1", "2"}[rank%2],
+		}
+	}
+	return rows
+}
+
+// シーズンリストとランキングファイルを返すテスト用の上流
+type fakeUpstream struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// シーズンリストのlistの中身
+	seasons map[string]map[string]SeasonData
+	// 設定した場合はseasonsの代わりにシーズンリストとしてそのまま返す
+	seasonListBody string
+	// シーズンリストのリクエストのうち、最初のこの回数は500を返す
+	seasonListFailures int32
+	// ランキングファイルのリクエストのうち、最初のこの回数は先頭の10行だけを返す
+	shortRankings int32
+	// 設定した場合はランキングファイルの代わりにこの本文を返す
+	rankingBody string
+	// "cId/rst/ts/page" ごとのランキングファイル
+	pages map[string][]RankResponseRawData
+	// "cId/rst/ts/page" ごとに、ランキングファイルの代わりに返すステータスコード
+	pageStatus map[string]int
+	// 設定した場合はランキングファイルを返す前にstartedに送り、gateが閉じられるまで待つ
+	started chan struct{}
+	gate    chan struct{}
+	// 設定した場合はレスポンスの先頭に付ける
+	bodyPrefix string
+	// ランキングファイルのレスポンスに付けるヘッダー
+	rankingHeader http.Header
+	// シーズンリストのリクエストで送られたsoft
+	seasonListSofts []string
+
+	seasonListCalls atomic.Int32
+	rankingCalls    atomic.Int32
+}
+
+// 開催中のシーズン1つと、その1ページ目のランキングファイルを返す上流を立ててDefaultClientの取得先にする
+// パッケージのキャッシュなどの状態はリセットしておく
+func newFakeUpstream(t *testing.T) *fakeUpstream {
+	t.Helper()
+	resetState(t)
+
+	season := fixtureSeason(1, "10001", 0, time.Now())
+	u := &fakeUpstream{
+		seasons:    map[string]map[string]SeasonData{"1": {season.CID: season}},
+		pages:      map[string][]RankResponseRawData{},
+		pageStatus: map[string]int{},
+	}
+	u.setPage(season, 1, fixtureRows(1, 1000))
+	u.Server = httptest.NewServer(http.HandlerFunc(u.serveHTTP))
+	t.Cleanup(u.Close)
+
+	client := DefaultClient
+	DefaultClient = &Client{HTTPClient: u.Client(), APIBaseURL: u.URL, ResourceBaseURL: u.URL, Soft: defaultSoft}
+	t.Cleanup(func() { DefaultClient = client })
+	return u
+}
+
+func fixturePageKey(cId string, rst int, ts string, page int) string {
+	return fmt.Sprintf("%s/%d/%s/%d", cId, rst, ts, page)
+}
+
+// シーズンのランキングファイルの指定ページを設定する
+func (u *fakeUpstream) setPage(seasonData SeasonData, page int, rows []RankResponseRawData) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.pages[fixturePageKey(seasonData.CID, seasonData.Rst, fmt.Sprint(seasonData.Ts1), page)] = rows
+}
+
+// シーズンのランキングファイルの指定ページをstatusのエラーにする
+func (u *fakeUpstream) failPage(seasonData SeasonData, page int, status int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.pageStatus[fixturePageKey(seasonData.CID, seasonData.Rst, fmt.Sprint(seasonData.Ts1), page)] = status
+}
+
+// シーズンリストに追加する
+func (u *fakeUpstream) addSeason(listKey string, seasonData SeasonData) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.seasons[listKey] == nil {
+		u.seasons[listKey] = map[string]SeasonData{}
+	}
+	u.seasons[listKey][seasonData.CID] = seasonData
+}
+
+func (u *fakeUpstream) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && r.URL.Path == "/tt/cbd/competition/rankmatch/list" {
+		if u.seasonListCalls.Add(1) <= u.seasonListFailures {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		var request struct {
+			Soft string `json:"soft"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		u.mu.Lock()
+		u.seasonListSofts = append(u.seasonListSofts, request.Soft)
+		body, err := json.Marshal(map[string]interface{}{"list": u.seasons})
+		if u.seasonListBody != "" {
+			body = []byte(u.seasonListBody)
+		}
+		u.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(u.bodyPrefix))
+		w.Write(body)
+		return
+	}
+
+	// /battledata/ranking/scvi/{cId}/{rst}/{ts}/traner-{page}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/battledata/ranking/scvi/"), "/")
+	if len(parts) != 4 || !strings.HasPrefix(parts[3], "traner-") {
+		http.NotFound(w, r)
+		return
+	}
+	calls := u.rankingCalls.Add(1)
+	if u.gate != nil {
+		u.started <- struct{}{}
+		<-u.gate
+	}
+	var page int
+	fmt.Sscanf(strings.TrimPrefix(parts[3], "traner-"), "%d", &page)
+	key := fixturePageKey(parts[0], atoiOrZero(parts[1]), parts[2], page)
+	u.mu.Lock()
+	rows, ok := u.pages[key]
+	status := u.pageStatus[key]
+	rankingBody := u.rankingBody
+	u.mu.Unlock()
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if calls <= u.shortRankings && len(rows) > 10 {
+		rows = rows[:10]
+	}
+	for name, values := range u.rankingHeader {
+		w.Header()[name] = values
+	}
+	w.Write([]byte(u.bodyPrefix))
+	if rankingBody != "" {
+		w.Write([]byte(rankingBody))
+		return
+	}
+	json.NewEncoder(w).Encode(rows)
+}
+
+func atoiOrZero(v string) int {
+	var n int
+	fmt.Sscanf(v, "%d", &n)
+	return n
+}
+
+// ランキングファイルを返す前に止める
+// 返した関数を呼ぶと止めていたリクエストを続ける
+func (u *fakeUpstream) holdRankings() (started <-chan struct{}, release func()) {
+	u.started = make(chan struct{}, 16)
+	u.gate = make(chan struct{})
+	var once sync.Once
+	release = func() { once.Do(func() { close(u.gate) }) }
+	return u.started, release
+}
+
+// パッケージのキャッシュとテストで書き換える設定を初期状態に戻す
+func resetState(t *testing.T) {
+	t.Helper()
+	seasonListCache = newTTLCache(CacheMaxEntries)
+	rankingDataCache = newTTLCache(CacheMaxEntries)
+	rankingHistory = newTTLCache(CacheMaxEntries)
+	iconCache = newTTLCache(CacheMaxEntries)
+	selectionCache = newSeasonSelectionCache(CacheMaxEntries)
+	rankingEncodedCache = newEncodedCache(CacheMaxEntries)
+	seasonListHealth = &upstreamHealth{}
+	maintenance.disable()
+}
+
+// ハンドラーにGETリクエストを送る
+func get(t *testing.T, handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// レスポンスのJSONをvにデコードする
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// /rankingsにGETリクエストを送り、200ならレスポンスをデコードする
+func getRanking(t *testing.T, target string) (*httptest.ResponseRecorder, RankingResponse) {
+	t.Helper()
+	rec := get(t, RankingHandler, target)
+	var ranking RankingResponse
+	if rec.Code == http.StatusOK {
+		decodeBody(t, rec, &ranking)
+	}
+	return rec, ranking
+}
+
+// 行の順位
+func ranksOf(rows []RankResponseRawData) []int {
+	ranks := make([]int, len(rows))
+	for i, row := range rows {
+		ranks[i] = row.Rank
+	}
+	return ranks
+}
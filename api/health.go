@@ -0,0 +1,90 @@
+package Handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 最後にシーズンリストを上流から取得したときの結果
+type upstreamHealth struct {
+	mu          sync.RWMutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+var seasonListHealth = &upstreamHealth{}
+
+func (h *upstreamHealth) record(err error, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.lastFailure = now
+		h.lastError = err.Error()
+		return
+	}
+	h.lastSuccess = now
+}
+
+// 最後の取得が失敗していればfalse
+// まだ一度も取得していない場合はtrue
+func (h *upstreamHealth) status() HealthResponse {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	response := HealthResponse{Status: "ok"}
+	if !h.lastSuccess.IsZero() {
+		lastSuccess := h.lastSuccess
+		response.LastSuccess = &lastSuccess
+	}
+	if h.lastFailure.After(h.lastSuccess) {
+		response.Status = "unavailable"
+		response.Error = h.lastError
+	}
+	return response
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+	// readyzのみ
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// endpoint handler
+// 上流には問い合わせない
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(HealthResponse{Status: "ok"}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// endpoint handler
+// 上流には問い合わせず、最後にシーズンリストを取得したときに失敗していれば503を返す
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := seasonListHealth.status()
+	if response.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
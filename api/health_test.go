@@ -0,0 +1,87 @@
+package Handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 上流が失敗していても上流には問い合わせずに200を返す
+func TestHealthzHandler(t *testing.T) {
+	tests := []struct {
+		method     string
+		wantStatus int
+	}{
+		{method: http.MethodGet, wantStatus: http.StatusOK},
+		{method: http.MethodHead, wantStatus: http.StatusOK},
+		{method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.seasonListFailures = 100
+			rec := httptest.NewRecorder()
+			HealthzHandler(rec, httptest.NewRequest(tt.method, "/healthz", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var response HealthResponse
+				decodeBody(t, rec, &response)
+				if response != (HealthResponse{Status: "ok"}) {
+					t.Errorf("response = %+v, want status ok only", response)
+				}
+			}
+			if n := upstream.seasonListCalls.Load() + upstream.rankingCalls.Load(); n != 0 {
+				t.Errorf("upstream calls = %d, want 0", n)
+			}
+		})
+	}
+}
+
+// 最後のシーズンリストの取得が失敗していれば503を返す
+func TestReadyzHandler(t *testing.T) {
+	withoutRetryDelay(t)
+
+	tests := []struct {
+		name            string
+		failures        []int32
+		wantStatus      int
+		wantLastSuccess bool
+	}{
+		{name: "not fetched yet", wantStatus: http.StatusOK},
+		{name: "fetched", failures: []int32{0}, wantStatus: http.StatusOK, wantLastSuccess: true},
+		{name: "failed", failures: []int32{100}, wantStatus: http.StatusServiceUnavailable},
+		{name: "failed after success", failures: []int32{0, 100}, wantStatus: http.StatusServiceUnavailable, wantLastSuccess: true},
+		{name: "recovered", failures: []int32{100, 0}, wantStatus: http.StatusOK, wantLastSuccess: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			for _, failures := range tt.failures {
+				// キャッシュを使わずに取得し直す
+				seasonListCache = newTTLCache(CacheMaxEntries)
+				upstream.seasonListCalls.Store(0)
+				upstream.seasonListFailures = failures
+				get(t, RankingHandler, "/rankings")
+			}
+			calls := upstream.seasonListCalls.Load()
+
+			rec := get(t, ReadyzHandler, "/readyz")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			var response HealthResponse
+			decodeBody(t, rec, &response)
+			if wantOK := tt.wantStatus == http.StatusOK; (response.Status == "ok") != wantOK || (response.Error == "") != wantOK {
+				t.Errorf("response = %+v, want ok %v", response, wantOK)
+			}
+			if (response.LastSuccess != nil) != tt.wantLastSuccess {
+				t.Errorf("last_success = %v, want set %v", response.LastSuccess, tt.wantLastSuccess)
+			}
+			if n := upstream.seasonListCalls.Load(); n != calls {
+				t.Errorf("readyz made %d season list calls, want 0", n-calls)
+			}
+		})
+	}
+}
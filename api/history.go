@@ -0,0 +1,328 @@
+package Handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// スナップショットを保存するディレクトリ
+// 空の場合はメモリ上に保持するため、再起動すると失われる
+var SnapshotDir = os.Getenv("SNAPSHOT_DIR")
+
+// ランキングのスナップショットを取る間隔で、0以下なら取らない
+var SnapshotInterval = envDuration("SNAPSHOT_INTERVAL", 0)
+
+// スナップショットの保存先
+var Snapshots = newSnapshotStore(SnapshotDir)
+
+func newSnapshotStore(dir string) SnapshotStore {
+	if dir == "" {
+		return NewMemorySnapshotStore()
+	}
+	store, err := NewFileSnapshotStore(dir)
+	if err != nil {
+		log.Printf("failed to use SNAPSHOT_DIR, keeping snapshots in memory: %v", err)
+		return NewMemorySnapshotStore()
+	}
+	return store
+}
+
+// 一定間隔で現在のシーズンのランキングを取得してスナップショットとして保存する
+// 上流は過去のシーズンのランキングを返さなくなるため、シーズンが終わった後も参照できるようにする
+type Snapshotter struct {
+	Store    SnapshotStore
+	Interval time.Duration
+}
+
+// ctxがキャンセルされるまで、起動時とInterval経過ごとにスナップショットを取る
+func (s *Snapshotter) Run(ctx context.Context) {
+	s.take(ctx, time.Now())
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.take(ctx, now)
+		}
+	}
+}
+
+func (s *Snapshotter) take(ctx context.Context, now time.Time) {
+	ranking, _, err := fetchLatestRanking(ctx, rankingQuery{depth: 1, maxAge: s.Interval})
+	if err != nil {
+		log.Printf("snapshot failed: %v", err)
+		return
+	}
+	// メンテナンスモードのデータは保存済みのスナップショットなので取り直さない
+	if ranking.Stale {
+		return
+	}
+	if err := s.Store.Save(ctx, Snapshot{Timestamp: now.UTC(), Ranking: ranking}); err != nil {
+		log.Printf("snapshot failed: %v", err)
+	}
+}
+
+// スパークラインの点数のデフォルトと上限
+const (
+	defaultSparklinePoints = 30
+	maxSparklinePoints     = 500
+)
+
+// トレーナーの順位の推移
+type SparklineResponse struct {
+	Name   string           `json:"name"`
+	Points []SparklinePoint `json:"points"`
+}
+
+type SparklinePoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Rank      int       `json:"rank"`
+}
+
+// シーズンのスナップショットのうちトレーナーがいるものから求めた順位の推移
+// seasonが0なら最新のスナップショットのシーズンにする
+// トレーナーがいるかは中身を見ないと分からないため、シーズンのものを1件ずつ読み込んで順位だけを残す
+func trainerRankSeries(ctx context.Context, store SnapshotStore, name string, season int) ([]SparklinePoint, error) {
+	infos, err := store.Index(ctx, SnapshotFilter{})
+	if err != nil {
+		return nil, err
+	}
+	if season == 0 && len(infos) > 0 {
+		season = infos[len(infos)-1].Season
+	}
+	series := []SparklinePoint{}
+	filter := SnapshotFilter{Season: season}
+	for _, info := range infos {
+		if !filter.match(info) {
+			continue
+		}
+		snapshot, err := store.Get(ctx, info.Timestamp)
+		// 一覧を取得した後に間引かれたものは飛ばす
+		if errors.Is(err, ErrSnapshotNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, data := range snapshot.Ranking.Top1000 {
+			if data.Name == name {
+				series = append(series, SparklinePoint{Timestamp: snapshot.Timestamp, Rank: data.Rank})
+				break
+			}
+		}
+	}
+	return series, nil
+}
+
+// 時刻順に並んだ推移を期間全体で等間隔になるように最大n点に間引く
+func downsampleSeries(series []SparklinePoint, n int) []SparklinePoint {
+	timestamps := make([]time.Time, len(series))
+	for i, point := range series {
+		timestamps[i] = point.Timestamp
+	}
+	selected := downsampleTimestamps(timestamps, n)
+	result := make([]SparklinePoint, 0, len(selected))
+	for _, point := range series {
+		if len(result) < len(selected) && point.Timestamp.Equal(selected[len(result)]) {
+			result = append(result, point)
+		}
+	}
+	return result
+}
+
+// 時刻順に並んだ時刻を期間全体で等間隔になるように最大n点に間引く
+func downsampleTimestamps(timestamps []time.Time, n int) []time.Time {
+	if len(timestamps) <= n {
+		return timestamps
+	}
+	if n == 1 {
+		return timestamps[len(timestamps)-1:]
+	}
+
+	first := timestamps[0]
+	span := timestamps[len(timestamps)-1].Sub(first)
+	result := make([]time.Time, 0, n)
+	j := 0
+	for i := 0; i < n; i++ {
+		target := first.Add(span * time.Duration(i) / time.Duration(n-1))
+		// 目標の時刻に最も近い点を選ぶ
+		for j+1 < len(timestamps) && absDuration(timestamps[j+1].Sub(target)) <= absDuration(timestamps[j].Sub(target)) {
+			j++
+		}
+		if len(result) > 0 && result[len(result)-1].Equal(timestamps[j]) {
+			continue
+		}
+		result = append(result, timestamps[j])
+	}
+	return result
+}
+
+// 指定時刻のスナップショットを読み込む
+// 一覧を取得した後に間引かれたものは飛ばす
+func loadSnapshots(ctx context.Context, store SnapshotStore, timestamps []time.Time) ([]Snapshot, error) {
+	snapshots := make([]Snapshot, 0, len(timestamps))
+	for _, ts := range timestamps {
+		snapshot, err := store.Get(ctx, ts)
+		if errors.Is(err, ErrSnapshotNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// endpoint handler
+func SparklineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing name parameter")
+		return
+	}
+	points := defaultSparklinePoints
+	if v := r.URL.Query().Get("points"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSparklinePoints {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid points parameter: must be between 1 and %d", maxSparklinePoints))
+			return
+		}
+		points = n
+	}
+
+	var season int
+	if v := r.URL.Query().Get("season"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid season parameter")
+			return
+		}
+		season = n
+	}
+
+	// シーズンが混ざらないように、またトレーナーがいない時刻で点数が減らないように、絞り込んでから間引く
+	series, err := trainerRankSeries(r.Context(), Snapshots, name, season)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Error loading snapshots: %v", err))
+		return
+	}
+
+	response := SparklineResponse{
+		Name:   name,
+		Points: downsampleSeries(series, points),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// atの形式
+// タイムゾーンの無いものはシーズンの日時と同じく日本時間とみなす
+var historyTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+func parseHistoryTime(v string) (time.Time, error) {
+	for _, layout := range historyTimeLayouts {
+		if t, err := time.ParseInLocation(layout, v, jst); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported time format %q", v)
+}
+
+// 指定時刻に最も近く、シーズンが一致するスナップショット
+// シーズンは一覧で絞り込むため、読み込むのは返すものだけになる。seasonが0ならシーズンを問わない
+func nearestStoredSnapshot(ctx context.Context, store SnapshotStore, season int, at time.Time) (Snapshot, bool, error) {
+	infos, err := store.Index(ctx, SnapshotFilter{Season: season})
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+	timestamps := snapshotTimestamps(infos)
+	// 古い順に並んでいるので、同じだけ離れている場合は前のものが先になる
+	sort.SliceStable(timestamps, func(i, j int) bool {
+		return absDuration(timestamps[i].Sub(at)) < absDuration(timestamps[j].Sub(at))
+	})
+	// 一覧を取得した後に間引かれたものは飛ばす
+	for _, ts := range timestamps {
+		snapshot, err := store.Get(ctx, ts)
+		if errors.Is(err, ErrSnapshotNotFound) {
+			continue
+		}
+		if err != nil {
+			return Snapshot{}, false, err
+		}
+		return snapshot, true, nil
+	}
+	return Snapshot{}, false, nil
+}
+
+// endpoint handler
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var season int
+	if v := r.URL.Query().Get("season"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid season parameter")
+			return
+		}
+		season = n
+	}
+	// 指定がなければ最新のスナップショットを返す
+	at := time.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		t, err := parseHistoryTime(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid at parameter: %v", err))
+			return
+		}
+		at = t
+	}
+
+	snapshot, ok, err := nearestStoredSnapshot(r.Context(), Snapshots, season, at)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Error loading snapshots: %v", err))
+		return
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, ErrSnapshotNotFound.Error())
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
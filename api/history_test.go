@@ -0,0 +1,258 @@
+package Handler
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Getで読み込んだ回数を数える保存先
+type countingSnapshotStore struct {
+	SnapshotStore
+	gets atomic.Int32
+}
+
+func (s *countingSnapshotStore) Get(ctx context.Context, ts time.Time) (Snapshot, error) {
+	s.gets.Add(1)
+	return s.SnapshotStore.Get(ctx, ts)
+}
+
+// Snapshotsをテスト用の保存先に差し替える
+func withSnapshots(t *testing.T, snapshots ...Snapshot) *countingSnapshotStore {
+	t.Helper()
+	store := &countingSnapshotStore{SnapshotStore: NewMemorySnapshotStore()}
+	for _, snapshot := range snapshots {
+		if err := store.Save(context.Background(), snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := Snapshots
+	t.Cleanup(func() { Snapshots = saved })
+	Snapshots = store
+	return store
+}
+
+// 基準の時刻から1時間ごとに取ったn件のスナップショット
+// i件目ではtrainer1がi%10+1位にいる
+func hourlySnapshots(base time.Time, n, season int) []Snapshot {
+	snapshots := make([]Snapshot, n)
+	for i := range snapshots {
+		rows := fixtureRows(1, 10)
+		rank := i%10 + 1
+		rows[0].Name, rows[rank-1].Name = rows[rank-1].Name, "trainer1"
+		snapshots[i] = Snapshot{
+			Timestamp: base.Add(time.Duration(i) * time.Hour),
+			Ranking:   RankingResponse{SeasonData: SeasonData{Season: season}, Top1000: rows},
+		}
+	}
+	return snapshots
+}
+
+func TestDownsampleTimestamps(t *testing.T) {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	hourly := make([]time.Time, 101)
+	for i := range hourly {
+		hourly[i] = base.Add(time.Duration(i) * time.Hour)
+	}
+
+	tests := []struct {
+		n     int
+		want  []int
+		wantN int
+	}{
+		{n: 5, want: []int{0, 25, 50, 75, 100}},
+		{n: 2, want: []int{0, 100}},
+		{n: 1, want: []int{100}},
+		{n: 30, wantN: 30},
+		{n: 101, wantN: 101},
+		{n: 500, wantN: 101},
+	}
+	for _, tt := range tests {
+		got := downsampleTimestamps(hourly, tt.n)
+		if len(got) > tt.n {
+			t.Errorf("downsample to %d returned %d points", tt.n, len(got))
+		}
+		if tt.wantN != 0 && len(got) != tt.wantN {
+			t.Errorf("downsample to %d returned %d points, want %d", tt.n, len(got), tt.wantN)
+		}
+		if tt.want == nil {
+			continue
+		}
+		want := make([]time.Time, len(tt.want))
+		for i, hour := range tt.want {
+			want[i] = hourly[hour]
+		}
+		assertTimes(t, got, want)
+	}
+}
+
+func TestSparklineHandler(t *testing.T) {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	// シーズン1の100件のうち4件に1件はtrainer1が圏外で、その後にシーズン2の20件がある
+	season1 := hourlySnapshots(base, 100, 1)
+	for i := 3; i < len(season1); i += 4 {
+		for j := range season1[i].Ranking.Top1000 {
+			if season1[i].Ranking.Top1000[j].Name == "trainer1" {
+				season1[i].Ranking.Top1000[j].Name = "someone"
+			}
+		}
+	}
+	season2 := hourlySnapshots(base.Add(100*time.Hour), 20, 2)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantPoints int
+		wantSeason int
+	}{
+		{target: "/trainer/sparkline?name=trainer1&season=1&points=30", wantStatus: http.StatusOK, wantPoints: 30, wantSeason: 1},
+		{target: "/trainer/sparkline?name=trainer1&season=1&points=7", wantStatus: http.StatusOK, wantPoints: 7, wantSeason: 1},
+		{target: "/trainer/sparkline?name=trainer1&season=1&points=1", wantStatus: http.StatusOK, wantPoints: 1, wantSeason: 1},
+		// 圏外だった時刻を除いた分だけ返す
+		{target: "/trainer/sparkline?name=trainer1&season=1&points=500", wantStatus: http.StatusOK, wantPoints: 75, wantSeason: 1},
+		// シーズンの指定がなければ最新のスナップショットのシーズン
+		{target: "/trainer/sparkline?name=trainer1&points=500", wantStatus: http.StatusOK, wantPoints: 20, wantSeason: 2},
+		{target: "/trainer/sparkline?name=trainer1", wantStatus: http.StatusOK, wantPoints: 20, wantSeason: 2},
+		{target: "/trainer/sparkline?name=trainer1&season=3", wantStatus: http.StatusOK, wantPoints: 0, wantSeason: 3},
+		{target: "/trainer/sparkline?name=nobody&points=30", wantStatus: http.StatusOK, wantPoints: 0, wantSeason: 2},
+		{target: "/trainer/sparkline?points=30", wantStatus: http.StatusBadRequest},
+		{target: "/trainer/sparkline?name=trainer1&points=0", wantStatus: http.StatusBadRequest},
+		{target: "/trainer/sparkline?name=trainer1&points=501", wantStatus: http.StatusBadRequest},
+		{target: "/trainer/sparkline?name=trainer1&season=x", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			store := withSnapshots(t, append(append([]Snapshot{}, season1...), season2...)...)
+			rec := get(t, SparklineHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response SparklineResponse
+			decodeBody(t, rec, &response)
+			if response.Points == nil {
+				t.Fatal("points is null, want an array")
+			}
+			if len(response.Points) != tt.wantPoints {
+				t.Errorf("points = %d, want %d", len(response.Points), tt.wantPoints)
+			}
+			// 他のシーズンのスナップショットは読み込まない
+			wantGets := map[int]int{1: len(season1), 2: len(season2)}[tt.wantSeason]
+			if gets := store.gets.Load(); int(gets) != wantGets {
+				t.Errorf("loaded %d snapshots, want the %d of season %d", gets, wantGets, tt.wantSeason)
+			}
+			seasonStart := base
+			if tt.wantSeason == 2 {
+				seasonStart = base.Add(100 * time.Hour)
+			}
+			for i, point := range response.Points {
+				if i > 0 && !point.Timestamp.After(response.Points[i-1].Timestamp) {
+					t.Errorf("points are not in time order: %v", response.Points)
+				}
+				if point.Timestamp.Before(seasonStart) || !point.Timestamp.Before(seasonStart.Add(100*time.Hour)) {
+					t.Errorf("point at %v is outside season %d", point.Timestamp, tt.wantSeason)
+				}
+				hour := int(point.Timestamp.Sub(base).Hours())
+				if want := hour%10 + 1; point.Rank != want {
+					t.Errorf("rank at hour %d = %d, want %d", hour, point.Rank, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	// 日本時間の2024-05-01 00:00
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, jst)
+	at := func(hours int) time.Time { return base.Add(time.Duration(hours) * time.Hour) }
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       time.Time
+	}{
+		{target: "/rankings/history?season=1&at=2024-05-01T00:00", wantStatus: http.StatusOK, want: at(0)},
+		{target: "/rankings/history?season=1&at=2024-05-01T01:30", wantStatus: http.StatusOK, want: at(2)},
+		// 同じだけ離れている場合は前のもの
+		{target: "/rankings/history?season=1&at=2024-05-01T01:00", wantStatus: http.StatusOK, want: at(0)},
+		// シーズン2の方が近くてもシーズン1のものを返す
+		{target: "/rankings/history?season=1&at=2024-05-01T05:00", wantStatus: http.StatusOK, want: at(2)},
+		{target: "/rankings/history?at=2024-05-01T05:00", wantStatus: http.StatusOK, want: at(5)},
+		{target: "/rankings/history?at=2024-04-30T17:00:00Z", wantStatus: http.StatusOK, want: at(2)},
+		{target: "/rankings/history?at=2024-05-01", wantStatus: http.StatusOK, want: at(0)},
+		{target: "/rankings/history", wantStatus: http.StatusOK, want: at(5)},
+		{target: "/rankings/history?season=3", wantStatus: http.StatusNotFound},
+		{target: "/rankings/history?at=yesterday", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/history?season=x", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			store := withSnapshots(t, fixtureSnapshot(at(0), 1), fixtureSnapshot(at(2), 1), fixtureSnapshot(at(5), 2))
+			rec := get(t, HistoryHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			// シーズンは一覧で絞り込むため、返すもの以外は読み込まない
+			wantGets := int32(0)
+			if tt.wantStatus == http.StatusOK {
+				wantGets = 1
+			}
+			if gets := store.gets.Load(); gets != wantGets {
+				t.Errorf("gets = %d, want %d", gets, wantGets)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var snapshot Snapshot
+			decodeBody(t, rec, &snapshot)
+			if !snapshot.Timestamp.Equal(tt.want) {
+				t.Errorf("timestamp = %v, want %v", snapshot.Timestamp, tt.want)
+			}
+			if len(snapshot.Ranking.Top1000) != 3 {
+				t.Errorf("got %d rows, want 3", len(snapshot.Ranking.Top1000))
+			}
+		})
+	}
+}
+
+// 起動時とInterval経過ごとにランキングを保存する
+func TestSnapshotterRun(t *testing.T) {
+	newFakeUpstream(t)
+	store := withSnapshots(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		(&Snapshotter{Store: store, Interval: 20 * time.Millisecond}).Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var timestamps []time.Time
+	for len(timestamps) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		infos, err := store.Index(ctx, SnapshotFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		timestamps = snapshotTimestamps(infos)
+	}
+	cancel()
+	<-done
+	if len(timestamps) < 3 {
+		t.Fatalf("got %d snapshots, want at least 3", len(timestamps))
+	}
+	snapshot, err := store.Get(context.Background(), timestamps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Ranking.SeasonData.Season != 1 || len(snapshot.Ranking.Top1000) != 1000 {
+		t.Errorf("got season %d with %d rows, want season 1 with 1000 rows", snapshot.Ranking.SeasonData.Season, len(snapshot.Ranking.Top1000))
+	}
+	if snapshot.Timestamp.Location() != time.UTC {
+		t.Errorf("timestamp location = %v, want UTC", snapshot.Timestamp.Location())
+	}
+}
@@ -0,0 +1,110 @@
+package Handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// アイコンのファイル名として受け付ける形式
+// /や..を含むものは別のパスを指せてしまうため受け付けない
+var iconFilePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*\.(png|jpg|jpeg|gif|webp)$`)
+
+// アイコン1件の大きさの上限
+const maxIconBytes = 1 << 20
+
+var iconCache = newTTLCache(CacheMaxEntries)
+
+// キャッシュするアイコン
+type cachedIcon struct {
+	data        []byte
+	contentType string
+}
+
+// 上流のアイコンが見つからない
+var errIconNotFound = errors.New("icon not found")
+
+// 上流のアイコンのパス
+const trainerIconPath = "/battledata/img/icons/trainer/"
+
+// resourceBaseURLのホストにあるアイコンのURL
+func iconURL(resourceBaseURL, file string) string {
+	return resourceBaseURL + trainerIconPath + file
+}
+
+// キャッシュを使ってアイコンを取得
+func cachedIconData(ctx context.Context, file string, maxAge time.Duration) (cachedIcon, error) {
+	if entry, ok := iconCache.get(file, maxAge, time.Now()); ok {
+		return entry.value.(cachedIcon), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", iconURL(DefaultClient.ResourceBaseURL, file), nil)
+	if err != nil {
+		return cachedIcon{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := doWithRetry(ctx, MaxAttempts, sendRequest(HTTPClient, req, newRetryBudget(RetryBudget)))
+	if err != nil {
+		return cachedIcon{}, fmt.Errorf("failed to fetch icon: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		return cachedIcon{}, fmt.Errorf("%w: %s", errIconNotFound, file)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return cachedIcon{}, fmt.Errorf("failed to fetch icon, status code: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxIconBytes+1))
+	if err != nil {
+		return cachedIcon{}, fmt.Errorf("failed to read icon: %v", err)
+	}
+	if len(data) > maxIconBytes {
+		return cachedIcon{}, fmt.Errorf("icon is larger than %d bytes", maxIconBytes)
+	}
+
+	// 画像以外の形式が返ってきた場合は拡張子から決める
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = mime.TypeByExtension(path.Ext(file))
+	}
+	icon := cachedIcon{data: data, contentType: contentType}
+	iconCache.set(file, icon, time.Now())
+	return icon, nil
+}
+
+// endpoint handler
+func IconHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	file := r.URL.Query().Get("file")
+	if !iconFilePattern.MatchString(file) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid file parameter")
+		return
+	}
+
+	maxAge := cacheTTL("/icon")
+	icon, err := cachedIconData(r.Context(), file, maxAge)
+	if err != nil {
+		if errors.Is(err, errIconNotFound) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", icon.contentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Write(icon.data)
+}
@@ -0,0 +1,83 @@
+package Handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// PNGのシグネチャ
+var fixtureIcon = []byte("\x89PNG\r\n\x1a\nicon")
+
+// アイコンだけを返すリソースのホストを立ててDefaultClientの取得先にする
+// icon_1.pngはimage/pngで、icon_2.jpgはContent-Typeなしで返し、それ以外は404
+func newFakeIconHost(t *testing.T) *atomic.Int32 {
+	t.Helper()
+	resetState(t)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch strings.TrimPrefix(r.URL.Path, trainerIconPath) {
+		case "icon_1.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(fixtureIcon)
+		case "icon_2.jpg":
+			w.Header()["Content-Type"] = nil
+			w.Write(fixtureIcon)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := DefaultClient
+	t.Cleanup(func() { DefaultClient = client })
+	DefaultClient = &Client{HTTPClient: server.Client(), APIBaseURL: server.URL, ResourceBaseURL: server.URL, Soft: defaultSoft}
+	return &calls
+}
+
+func TestIconHandler(t *testing.T) {
+	tests := []struct {
+		target          string
+		wantStatus      int
+		wantContentType string
+		wantCalls       int32
+	}{
+		{target: "/icon?file=icon_1.png", wantStatus: http.StatusOK, wantContentType: "image/png", wantCalls: 1},
+		{target: "/icon?file=icon_2.jpg", wantStatus: http.StatusOK, wantContentType: "image/jpeg", wantCalls: 1},
+		{target: "/icon?file=missing.png", wantStatus: http.StatusNotFound, wantCalls: 2},
+		{target: "/icon?file=../index.html", wantStatus: http.StatusBadRequest},
+		{target: "/icon?file=..%2Fsecret.png", wantStatus: http.StatusBadRequest},
+		{target: "/icon?file=dir/icon_1.png", wantStatus: http.StatusBadRequest},
+		{target: "/icon?file=..png", wantStatus: http.StatusBadRequest},
+		{target: "/icon?file=icon_1.exe", wantStatus: http.StatusBadRequest},
+		{target: "/icon", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			calls := newFakeIconHost(t)
+			// 2回目はキャッシュから返す
+			for i := 0; i < 2; i++ {
+				rec := get(t, IconHandler, tt.target)
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, rec.Code, tt.wantStatus, rec.Body)
+				}
+				if tt.wantStatus != http.StatusOK {
+					continue
+				}
+				if ct := rec.Header().Get("Content-Type"); ct != tt.wantContentType {
+					t.Errorf("request %d: Content-Type = %q, want %q", i, ct, tt.wantContentType)
+				}
+				if !bytes.Equal(rec.Body.Bytes(), fixtureIcon) {
+					t.Errorf("request %d: body = %q, want %q", i, rec.Body.Bytes(), fixtureIcon)
+				}
+			}
+			// 見つからなかったものはキャッシュしない
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -1,15 +1,82 @@
 package Handler
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+
+	"golang.org/x/text/language"
+	"log/slog"
 )
 
+// 一度に取得するランキングデータの最大ページ数
+const maxRankingDepth = 10
+
+// シーズンデータにランキングファイルのタイムスタンプがない
+var errRankingFileUnavailable = errors.New("ranking file address unavailable")
+
+// クライアントが持っているデータから更新がない
+var errNotModified = errors.New("ranking data not modified")
+
+// 指定されたrstが選択したシーズンのものと異なる
+var errRstMismatch = errors.New("rst does not match the selected season")
+
+// 指定されたシーズンがシーズンリストにない
+var errSeasonNotFound = errors.New("season not found")
+
+// 開催中のシーズンも、最終結果として返すシーズンもない
+var ErrNoActiveSeason = errors.New("no active season")
+
+// 上流が200以外のステータスコードを返した
+// upstreamStatusはerrors.Isでこのエラーとして扱われる
+var ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+// 取得できなかったページがあり、取得できた分だけを返す
+// fetchLatestRankingはこのエラーと一緒に取得できた分のレスポンスを返す
+var ErrInsufficientData = errors.New("insufficient ranking data")
+
+// レスポンスで返すシーズンの日時の形式
+const seasonTimeLayout = "2006-01-02 15:04:05"
+
+// 上流の日時は日本時間
+var jst = time.FixedZone("JST", 9*60*60)
+
+// シーズンの日時を日本時間として読む
+// 上流の "2006/01/02 15:04" 形式のほか、前後の空白、"-" 区切り、秒付きの形式も受け付ける
+func parseSeasonTime(value string) (time.Time, error) {
+	v := strings.Join(strings.Fields(value), " ")
+	if v == "" {
+		return time.Time{}, errors.New("season time is empty")
+	}
+	v = strings.Replace(v, "/", "-", -1)
+	for _, layout := range []string{seasonTimeLayout, "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, v, jst); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("season time %q is not in the form YYYY/MM/DD hh:mm", value)
+}
+
 // シーズンごとのデータを表す構造体
+// Cntはシーズンの参加者数（レートが付いたトレーナーの総数）、RankCntは順位が付いたトレーナー数と考えられる
+// Rstはランキングファイルのパス（/ranking/scvi/{cId}/{rst}/{ts}/）に使う値で、
+// 意味は公開されていないためシーズンリストの値をそのまま使う
+// Ts1、Ts2は桁の大きい整数のタイムスタンプで、浮動小数点数だと丸められるため整数で受け取る
 type SeasonData struct {
 	CID     string  `json:"cId"`
 	Cnt     float64 `json:"cnt"`
@@ -20,15 +87,86 @@ type SeasonData struct {
 	Rule    int     `json:"rule"`
 	Season  int     `json:"season"`
 	Start   string  `json:"start"`
-	Ts1     float64 `json:"ts1"`
-	Ts2     float64 `json:"ts2"`
+	Ts1     int64   `json:"ts1"`
+	Ts2     int64   `json:"ts2"`
+	// ソフト。上流が返さなかった場合も、選択したシーズンには指定したソフトを入れる
+	Soft string `json:"soft,omitempty"`
+
+	// Cntから求めた参加者数で、Cntが不正な値の場合は含めない
+	Participants *int64 `json:"participants,omitempty"`
+	// langを指定した場合の翻訳前のシーズン名
+	NameOriginal string `json:"name_original,omitempty"`
+	// ルールとrstの表示名で、表示名が無い値の場合は含めない
+	RuleLabel string `json:"rule_label,omitempty"`
+	RstLabel  string `json:"rst_label,omitempty"`
+	// 開催中のシーズンが無く、終了後SEASON_FINAL_GRACEの間のシーズンを最終結果として選択した
+	Final bool `json:"final,omitempty"`
+	// シーズンリストの内側のマップのキー
+	// 上流はcIdをキーにしているが、言語ごとに分けたリストを返した場合にどの言語のものかを残す
+	LangKey string `json:"lang_key,omitempty"`
+
+	// シーズンリストの外側（シーズン番号）と内側（cId）のマップのキー
+	// include_source=trueのときにselectedに含める
+	listKey   string
+	seasonKey string
+}
+
+// Cntを参加者数として整数に変換
+// 負の値、NaN、小数を含む値は参加者数として扱えないためnilを返す
+// math.MaxInt64はfloat64にすると2^63に丸められるため、2^63以上はint64に収まらない
+func participantCount(cnt float64) *int64 {
+	if math.IsNaN(cnt) || math.IsInf(cnt, 0) || cnt < 0 || cnt != math.Trunc(cnt) || cnt >= math.MaxInt64 {
+		return nil
+	}
+	n := int64(cnt)
+	return &n
 }
 
 // シーズンリスト
+// 外側のマップのキーはシーズン番号、内側のマップのキーはcId（言語ごとに分けたリストでは言語）
+// 内側のキーはLangKeyに残し、langと一致するものがあればそのシーズンを選ぶ。翻訳はSEASON_NAME_TRANSLATIONS_FILEで行う
 type SeasonList struct {
 	Seasons map[string]map[string]SeasonData `json:"list"`
 }
 
+// listが入れ子のマップでなく配列で返ってきた場合も読めるようにする
+// 配列の場合はシーズン番号とcIdをキーにした入れ子のマップに揃える
+func (l *SeasonList) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		List json.RawMessage `json:"list"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw.List) == 0 || string(raw.List) == "null" {
+		l.Seasons = nil
+		return nil
+	}
+
+	var nested map[string]map[string]SeasonData
+	nestedErr := json.Unmarshal(raw.List, &nested)
+	if nestedErr == nil {
+		l.Seasons = nested
+		return nil
+	}
+
+	var flat []SeasonData
+	if err := json.Unmarshal(raw.List, &flat); err != nil {
+		return fmt.Errorf("season list is neither a nested map (%v) nor an array (%v)", nestedErr, err)
+	}
+	// 上流の形式が変わったことに気付けるように、想定と違う形式のときだけ出す
+	slog.Debug("decoded season list as array")
+	l.Seasons = map[string]map[string]SeasonData{}
+	for _, seasonData := range flat {
+		key := strconv.Itoa(seasonData.Season)
+		if l.Seasons[key] == nil {
+			l.Seasons[key] = map[string]SeasonData{}
+		}
+		l.Seasons[key][seasonData.CID] = seasonData
+	}
+	return nil
+}
+
 // ランキング
 type RankResponseRawData struct {
 	Rank        int     `json:"rank"`
@@ -36,16 +174,108 @@ type RankResponseRawData struct {
 	Icon        string  `json:"icon"`
 	Name        string  `json:"name"`
 	Lng         string  `json:"lng"`
+	// 上流が返したscaleで割る前のレート
+	RatingRaw float64 `json:"rating_raw"`
+	// rating_display=trueのときのみ付ける桁区切り付きのレート
+	RatingDisplay string `json:"rating_display,omitempty"`
+	// fill_gaps=trueで上流に無かった順位を埋めた行
+	Placeholder bool `json:"placeholder,omitempty"`
 }
 
 // レスポンス
 type RankingResponse struct {
 	SeasonData SeasonData            `json:"season_data"`
+	Selected   SelectedSeason        `json:"selected"`
 	Top1000    []RankResponseRawData `json:"top_1000"`
+	Warnings   []string              `json:"warnings,omitempty"`
+	EmptyNames int                   `json:"empty_names,omitempty"`
+	Timing     *ResponseTiming       `json:"timing,omitempty"`
+	Source     *UpstreamSource       `json:"_source,omitempty"`
+	// メンテナンスモードで保存済みのスナップショットか、上流から取得できずに有効期間を過ぎたキャッシュを返した
+	// as_ofはそのデータを取得した日時
+	Stale bool       `json:"stale,omitempty"`
+	AsOf  *time.Time `json:"as_of,omitempty"`
+	// delta=trueで差分を返した場合のみ
+	Delta *RankingDelta `json:"delta,omitempty"`
+	// avg_topを指定した場合の上位N件の平均レート
+	AvgTop *float64 `json:"avg_top,omitempty"`
+	// 上流から取得できた行数
+	// シーズン序盤や参加者の少ないルールでは1000件に満たないことがある
+	ActualCount int `json:"actual_count"`
+	// 取得した時点でのシーズンの残り秒数と経過した割合（0〜100）
+	RemainingSeconds int64   `json:"remaining_seconds"`
+	ProgressPercent  float64 `json:"progress_percent"`
+	// dense_rank=trueで上流の順位から付け直した行があった
+	RanksAdjusted bool `json:"ranks_adjusted,omitempty"`
+	// 取得できなかったページがあり、取得できた分だけを返した
+	Partial bool `json:"partial,omitempty"`
+	// トレーナー名、アイコン、レートのいずれかが無かった行数
+	// 0でなければ上流の形式が変わった可能性がある
+	InvalidRows int `json:"invalid_rows,omitempty"`
+}
+
+// ランキングファイルの取得に使ったシーズンの値
+type SelectedSeason struct {
+	CID string `json:"cId"`
+	Rst int    `json:"rst"`
+	Ts  string `json:"ts"`
+	// include_source=trueのときのみ、シーズンリストでこのシーズンがあったキー
+	ListKey   string `json:"list_key,omitempty"`
+	SeasonKey string `json:"season_key,omitempty"`
+}
+
+// ランキングの取得条件
+type rankingQuery struct {
+	// 取得するページ数（1ページ1000件）
+	depth int
+	// trueなら2ページ目以降の取得に失敗した場合もエラーにする
+	strict bool
+	// キャッシュを使う場合の有効期間
+	maxAge time.Duration
+	// 指定された場合は選択したシーズンのRstと一致するか確認する
+	rst *int
+	// 上流へ送れるリクエストの残り回数
+	budget *retryBudget
+	// クライアントが最後に取得したデータのTs1
+	sinceTs1 string
+	// 指定された場合は現在のシーズンではなくこの番号のシーズンを使う
+	season *int
+	// 指定された場合はこのルールのシーズンを使う
+	rule *int
+	// 取得するソフト。空ならdefaultSoft
+	soft string
+	// 指定された場合はシーズンリストの内側のキーがこの言語のシーズンを優先する
+	lang string
+}
+
+// CDNによっては先頭にBOMが付くため、先頭の空白とBOMを読み飛ばす
+func skipLeadingBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	for {
+		b, _ := br.Peek(3)
+		if len(b) == 0 {
+			return br
+		}
+		switch {
+		case bytes.HasPrefix(b, []byte("\xEF\xBB\xBF")):
+			br.Discard(3)
+		case b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n':
+			br.Discard(1)
+		default:
+			return br
+		}
+	}
 }
 
-func fetchRankingData() (*SeasonList, error) {
-	req, err := http.NewRequest("POST", "https://api.battle.pokemon-home.com/tt/cbd/competition/rankmatch/list", strings.NewReader(`{"soft": "Sc"}`))
+func (c *Client) fetchRankingData(ctx context.Context, soft string, budget *retryBudget) (*SeasonList, error) {
+	started := time.Now()
+	seasonList, err := c.requestSeasonList(ctx, soft, budget)
+	observeUpstream(upstreamCallSeasonList, started, err)
+	return seasonList, err
+}
+
+func (c *Client) requestSeasonList(ctx context.Context, soft string, budget *retryBudget) (*SeasonList, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.APIBaseURL+"/tt/cbd/competition/rankmatch/list", strings.NewReader(fmt.Sprintf(`{"soft": %q}`, soft)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -53,158 +283,1122 @@ func fetchRankingData() (*SeasonList, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json, text/javascript, */*; q=0.01")
 	req.Header.Set("Accept-Language", "ja,en-US;q=0.9,en;q=0.8")
-	req.Header.Set("Origin", "https://resource.pokemon-home.com")
-	req.Header.Set("Referer", "https://resource.pokemon-home.com/")
+	req.Header.Set("Origin", c.ResourceBaseURL)
+	req.Header.Set("Referer", c.ResourceBaseURL+"/")
 	req.Header.Set("Sec-Fetch-Dest", "empty")
 	req.Header.Set("Sec-Fetch-Mode", "cors")
 	req.Header.Set("Sec-Fetch-Site", "same-site")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doWithRetry(ctx, MaxAttempts, sendRequest(c.HTTPClient, req, budget))
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %v", err)
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch data, status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf("failed to fetch data, status code: %w", upstreamStatus(resp.StatusCode))
 	}
 
-	var seasonList SeasonList
-	err = json.NewDecoder(resp.Body).Decode(&seasonList)
+	body, err := readUpstreamBody(resp.Body, &bytes.Buffer{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var seasonList SeasonList
+	if err := json.Unmarshal(body, &seasonList); err != nil {
+		logMalformedBody("season list", body, err)
+		return nil, fmt.Errorf("failed to decode response: %w", ErrDecode)
 	}
 
-	for _, season := range seasonList.Seasons {
-		for _, seasonData := range season {
-			startDate := strings.Replace(seasonData.Start, "/", "-", -1) + ":00"
-			start, err := time.Parse("2006-01-02 15:04:05", startDate)
+	// シーズンの切り替わり時に中身がnullのものが返ることがあるため取り除く
+	for listKey, season := range seasonList.Seasons {
+		if season == nil {
+			log.Printf("skipping season list entry %q with no seasons", listKey)
+			delete(seasonList.Seasons, listKey)
+		}
+	}
+
+	// 日時はここでseasonTimeLayoutの形式に揃えて保持し、以降はこの形式のものを使う
+	for listKey, season := range seasonList.Seasons {
+		for seasonKey, seasonData := range season {
+			start, err := parseSeasonTime(seasonData.Start)
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse start time: %v", err)
 			}
-			endDate := strings.Replace(seasonData.End, "/", "-", -1) + ":00"
-			end, err := time.Parse("2006-01-02 15:04:05", endDate)
+			end, err := parseSeasonTime(seasonData.End)
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse end time: %v", err)
 			}
-			seasonData.Start = start.Format("2006-01-02 15:04:05")
-			seasonData.End = end.Format("2006-01-02 15:04:05")
+			seasonData.Start = start.Format(seasonTimeLayout)
+			seasonData.End = end.Format(seasonTimeLayout)
+			seasonData.Participants = participantCount(seasonData.Cnt)
+			seasonData.listKey, seasonData.seasonKey = listKey, seasonKey
+			seasonData.LangKey = seasonKey
+			seasonData = enrichSeasonData(seasonData)
+			seasonList.Seasons[listKey][seasonKey] = seasonData
 		}
 	}
 
 	return &seasonList, nil
 }
 
+// ランキングファイルのURLに使うタイムスタンプ
+// Ts1がなければTs2を使い、どちらもなければURLを組み立てられない
+func rankingFileTimestamp(seasonData SeasonData) (string, error) {
+	switch {
+	case seasonData.Ts1 != 0:
+		return strconv.FormatInt(seasonData.Ts1, 10), nil
+	case seasonData.Ts2 != 0:
+		return strconv.FormatInt(seasonData.Ts2, 10), nil
+	}
+	return "", errRankingFileUnavailable
+}
+
+// 1ページ目の件数がRankCnt（1000を超える場合は1000）に対してこの割合未満なら、
+// CDNが一時的に途中までのファイルを返したとみなして取得し直す。0なら取得し直さない
+var ShortRankingRetryFraction = envFloat("SHORT_RANKING_RETRY_FRACTION", 0)
+
+// 1ページ目の件数がRankCntに対して少なすぎるか
+func isShortRanking(rows, rankCnt int) bool {
+	if ShortRankingRetryFraction <= 0 || rankCnt <= 0 {
+		return false
+	}
+	expected := rankCnt
+	if expected > 1000 {
+		expected = 1000
+	}
+	return float64(rows) < ShortRankingRetryFraction*float64(expected)
+}
+
 // 最新の1000位までのランキングデータを取得
-func fetchTop1000RankingData(cId string, rst int, ts1 string) ([]RankResponseRawData, error) {
-	rankingURL := fmt.Sprintf("https://resource.pokemon-home.com/battledata/ranking/scvi/%s/%d/%s/traner-1", cId, rst, ts1)
-	resp, err := http.Get(rankingURL)
+// まだ1000人に満たない場合は取得できた分だけを返す
+// 件数がrankCntに対して少なすぎる場合はMaxAttemptsまで取得し直し、budgetを使い切った場合は最後に取得できたものを使う
+func (c *Client) fetchTop1000RankingData(ctx context.Context, soft string, cId string, rst int, ts1 string, rankCnt int, budget *retryBudget) ([]RankResponseRawData, UpstreamSource, error) {
+	rankingData, source, err := c.fetchRankingPage(ctx, soft, cId, rst, ts1, 1, budget)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch top 1000 ranking data: %v", err)
+		return nil, UpstreamSource{}, err
+	}
+	for attempt := 1; attempt < MaxAttempts && isShortRanking(len(rankingData), rankCnt); attempt++ {
+		log.Printf("ranking data for %s/%d/%s has only %d rows for rankCnt %d, retrying", cId, rst, ts1, len(rankingData), rankCnt)
+		if err := waitRetry(ctx, attempt); err != nil {
+			return nil, UpstreamSource{}, err
+		}
+		retried, retriedSource, err := c.fetchRankingPage(ctx, soft, cId, rst, ts1, 1, budget)
+		if errors.Is(err, errRetryBudgetExhausted) {
+			break
+		}
+		if err != nil {
+			return nil, UpstreamSource{}, err
+		}
+		rankingData, source = retried, retriedSource
+	}
+	return rankingData, source, nil
+}
+
+// ランキングデータの指定ページのURL
+func (c *Client) rankingPageURL(soft string, cId string, rst int, ts1 string, page int) string {
+	return fmt.Sprintf("%s/battledata/ranking/%s/%s/%d/%s/traner-%d", c.ResourceBaseURL, softRankingPaths[soft], cId, rst, ts1, page)
+}
+
+// ランキングデータの指定ページを取得
+// 1ページ目が1000位まで、2ページ目が2000位まで
+// 上流のレスポンスヘッダーも返す
+func (c *Client) fetchRankingPage(ctx context.Context, soft string, cId string, rst int, ts1 string, page int, budget *retryBudget) ([]RankResponseRawData, UpstreamSource, error) {
+	started := time.Now()
+	rankingData, source, err := c.requestRankingPage(ctx, soft, cId, rst, ts1, page, budget)
+	observeUpstream(upstreamCallRanking, started, err)
+	return rankingData, source, err
+}
+
+func (c *Client) requestRankingPage(ctx context.Context, soft string, cId string, rst int, ts1 string, page int, budget *retryBudget) ([]RankResponseRawData, UpstreamSource, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.rankingPageURL(soft, cId, rst, ts1, page), nil)
+	if err != nil {
+		return nil, UpstreamSource{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := doWithRetry(ctx, MaxAttempts, sendRequest(c.HTTPClient, req, budget))
+	if err != nil {
+		return nil, UpstreamSource{}, fmt.Errorf("failed to fetch ranking data page %d: %w", page, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch top 1000 ranking data, status code: %d", resp.StatusCode)
+		return nil, UpstreamSource{}, fmt.Errorf("failed to fetch ranking data page %d, status code: %w", page, upstreamStatus(resp.StatusCode))
 	}
 
-	var rankingData []RankResponseRawData
-	err = json.NewDecoder(resp.Body).Decode(&rankingData)
+	// 読み込みとデコードのバッファは使い回し、変換した新しいスライスを返す
+	buf := getRankingDecodeBuffer()
+	defer putRankingDecodeBuffer(buf)
+	body, err := readUpstreamBody(resp.Body, &buf.body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode ranking data: %v", err)
+		return nil, UpstreamSource{}, fmt.Errorf("failed to read ranking data: %w", err)
+	}
+	if err := decodeRankingRows(body, &buf.rows); err != nil {
+		return nil, UpstreamSource{}, fmt.Errorf("failed to decode ranking data page %d: %w", page, err)
 	}
 
-	if len(rankingData) < 1000 {
-		return nil, fmt.Errorf("top 1000 ranking data is less than 1000")
+	rankingResponse := convertRawDataToResponse(buf.rows, ratingScale(soft), c.ResourceBaseURL)
+	if DebugChecks {
+		checkRatingsMonotonic(rankingResponse)
 	}
+	return rankingResponse, newUpstreamSource(resp.Header), nil
+}
+
+// トレーナー名が空の行の扱い
+const (
+	// そのまま返す
+	EmptyNameKeep = "keep"
+	// 行を取り除く
+	EmptyNameDrop = "drop"
+	// emptyNamePlaceholderに置き換える
+	EmptyNamePlaceholder = "placeholder"
+)
+
+const emptyNamePlaceholder = "(no name)"
 
-	rankingResponse := convertRawDataToResponse(rankingData)
-	return rankingResponse, nil
+// トレーナー名が空の行の扱い（EMPTY_NAME_MODE）
+var EmptyNameMode = os.Getenv("EMPTY_NAME_MODE")
+
+// トレーナー名が空の行をmodeに従って変換し、該当した行数を返す
+func convertEmptyNames(rankingData []RankResponseRawData, mode string) ([]RankResponseRawData, int) {
+	affected := 0
+	result := rankingData[:0]
+	for _, data := range rankingData {
+		if data.Name == "" {
+			affected++
+			switch mode {
+			case EmptyNameDrop:
+				continue
+			case EmptyNamePlaceholder:
+				data.Name = emptyNamePlaceholder
+			}
+		}
+		result = append(result, data)
+	}
+	return result, affected
 }
 
 // ランキングの元データから変換
-func convertRawDataToResponse(rawData []RankResponseRawData) []RankResponseRawData {
+// レートはscaleで割った値にし（割る前の値はRatingRawに残す）、順位の昇順に並べる（同じ順位は上流の並びのまま）
+// アイコンはresourceBaseURLのホストのURLにし、アイコンが無い行は不正なURLにならないように空のままにする
+func convertRawDataToResponse(rawData []RankResponseRawData, scale float64, resourceBaseURL string) []RankResponseRawData {
 	result := make([]RankResponseRawData, len(rawData))
 	for i, data := range rawData {
-		iconURL := fmt.Sprintf("https://resource.pokemon-home.com/battledata/img/icons/trainer/%s", data.Icon)
-		result[i].Icon = iconURL
-		result[i].RatingValue = data.RatingValue / 1000
+		if data.Icon != "" {
+			result[i].Icon = iconURL(resourceBaseURL, data.Icon)
+		}
+		result[i].RatingValue = data.RatingValue / scale
+		result[i].RatingRaw = data.RatingValue
 		result[i].Rank = data.Rank
 		result[i].Name = data.Name
 		result[i].Lng = data.Lng
 	}
+	// ボーダーやパーセンタイルの計算は順位順に並んでいる前提のため、上流の並びに関わらず順位順にする
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Rank < result[j].Rank })
+	if invalid := countInvalidRows(result); invalid > 0 {
+		log.Printf("warning: %d of %d ranking rows have no name, icon or rating", invalid, len(result))
+	}
 	return result
 }
 
+// トレーナー名、アイコン、レートのいずれかが無い行数
+// 上流でレートが無い場合は0になるため、0のレートも無いものとして数える
+func countInvalidRows(rankingData []RankResponseRawData) int {
+	invalid := 0
+	for _, data := range rankingData {
+		if data.Placeholder {
+			continue
+		}
+		if data.Name == "" || data.Icon == "" || data.RatingValue == 0 {
+			invalid++
+		}
+	}
+	return invalid
+}
+
+// trueでデータの整合性の確認を行う
+// 本番ではオーバーヘッドを避けるため無効にしておく
+var DebugChecks = os.Getenv("DEBUG_CHECKS") == "true"
+
+// 順位順に並んだランキングのレートが順位が下がるほど上がっていないか確認し、問題があれば警告を出す
+// 同率は許容する。警告が出る場合は上流のデータが壊れているか変換に誤りがある
+func checkRatingsMonotonic(rankingData []RankResponseRawData) bool {
+	ok := true
+	for i := 1; i < len(rankingData); i++ {
+		prev, cur := rankingData[i-1], rankingData[i]
+		if cur.RatingValue > prev.RatingValue {
+			log.Printf("warning: rating increases from rank %d (%v) to rank %d (%v)", prev.Rank, prev.RatingValue, cur.Rank, cur.RatingValue)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// 上流に無かった順位を空の行で埋める
+// 同率の後に順位が飛ぶのは正しいため、それまでの行数から次にあるべき順位を求めて足りない分だけ埋める
+func fillRankGaps(rankingData []RankResponseRawData) []RankResponseRawData {
+	result := make([]RankResponseRawData, 0, len(rankingData))
+	next := 1
+	for _, data := range rankingData {
+		for ; next < data.Rank; next++ {
+			result = append(result, RankResponseRawData{Rank: next, Placeholder: true})
+		}
+		result = append(result, data)
+		next++
+	}
+	return result
+}
+
+// 順位順に並んだランキングの上位n件の平均レート
+// n件に満たない場合はある分だけで計算する
+func averageTopRating(rankingData []RankResponseRawData, n int) float64 {
+	if n > len(rankingData) {
+		n = len(rankingData)
+	}
+	if n == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, data := range rankingData[:n] {
+		sum += data.RatingValue
+	}
+	return sum / float64(n)
+}
+
+// 順位がfromからtoまでの行を取得
+func rankRangeData(rankingData []RankResponseRawData, from, to int) []RankResponseRawData {
+	result := []RankResponseRawData{}
+	for _, data := range rankingData {
+		if data.Rank >= from && data.Rank <= to {
+			result = append(result, data)
+		}
+	}
+	return result
+}
+
+// 全体の分布がわかるように等間隔でn件を抽出
+func sampleRankingData(rankingData []RankResponseRawData, n int) []RankResponseRawData {
+	if n >= len(rankingData) {
+		return rankingData
+	}
+	result := make([]RankResponseRawData, n)
+	for i := 0; i < n; i++ {
+		result[i] = rankingData[i*len(rankingData)/n]
+	}
+	return result
+}
+
+// エラーに応じたステータスコード
+func rankingErrorStatus(err error) int {
+	if errors.Is(err, errRankingFileUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, errRstMismatch) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, errSeasonNotFound) || errors.Is(err, ErrNoActiveSeason) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, errRetryBudgetExhausted) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, ErrDecode) || errors.Is(err, ErrUpstreamUnavailable) {
+		return http.StatusBadGateway
+	}
+	if isTimeout(err) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// 上流へのリクエストがタイムアウトしたか
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // endpoint handler
 func RankingHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	seasonList, err := fetchRankingData()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching ranking data: %v", err), http.StatusInternalServerError)
+	sample := 0
+	if v := r.URL.Query().Get("sample"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid sample parameter: must be between 1 and 1000")
+			return
+		}
+		sample = n
+	}
+	delta := r.URL.Query().Get("delta") == "true"
+	if delta && sample > 0 {
+		writeJSONError(w, http.StatusBadRequest, "sample and delta cannot be used together")
+		return
+	}
+	knownHash := strings.Trim(r.URL.Query().Get("known_hash"), `"`)
+	fillGaps := r.URL.Query().Get("fill_gaps") == "true"
+	avgTop := 0
+	if v := r.URL.Query().Get("avg_top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid avg_top parameter: must be between 1 and 1000")
+			return
+		}
+		avgTop = n
+	}
+	if delta && fillGaps {
+		writeJSONError(w, http.StatusBadRequest, "fill_gaps and delta cannot be used together")
 		return
 	}
 
-	// 最新のシーズンデータ取得
-	latestSeasonData, err := getLatestSeasonData(seasonList.Seasons)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching latest season data: %v", err), http.StatusInternalServerError)
+	query := rankingQuery{
+		depth:    1,
+		strict:   r.URL.Query().Get("strict") == "true",
+		maxAge:   cacheTTL("/rankings"),
+		sinceTs1: r.URL.Query().Get("since_ts1"),
+		lang:     r.URL.Query().Get("lang"),
+	}
+	if v := r.URL.Query().Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRankingDepth {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid depth parameter: must be between 1 and %d", maxRankingDepth))
+			return
+		}
+		query.depth = n
+	}
+	// 指定した件数が入るだけのページを取得し、超えた分は返さない
+	top := 0
+	if v := r.URL.Query().Get("top"); v != "" {
+		if r.URL.Query().Get("depth") != "" {
+			writeJSONError(w, http.StatusBadRequest, "top and depth cannot be used together")
+			return
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRankingDepth*1000 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid top parameter: must be between 1 and %d", maxRankingDepth*1000))
+			return
+		}
+		top = n
+		query.depth = (n + 999) / 1000
+	}
+	if v := r.URL.Query().Get("rst"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid rst parameter")
+			return
+		}
+		query.rst = &n
+	}
+	if v := r.URL.Query().Get("season"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid season parameter")
+			return
+		}
+		query.season = &n
+	}
+	if v := r.URL.Query().Get("rule"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid rule parameter")
+			return
+		}
+		query.rule = &n
+	}
+	if v := r.URL.Query().Get("soft"); v != "" {
+		if !knownSoft(v) {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid soft parameter: %q is not a known soft", v))
+			return
+		}
+		query.soft = v
+	}
+	// 指定がなければ取得したすべての順位を返す
+	rankFrom, rankTo := 0, 0
+	if v := r.URL.Query().Get("from"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid from parameter: must be 1 or greater")
+			return
+		}
+		rankFrom = n
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > query.depth*1000 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid to parameter: must be between 1 and %d", query.depth*1000))
+			return
+		}
+		rankTo = n
+	}
+	if rankFrom > 0 || rankTo > 0 {
+		if rankFrom == 0 {
+			rankFrom = 1
+		}
+		if rankTo == 0 {
+			rankTo = query.depth * 1000
+		}
+		if rankFrom > rankTo {
+			writeJSONError(w, http.StatusBadRequest, "Invalid rank range: from must be less than or equal to to")
+			return
+		}
+	}
+	// lngとmin_ratingの絞り込み。順位は元のままで、一致しなければ空のリストを返す
+	rowFilter := RankingQueryFilter{Languages: parseLanguages(r.URL.Query().Get("lng"))}
+	if v := r.URL.Query().Get("min_rating"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			writeJSONError(w, http.StatusBadRequest, "Invalid min_rating parameter: must be a non-negative number")
+			return
+		}
+		rowFilter.RatingMin = &f
+	}
+	// Acceptで形式が変わるため、どの形式でもキャッシュに伝える
+	w.Header().Add("Vary", "Accept")
+	responseFormat := rankingFormat(r)
+	if responseFormat != rankingFormatJSON && responseFormat != rankingFormatCSV && responseFormat != rankingFormatJSONLines {
+		writeJSONError(w, http.StatusBadRequest, `Invalid format parameter: must be "json", "csv" or "jsonl"`)
+		return
+	}
+	sortBy := r.URL.Query().Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = rankingSortRank
+	case rankingSortRank, rankingSortRating, rankingSortName, rankingSortLng:
+	default:
+		writeJSONError(w, http.StatusBadRequest, `Invalid sort parameter: must be "rank", "rating", "name" or "lng"`)
+		return
+	}
+	order := r.URL.Query().Get("order")
+	switch order {
+	case "":
+		order = defaultRankingOrder(sortBy)
+	case rankingOrderAsc, rankingOrderDesc:
+	default:
+		writeJSONError(w, http.StatusBadRequest, `Invalid order parameter: must be "asc" or "desc"`)
+		return
+	}
+	ties := r.URL.Query().Get("ties")
+	if ties != "" && ties != rankingTiesRank && ties != rankingTiesName {
+		writeJSONError(w, http.StatusBadRequest, `Invalid ties parameter: must be "rank" or "name"`)
 		return
 	}
+	denseRank := r.URL.Query().Get("dense_rank") == "true"
+	// 差分や埋めた行の順位を付け直すと元のデータと合わなくなる
+	if denseRank && (delta || fillGaps) {
+		writeJSONError(w, http.StatusBadRequest, "dense_rank cannot be used with delta or fill_gaps")
+		return
+	}
+	var collation language.Tag
+	if sortBy == rankingSortRating || sortBy == rankingSortName || ties == rankingTiesName {
+		tag, err := collationTag(r.URL.Query().Get("locale"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid locale parameter: %v", err))
+			return
+		}
+		collation = tag
+	}
+	var displaySeparators *numberSeparators
+	if r.URL.Query().Get("rating_display") == "true" {
+		separators, err := separatorsFor(r.URL.Query().Get("locale"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid locale parameter: %v", err))
+			return
+		}
+		displaySeparators = &separators
+	}
 
-	// 上位1000位のランキングデータ取得
-	top1000Data, err := fetchTop1000RankingData(latestSeasonData.CID, latestSeasonData.Rst, fmt.Sprintf("%.0f", latestSeasonData.Ts1))
+	started := time.Now()
+	responseData, status, err := fetchLatestRanking(r.Context(), query)
+	summary := requestSummaryFrom(r.Context())
+	summary.recordFetch(status)
+	if errors.Is(err, errNotModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	// 取得できた分だけのレスポンスは200で返す
+	if err != nil && !errors.Is(err, ErrInsufficientData) {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+	elapsed := time.Since(started)
+	if status.rankingStale {
+		w.Header().Set("X-Cache", "STALE")
+		w.Header().Set("Age", strconv.Itoa(int(time.Since(status.rankingFetchedAt).Seconds())))
+	}
+
+	if top > 0 && len(responseData.Top1000) > top {
+		responseData.Top1000 = responseData.Top1000[:top]
+	}
+	if avgTop > 0 {
+		avg := averageTopRating(responseData.Top1000, avgTop)
+		responseData.AvgTop = &avg
+	}
+
+	hash, err := rankingHash(responseData.Top1000, normalizedRankingQuery(r.URL.Query(), responseFormat))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching top 1000 ranking data: %v", err), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	w.Header().Set("ETag", `"`+hash+`"`)
+	if knownHash == hash || etagMatches(r.Header.Get("If-None-Match"), hash) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	now := time.Now()
+	rememberRanking(hash, responseData.Top1000, now)
+	// 基準のデータを保持していなければすべての行を返す
+	if delta && knownHash != "" {
+		if previous, ok := previousRanking(knownHash, now); ok {
+			changed, removed := diffRankingData(previous, responseData.Top1000)
+			responseData.Top1000 = changed
+			responseData.Delta = &RankingDelta{BaseHash: knownHash, Removed: removed}
+		}
+	}
 
-	responseData := RankingResponse{
-		SeasonData: latestSeasonData,
-		Top1000:    top1000Data,
+	if fillGaps {
+		responseData.Top1000 = fillRankGaps(responseData.Top1000)
+	}
+	if ties == rankingTiesName {
+		sortTiesByName(responseData.Top1000, collation)
+	}
+	if denseRank {
+		responseData.RanksAdjusted = denseRanks(responseData.Top1000)
+	}
+	if len(rowFilter.Languages) > 0 || rowFilter.RatingMin != nil {
+		responseData.Top1000 = applyRankingQuery(responseData.Top1000, RankingQueryRequest{Filter: rowFilter})
+	}
+	if rankFrom > 0 {
+		responseData.Top1000 = rankRangeData(responseData.Top1000, rankFrom, rankTo)
 	}
+	if sample > 0 {
+		responseData.Top1000 = sampleRankingData(responseData.Top1000, sample)
+	}
+	sortRankingData(responseData.Top1000, sortBy, order == rankingOrderDesc, collation)
+	if displaySeparators != nil {
+		addRatingDisplay(responseData.Top1000, *displaySeparators)
+	}
+	responseData.SeasonData = localizeSeasonData(responseData.SeasonData, r.URL.Query().Get("lang"))
+	if r.URL.Query().Get("include_timing") == "true" {
+		responseData.Timing = newResponseTiming(status)
+	}
+	if r.URL.Query().Get("include_source") == "true" {
+		responseData.Source = &status.source
+		responseData.Selected.ListKey = responseData.SeasonData.listKey
+		responseData.Selected.SeasonKey = responseData.SeasonData.seasonKey
+	}
+
+	// 同じデータと条件のレスポンスの行は、形式とgzipで圧縮するかごとにエンコード済みのものを使い回す
+	// 差分は保持している基準のデータによって変わるため対象外
+	cacheRows := status.rankingVersion != "" && !delta
+	encodedKey := normalizedRankingQuery(r.URL.Query(), responseFormat)
 
-	if err := json.NewEncoder(w).Encode(responseData); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+	if responseFormat == rankingFormatCSV {
+		summary.recordResponse(len(responseData.Top1000), rankingFormatCSV)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="rankings-season%d.csv"`, responseData.SeasonData.Season))
+		encode := func() ([]byte, error) {
+			var buf bytes.Buffer
+			err := writeRankingCSV(&buf, responseData.Top1000)
+			return buf.Bytes(), err
+		}
+		var err error
+		if cacheRows {
+			err = writeEncodedRanking(w, r, status.rankingVersion, encodedKey, nil, nil, encode)
+		} else {
+			err = writeRankingCSV(w, responseData.Top1000)
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		}
+		return
+	}
+	if responseFormat == rankingFormatJSONLines {
+		summary.recordResponse(len(responseData.Top1000), rankingFormatJSONLines)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writeRankingJSONLines(w, responseData)
 		return
 	}
+
+	format := "json"
+	if UseResponseEnvelope {
+		format = "envelope"
+	}
+	summary.recordResponse(len(responseData.Top1000), format)
+
+	var body interface{} = responseData
+	if cacheRows {
+		// 行以外はリクエストごとにエンコードする
+		body = encodedRankingResponse{RankingResponse: responseData, Top1000: json.RawMessage("0")}
+	}
+	if UseResponseEnvelope {
+		body = ResponseEnvelope{
+			Data: body,
+			Meta: newResponseMeta(responseData.SeasonData, status, elapsed),
+		}
+	}
+
+	if cacheRows {
+		prefix, suffix, err := encodeAroundRows(body)
+		if err == nil {
+			err = writeEncodedRanking(w, r, status.rankingVersion, encodedKey, prefix, suffix, func() ([]byte, error) {
+				return json.Marshal(responseData.Top1000)
+			})
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		}
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// 最新シーズンのデータと上位1000位のランキングデータを取得
+func fetchLatestRanking(ctx context.Context, query rankingQuery) (RankingResponse, fetchStatus, error) {
+	var status fetchStatus
+	// メンテナンスモードなら上流には問い合わせない
+	if snapshot, ok := maintenance.current(); ok {
+		return maintenanceRanking(snapshot), status, nil
+	}
+	if query.budget == nil {
+		query.budget = newRetryBudget(RetryBudget)
+	}
+
+	latestSeasonData, err := selectLatestSeason(ctx, query, &status)
+	if err != nil {
+		return RankingResponse{}, status, err
+	}
+
+	// クライアントが持っているデータから更新がなければランキングデータは取得しない
+	if query.sinceTs1 != "" && query.sinceTs1 == strconv.FormatInt(latestSeasonData.Ts1, 10) {
+		return RankingResponse{}, status, errNotModified
+	}
+
+	// 指定されたrstが選択したシーズンと異なる場合は別のランキングファイルを指してしまうためエラーにする
+	if query.rst != nil && *query.rst != latestSeasonData.Rst {
+		return RankingResponse{}, status, fmt.Errorf("%w: requested %d, selected season has %d", errRstMismatch, *query.rst, latestSeasonData.Rst)
+	}
+
+	response := RankingResponse{
+		SeasonData: latestSeasonData,
+		Selected:   newSelectedSeason(latestSeasonData),
+	}
+
+	// 上位1000位のランキングデータ取得
+	started := time.Now()
+	var partialErr error
+	if query.depth > 1 {
+		pages, err := DefaultClient.fetchSeasonRankingPages(ctx, latestSeasonData, query.depth, query.strict, query.budget)
+		status.rankingElapsed = time.Since(started)
+		if errors.Is(err, ErrInsufficientData) {
+			partialErr = err
+			response.Partial = true
+		} else if err != nil {
+			return RankingResponse{}, status, fmt.Errorf("Error fetching ranking data pages: %w", err)
+		}
+		response.Top1000 = pages.rows
+		response.Warnings = pages.warnings
+		status.source = pages.source
+	} else {
+		top1000Data, info, err := cachedSeasonRanking(ctx, latestSeasonData, query.maxAge, query.budget)
+		status.rankingElapsed = time.Since(started)
+		status.rankingCached = info.hit
+		status.rankingVersion = info.version
+		status.source = info.source
+		if err != nil {
+			return RankingResponse{}, status, fmt.Errorf("Error fetching top 1000 ranking data: %w", err)
+		}
+		response.Top1000 = top1000Data
+		if info.stale {
+			status.rankingStale, status.rankingFetchedAt = true, info.fetchedAt
+			response.Stale = true
+			asOf := info.fetchedAt
+			response.AsOf = &asOf
+		}
+	}
+
+	// placeholderで名前を置き換える前に数える
+	response.InvalidRows = countInvalidRows(response.Top1000)
+	response.Top1000, response.EmptyNames = convertEmptyNames(response.Top1000, EmptyNameMode)
+	response.ActualCount = len(response.Top1000)
+	response.RemainingSeconds, response.ProgressPercent = seasonProgress(latestSeasonData, time.Now())
+	return response, status, partialErr
+}
+
+// 現在のシーズンデータを取得
+// シーズン中は選択済みのシーズンをシーズンリストから直接引き、Ts1などはシーズンリストの最新の値を使う
+// シーズンの指定があればシーズンリストからそのシーズンを探す
+func selectLatestSeason(ctx context.Context, query rankingQuery, status *fetchStatus) (SeasonData, error) {
+	soft := softOrDefault(query.soft)
+	if query.season != nil {
+		started := time.Now()
+		seasonList, cached, err := cachedSeasonList(ctx, soft, query.maxAge, query.budget)
+		status.seasonListElapsed = time.Since(started)
+		status.seasonListCached = cached
+		if err != nil {
+			return SeasonData{}, fmt.Errorf("Error fetching ranking data: %w", err)
+		}
+		seasonData, err := findSeasonData(seasonList.Seasons, *query.season, query.rule, query.lang)
+		if err != nil {
+			return SeasonData{}, err
+		}
+		seasonData.Soft = soft
+		return seasonData, nil
+	}
+
+	started := time.Now()
+	seasonList, cached, err := cachedSeasonList(ctx, soft, query.maxAge, query.budget)
+	status.seasonListElapsed = time.Since(started)
+	status.seasonListCached = cached
+	if err != nil {
+		return SeasonData{}, fmt.Errorf("Error fetching ranking data: %w", err)
+	}
+
+	key := selectionKey(soft, query.rule, selectionLang(seasonList.Seasons, query.lang))
+	if latestSeasonData, ok := selectionCache.lookup(key, seasonList.Seasons, time.Now()); ok {
+		status.selectionCached = true
+		latestSeasonData.Soft = soft
+		return latestSeasonData, nil
+	}
+
+	// 最新のシーズンデータ取得
+	latestSeasonData, err := getLatestSeasonData(seasonList.Seasons, soft, query.rule, query.lang, time.Now())
+	if err != nil {
+		return SeasonData{}, fmt.Errorf("Error fetching latest season data: %w", err)
+	}
+	latestSeasonData.Soft = soft
+	selectionCache.set(key, latestSeasonData)
+	return latestSeasonData, nil
+}
+
+// 選択したシーズンのランキングファイルの指定
+func newSelectedSeason(seasonData SeasonData) SelectedSeason {
+	ts, _ := rankingFileTimestamp(seasonData)
+	return SelectedSeason{
+		CID: seasonData.CID,
+		Rst: seasonData.Rst,
+		Ts:  ts,
+	}
+}
+
+// 指定シーズンの上位1000位のランキングデータを取得
+func (c *Client) fetchSeasonRanking(ctx context.Context, seasonData SeasonData, budget *retryBudget) ([]RankResponseRawData, UpstreamSource, error) {
+	ts, err := rankingFileTimestamp(seasonData)
+	if err != nil {
+		return nil, UpstreamSource{}, err
+	}
+	return c.fetchTop1000RankingData(ctx, softOrDefault(seasonData.Soft), seasonData.CID, seasonData.Rst, ts, seasonData.RankCnt, budget)
+}
+
+// 複数ページ分のランキングデータ
+type rankingPages struct {
+	rows     []RankResponseRawData
+	warnings []string
+	// 1ページ目のレスポンスヘッダー
+	source UpstreamSource
+}
+
+// 指定シーズンのランキングデータを複数ページ分取得
+// 1ページ分に満たないページか、404のページがあればそこで終わりとみなす
+// 2ページ目以降の取得に失敗した場合、strictでなければ取得できたページまでを警告付きで返す
+// その場合はErrInsufficientDataを包んだエラーも返す
+func (c *Client) fetchSeasonRankingPages(ctx context.Context, seasonData SeasonData, depth int, strict bool, budget *retryBudget) (rankingPages, error) {
+	rankingData, source, err := c.fetchSeasonRanking(ctx, seasonData, budget)
+	if err != nil {
+		return rankingPages{}, err
+	}
+
+	pages := rankingPages{rows: rankingData, source: source}
+	var partialErr error
+	// 1000件に満たなければ次のページは無い
+	if len(rankingData) < 1000 {
+		return pages, nil
+	}
+	ts, _ := rankingFileTimestamp(seasonData)
+	for page := 2; page <= depth; page++ {
+		pageData, err := c.sharedRankingPage(ctx, seasonData, ts, page, budget)
+		var status upstreamStatus
+		if errors.As(err, &status) && status == http.StatusNotFound {
+			break
+		}
+		if err != nil {
+			if strict {
+				return rankingPages{}, err
+			}
+			pages.warnings = append(pages.warnings, fmt.Sprintf("page %d was not fetched: %v", page, err))
+			partialErr = fmt.Errorf("%w: page %d was not fetched: %w", ErrInsufficientData, page, err)
+			break
+		}
+		pages.rows = append(pages.rows, pageData...)
+		if len(pageData) < 1000 {
+			break
+		}
+	}
+	// ページの境目で順位が前後していても順位順になるようにする
+	sort.SliceStable(pages.rows, func(i, j int) bool { return pages.rows[i].Rank < pages.rows[j].Rank })
+	return pages, partialErr
+}
+
+// 2ページ目以降のランキングファイルを取得
+// キャッシュしないページも、同時に同じページを取得する場合は上流へのリクエストを1回にまとめる
+// まとめた結果は呼び出し側の間で共有するため書き換えない
+func (c *Client) sharedRankingPage(ctx context.Context, seasonData SeasonData, ts string, page int, budget *retryBudget) ([]RankResponseRawData, error) {
+	soft := softOrDefault(seasonData.Soft)
+	key := fmt.Sprintf("%s/%s/%d/%s/%d", soft, seasonData.CID, seasonData.Rst, ts, page)
+	value, err := sharedFetch(ctx, &rankingPageFlight, key, func(ctx context.Context) (interface{}, error) {
+		pageData, _, err := c.fetchRankingPage(ctx, soft, seasonData.CID, seasonData.Rst, ts, page, budget)
+		return pageData, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]RankResponseRawData), nil
+}
+
+// すべてのエンドポイントのパスの前に付ける文字列
+// ROUTE_PREFIX="/api/v1" ならランキングは /api/v1/rankings になる
+var RoutePrefix = strings.TrimSuffix(os.Getenv("ROUTE_PREFIX"), "/")
+
+// エンドポイントをprefix配下に登録
+func registerRoutes(mux *http.ServeMux, prefix string) {
+	mux.HandleFunc(prefix+"/rankings", withRequestLog(withCompression(RankingHandler)))
+	mux.HandleFunc(prefix+"/rankings/query", withRequestLog(withCompression(RankingQueryHandler)))
+	mux.HandleFunc(prefix+"/rankings/search", withRequestLog(withCompression(RankingSearchHandler)))
+	mux.HandleFunc(prefix+"/rankings/rank", withRequestLog(withCompression(RankLookupHandler)))
+	mux.HandleFunc(prefix+"/rankings/cutoff", withRequestLog(withCompression(CutoffHandler)))
+	mux.HandleFunc(prefix+"/rankings/cutoff/compare", withRequestLog(withCompression(CutoffCompareHandler)))
+	mux.HandleFunc(prefix+"/rankings/cutoff/seasons", withRequestLog(withCompression(CutoffSeasonsHandler)))
+	mux.HandleFunc(prefix+"/rankings/batch", withRequestLog(withCompression(BatchHandler)))
+	mux.HandleFunc(prefix+"/rankings/history", withRequestLog(withCompression(HistoryHandler)))
+	mux.HandleFunc(prefix+"/rankings/diff", withRequestLog(withCompression(RankingDiffHandler)))
+	mux.HandleFunc(prefix+"/rankings/stats", withRequestLog(withCompression(StatsHandler)))
+	mux.HandleFunc(prefix+"/rankings/overview", withRequestLog(withCompression(OverviewHandler)))
+	mux.HandleFunc(prefix+"/rankings/percentiles", withRequestLog(withCompression(PercentilesHandler)))
+	mux.HandleFunc(prefix+"/rankings/histogram", withRequestLog(withCompression(HistogramHandler)))
+	mux.HandleFunc(prefix+"/rankings/cdf", withRequestLog(withCompression(CDFHandler)))
+	mux.HandleFunc(prefix+"/rankings/tiers", withRequestLog(withCompression(TiersHandler)))
+	mux.HandleFunc(prefix+"/rankings/threshold", withRequestLog(withCompression(ThresholdHandler)))
+	mux.HandleFunc(prefix+"/rankings/climb", withRequestLog(withCompression(ClimbHandler)))
+	mux.HandleFunc(prefix+"/rankings/estimate", withRequestLog(withCompression(EstimateHandler)))
+	mux.HandleFunc(prefix+"/seasons", withRequestLog(withCompression(SeasonsHandler)))
+	mux.HandleFunc(prefix+"/seasons/active", withRequestLog(withCompression(ActiveSeasonsHandler)))
+	mux.HandleFunc(prefix+"/seasons/schedule", withRequestLog(withCompression(SeasonScheduleHandler)))
+	mux.HandleFunc(prefix+"/season/current", withRequestLog(withCompression(CurrentSeasonHandler)))
+	mux.HandleFunc(prefix+"/season/current.ics", withRequestLog(withCompression(SeasonCalendarHandler)))
+	mux.HandleFunc(prefix+"/trainer/sparkline", withRequestLog(withCompression(SparklineHandler)))
+	mux.HandleFunc(prefix+"/icon", withRequestLog(withCompression(IconHandler)))
+	// 監視から頻繁に呼ばれるためリクエストごとのログはDebugで出す
+	mux.HandleFunc(prefix+"/healthz", withRequestLogLevel(slog.LevelDebug, HealthzHandler))
+	mux.HandleFunc(prefix+"/readyz", withRequestLogLevel(slog.LevelDebug, ReadyzHandler))
+	mux.HandleFunc(prefix+"/metrics", withRequestLogLevel(slog.LevelDebug, MetricsHandler.ServeHTTP))
+	mux.HandleFunc(prefix+"/openapi.json", withRequestLog(withCompression(OpenAPIHandler)))
+	mux.HandleFunc(prefix+"/admin/maintenance", withRequestLog(withCompression(MaintenanceHandler)))
 }
 
 func Handler() {
-	http.HandleFunc("/rankings", RankingHandler)
+	registerRoutes(http.DefaultServeMux, RoutePrefix)
+
+	if os.Getenv("MAINTENANCE_MODE") == "true" {
+		if err := maintenance.enable(MaintenanceSnapshotFile); err != nil {
+			log.Printf("failed to enable maintenance mode: %v", err)
+		}
+	}
+
+	// SIGINTかSIGTERMを受け取ったらキャンセルされる
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	fmt.Println("Server is running on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	if CacheRefreshInterval > 0 {
+		refresher := &CacheRefresher{Interval: CacheRefreshInterval}
+		go refresher.Run(ctx)
+	}
+	if SnapshotCompactionInterval > 0 {
+		go runSnapshotCompaction(ctx, Snapshots, SnapshotCompactionInterval)
+	}
+	if SnapshotInterval > 0 {
+		snapshotter := &Snapshotter{Store: Snapshots, Interval: SnapshotInterval}
+		go snapshotter.Run(ctx)
+	}
+
+	if SeasonWebhookURL != "" {
+		watcher, err := NewSeasonWatcher(SeasonWebhookURL, SeasonWebhookInterval, SeasonWebhookStateFile, SeasonWebhookTemplate)
+		if err != nil {
+			log.Printf("failed to start season watcher: %v", err)
+		} else {
+			go watcher.Run(ctx)
+		}
+	}
+
+	var handler http.Handler = http.DefaultServeMux
+	if RateLimit > 0 {
+		handler = withRateLimit(handler, newIPRateLimiter(RateLimit, RateLimitBurst, RateLimitIdleTimeout))
+	}
+	// 429のレスポンスもブラウザから読めるようにCORSは最後に包む
+	handler = withCORS(handler, CORSAllowedOrigins, CORSAllowCredentials)
+	srv := &http.Server{Addr: ":" + Port, Handler: handler}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Server is running on port %s\n", Port)
+	if err := serve(ctx, srv, ln); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// lnで受け付け、ctxがキャンセルされたら処理中のリクエストが終わるまでShutdownTimeoutだけ待って止める
+// 受け付けを続けられなくなった場合はそのエラーを返す
+func serve(ctx context.Context, srv *http.Server, ln net.Listener) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down, waiting up to %s for in-flight requests", ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to shut down gracefully: %v", err)
+		return nil
+	}
+	log.Printf("server stopped")
+	return nil
 }
 
+// 終了のシグナルを受け取ってから処理中のリクエストを待つ時間
+var ShutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", 15*time.Second)
+
 // 最新のシーズンデータ取得
-func getLatestSeasonData(seasons map[string]map[string]SeasonData) (SeasonData, error) {
-	now := time.Now()
+// ruleの指定があればそのルールのシーズンから選ぶ
+// 複数のルールのシーズンが開催中の場合はルールの番号が小さいもの（シングル）を返す
+// シーズンリストに他のソフトのシーズンが混ざっていても、softのシーズンから選ぶ
+// 同じルールのシーズンが複数ある場合は、シーズンリストの内側のキーがlangのものを返す
+// シーズンの日時は日本時間のため、nowも日本時間にして比べる
+func getLatestSeasonData(seasons map[string]map[string]SeasonData, soft string, rule *int, lang string, now time.Time) (SeasonData, error) {
+	now = now.In(jst)
+	var found *SeasonData
 	// 現在時刻がシーズンの開始日時と終了日時の間にあるものを取得
+	// 日時の形式はfetchRankingDataで揃えてあるため、比べるために読むだけにする
 	for _, season := range seasons {
-		for _, seasonData := range season {
-			seasonData.Start = strings.Replace(seasonData.Start, "/", "-", -1) + ":00"
-			start, err := time.Parse("2006-01-02 15:04:05", seasonData.Start)
+		if season == nil {
+			continue
+		}
+		for langKey, seasonData := range season {
+			seasonData.LangKey = langKey
+			start, err := parseSeasonTime(seasonData.Start)
 			if err != nil {
 				return SeasonData{}, fmt.Errorf("failed to parse start time: %v", err)
 			}
-			seasonData.End = strings.Replace(seasonData.End, "/", "-", -1) + ":00"
-			end, err := time.Parse("2006-01-02 15:04:05", seasonData.End)
+			end, err := parseSeasonTime(seasonData.End)
 			if err != nil {
 				return SeasonData{}, fmt.Errorf("failed to parse end time: %v", err)
 			}
-			if now.After(start) && now.Before(end) {
-				return seasonData, nil
+			if !matchesSoft(seasonData, soft) || !now.After(start) || !now.Before(end) || (rule != nil && seasonData.Rule != *rule) {
+				continue
+			}
+			if found == nil || preferSeason(seasonData, *found, lang) {
+				seasonData := seasonData
+				found = &seasonData
+			}
+		}
+	}
+	if found == nil {
+		return getFinalSeasonData(seasons, soft, rule, lang, now)
+	}
+	return *found, nil
+}
+
+// ルールの番号が小さいシーズンを優先し、同じルールならシーズンリストの内側のキーがlangのものを優先する
+func preferSeason(candidate, found SeasonData, lang string) bool {
+	if candidate.Rule != found.Rule {
+		return candidate.Rule < found.Rule
+	}
+	return lang != "" && candidate.LangKey == lang && found.LangKey != lang
+}
+
+// シーズンの終了後、最終結果を現在のシーズンとして返す期間
+// 0なら終了したシーズンは選択しない
+var SeasonFinalGrace = envDuration("SEASON_FINAL_GRACE", 0)
+
+// 終了してからSeasonFinalGraceが経っていないシーズンのうち、最後に終了したものをFinalにして返す
+// 同時に終了したものはgetLatestSeasonDataと同じくルールとlangで選ぶ
+func getFinalSeasonData(seasons map[string]map[string]SeasonData, soft string, rule *int, lang string, now time.Time) (SeasonData, error) {
+	var found *SeasonData
+	var foundEnd time.Time
+	if SeasonFinalGrace > 0 {
+		for _, season := range seasons {
+			for langKey, seasonData := range season {
+				seasonData.LangKey = langKey
+				end, err := parseSeasonTime(seasonData.End)
+				if err != nil {
+					return SeasonData{}, fmt.Errorf("failed to parse end time: %v", err)
+				}
+				if !matchesSoft(seasonData, soft) || now.Before(end) || !now.Before(end.Add(SeasonFinalGrace)) || (rule != nil && seasonData.Rule != *rule) {
+					continue
+				}
+				if found == nil || end.After(foundEnd) || (end.Equal(foundEnd) && preferSeason(seasonData, *found, lang)) {
+					seasonData := seasonData
+					found, foundEnd = &seasonData, end
+				}
+			}
+		}
+	}
+	if found == nil {
+		return SeasonData{}, ErrNoActiveSeason
+	}
+	found.Final = true
+	return *found, nil
+}
+
+// trueならソフトが入っていないシーズンを選択の候補にしない
+// falseならソフトを指定して取得したシーズンリストのため、指定したソフトのシーズンとして扱う
+var RequireSeasonSoft = os.Getenv("REQUIRE_SEASON_SOFT") == "true"
+
+// シーズンデータがsoftのものか
+func matchesSoft(seasonData SeasonData, soft string) bool {
+	if seasonData.Soft == "" {
+		return !RequireSeasonSoft
+	}
+	return seasonData.Soft == soft
+}
+
+// シーズン番号からシーズンデータを取得
+// 同じシーズン番号が複数のルールにある場合は、ruleの指定があればそのルールのものを、なければルールの番号が小さいものを返す
+// 同じルールのものが複数ある場合は、シーズンリストの内側のキーがlangのものを返す
+func findSeasonData(seasons map[string]map[string]SeasonData, seasonNumber int, rule *int, lang string) (SeasonData, error) {
+	var found *SeasonData
+	for _, season := range seasons {
+		for langKey, seasonData := range season {
+			seasonData.LangKey = langKey
+			if seasonData.Season != seasonNumber || (rule != nil && seasonData.Rule != *rule) {
+				continue
+			}
+			if found == nil || preferSeason(seasonData, *found, lang) {
+				seasonData := seasonData
+				found = &seasonData
 			}
 		}
 	}
-	return SeasonData{}, fmt.Errorf("no season data available")
+	if found == nil {
+		if rule != nil {
+			return SeasonData{}, fmt.Errorf("%w: season %d with rule %d", errSeasonNotFound, seasonNumber, *rule)
+		}
+		return SeasonData{}, fmt.Errorf("%w: season %d", errSeasonNotFound, seasonNumber)
+	}
+	return *found, nil
 }
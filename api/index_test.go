@@ -0,0 +1,1410 @@
+package Handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 言語ごとに分けたシーズンリスト
+// シーズン1は日本語と英語、シーズン2は日本語だけで、英語のシーズン1はダブル
+func multiLanguageSeasons(now time.Time) map[string]map[string]SeasonData {
+	ja := fixtureSeason(1, "10001", 0, now)
+	en := fixtureSeason(1, "10001", 0, now)
+	en.Name = "Season 1"
+	enDouble := fixtureSeason(1, "10002", 1, now)
+	enDouble.Name = "Season 1 Doubles"
+	past := fixtureSeason(2, "10003", 0, now.Add(-30*24*time.Hour))
+	return map[string]map[string]SeasonData{
+		"1": {"ja": ja, "en": en},
+		"2": {"ja": past},
+		"3": {"en": enDouble},
+	}
+}
+
+func TestGetLatestSeasonDataLanguage(t *testing.T) {
+	now := time.Now()
+	seasons := multiLanguageSeasons(now)
+	double := 1
+
+	tests := []struct {
+		name     string
+		lang     string
+		rule     *int
+		wantName string
+		wantKey  string
+	}{
+		{name: "japanese", lang: "ja", wantName: "シーズン1", wantKey: "ja"},
+		{name: "english", lang: "en", wantName: "Season 1", wantKey: "en"},
+		{name: "unknown language falls back", lang: "fr", wantKey: ""},
+		{name: "rule before language", lang: "ja", rule: &double, wantName: "Season 1 Doubles", wantKey: "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seasonData, err := getLatestSeasonData(seasons, defaultSoft, tt.rule, tt.lang, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantKey == "" {
+				if seasonData.Season != 1 || seasonData.Rule != 0 || seasonData.LangKey == "" {
+					t.Errorf("got season %d rule %d lang_key %q, want season 1 rule 0 with a lang_key", seasonData.Season, seasonData.Rule, seasonData.LangKey)
+				}
+				return
+			}
+			if seasonData.Name != tt.wantName || seasonData.LangKey != tt.wantKey {
+				t.Errorf("got %q (%q), want %q (%q)", seasonData.Name, seasonData.LangKey, tt.wantName, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestFindSeasonDataLanguage(t *testing.T) {
+	seasons := multiLanguageSeasons(time.Now())
+	tests := []struct {
+		lang    string
+		season  int
+		wantKey string
+	}{
+		{lang: "ja", season: 1, wantKey: "ja"},
+		{lang: "en", season: 1, wantKey: "en"},
+		{lang: "en", season: 2, wantKey: "ja"},
+	}
+	for _, tt := range tests {
+		seasonData, err := findSeasonData(seasons, tt.season, nil, tt.lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seasonData.LangKey != tt.wantKey {
+			t.Errorf("findSeasonData(%d, %q) lang_key = %q, want %q", tt.season, tt.lang, seasonData.LangKey, tt.wantKey)
+		}
+	}
+}
+
+// シーズンリストの取得で内側のキーをLangKeyに残し、?lang=でそのシーズンを選ぶ
+func TestCurrentSeasonLanguage(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.seasons = multiLanguageSeasons(time.Now())
+
+	seasonList, err := DefaultClient.FetchSeasons(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for listKey, season := range seasonList.Seasons {
+		for key, seasonData := range season {
+			if seasonData.LangKey != key {
+				t.Errorf("seasons[%s][%s].LangKey = %q, want %q", listKey, key, seasonData.LangKey, key)
+			}
+		}
+	}
+
+	tests := []struct {
+		target   string
+		wantName string
+		wantKey  string
+	}{
+		{target: "/season/current?lang=en", wantName: "Season 1", wantKey: "en"},
+		{target: "/season/current?lang=ja", wantName: "シーズン1", wantKey: "ja"},
+		// 選択の結果は言語ごとにキャッシュする
+		{target: "/season/current?lang=en", wantName: "Season 1", wantKey: "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, CurrentSeasonHandler, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusOK, rec.Body.String())
+			}
+			var seasonData SeasonData
+			decodeBody(t, rec, &seasonData)
+			if seasonData.Name != tt.wantName || seasonData.LangKey != tt.wantKey {
+				t.Errorf("got %q (%q), want %q (%q)", seasonData.Name, seasonData.LangKey, tt.wantName, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestParseSeasonTime(t *testing.T) {
+	want := time.Date(2024, 5, 1, 9, 0, 0, 0, jst)
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: "2024/05/01 09:00"},
+		{value: "2024-05-01 09:00"},
+		{value: "2024/05/01 09:00:00"},
+		{value: " 2024-05-01  09:00:00 "},
+		{value: "", wantErr: true},
+		{value: "   ", wantErr: true},
+		{value: "2024/13/01 09:00", wantErr: true},
+		{value: "not a time", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseSeasonTime(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSeasonTime(%q) = %v, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("parseSeasonTime(%q) = %v, want %v", tt.value, got, want)
+			}
+		})
+	}
+}
+
+// どんな文字列でもパニックせず、成功した場合は日本時間の日時を返す
+func FuzzParseSeasonTime(f *testing.F) {
+	for _, seed := range []string{
+		"2024/05/01 09:00",
+		"2024-05-01 09:00:00",
+		" 2024-05-01  09:00:00 ",
+		"2024/05/01\t09:00",
+		"",
+		"/",
+		"2024/02/30 25:61",
+		"9999/99/99 99:99:99",
+		"シーズン",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		got, err := parseSeasonTime(value)
+		if err != nil {
+			return
+		}
+		if got.Location() != jst {
+			t.Errorf("parseSeasonTime(%q) location = %v, want %v", value, got.Location(), jst)
+		}
+	})
+}
+
+func TestRankingSample(t *testing.T) {
+	newFakeUpstream(t)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantCount  int
+		wantStep   int
+	}{
+		{target: "/rankings?sample=50", wantStatus: http.StatusOK, wantCount: 50, wantStep: 20},
+		{target: "/rankings?sample=10", wantStatus: http.StatusOK, wantCount: 10, wantStep: 100},
+		{target: "/rankings?sample=1", wantStatus: http.StatusOK, wantCount: 1},
+		{target: "/rankings?sample=1000", wantStatus: http.StatusOK, wantCount: 1000, wantStep: 1},
+		{target: "/rankings?sample=0", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?sample=1001", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?sample=abc", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?sample=50&delta=true", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if len(ranking.Top1000) != tt.wantCount {
+				t.Fatalf("rows = %d, want %d", len(ranking.Top1000), tt.wantCount)
+			}
+			// 1位から等間隔
+			for i, row := range ranking.Top1000 {
+				if want := 1 + i*tt.wantStep; row.Rank != want {
+					t.Fatalf("rank[%d] = %d, want %d (ranks %v)", i, row.Rank, want, ranksOf(ranking.Top1000))
+				}
+			}
+		})
+	}
+}
+
+func TestRankingFileTimestamp(t *testing.T) {
+	tests := []struct {
+		name    string
+		ts1     int64
+		ts2     int64
+		want    string
+		wantErr error
+	}{
+		{name: "ts1", ts1: 1700000000, ts2: 1700000001, want: "1700000000"},
+		{name: "ts2 only", ts2: 1700000001, want: "1700000001"},
+		{name: "both zero", wantErr: errRankingFileUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rankingFileTimestamp(SeasonData{Ts1: tt.ts1, Ts2: tt.ts2})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// float64では表せない桁のタイムスタンプも丸めずにランキングファイルのURLに使う
+func TestRankingLargeTimestamp(t *testing.T) {
+	const ts1 = "9007199254740993"
+	var seasonList SeasonList
+	body := `{"list":{"1":{"10001":{"cId":"10001","season":1,"ts1":` + ts1 + `,"ts2":9007199254740995}}}}`
+	if err := json.Unmarshal([]byte(body), &seasonList); err != nil {
+		t.Fatal(err)
+	}
+	seasonData := seasonList.Seasons["1"]["10001"]
+	got, err := rankingFileTimestamp(seasonData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != ts1 {
+		t.Errorf("ts = %q, want %q", got, ts1)
+	}
+	if url := DefaultClient.rankingPageURL(defaultSoft, seasonData.CID, seasonData.Rst, got, 1); !strings.Contains(url, "/"+ts1+"/") {
+		t.Errorf("url = %q, want it to contain %s", url, ts1)
+	}
+
+	// 上流は正確なタイムスタンプのパスにだけランキングファイルを返す
+	upstream := newFakeUpstream(t)
+	season := fixtureSeason(1, "10001", 0, time.Now())
+	season.Ts1 = 9007199254740993
+	upstream.seasons = map[string]map[string]SeasonData{"1": {season.CID: season}}
+	upstream.setPage(season, 1, fixtureRows(1, 1000))
+	rec, ranking := getRanking(t, "/rankings")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if ranking.SeasonData.Ts1 != season.Ts1 {
+		t.Errorf("ts1 = %d, want %d", ranking.SeasonData.Ts1, season.Ts1)
+	}
+}
+
+// Ts1とTs2がどちらも0のシーズンは上流に問い合わせずに503を返す
+func TestRankingWithoutTimestamps(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	season := upstream.seasons["1"]["10001"]
+	season.Ts1, season.Ts2 = 0, 0
+	upstream.addSeason("1", season)
+
+	rec, _ := getRanking(t, "/rankings")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(rec.Body.String(), errRankingFileUnavailable.Error()) {
+		t.Errorf("body = %s, want %q", rec.Body, errRankingFileUnavailable)
+	}
+	if n := upstream.rankingCalls.Load(); n != 0 {
+		t.Errorf("ranking calls = %d, want 0", n)
+	}
+}
+
+// 2ページ目以降の取得に失敗した場合、strictでなければ取得できたページまでを警告付きで返す
+func TestRankingPartialPages(t *testing.T) {
+	tests := []struct {
+		name         string
+		failPage     int
+		failStatus   int
+		target       string
+		wantStatus   int
+		wantRows     int
+		wantWarnings int
+		wantPartial  bool
+	}{
+		{name: "all pages", target: "/rankings?depth=3", wantStatus: http.StatusOK, wantRows: 2500},
+		{name: "page 2 fails", failPage: 2, failStatus: http.StatusForbidden, target: "/rankings?depth=3", wantStatus: http.StatusOK, wantRows: 1000, wantWarnings: 1, wantPartial: true},
+		{name: "page 3 fails", failPage: 3, failStatus: http.StatusForbidden, target: "/rankings?depth=3", wantStatus: http.StatusOK, wantRows: 2000, wantWarnings: 1, wantPartial: true},
+		{name: "page 2 not found ends the ranking", failPage: 2, failStatus: http.StatusNotFound, target: "/rankings?depth=3", wantStatus: http.StatusOK, wantRows: 1000},
+		{name: "strict", failPage: 2, failStatus: http.StatusForbidden, target: "/rankings?depth=3&strict=true", wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			season := upstream.seasons["1"]["10001"]
+			upstream.setPage(season, 2, fixtureRows(1001, 1000))
+			upstream.setPage(season, 3, fixtureRows(2001, 500))
+			if tt.failPage != 0 {
+				upstream.failPage(season, tt.failPage, tt.failStatus)
+			}
+
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if len(ranking.Top1000) != tt.wantRows {
+				t.Errorf("rows = %d, want %d", len(ranking.Top1000), tt.wantRows)
+			}
+			if len(ranking.Warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %q, want %d", ranking.Warnings, tt.wantWarnings)
+			}
+			if ranking.Partial != tt.wantPartial {
+				t.Errorf("partial = %v, want %v", ranking.Partial, tt.wantPartial)
+			}
+		})
+	}
+}
+
+// topの件数が入るだけのページを順に取得し、足りないページか404で打ち切る
+func TestRankingTop(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		missing2   bool
+		wantStatus int
+		wantRows   int
+		wantCalls  int32
+	}{
+		{name: "first page", target: "/rankings?top=10", wantStatus: http.StatusOK, wantRows: 10, wantCalls: 1},
+		{name: "two pages", target: "/rankings?top=1500", wantStatus: http.StatusOK, wantRows: 1500, wantCalls: 2},
+		{name: "short page ends", target: "/rankings?top=5000", wantStatus: http.StatusOK, wantRows: 2500, wantCalls: 3},
+		{name: "max", target: "/rankings?top=10000", wantStatus: http.StatusOK, wantRows: 2500, wantCalls: 3},
+		{name: "missing page ends", target: "/rankings?top=3000", missing2: true, wantStatus: http.StatusOK, wantRows: 1000, wantCalls: 2},
+		{name: "zero", target: "/rankings?top=0", wantStatus: http.StatusBadRequest},
+		{name: "too many", target: "/rankings?top=10001", wantStatus: http.StatusBadRequest},
+		{name: "not a number", target: "/rankings?top=x", wantStatus: http.StatusBadRequest},
+		{name: "with depth", target: "/rankings?top=10&depth=1", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			season := upstream.seasons["1"]["10001"]
+			// 上流のページ内が順位順でなくても順位順に並べ直す
+			page2 := fixtureRows(1001, 1000)
+			for i, j := 0, len(page2)-1; i < j; i, j = i+1, j-1 {
+				page2[i], page2[j] = page2[j], page2[i]
+			}
+			upstream.setPage(season, 2, page2)
+			upstream.setPage(season, 3, fixtureRows(2001, 500))
+			if tt.missing2 {
+				upstream.failPage(season, 2, http.StatusNotFound)
+			}
+
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if len(ranking.Top1000) != tt.wantRows {
+				t.Fatalf("rows = %d, want %d", len(ranking.Top1000), tt.wantRows)
+			}
+			for i, row := range ranking.Top1000 {
+				if row.Rank != i+1 {
+					t.Fatalf("row %d has rank %d, want %d", i, row.Rank, i+1)
+				}
+			}
+			if n := upstream.rankingCalls.Load(); n != tt.wantCalls {
+				t.Errorf("ranking calls = %d, want %d", n, tt.wantCalls)
+			}
+			if ranking.Partial || len(ranking.Warnings) != 0 {
+				t.Errorf("partial = %v with warnings %q, want a complete ranking", ranking.Partial, ranking.Warnings)
+			}
+		})
+	}
+}
+
+// 指定したrstが選択したシーズンと異なれば400で、一致すればselectedに含める
+func TestRankingRst(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	season := upstream.seasons["1"]["10001"]
+	season.Rst = 2
+	upstream.addSeason("1", season)
+	upstream.setPage(season, 1, fixtureRows(1, 1000))
+
+	tests := []struct {
+		target     string
+		wantStatus int
+	}{
+		{target: "/rankings", wantStatus: http.StatusOK},
+		{target: "/rankings?rst=2", wantStatus: http.StatusOK},
+		{target: "/rankings?rst=0", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?rst=x", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			want := SelectedSeason{CID: "10001", Rst: 2, Ts: "1700000000"}
+			if ranking.Selected.CID != want.CID || ranking.Selected.Rst != want.Rst || ranking.Selected.Ts != want.Ts {
+				t.Errorf("selected = %+v, want %+v", ranking.Selected, want)
+			}
+		})
+	}
+}
+
+func TestParticipantCount(t *testing.T) {
+	tests := []struct {
+		cnt  float64
+		want *int64
+	}{
+		{cnt: 5000, want: int64Ptr(5000)},
+		{cnt: 0, want: int64Ptr(0)},
+		{cnt: 1 << 52, want: int64Ptr(1 << 52)},
+		{cnt: -1},
+		{cnt: 12.5},
+		{cnt: math.NaN()},
+		{cnt: math.Inf(1)},
+		{cnt: 1 << 63},
+	}
+	for _, tt := range tests {
+		got := participantCount(tt.cnt)
+		switch {
+		case tt.want == nil && got != nil:
+			t.Errorf("participantCount(%v) = %d, want nil", tt.cnt, *got)
+		case tt.want != nil && (got == nil || *got != *tt.want):
+			t.Errorf("participantCount(%v) = %v, want %d", tt.cnt, got, *tt.want)
+		}
+	}
+}
+
+// シーズンリストのcntを参加者数としてseason_dataに含める
+func TestRankingParticipants(t *testing.T) {
+	tests := []struct {
+		name string
+		cnt  float64
+		want *int64
+	}{
+		{name: "count", cnt: 5000, want: int64Ptr(5000)},
+		{name: "negative", cnt: -3},
+		{name: "fraction", cnt: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			season := upstream.seasons["1"]["10001"]
+			season.Cnt = tt.cnt
+			upstream.addSeason("1", season)
+
+			rec, ranking := getRanking(t, "/rankings")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			got := ranking.SeasonData.Participants
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("participants = %d, want none", *got)
+			case tt.want != nil && (got == nil || *got != *tt.want):
+				t.Errorf("participants = %v, want %d", got, *tt.want)
+			}
+			if ranking.SeasonData.Cnt != tt.cnt {
+				t.Errorf("cnt = %v, want %v", ranking.SeasonData.Cnt, tt.cnt)
+			}
+		})
+	}
+}
+
+func int64Ptr(n int64) *int64 {
+	return &n
+}
+
+func TestSeasonListDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name: "nested map",
+			body: `{"list":{"1":{"10001":{"cId":"10001","season":1,"rule":0},"10002":{"cId":"10002","season":1,"rule":1}},"2":{"10003":{"cId":"10003","season":2}}}}`,
+			want: map[string][]string{"1": {"10001", "10002"}, "2": {"10003"}},
+		},
+		{
+			name: "array",
+			body: `{"list":[{"cId":"10001","season":1,"rule":0},{"cId":"10002","season":1,"rule":1},{"cId":"10003","season":2}]}`,
+			want: map[string][]string{"1": {"10001", "10002"}, "2": {"10003"}},
+		},
+		{name: "null", body: `{"list":null}`, want: map[string][]string{}},
+		{name: "missing", body: `{}`, want: map[string][]string{}},
+		{name: "neither", body: `{"list":"seasons"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			var seasonList SeasonList
+			err := json.Unmarshal([]byte(tt.body), &seasonList)
+			// 取得のたびに通るため、デフォルトのレベルではログを出さない
+			if logs.Len() != 0 {
+				t.Errorf("logged %q, want nothing", logs)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decoded %v, want error", seasonList.Seasons)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(seasonList.Seasons) != len(tt.want) {
+				t.Fatalf("seasons = %v, want keys %v", seasonList.Seasons, tt.want)
+			}
+			for listKey, cIds := range tt.want {
+				for _, cId := range cIds {
+					if seasonData, ok := seasonList.Seasons[listKey][cId]; !ok || seasonData.CID != cId {
+						t.Errorf("seasons[%s][%s] = %+v, %v", listKey, cId, seasonData, ok)
+					}
+				}
+			}
+		})
+	}
+}
+
+// 配列のシーズンリストからもランキングを取得できる
+func TestRankingWithArraySeasonList(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	season := upstream.seasons["1"]["10001"]
+	list, err := json.Marshal(map[string]interface{}{"list": []SeasonData{season}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream.seasonListBody = string(list)
+
+	rec, ranking := getRanking(t, "/rankings")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if ranking.SeasonData.CID != season.CID || len(ranking.Top1000) != 1000 {
+		t.Errorf("got %s with %d rows, want %s with 1000 rows", ranking.SeasonData.CID, len(ranking.Top1000), season.CID)
+	}
+}
+
+func TestConvertEmptyNames(t *testing.T) {
+	tests := []struct {
+		mode      string
+		wantNames []string
+	}{
+		{mode: "", wantNames: []string{"trainer1", "", "trainer3"}},
+		{mode: EmptyNameKeep, wantNames: []string{"trainer1", "", "trainer3"}},
+		{mode: EmptyNameDrop, wantNames: []string{"trainer1", "trainer3"}},
+		{mode: EmptyNamePlaceholder, wantNames: []string{"trainer1", "(no name)", "trainer3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			rows := fixtureRows(1, 3)
+			rows[1].Name = ""
+			got, affected := convertEmptyNames(rows, tt.mode)
+			if affected != 1 {
+				t.Errorf("affected = %d, want 1", affected)
+			}
+			if len(got) != len(tt.wantNames) {
+				t.Fatalf("rows = %d, want %d", len(got), len(tt.wantNames))
+			}
+			for i, name := range tt.wantNames {
+				if got[i].Name != name {
+					t.Errorf("name[%d] = %q, want %q", i, got[i].Name, name)
+				}
+			}
+		})
+	}
+}
+
+// EMPTY_NAME_MODEに従って変換し、該当した行数をempty_namesで返す
+// キャッシュしたデータは書き換えないため、繰り返しても同じ結果になる
+func TestRankingEmptyNames(t *testing.T) {
+	saved := EmptyNameMode
+	t.Cleanup(func() { EmptyNameMode = saved })
+
+	tests := []struct {
+		mode     string
+		wantRows int
+		wantName string
+	}{
+		{mode: EmptyNameKeep, wantRows: 1000, wantName: ""},
+		{mode: EmptyNameDrop, wantRows: 999, wantName: "trainer3"},
+		{mode: EmptyNamePlaceholder, wantRows: 1000, wantName: "(no name)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			rows := fixtureRows(1, 1000)
+			rows[1].Name = ""
+			upstream.setPage(upstream.seasons["1"]["10001"], 1, rows)
+			EmptyNameMode = tt.mode
+
+			for i := 0; i < 2; i++ {
+				rec, ranking := getRanking(t, "/rankings")
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+				}
+				if len(ranking.Top1000) != tt.wantRows || ranking.Top1000[1].Name != tt.wantName {
+					t.Errorf("request %d: got %d rows with %q second, want %d with %q", i, len(ranking.Top1000), ranking.Top1000[1].Name, tt.wantRows, tt.wantName)
+				}
+				if ranking.EmptyNames != 1 {
+					t.Errorf("request %d: empty_names = %d, want 1", i, ranking.EmptyNames)
+				}
+			}
+		})
+	}
+}
+
+func TestRankingSource(t *testing.T) {
+	tests := []struct {
+		target string
+		want   *UpstreamSource
+	}{
+		{target: "/rankings?include_source=true", want: &UpstreamSource{Server: "AmazonS3", XCache: "Hit from cloudfront", Age: "120"}},
+		{target: "/rankings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.rankingHeader = http.Header{
+				"Server":  {"AmazonS3"},
+				"X-Cache": {"Hit from cloudfront"},
+				"Age":     {"120"},
+			}
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			switch {
+			case tt.want == nil && ranking.Source != nil:
+				t.Errorf("_source = %+v, want none", *ranking.Source)
+			case tt.want != nil && (ranking.Source == nil || *ranking.Source != *tt.want):
+				t.Errorf("_source = %+v, want %+v", ranking.Source, *tt.want)
+			}
+			if tt.want != nil && ranking.Selected.ListKey != "1" {
+				t.Errorf("selected list key = %q, want %q", ranking.Selected.ListKey, "1")
+			}
+		})
+	}
+}
+
+// シーズンの切り替わりで内側のマップがnullになっているシーズンリスト
+func TestSeasonListWithNilInnerMap(t *testing.T) {
+	now := time.Now()
+	seasonData := fixtureSeason(1, "10001", 0, now)
+	seasons := map[string]map[string]SeasonData{"1": nil, "2": {seasonData.CID: seasonData}, "3": {}}
+
+	latest, err := getLatestSeasonData(seasons, defaultSoft, nil, "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.CID != "10001" {
+		t.Errorf("latest cId = %q, want %q", latest.CID, "10001")
+	}
+	if _, err := findSeasonData(seasons, 1, nil, ""); err != nil {
+		t.Errorf("findSeasonData: %v", err)
+	}
+
+	// nilのマップはnullとして返る
+	upstream := newFakeUpstream(t)
+	upstream.seasons = seasons
+	tests := []struct {
+		target  string
+		handler http.HandlerFunc
+	}{
+		{target: "/season/current", handler: CurrentSeasonHandler},
+		{target: "/rankings", handler: RankingHandler},
+		{target: "/seasons", handler: SeasonsHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, tt.handler, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+		})
+	}
+}
+
+func TestConvertRawDataToResponseOrder(t *testing.T) {
+	rawData := []RankResponseRawData{
+		{Rank: 3, Name: "c", RatingValue: 1980000},
+		{Rank: 1, Name: "a", RatingValue: 2000000, Icon: "icon_a.png"},
+		{Rank: 2, Name: "b2", RatingValue: 1990000},
+		{Rank: 2, Name: "b1", RatingValue: 1990000},
+	}
+	got := convertRawDataToResponse(rawData, 1000, "https://resource.example.com")
+
+	// 同じ順位は上流の並びのまま
+	wantNames := []string{"a", "b2", "b1", "c"}
+	for i, row := range got {
+		if row.Name != wantNames[i] {
+			t.Errorf("rows = %v, want names in order %v", got, wantNames)
+			break
+		}
+	}
+	if got[0].RatingValue != 2000 || got[0].RatingRaw != 2000000 || got[0].Icon != iconURL("https://resource.example.com", "icon_a.png") {
+		t.Errorf("first row = %+v, want rating 2000 from 2000000 with the resource icon URL", got[0])
+	}
+	if got[1].Icon != "" {
+		t.Errorf("icon without upstream icon = %q, want empty", got[1].Icon)
+	}
+	// 元のデータは並べ替えない
+	if rawData[0].Rank != 3 {
+		t.Errorf("raw data was reordered: %v", rawData)
+	}
+}
+
+// 割る前のレートはそのまま残し、割った値とは別に返す
+func TestConvertRawDataToResponseRatingRaw(t *testing.T) {
+	tests := []struct {
+		raw   float64
+		scale float64
+		want  float64
+	}{
+		{raw: 2099000, scale: 1000, want: 2099},
+		{raw: 1234567, scale: 1000, want: 1234.567},
+		{raw: 1500123, scale: 1000, want: 1500.123},
+		{raw: 1999, scale: 1, want: 1999},
+		{raw: 0, scale: 1000, want: 0},
+	}
+	for _, tt := range tests {
+		got := convertRawDataToResponse([]RankResponseRawData{{Rank: 1, Name: "a", RatingValue: tt.raw}}, tt.scale, "https://resource.example.com")
+		if got[0].RatingRaw != tt.raw {
+			t.Errorf("raw %v: rating_raw = %v, want %v", tt.raw, got[0].RatingRaw, tt.raw)
+		}
+		if math.Abs(got[0].RatingValue-tt.want) > floatTolerance {
+			t.Errorf("raw %v: rating = %v, want %v", tt.raw, got[0].RatingValue, tt.want)
+		}
+	}
+
+	// JSONにも両方の値を含める
+	upstream := newFakeUpstream(t)
+	rows := fixtureRows(1, 1)
+	rows[0].RatingValue = 1234567
+	upstream.setPage(upstream.seasons["1"]["10001"], 1, rows)
+	rec := get(t, RankingHandler, "/rankings")
+	if body := rec.Body.String(); !strings.Contains(body, `"rating_value":1234.567`) || !strings.Contains(body, `"rating_raw":1234567`) {
+		t.Errorf("body = %s, want rating_value 1234.567 and rating_raw 1234567", body)
+	}
+}
+
+func TestCountInvalidRows(t *testing.T) {
+	valid := RankResponseRawData{Rank: 1, Name: "a", Icon: "icon_a.png", RatingValue: 2000}
+	tests := []struct {
+		name string
+		row  func(row *RankResponseRawData)
+		want int
+	}{
+		{name: "valid", row: func(row *RankResponseRawData) {}, want: 0},
+		{name: "empty name", row: func(row *RankResponseRawData) { row.Name = "" }, want: 1},
+		{name: "empty icon", row: func(row *RankResponseRawData) { row.Icon = "" }, want: 1},
+		{name: "zero rating", row: func(row *RankResponseRawData) { row.RatingValue = 0 }, want: 1},
+		{name: "nothing", row: func(row *RankResponseRawData) { *row = RankResponseRawData{Rank: 1} }, want: 1},
+		{name: "placeholder", row: func(row *RankResponseRawData) { row.Name, row.Placeholder = "", true }, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := valid
+			tt.row(&row)
+			if got := countInvalidRows([]RankResponseRawData{valid, row, valid}); got != tt.want {
+				t.Errorf("countInvalidRows = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// アイコンやレートが無い行も返し、アイコンのURLは空のまま、行数はinvalid_rowsに出す
+func TestRankingInvalidRows(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	logs := captureLog(t)
+	rows := fixtureRows(1, 5)
+	rows[1].Icon = ""
+	rows[2].RatingValue = 0
+	rows[3].Icon, rows[3].RatingValue = "", 0
+	upstream.setPage(upstream.seasons["1"]["10001"], 1, rows)
+
+	rec, ranking := getRanking(t, "/rankings")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if len(ranking.Top1000) != 5 || ranking.InvalidRows != 3 {
+		t.Fatalf("got %d rows with invalid_rows %d, want 5 rows with 3 invalid", len(ranking.Top1000), ranking.InvalidRows)
+	}
+	for _, i := range []int{1, 3} {
+		if icon := ranking.Top1000[i].Icon; icon != "" {
+			t.Errorf("rank %d icon = %q, want empty", ranking.Top1000[i].Rank, icon)
+		}
+	}
+	if icon := ranking.Top1000[0].Icon; icon != iconURL(DefaultClient.ResourceBaseURL, "icon_1.png") {
+		t.Errorf("rank 1 icon = %q, want the resource icon URL", icon)
+	}
+	if rating := ranking.Top1000[2].RatingValue; rating != 0 {
+		t.Errorf("rank 3 rating = %v, want 0", rating)
+	}
+	if !strings.Contains(logs.String(), "3 of 5 ranking rows have no name, icon or rating") {
+		t.Errorf("log = %q, want a warning for the invalid rows", logs.String())
+	}
+
+	// 問題の無いランキングにはinvalid_rowsを含めない
+	resetState(t)
+	upstream.setPage(upstream.seasons["1"]["10001"], 1, fixtureRows(1, 5))
+	rec = get(t, RankingHandler, "/rankings")
+	if strings.Contains(rec.Body.String(), "invalid_rows") {
+		t.Errorf("body = %s, want no invalid_rows", rec.Body)
+	}
+}
+
+// 失敗の種類ごとのエラーは包まれてもerrors.Isで判別でき、ハンドラーはそれぞれのステータスコードを返す
+func TestRankingErrorKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(upstream *fakeUpstream)
+		depth   int
+		wantErr error
+		// wantErrと一緒に包まれているエラー
+		alsoErr    error
+		wantStatus int
+	}{
+		{name: "no active season", setup: func(upstream *fakeUpstream) {
+			upstream.seasons = map[string]map[string]SeasonData{}
+		}, wantErr: ErrNoActiveSeason, wantStatus: http.StatusNotFound},
+		{name: "upstream status", setup: func(upstream *fakeUpstream) {
+			upstream.failPage(upstream.seasons["1"]["10001"], 1, http.StatusForbidden)
+		}, wantErr: ErrUpstreamUnavailable, wantStatus: http.StatusBadGateway},
+		{name: "decode", setup: func(upstream *fakeUpstream) {
+			upstream.rankingBody = `[{"rank":1`
+		}, wantErr: ErrDecode, wantStatus: http.StatusBadGateway},
+		{name: "insufficient data", setup: func(upstream *fakeUpstream) {
+			season := upstream.seasons["1"]["10001"]
+			upstream.setPage(season, 2, fixtureRows(1001, 1000))
+			upstream.failPage(season, 3, http.StatusForbidden)
+			// 取得できなかった理由も包む
+		}, depth: 3, wantErr: ErrInsufficientData, alsoErr: ErrUpstreamUnavailable, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			withoutRetryDelay(t)
+			captureLog(t)
+			tt.setup(upstream)
+			depth := max(tt.depth, 1)
+
+			_, _, err := fetchLatestRanking(context.Background(), rankingQuery{depth: depth})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			for _, other := range []error{ErrNoActiveSeason, ErrUpstreamUnavailable, ErrDecode, ErrInsufficientData} {
+				if other != tt.wantErr && errors.Is(err, other) != (other == tt.alsoErr) {
+					t.Errorf("err = %v, errors.Is(err, %v) = %v", err, other, errors.Is(err, other))
+				}
+			}
+			if status := rankingErrorStatus(fmt.Errorf("wrapped: %w", err)); tt.wantStatus != http.StatusOK && status != tt.wantStatus {
+				t.Errorf("rankingErrorStatus = %d, want %d", status, tt.wantStatus)
+			}
+
+			resetState(t)
+			rec := get(t, RankingHandler, fmt.Sprintf("/rankings?depth=%d", depth))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+// 上流が順位の逆順で返してもボーダーは順位で求まる
+func TestRankingOutOfOrderUpstream(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	rows := fixtureRows(1, 1000)
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+	upstream.setPage(upstream.seasons["1"]["10001"], 1, rows)
+
+	rec, ranking := getRanking(t, "/rankings")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	for i, row := range ranking.Top1000 {
+		if row.Rank != i+1 {
+			t.Fatalf("rows[%d] has rank %d, want %d", i, row.Rank, i+1)
+		}
+	}
+
+	rec = get(t, CutoffHandler, "/rankings/cutoff?rank=100")
+	var cutoff CutoffResponse
+	decodeBody(t, rec, &cutoff)
+	if cutoff.RatingValue != 2000 {
+		t.Errorf("cutoff = %v, want 2000", cutoff.RatingValue)
+	}
+}
+
+// ROUTE_PREFIXの配下にだけエンドポイントを登録し、OpenAPIのパスにも付ける
+func TestRoutePrefix(t *testing.T) {
+	newFakeUpstream(t)
+	saved := RoutePrefix
+	t.Cleanup(func() { RoutePrefix = saved })
+	RoutePrefix = "/api/v1"
+	mux := http.NewServeMux()
+	registerRoutes(mux, RoutePrefix)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+	}{
+		{target: "/api/v1/rankings", wantStatus: http.StatusOK},
+		{target: "/api/v1/rankings/cutoff?rank=100", wantStatus: http.StatusOK},
+		{target: "/api/v1/seasons", wantStatus: http.StatusOK},
+		{target: "/api/v1/healthz", wantStatus: http.StatusOK},
+		{target: "/rankings", wantStatus: http.StatusNotFound},
+		{target: "/api/rankings", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	var spec struct {
+		Paths map[string]interface{} `json:"paths"`
+	}
+	decodeBody(t, rec, &spec)
+	if _, ok := spec.Paths["/api/v1/rankings"]; !ok {
+		t.Errorf("openapi has no /api/v1/rankings: %s", rec.Body)
+	}
+	if _, ok := spec.Paths["/rankings"]; ok {
+		t.Error("openapi paths contain /rankings without the prefix")
+	}
+}
+
+func TestFillRankGaps(t *testing.T) {
+	tests := []struct {
+		name             string
+		ranks            []int
+		want             []int
+		wantPlaceholders []int
+	}{
+		{name: "no gaps", ranks: []int{1, 2, 3}, want: []int{1, 2, 3}},
+		{name: "missing rank", ranks: []int{1, 2, 4, 7}, want: []int{1, 2, 3, 4, 5, 6, 7}, wantPlaceholders: []int{3, 5, 6}},
+		{name: "missing first ranks", ranks: []int{3}, want: []int{1, 2, 3}, wantPlaceholders: []int{1, 2}},
+		// 同率の後に順位が飛ぶのは欠けていない
+		{name: "tie", ranks: []int{1, 1, 3}, want: []int{1, 1, 3}},
+		{name: "gap after tie", ranks: []int{1, 1, 4}, want: []int{1, 1, 3, 4}, wantPlaceholders: []int{3}},
+		{name: "empty", ranks: nil, want: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := make([]RankResponseRawData, len(tt.ranks))
+			for i, rank := range tt.ranks {
+				rows[i] = RankResponseRawData{Rank: rank, Name: fmt.Sprintf("trainer%d", rank), RatingValue: 2000}
+			}
+			got := fillRankGaps(rows)
+			if ranks := ranksOf(got); !equalInts(ranks, tt.want) {
+				t.Fatalf("ranks = %v, want %v", ranks, tt.want)
+			}
+			var placeholders []int
+			for _, row := range got {
+				if !row.Placeholder {
+					continue
+				}
+				placeholders = append(placeholders, row.Rank)
+				if row.Name != "" || row.RatingValue != 0 {
+					t.Errorf("placeholder row = %+v, want an empty row", row)
+				}
+			}
+			if !equalInts(placeholders, tt.wantPlaceholders) {
+				t.Errorf("placeholders = %v, want %v", placeholders, tt.wantPlaceholders)
+			}
+		})
+	}
+}
+
+// 上流に3位と5位が無いランキング
+func TestRankingFillGaps(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantRanks  []int
+	}{
+		{target: "/rankings?fill_gaps=true", wantStatus: http.StatusOK, wantRanks: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{target: "/rankings", wantStatus: http.StatusOK, wantRanks: []int{1, 2, 4, 6, 7, 8, 9, 10}},
+		{target: "/rankings?fill_gaps=true&delta=true", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			rows := fixtureRows(1, 10)
+			gapped := append([]RankResponseRawData{rows[0], rows[1], rows[3]}, rows[5:]...)
+			upstream.setPage(upstream.seasons["1"]["10001"], 1, gapped)
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if ranks := ranksOf(ranking.Top1000); !equalInts(ranks, tt.wantRanks) {
+				t.Errorf("ranks = %v, want %v", ranks, tt.wantRanks)
+			}
+			for _, row := range ranking.Top1000 {
+				if wantPlaceholder := row.Rank == 3 || row.Rank == 5; row.Placeholder != wantPlaceholder {
+					t.Errorf("rank %d placeholder = %v, want %v", row.Rank, row.Placeholder, wantPlaceholder)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckRatingsMonotonic(t *testing.T) {
+	tests := []struct {
+		name        string
+		ratings     []float64
+		want        bool
+		wantWarning string
+	}{
+		{name: "decreasing", ratings: []float64{2000, 1990, 1980}, want: true},
+		{name: "ties", ratings: []float64{2000, 1990, 1990, 1980}, want: true},
+		{name: "increase", ratings: []float64{2000, 1990, 1995, 1980}, wantWarning: "rating increases from rank 2 (1990) to rank 3 (1995)"},
+		{name: "empty", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			if got := checkRatingsMonotonic(rowsWithRatings(tt.ratings...)); got != tt.want {
+				t.Errorf("checkRatingsMonotonic = %v, want %v", got, tt.want)
+			}
+			if tt.wantWarning == "" && logs.Len() != 0 {
+				t.Errorf("logged %q, want no warning", logs)
+			}
+			if tt.wantWarning != "" && !strings.Contains(logs.String(), tt.wantWarning) {
+				t.Errorf("logged %q, want %q", logs, tt.wantWarning)
+			}
+		})
+	}
+}
+
+// DEBUG_CHECKSのときだけ上流のデータのレートの並びを確認する
+func TestRankingDebugChecks(t *testing.T) {
+	saved := DebugChecks
+	t.Cleanup(func() { DebugChecks = saved })
+
+	tests := []struct {
+		debug       bool
+		wantWarning bool
+	}{
+		{debug: true, wantWarning: true},
+		{debug: false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.debug), func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			rows := fixtureRows(1, 1000)
+			rows[500].RatingValue = rows[0].RatingValue
+			upstream.setPage(upstream.seasons["1"]["10001"], 1, rows)
+			DebugChecks = tt.debug
+			logs := captureLog(t)
+
+			if rec, _ := getRanking(t, "/rankings"); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := strings.Contains(logs.String(), "rating increases from rank 500"); got != tt.wantWarning {
+				t.Errorf("warning logged = %v, want %v: %q", got, tt.wantWarning, logs)
+			}
+		})
+	}
+}
+
+func TestRankingAvgTop(t *testing.T) {
+	// 1位から50位は2099から2050で、平均は(2099+2050)/2
+	newFakeUpstream(t)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       *float64
+	}{
+		{target: "/rankings?avg_top=50", wantStatus: http.StatusOK, want: floatPtr(2074.5)},
+		{target: "/rankings?avg_top=1", wantStatus: http.StatusOK, want: floatPtr(2099)},
+		{target: "/rankings?avg_top=1000", wantStatus: http.StatusOK, want: floatPtr(1599.5)},
+		{target: "/rankings", wantStatus: http.StatusOK},
+		{target: "/rankings?avg_top=0", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?avg_top=1001", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?avg_top=x", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			switch {
+			case tt.want == nil && ranking.AvgTop != nil:
+				t.Errorf("avg_top = %v, want none", *ranking.AvgTop)
+			case tt.want != nil && (ranking.AvgTop == nil || math.Abs(*ranking.AvgTop-*tt.want) > floatTolerance):
+				t.Errorf("avg_top = %v, want %v", ranking.AvgTop, *tt.want)
+			}
+		})
+	}
+	if got := averageTopRating(nil, 10); got != 0 {
+		t.Errorf("average of no rows = %v, want 0", got)
+	}
+}
+
+// from・toで順位の範囲を絞る
+func TestRankingRankRange(t *testing.T) {
+	newFakeUpstream(t)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantFirst  int
+		wantLen    int
+	}{
+		{target: "/rankings", wantStatus: http.StatusOK, wantFirst: 1, wantLen: 1000},
+		{target: "/rankings?from=1&to=10", wantStatus: http.StatusOK, wantFirst: 1, wantLen: 10},
+		{target: "/rankings?from=250&to=300", wantStatus: http.StatusOK, wantFirst: 250, wantLen: 51},
+		{target: "/rankings?from=1000&to=1000", wantStatus: http.StatusOK, wantFirst: 1000, wantLen: 1},
+		{target: "/rankings?from=991", wantStatus: http.StatusOK, wantFirst: 991, wantLen: 10},
+		{target: "/rankings?to=5", wantStatus: http.StatusOK, wantFirst: 1, wantLen: 5},
+		{target: "/rankings?from=0&to=10", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?from=1&to=1001", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?from=20&to=10", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?from=x", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var body ErrorResponse
+				decodeBody(t, rec, &body)
+				if body.Status != tt.wantStatus || body.Error == "" {
+					t.Errorf("body = %+v, want status %d and a message", body, tt.wantStatus)
+				}
+				return
+			}
+			if len(ranking.Top1000) != tt.wantLen {
+				t.Fatalf("got %d rows, want %d", len(ranking.Top1000), tt.wantLen)
+			}
+			if got := ranking.Top1000[0].Rank; got != tt.wantFirst {
+				t.Errorf("first rank = %d, want %d", got, tt.wantFirst)
+			}
+			if ranking.SeasonData.Season != 1 || ranking.SeasonData.CID != "10001" {
+				t.Errorf("season data = %+v, want season 1 cId 10001", ranking.SeasonData)
+			}
+		})
+	}
+}
+
+// 1000人に満たないシーズンは取得できた分だけを返し、actual_countで件数を伝える
+func TestRankingFewerThan1000(t *testing.T) {
+	tests := []struct {
+		target    string
+		wantRanks []int
+	}{
+		{target: "/rankings", wantRanks: []int{1, 300}},
+		{target: "/rankings?to=500", wantRanks: []int{1, 300}},
+		{target: "/rankings?from=291", wantRanks: []int{291, 300}},
+		{target: "/rankings?from=301"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			seasonData := upstream.seasons["1"]["10001"]
+			upstream.setPage(seasonData, 1, fixtureRows(1, 300))
+
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if ranking.ActualCount != 300 {
+				t.Errorf("actual_count = %d, want 300", ranking.ActualCount)
+			}
+			if ranking.Top1000 == nil {
+				t.Fatal("top_1000 is null, want an array")
+			}
+			var gotRanks []int
+			if n := len(ranking.Top1000); n > 0 {
+				gotRanks = []int{ranking.Top1000[0].Rank, ranking.Top1000[n-1].Rank}
+				if want := tt.wantRanks[1] - tt.wantRanks[0] + 1; n != want {
+					t.Errorf("got %d rows, want %d", n, want)
+				}
+			}
+			if fmt.Sprint(gotRanks) != fmt.Sprint(tt.wantRanks) {
+				t.Errorf("first and last ranks = %v, want %v", gotRanks, tt.wantRanks)
+			}
+			if last := len(ranking.Top1000) - 1; last >= 0 && ranking.Top1000[last].RatingValue != float64(2100-tt.wantRanks[1]) {
+				t.Errorf("last rating = %v, want %d", ranking.Top1000[last].RatingValue, 2100-tt.wantRanks[1])
+			}
+		})
+	}
+}
+
+// 上流の日時の形式がまちまちでも、シーズンリストには揃えた形式で保持し、レスポンスもその形式で返す
+func TestSeasonTimesNormalized(t *testing.T) {
+	start := time.Now().Add(-24 * time.Hour).In(jst).Truncate(time.Minute)
+	end := time.Now().Add(24 * time.Hour).In(jst).Truncate(time.Minute)
+	wantStart, wantEnd := start.Format(seasonTimeLayout), end.Format(seasonTimeLayout)
+
+	tests := []struct {
+		name   string
+		layout string
+	}{
+		{name: "slashes", layout: "2006/01/02 15:04"},
+		{name: "slashes with seconds", layout: "2006/01/02 15:04:05"},
+		{name: "dashes", layout: "2006-01-02 15:04"},
+		{name: "double space", layout: "2006-01-02  15:04:05"},
+		{name: "normalized", layout: seasonTimeLayout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			seasonData := upstream.seasons["1"]["10001"]
+			seasonData.Start, seasonData.End = start.Format(tt.layout), end.Format(tt.layout)
+			upstream.addSeason("1", seasonData)
+
+			seasonList, err := DefaultClient.fetchRankingData(context.Background(), defaultSoft, newRetryBudget(RetryBudget))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored := seasonList.Seasons["1"]["10001"]
+			if stored.Start != wantStart || stored.End != wantEnd {
+				t.Errorf("stored season = %q to %q, want %q to %q", stored.Start, stored.End, wantStart, wantEnd)
+			}
+
+			rec, ranking := getRanking(t, "/rankings")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := ranking.SeasonData; got.Start != wantStart || got.End != wantEnd {
+				t.Errorf("response season = %q to %q, want %q to %q", got.Start, got.End, wantStart, wantEnd)
+			}
+		})
+	}
+}
+
+// include_source=trueではselectedに選んだシーズンのシーズンリストでのキーを含める
+func TestRankingSelectedSourceKeys(t *testing.T) {
+	now := time.Now()
+	single := fixtureSeason(12, "10012", RuleSingle, now)
+	double := fixtureSeason(12, "20012", RuleDouble, now)
+
+	tests := []struct {
+		target        string
+		wantListKey   string
+		wantSeasonKey string
+	}{
+		{target: "/rankings?include_source=true", wantListKey: "7", wantSeasonKey: "ja"},
+		{target: "/rankings?include_source=true&rule=1", wantListKey: "8", wantSeasonKey: "20012"},
+		{target: "/rankings"},
+		{target: "/rankings?include_source=false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.seasons = map[string]map[string]SeasonData{
+				"7": {"ja": single},
+				"8": {double.CID: double},
+			}
+			upstream.setPage(single, 1, fixtureRows(1, 1000))
+			upstream.setPage(double, 1, fixtureRows(1, 1000))
+
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := ranking.Selected; got.ListKey != tt.wantListKey || got.SeasonKey != tt.wantSeasonKey {
+				t.Errorf("selected keys = %q/%q, want %q/%q", got.ListKey, got.SeasonKey, tt.wantListKey, tt.wantSeasonKey)
+			}
+			if tt.wantListKey == "" && (strings.Contains(rec.Body.String(), `"list_key"`) || strings.Contains(rec.Body.String(), `"season_key"`)) {
+				t.Errorf("body has season list keys without include_source: %+v", ranking.Selected)
+			}
+		})
+	}
+}
+
+// 停止を始めてから処理中の/rankingsが終わるまで待ち、ShutdownTimeoutを過ぎたら諦める
+func TestServeGracefulShutdown(t *testing.T) {
+	saved := ShutdownTimeout
+	t.Cleanup(func() { ShutdownTimeout = saved })
+
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		releaseIn  time.Duration
+		wantStatus int
+		wantLog    string
+	}{
+		{name: "drains in-flight request", timeout: 5 * time.Second, releaseIn: 50 * time.Millisecond, wantStatus: http.StatusOK, wantLog: "server stopped"},
+		{name: "drain timeout", timeout: 50 * time.Millisecond, releaseIn: time.Second, wantLog: "failed to shut down gracefully"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ShutdownTimeout = tt.timeout
+			upstream := newFakeUpstream(t)
+			started, release := upstream.holdRankings()
+			t.Cleanup(release)
+			logs := captureLog(t)
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			srv := &http.Server{Handler: http.HandlerFunc(RankingHandler)}
+			served := make(chan error, 1)
+			go func() { served <- serve(ctx, srv, ln) }()
+
+			url := "http://" + ln.Addr().String() + "/rankings"
+			responses := make(chan int, 1)
+			go func() {
+				resp, err := http.Get(url)
+				if err != nil {
+					responses <- 0
+					return
+				}
+				resp.Body.Close()
+				responses <- resp.StatusCode
+			}()
+			<-started
+
+			// 終了のシグナルを受け取った
+			cancel()
+			time.AfterFunc(tt.releaseIn, release)
+			shutdownStarted := time.Now()
+			if err := <-served; err != nil {
+				t.Fatalf("serve returned %v, want nil", err)
+			}
+			if elapsed := time.Since(shutdownStarted); tt.wantStatus == 0 && elapsed > tt.releaseIn {
+				t.Errorf("shutdown took %s, want it to give up after %s", elapsed, tt.timeout)
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("log = %q, want %q", logs.String(), tt.wantLog)
+			}
+			// 止めた後は受け付けない
+			if resp, err := http.Get(url); err == nil {
+				resp.Body.Close()
+				t.Errorf("request after shutdown succeeded with %d", resp.StatusCode)
+			}
+			// 待ちきれなかったリクエストも、次のテストのキャッシュに書き込まないように終わらせておく
+			release()
+			if status := <-responses; tt.wantStatus != 0 && status != tt.wantStatus {
+				t.Errorf("in-flight request status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package Handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+)
+
+// JSON Linesで返す場合に何行ごとにクライアントへ送り出すか
+const jsonLinesFlushInterval = 100
+
+// JSON Linesの1行目に書き込むシーズンの情報
+type RankingStreamHeader struct {
+	SeasonData  SeasonData     `json:"season_data"`
+	Selected    SelectedSeason `json:"selected"`
+	Warnings    []string       `json:"warnings,omitempty"`
+	ActualCount int            `json:"actual_count"`
+	Stale       bool           `json:"stale,omitempty"`
+	Partial     bool           `json:"partial,omitempty"`
+}
+
+// ランキングを1行目にシーズンの情報、2行目以降に1行ずつランキングの行を書き込むJSON Linesで返す
+// 途中でエンコードに失敗した場合はステータスコードを変えられないため、エラーの行を書き込んで打ち切る
+func writeRankingJSONLines(w http.ResponseWriter, response RankingResponse) error {
+	controller := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	header := RankingStreamHeader{
+		SeasonData:  response.SeasonData,
+		Selected:    response.Selected,
+		Warnings:    response.Warnings,
+		ActualCount: response.ActualCount,
+		Stale:       response.Stale,
+		Partial:     response.Partial,
+	}
+	if err := enc.Encode(header); err != nil {
+		return writeJSONLinesError(w, err)
+	}
+	for i, data := range response.Top1000 {
+		if err := enc.Encode(data); err != nil {
+			return writeJSONLinesError(w, err)
+		}
+		if (i+1)%jsonLinesFlushInterval == 0 {
+			// 送り出せないResponseWriterでも最後にまとめて返せばよいため無視する
+			if err := controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// エラーの行を書き込み、元のエラーを返す
+func writeJSONLinesError(w io.Writer, err error) error {
+	log.Printf("failed to encode JSON Lines response: %v", err)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to encode response: " + err.Error(), Status: http.StatusInternalServerError})
+	return err
+}
@@ -0,0 +1,127 @@
+package Handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 1行目にシーズンの情報、2行目以降にランキングの行が1行ずつ並ぶ
+func TestRankingJSONLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		accept    string
+		wantRanks int
+	}{
+		{name: "format", target: "/rankings?format=jsonl", wantRanks: 1000},
+		{name: "accept", target: "/rankings", accept: "application/x-ndjson", wantRanks: 1000},
+		{name: "top", target: "/rankings?format=jsonl&top=10", wantRanks: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeUpstream(t)
+			server := httptest.NewServer(http.HandlerFunc(RankingHandler))
+			t.Cleanup(server.Close)
+			req, err := http.NewRequest(http.MethodGet, server.URL+tt.target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if got := resp.Header.Get("Content-Type"); got != "application/x-ndjson" {
+				t.Errorf("Content-Type = %q, want application/x-ndjson", got)
+			}
+
+			scanner := bufio.NewScanner(resp.Body)
+			if !scanner.Scan() {
+				t.Fatal("stream has no header line")
+			}
+			var header RankingStreamHeader
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+				t.Fatalf("invalid header line %q: %v", scanner.Text(), err)
+			}
+			if header.SeasonData.Season != 1 || header.ActualCount != 1000 {
+				t.Errorf("header = %+v, want season 1 with actual_count 1000", header)
+			}
+			rank := 0
+			for scanner.Scan() {
+				var row RankResponseRawData
+				if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+					t.Fatalf("invalid line %d %q: %v", rank+2, scanner.Text(), err)
+				}
+				rank++
+				if row.Rank != rank || row.Name == "" {
+					t.Fatalf("line %d = %+v, want rank %d", rank+1, row, rank)
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if rank != tt.wantRanks {
+				t.Errorf("got %d rows, want %d", rank, tt.wantRanks)
+			}
+		})
+	}
+}
+
+// 送り出した回数を数えるResponseWriter
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+	f.ResponseRecorder.Flush()
+}
+
+func TestWriteRankingJSONLinesFlush(t *testing.T) {
+	w := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+	response := RankingResponse{Top1000: fixtureRows(1, 2*jsonLinesFlushInterval+50)}
+	if err := writeRankingJSONLines(w, response); err != nil {
+		t.Fatal(err)
+	}
+	if w.flushes != 2 {
+		t.Errorf("flushed %d times, want 2", w.flushes)
+	}
+	if lines := strings.Count(w.Body.String(), "\n"); lines != len(response.Top1000)+1 {
+		t.Errorf("wrote %d lines, want %d", lines, len(response.Top1000)+1)
+	}
+}
+
+// 途中でエンコードに失敗した場合はそこまでの行の後にエラーの行を書いて打ち切る
+func TestWriteRankingJSONLinesEncodeError(t *testing.T) {
+	captureLog(t)
+	rows := fixtureRows(1, 5)
+	rows[2].RatingValue = math.NaN()
+	rec := httptest.NewRecorder()
+	if err := writeRankingJSONLines(rec, RankingResponse{Top1000: rows}); err == nil {
+		t.Fatal("writeRankingJSONLines succeeded, want an encode error")
+	}
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("wrote %d lines, want a header, 2 rows and an error: %q", len(lines), lines)
+	}
+	var last ErrorResponse
+	if err := json.Unmarshal([]byte(lines[3]), &last); err != nil {
+		t.Fatalf("invalid error line %q: %v", lines[3], err)
+	}
+	if last.Status != http.StatusInternalServerError || !strings.Contains(last.Error, "Failed to encode response") {
+		t.Errorf("error line = %+v, want the encode error", last)
+	}
+}
@@ -0,0 +1,47 @@
+package Handler
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ルールの表示名
+var ruleLabels = map[int]string{
+	RuleSingle: "single",
+	RuleDouble: "double",
+}
+
+// rstの表示名
+// rstの意味は公開されていないため、RST_LABELS="0=レギュレーションA,1=レギュレーションB" のように指定したものだけを付ける
+var RstLabels = parseRstLabels(os.Getenv("RST_LABELS"))
+
+func parseRstLabels(v string) map[int]string {
+	labels := map[int]string{}
+	if v == "" {
+		return labels
+	}
+	for _, pair := range strings.Split(v, ",") {
+		key, label, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Printf("invalid RST_LABELS entry %q", pair)
+			continue
+		}
+		rst, err := strconv.Atoi(key)
+		if err != nil {
+			log.Printf("invalid RST_LABELS entry %q: %v", pair, err)
+			continue
+		}
+		labels[rst] = label
+	}
+	return labels
+}
+
+// シーズンデータにルールとrstの表示名を付ける
+// どのエンドポイントでも同じ項目が付くようにシーズンリストの取得時に行う
+func enrichSeasonData(seasonData SeasonData) SeasonData {
+	seasonData.RuleLabel = ruleLabels[seasonData.Rule]
+	seasonData.RstLabel = RstLabels[seasonData.Rst]
+	return seasonData
+}
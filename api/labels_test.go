@@ -0,0 +1,85 @@
+package Handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRstLabels(t *testing.T) {
+	labels := parseRstLabels("0=レギュレーションA, 1=レギュレーションB,x=C,bad")
+	if len(labels) != 2 || labels[0] != "レギュレーションA" || labels[1] != "レギュレーションB" {
+		t.Errorf("labels = %v, want 0 and 1 only", labels)
+	}
+	if labels := parseRstLabels(""); len(labels) != 0 {
+		t.Errorf("labels = %v, want none", labels)
+	}
+}
+
+// どのエンドポイントのシーズンデータにもルールとrstの数値と表示名が付く
+func TestSeasonLabels(t *testing.T) {
+	saved := RstLabels
+	t.Cleanup(func() { RstLabels = saved })
+	RstLabels = map[int]string{0: "レギュレーションA"}
+
+	tests := []struct {
+		target  string
+		handler http.HandlerFunc
+		// レスポンスからシーズンデータを取り出す
+		seasons func(t *testing.T, body []byte) []map[string]interface{}
+	}{
+		{target: "/rankings", handler: RankingHandler, seasons: func(t *testing.T, body []byte) []map[string]interface{} {
+			var response struct {
+				SeasonData map[string]interface{} `json:"season_data"`
+			}
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatal(err)
+			}
+			return []map[string]interface{}{response.SeasonData}
+		}},
+		{target: "/seasons", handler: SeasonsHandler, seasons: func(t *testing.T, body []byte) []map[string]interface{} {
+			var response []map[string]interface{}
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatal(err)
+			}
+			return response
+		}},
+		{target: "/season/current", handler: CurrentSeasonHandler, seasons: func(t *testing.T, body []byte) []map[string]interface{} {
+			var response map[string]interface{}
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatal(err)
+			}
+			return []map[string]interface{}{response}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			// 表示名のないrst
+			past := fixtureSeason(2, "10002", RuleDouble, time.Now().Add(-30*24*time.Hour))
+			past.Rst = 9
+			upstream.addSeason("2", past)
+
+			rec := get(t, tt.handler, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			seasons := tt.seasons(t, rec.Body.Bytes())
+			if len(seasons) == 0 {
+				t.Fatal("no season data")
+			}
+			for _, seasonData := range seasons {
+				want := map[string]interface{}{"rule": float64(RuleSingle), "rule_label": "single", "rst": float64(0), "rst_label": "レギュレーションA"}
+				if seasonData["season"] == float64(2) {
+					want = map[string]interface{}{"rule": float64(RuleDouble), "rule_label": "double", "rst": float64(9), "rst_label": nil}
+				}
+				for key, value := range want {
+					if got := seasonData[key]; got != value {
+						t.Errorf("season %v %s = %v, want %v", seasonData["season"], key, got, value)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package Handler
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// 言語ごとのシーズン名の翻訳表（言語 -> 上流のシーズン名 -> 翻訳したシーズン名）
+// SEASON_NAME_TRANSLATIONS_FILEに同じ形のJSONファイルを指定して読み込む
+var SeasonNameTranslations = loadSeasonNameTranslations(os.Getenv("SEASON_NAME_TRANSLATIONS_FILE"))
+
+func loadSeasonNameTranslations(path string) map[string]map[string]string {
+	translations := map[string]map[string]string{}
+	if path == "" {
+		return translations
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("failed to read season name translations: %v", err)
+		return translations
+	}
+	if err := json.Unmarshal(data, &translations); err != nil {
+		log.Printf("failed to decode season name translations: %v", err)
+		return map[string]map[string]string{}
+	}
+	return translations
+}
+
+// シーズン名を指定言語に翻訳する
+// 元のシーズン名はNameOriginalに残し、翻訳がなければ元のシーズン名のままにする
+func localizeSeasonData(seasonData SeasonData, lang string) SeasonData {
+	if lang == "" {
+		return seasonData
+	}
+	seasonData.NameOriginal = seasonData.Name
+	if name, ok := SeasonNameTranslations[lang][seasonData.Name]; ok {
+		seasonData.Name = name
+	}
+	return seasonData
+}
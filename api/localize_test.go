@@ -0,0 +1,100 @@
+package Handler
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// テスト用の翻訳表
+func withSeasonNameTranslations(t *testing.T, translations map[string]map[string]string) {
+	t.Helper()
+	saved := SeasonNameTranslations
+	t.Cleanup(func() { SeasonNameTranslations = saved })
+	SeasonNameTranslations = translations
+}
+
+func TestLoadSeasonNameTranslations(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	if err := os.WriteFile(valid, []byte(`{"en":{"シーズン1":"Season 1"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"en":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "valid", path: valid, want: "Season 1"},
+		{name: "unset", path: ""},
+		{name: "missing file", path: filepath.Join(dir, "missing.json")},
+		{name: "invalid file", path: invalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			translations := loadSeasonNameTranslations(tt.path)
+			if translations == nil {
+				t.Fatal("translations are nil")
+			}
+			if got := translations["en"]["シーズン1"]; got != tt.want {
+				t.Errorf("translation = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizeSeasonData(t *testing.T) {
+	withSeasonNameTranslations(t, map[string]map[string]string{"en": {"シーズン1": "Season 1"}})
+
+	tests := []struct {
+		name             string
+		lang             string
+		seasonName       string
+		wantName         string
+		wantNameOriginal string
+	}{
+		{name: "translated", lang: "en", seasonName: "シーズン1", wantName: "Season 1", wantNameOriginal: "シーズン1"},
+		{name: "unknown name falls back", lang: "en", seasonName: "シーズン2", wantName: "シーズン2", wantNameOriginal: "シーズン2"},
+		{name: "unknown language falls back", lang: "fr", seasonName: "シーズン1", wantName: "シーズン1", wantNameOriginal: "シーズン1"},
+		{name: "no language", seasonName: "シーズン1", wantName: "シーズン1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := localizeSeasonData(SeasonData{Name: tt.seasonName}, tt.lang)
+			if got.Name != tt.wantName || got.NameOriginal != tt.wantNameOriginal {
+				t.Errorf("got name %q original %q, want %q and %q", got.Name, got.NameOriginal, tt.wantName, tt.wantNameOriginal)
+			}
+		})
+	}
+}
+
+func TestRankingLocalizedSeasonName(t *testing.T) {
+	newFakeUpstream(t)
+	withSeasonNameTranslations(t, map[string]map[string]string{"en": {"シーズン1": "Season 1"}})
+
+	tests := []struct {
+		target   string
+		wantName string
+	}{
+		{target: "/rankings", wantName: "シーズン1"},
+		{target: "/rankings?lang=en", wantName: "Season 1"},
+		{target: "/rankings?lang=de", wantName: "シーズン1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if ranking.SeasonData.Name != tt.wantName {
+				t.Errorf("name = %q, want %q", ranking.SeasonData.Name, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package Handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// リクエストごとのログの1行にまとめる値
+// ハンドラーがcontext経由で書き込み、ミドルウェアが出力する
+type requestSummary struct {
+	recorded        bool
+	seasonListCache bool
+	rankingCache    bool
+	upstream        time.Duration
+	rows            int
+	format          string
+}
+
+type requestSummaryKey struct{}
+
+func requestSummaryFrom(ctx context.Context) *requestSummary {
+	summary, _ := ctx.Value(requestSummaryKey{}).(*requestSummary)
+	return summary
+}
+
+// 上流からの取得の状況を記録
+// 取得にかかった時間はキャッシュを使わなかったものだけを合計する
+func (s *requestSummary) recordFetch(status fetchStatus) {
+	if s == nil {
+		return
+	}
+	s.recorded = true
+	s.seasonListCache = status.seasonListCached
+	s.rankingCache = status.rankingCached
+	s.upstream = 0
+	if !status.seasonListCached {
+		s.upstream += status.seasonListElapsed
+	}
+	if !status.rankingCached {
+		s.upstream += status.rankingElapsed
+	}
+}
+
+// 返した行数とレスポンスの形式を記録
+func (s *requestSummary) recordResponse(rows int, format string) {
+	if s == nil {
+		return
+	}
+	s.rows = rows
+	s.format = format
+}
+
+func cacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+// ステータスコードを記録するResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// http.ResponseControllerで元のResponseWriterのFlushなどを使えるようにする
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// リクエストごとのログの出力先
+// ログの集約で項目ごとに検索できるように1行1件のJSONで出す
+var requestLogger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: envLogLevel("LOG_LEVEL", slog.LevelInfo)}))
+
+// リクエストごとに1行のログを出力するミドルウェア
+func withRequestLog(next http.HandlerFunc) http.HandlerFunc {
+	return withRequestLogLevel(slog.LevelInfo, next)
+}
+
+// 指定したレベルでリクエストごとのログを出力するミドルウェア
+// 監視から頻繁に呼ばれるエンドポイントはDebugにして、通常は出さないようにする
+func withRequestLogLevel(level slog.Level, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		summary := &requestSummary{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r.WithContext(context.WithValue(r.Context(), requestSummaryKey{}, summary)))
+		elapsed := time.Since(started)
+		observeRequest(r.URL.Path, recorder.status, elapsed)
+
+		// 毎回のリクエストで出すため、Attrを直接渡して余計な変換を避ける
+		attrs := [11]slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("query", r.URL.RawQuery),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.Int("status", recorder.status),
+			slog.Int64("elapsed_ms", elapsed.Milliseconds()),
+		}
+		n := 6
+		if summary.recorded {
+			attrs[6] = slog.String("season_list_cache", cacheResult(summary.seasonListCache))
+			attrs[7] = slog.String("ranking_cache", cacheResult(summary.rankingCache))
+			attrs[8] = slog.Int64("upstream_ms", summary.upstream.Milliseconds())
+			attrs[9] = slog.Int("rows", summary.rows)
+			attrs[10] = slog.String("format", summary.format)
+			n = len(attrs)
+		}
+		requestLogger.LogAttrs(r.Context(), level, "request", attrs[:n]...)
+	}
+}
@@ -0,0 +1,183 @@
+package Handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 標準のloggerの出力を記録する
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	writer, flags := log.Writer(), log.Flags()
+	t.Cleanup(func() {
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+	})
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	return &buf
+}
+
+// requestLoggerの出力を記録し、出力したログを1行ずつデコードして返す関数を返す
+func captureRequestLog(t *testing.T) func() []map[string]interface{} {
+	t.Helper()
+	return captureRequestLogLevel(t, slog.LevelInfo)
+}
+
+// 指定したレベル以上のログだけを記録するcaptureRequestLog
+func captureRequestLogLevel(t *testing.T, level slog.Level) func() []map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	saved := requestLogger
+	t.Cleanup(func() { requestLogger = saved })
+	requestLogger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: level}))
+	return func() []map[string]interface{} {
+		var lines []map[string]interface{}
+		if buf.Len() == 0 {
+			return nil
+		}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var fields map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &fields); err != nil {
+				t.Fatalf("invalid log line %q: %v", line, err)
+			}
+			lines = append(lines, fields)
+		}
+		buf.Reset()
+		return lines
+	}
+}
+
+func TestRequestSummaryLog(t *testing.T) {
+	newFakeUpstream(t)
+	logs := captureRequestLog(t)
+	handler := withRequestLog(RankingHandler)
+
+	tests := []struct {
+		name   string
+		target string
+		want   map[string]interface{}
+		absent []string
+	}{
+		{name: "first request", target: "/rankings", want: map[string]interface{}{
+			"msg": "request", "path": "/rankings", "status": float64(200), "season_list_cache": "miss", "ranking_cache": "miss", "rows": float64(1000), "format": "json",
+		}},
+		{name: "cached", target: "/rankings?top=10", want: map[string]interface{}{
+			"query": "top=10", "status": float64(200), "season_list_cache": "hit", "ranking_cache": "hit", "upstream_ms": float64(0), "rows": float64(10), "format": "json",
+		}},
+		{name: "csv", target: "/rankings?format=csv", want: map[string]interface{}{
+			"status": float64(200), "rows": float64(1000), "format": "csv",
+		}},
+		{name: "invalid parameter", target: "/rankings?sample=0", want: map[string]interface{}{
+			"status": float64(400),
+		}, absent: []string{"season_list_cache", "ranking_cache", "upstream_ms", "rows", "format"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			get(t, handler, tt.target)
+			lines := logs()
+			if len(lines) != 1 {
+				t.Fatalf("logged %d lines, want 1: %v", len(lines), lines)
+			}
+			for key, want := range tt.want {
+				if got := lines[0][key]; got != want {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+			for _, key := range tt.absent {
+				if got, ok := lines[0][key]; ok {
+					t.Errorf("%s = %v, want no field", key, got)
+				}
+			}
+			if _, ok := lines[0]["elapsed_ms"]; !ok {
+				t.Error("elapsed_ms is missing")
+			}
+		})
+	}
+}
+
+// ステータスコードを書かないハンドラーは200として記録する
+func TestRequestLogStatus(t *testing.T) {
+	logs := captureRequestLog(t)
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus float64
+	}{
+		{name: "implicit ok", handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, wantStatus: 200},
+		{name: "not found", handler: http.NotFound, wantStatus: 404},
+		{name: "json error", handler: func(w http.ResponseWriter, r *http.Request) {
+			writeJSONError(w, http.StatusTeapot, "teapot")
+		}, wantStatus: 418},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/anything?x=1", nil)
+			req.RemoteAddr = "192.0.2.1:1000"
+			withRequestLog(tt.handler).ServeHTTP(httptest.NewRecorder(), req)
+			lines := logs()
+			if len(lines) != 1 {
+				t.Fatalf("logged %d lines, want 1: %v", len(lines), lines)
+			}
+			want := map[string]interface{}{"method": "POST", "path": "/anything", "query": "x=1", "remote_addr": "192.0.2.1:1000", "status": tt.wantStatus}
+			for key, value := range want {
+				if got := lines[0][key]; got != value {
+					t.Errorf("%s = %v, want %v", key, got, value)
+				}
+			}
+		})
+	}
+}
+
+// registerRoutesで登録するエンドポイントはすべてログを出し、ヘルスチェックとメトリクスはDebugで出す
+func TestRequestLogRoutes(t *testing.T) {
+	newFakeUpstream(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux, "")
+
+	tests := []struct {
+		target    string
+		wantLevel slog.Level
+	}{
+		{target: "/rankings", wantLevel: slog.LevelInfo},
+		{target: "/rankings/stats", wantLevel: slog.LevelInfo},
+		{target: "/rankings/histogram?bucket=0", wantLevel: slog.LevelInfo},
+		{target: "/seasons", wantLevel: slog.LevelInfo},
+		{target: "/openapi.json", wantLevel: slog.LevelInfo},
+		{target: "/healthz", wantLevel: slog.LevelDebug},
+		{target: "/readyz", wantLevel: slog.LevelDebug},
+		{target: "/metrics", wantLevel: slog.LevelDebug},
+	}
+	for _, level := range []slog.Level{slog.LevelInfo, slog.LevelDebug} {
+		logs := captureRequestLogLevel(t, level)
+		for _, tt := range tests {
+			t.Run(level.String()+tt.target, func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+				lines := logs()
+				if tt.wantLevel < level {
+					if len(lines) != 0 {
+						t.Errorf("logged %v, want no log", lines)
+					}
+					return
+				}
+				if len(lines) != 1 {
+					t.Fatalf("logged %d lines, want 1: %v", len(lines), lines)
+				}
+				if got := lines[0]["level"]; got != tt.wantLevel.String() {
+					t.Errorf("level = %v, want %s", got, tt.wantLevel)
+				}
+				if got := lines[0]["status"]; got != float64(rec.Code) {
+					t.Errorf("status = %v, want %d", got, rec.Code)
+				}
+			})
+		}
+	}
+}
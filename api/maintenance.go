@@ -0,0 +1,144 @@
+package Handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// メンテナンスモードで返すスナップショットのファイル
+// Snapshotと同じ形式のJSON
+var MaintenanceSnapshotFile = os.Getenv("MAINTENANCE_SNAPSHOT_FILE")
+
+// 管理用エンドポイントのトークン
+// 空の場合は管理用エンドポイントを使えない
+var AdminToken = os.Getenv("ADMIN_TOKEN")
+
+// メンテナンスモードの状態
+// 有効な間は上流から取得せずにファイルから読み込んだスナップショットを返す
+type maintenanceMode struct {
+	mu       sync.RWMutex
+	snapshot *Snapshot
+}
+
+var maintenance = &maintenanceMode{}
+
+// スナップショットを読み込んでメンテナンスモードにする
+func (m *maintenanceMode) enable(path string) error {
+	snapshot, err := loadSnapshotFile(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot = &snapshot
+	return nil
+}
+
+func (m *maintenanceMode) disable() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot = nil
+}
+
+// メンテナンスモードなら返すスナップショットを取得
+func (m *maintenanceMode) current() (Snapshot, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.snapshot == nil {
+		return Snapshot{}, false
+	}
+	return *m.snapshot, true
+}
+
+func loadSnapshotFile(path string) (Snapshot, error) {
+	if path == "" {
+		return Snapshot{}, fmt.Errorf("MAINTENANCE_SNAPSHOT_FILE is not set")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to open snapshot file: %v", err)
+	}
+	defer f.Close()
+	var snapshot Snapshot
+	if err := json.NewDecoder(f).Decode(&snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decode snapshot file: %v", err)
+	}
+	return snapshot, nil
+}
+
+// スナップショットを古いデータであることがわかるレスポンスにする
+// 呼び出し側で書き換えてもスナップショットが壊れないようにコピーを返す
+func maintenanceRanking(snapshot Snapshot) RankingResponse {
+	response := snapshot.Ranking
+	response.Top1000 = append([]RankResponseRawData(nil), snapshot.Ranking.Top1000...)
+	response.Stale = true
+	asOf := snapshot.Timestamp
+	response.AsOf = &asOf
+	return response
+}
+
+// メンテナンスモードの状態
+type MaintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	AsOf    *time.Time `json:"as_of,omitempty"`
+}
+
+func currentMaintenanceStatus() MaintenanceStatus {
+	snapshot, ok := maintenance.current()
+	if !ok {
+		return MaintenanceStatus{}
+	}
+	return MaintenanceStatus{Enabled: true, AsOf: &snapshot.Timestamp}
+}
+
+// Authorizationヘッダーが管理用のトークンと一致するか
+// 一致するまでの時間からトークンを推測されないように比較にかかる時間を一定にする
+func authorizedAdmin(r *http.Request) bool {
+	if AdminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+AdminToken)) == 1
+}
+
+// endpoint handler
+// GETで状態を返し、POSTの?enabled=true|falseで切り替える
+func MaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !authorizedAdmin(r) {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid enabled parameter")
+			return
+		}
+		if enabled {
+			if err := maintenance.enable(MaintenanceSnapshotFile); err != nil {
+				writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to enable maintenance mode: %v", err))
+				return
+			}
+		} else {
+			maintenance.disable()
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(currentMaintenanceStatus()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
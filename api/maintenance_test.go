@@ -0,0 +1,135 @@
+package Handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 管理用のトークンとメンテナンスモードのスナップショットのファイルを設定する
+func withMaintenanceConfig(t *testing.T, token, snapshotFile string) {
+	t.Helper()
+	savedToken, savedFile := AdminToken, MaintenanceSnapshotFile
+	t.Cleanup(func() { AdminToken, MaintenanceSnapshotFile = savedToken, savedFile })
+	AdminToken, MaintenanceSnapshotFile = token, snapshotFile
+}
+
+// 管理用エンドポイントにトークン付きでリクエストを送る
+func adminRequest(t *testing.T, method, target, authorization string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	MaintenanceHandler(rec, req)
+	return rec
+}
+
+func TestAuthorizedAdmin(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          bool
+	}{
+		{name: "matching token", token: "secret", authorization: "Bearer secret", want: true},
+		{name: "wrong token", token: "secret", authorization: "Bearer secreT"},
+		{name: "prefix of token", token: "secret", authorization: "Bearer sec"},
+		{name: "without scheme", token: "secret", authorization: "secret"},
+		{name: "no header", token: "secret"},
+		{name: "token not configured", authorization: "Bearer "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withMaintenanceConfig(t, tt.token, "")
+			req := httptest.NewRequest(http.MethodGet, "/admin/maintenance", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if got := authorizedAdmin(req); got != tt.want {
+				t.Errorf("authorizedAdmin = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// メンテナンスモードの間は上流から取得せずにファイルのスナップショットを返す
+func TestMaintenanceMode(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	asOf := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	snapshot := fixtureSnapshot(asOf, 9)
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withMaintenanceConfig(t, "secret", path)
+
+	if rec := adminRequest(t, http.MethodPost, "/admin/maintenance?enabled=true", "Bearer wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status with a wrong token = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := adminRequest(t, http.MethodPost, "/admin/maintenance?enabled=x", "Bearer secret"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status with an invalid enabled = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec := adminRequest(t, http.MethodPost, "/admin/maintenance?enabled=true", "Bearer secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var status MaintenanceStatus
+	decodeBody(t, rec, &status)
+	if !status.Enabled || status.AsOf == nil || !status.AsOf.Equal(asOf) {
+		t.Errorf("status = %+v, want enabled as of %v", status, asOf)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec, ranking := getRanking(t, "/rankings")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		if !ranking.Stale || ranking.AsOf == nil || !ranking.AsOf.Equal(asOf) {
+			t.Errorf("request %d: stale = %v as_of = %v, want stale as of %v", i, ranking.Stale, ranking.AsOf, asOf)
+		}
+		if ranking.SeasonData.Season != 9 || len(ranking.Top1000) != 3 {
+			t.Errorf("request %d: got season %d with %d rows, want the snapshot's season 9 with 3 rows", i, ranking.SeasonData.Season, len(ranking.Top1000))
+		}
+	}
+	if n := upstream.rankingCalls.Load() + upstream.seasonListCalls.Load(); n != 0 {
+		t.Errorf("upstream calls = %d, want 0", n)
+	}
+
+	rec = adminRequest(t, http.MethodPost, "/admin/maintenance?enabled=false", "Bearer secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	rec, ranking := getRanking(t, "/rankings")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if ranking.Stale || ranking.SeasonData.Season != 1 || len(ranking.Top1000) != 1000 {
+		t.Errorf("after disabling got stale = %v season %d with %d rows, want season 1 from upstream", ranking.Stale, ranking.SeasonData.Season, len(ranking.Top1000))
+	}
+}
+
+// スナップショットのファイルが読めなければメンテナンスモードにしない
+func TestMaintenanceModeWithoutSnapshot(t *testing.T) {
+	newFakeUpstream(t)
+	withMaintenanceConfig(t, "secret", filepath.Join(t.TempDir(), "missing.json"))
+
+	if rec := adminRequest(t, http.MethodPost, "/admin/maintenance?enabled=true", "Bearer secret"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	rec := adminRequest(t, http.MethodGet, "/admin/maintenance", "Bearer secret")
+	var status MaintenanceStatus
+	decodeBody(t, rec, &status)
+	if status.Enabled {
+		t.Errorf("status = %+v, want disabled", status)
+	}
+}
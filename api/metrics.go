@@ -0,0 +1,98 @@
+package Handler
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 上流への呼び出しの種類
+const (
+	upstreamCallSeasonList = "season_list"
+	upstreamCallRanking    = "ranking"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rankbattle",
+		Name:      "http_requests_total",
+		Help:      "エンドポイントとステータスコードごとのリクエスト数",
+	}, []string{"path", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "rankbattle",
+		Name:      "http_request_duration_seconds",
+		Help:      "エンドポイントごとのリクエストの処理時間",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"path"})
+	upstreamFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "rankbattle",
+		Name:      "upstream_fetch_duration_seconds",
+		Help:      "上流からの取得にかかった時間（再試行を含む）",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"call"})
+	upstreamErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rankbattle",
+		Name:      "upstream_errors_total",
+		Help:      "上流からの取得に失敗した回数",
+	}, []string{"call", "type"})
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rankbattle",
+		Name:      "cache_requests_total",
+		Help:      "キャッシュを参照した回数",
+	}, []string{"cache", "result"})
+)
+
+// 上流のレスポンスのステータスコード
+// エラーの種類を区別できるように、ステータスコードのエラーはこの型を包む
+type upstreamStatus int
+
+func (s upstreamStatus) Error() string {
+	return strconv.Itoa(int(s))
+}
+
+func (s upstreamStatus) Is(target error) bool {
+	return target == ErrUpstreamUnavailable
+}
+
+// メトリクスのラベルに使う上流のエラーの種類
+func upstreamErrorType(err error) string {
+	var status upstreamStatus
+	switch {
+	case isTimeout(err):
+		return "timeout"
+	case errors.Is(err, errRetryBudgetExhausted):
+		return "budget_exhausted"
+	case errors.As(err, &status):
+		return "status"
+	case errors.Is(err, ErrDecode):
+		return "decode"
+	default:
+		return "other"
+	}
+}
+
+// 上流からの取得にかかった時間と失敗を記録
+func observeUpstream(call string, started time.Time, err error) {
+	upstreamFetchDuration.WithLabelValues(call).Observe(time.Since(started).Seconds())
+	if err != nil {
+		upstreamErrorsTotal.WithLabelValues(call, upstreamErrorType(err)).Inc()
+	}
+}
+
+// キャッシュを使えたかを記録
+func observeCache(cache string, hit bool) {
+	cacheRequestsTotal.WithLabelValues(cache, cacheResult(hit)).Inc()
+}
+
+// リクエストの処理時間とステータスコードを記録
+func observeRequest(path string, status int, elapsed time.Duration) {
+	httpRequestsTotal.WithLabelValues(path, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(path).Observe(elapsed.Seconds())
+}
+
+// Prometheusの形式でメトリクスを返すハンドラー
+var MetricsHandler = promhttp.Handler()
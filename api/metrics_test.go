@@ -0,0 +1,122 @@
+package Handler
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// /metricsの出力から系列の値を読む
+// まだ出力されていない系列は0とする
+func scrapeMetric(t *testing.T, mux http.Handler, series string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), series+" ")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("series %s has invalid value %q", series, value)
+		}
+		return v
+	}
+	return 0
+}
+
+// メトリクスは他のテストと共有しているため、リクエストの前後の差を比べる
+func TestMetrics(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	withoutRetryDelay(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux, "")
+
+	const (
+		requestsOK         = `rankbattle_http_requests_total{path="/rankings",status="200"}`
+		requestsBadRequest = `rankbattle_http_requests_total{path="/rankings",status="400"}`
+		requestsBadGateway = `rankbattle_http_requests_total{path="/rankings",status="502"}`
+		durationCount      = `rankbattle_http_request_duration_seconds_count{path="/rankings"}`
+		seasonListFetches  = `rankbattle_upstream_fetch_duration_seconds_count{call="season_list"}`
+		rankingFetches     = `rankbattle_upstream_fetch_duration_seconds_count{call="ranking"}`
+		rankingErrors      = `rankbattle_upstream_errors_total{call="ranking",type="status"}`
+		seasonListHits     = `rankbattle_cache_requests_total{cache="season_list",result="hit"}`
+		seasonListMisses   = `rankbattle_cache_requests_total{cache="season_list",result="miss"}`
+		rankingHits        = `rankbattle_cache_requests_total{cache="ranking",result="hit"}`
+		rankingMisses      = `rankbattle_cache_requests_total{cache="ranking",result="miss"}`
+	)
+	all := []string{requestsOK, requestsBadRequest, requestsBadGateway, durationCount, seasonListFetches, rankingFetches, rankingErrors, seasonListHits, seasonListMisses, rankingHits, rankingMisses}
+
+	tests := []struct {
+		name       string
+		target     string
+		setup      func(t *testing.T)
+		wantStatus int
+		want       map[string]float64
+	}{
+		{name: "first request", target: "/rankings", wantStatus: http.StatusOK, want: map[string]float64{
+			requestsOK: 1, durationCount: 1, seasonListFetches: 1, rankingFetches: 1, seasonListMisses: 1, rankingMisses: 1,
+		}},
+		{name: "cached", target: "/rankings?top=10", wantStatus: http.StatusOK, want: map[string]float64{
+			requestsOK: 1, durationCount: 1, seasonListHits: 1, rankingHits: 1,
+		}},
+		{name: "invalid parameter", target: "/rankings?sample=0", wantStatus: http.StatusBadRequest, want: map[string]float64{
+			requestsBadRequest: 1, durationCount: 1,
+		}},
+		{name: "upstream error", target: "/rankings", setup: func(t *testing.T) {
+			resetState(t)
+			upstream.failPage(upstream.seasons["1"]["10001"], 1, http.StatusInternalServerError)
+		}, wantStatus: http.StatusBadGateway, want: map[string]float64{
+			requestsBadGateway: 1, durationCount: 1, seasonListFetches: 1, rankingFetches: 1, rankingErrors: 1, seasonListMisses: 1, rankingMisses: 1,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			before := map[string]float64{}
+			for _, series := range all {
+				before[series] = scrapeMetric(t, mux, series)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			for _, series := range all {
+				if got := scrapeMetric(t, mux, series) - before[series]; got != tt.want[series] {
+					t.Errorf("%s increased by %v, want %v", series, got, tt.want[series])
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamErrorType(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "status", err: upstreamStatus(http.StatusServiceUnavailable), want: "status"},
+		{name: "decode", err: ErrDecode, want: "decode"},
+		{name: "budget", err: errRetryBudgetExhausted, want: "budget_exhausted"},
+		{name: "other", err: http.ErrHandlerTimeout, want: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := upstreamErrorType(tt.err); got != tt.want {
+				t.Errorf("upstreamErrorType(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package Handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// OpenAPIに記載するクエリパラメータ
+type openAPIParam struct {
+	Name        string
+	Type        string
+	Required    bool
+	Description string
+}
+
+// OpenAPIに記載するエンドポイント
+// Methodが空ならGET
+type openAPIEndpoint struct {
+	Path        string
+	Method      string
+	Summary     string
+	Params      []openAPIParam
+	RequestBody interface{}
+	Response    interface{}
+}
+
+// エンドポイント一覧
+// ハンドラーにパラメータを追加した場合はここも更新する
+var openAPIEndpoints = []openAPIEndpoint{
+	{
+		Path:    "/rankings",
+		Summary: "現在のシーズン情報と上位1000位のランキング",
+		Params: []openAPIParam{
+			{Name: "sample", Type: "integer", Description: "全体から等間隔に抽出する件数 (1-1000)"},
+			{Name: "depth", Type: "integer", Description: "取得するページ数 (1-10)、1ページ1000件"},
+			{Name: "top", Type: "integer", Description: "上位から返す件数 (1-10000)。必要なページ数を取得する。depthとは併用できない"},
+			{Name: "format", Type: "string", Description: "json（デフォルト）、csvまたはjsonl。指定がなくAcceptにtext/csvが含まれる場合はcsv、application/x-ndjsonが含まれる場合はjsonl"},
+			{Name: "soft", Type: "string", Description: "ソフト（Sc または Vi）。指定がなければSc"},
+			{Name: "from", Type: "integer", Description: "返す最初の順位（1以上）"},
+			{Name: "to", Type: "integer", Description: "返す最後の順位（depth×1000以下）"},
+			{Name: "strict", Type: "boolean", Description: "2ページ目以降の取得に失敗した場合もエラーにする"},
+			{Name: "season", Type: "integer", Description: "現在のシーズンではなく指定した番号のシーズンを返す。見つからなければ404"},
+			{Name: "rule", Type: "integer", Description: "シーズンのルール（0がシングル、1がダブル）。指定がなく複数のルールのシーズンがある場合はシングル"},
+			{Name: "rst", Type: "integer", Description: "選択したシーズンのrstと一致しない場合は400を返す"},
+			{Name: "include_timing", Type: "boolean", Description: "上流からの取得にかかった時間とキャッシュの利用有無を含める"},
+			{Name: "lang", Type: "string", Description: "シーズン名を翻訳する言語。シーズンリストの内側のキーがこの言語のシーズンがあればそれを選ぶ"},
+			{Name: "lng", Type: "string", Description: "トレーナーの言語コード（カンマ区切りで複数指定可）。いずれかに一致する行だけを元の順位のまま返す"},
+			{Name: "min_rating", Type: "number", Description: "このレート未満の行を除き、元の順位のまま返す（0以上）"},
+			{Name: "since_ts1", Type: "string", Description: "最後に取得したデータのts1。更新がなければ304を返す"},
+			{Name: "avg_top", Type: "integer", Description: "上位N件の平均レートをavg_topとして含める (1-1000)"},
+			{Name: "fill_gaps", Type: "boolean", Description: "上流に無かった順位をplaceholderがtrueの空の行で埋める"},
+			{Name: "rating_display", Type: "boolean", Description: "各行にロケールの桁区切り付きのレートをrating_displayとして含める"},
+			{Name: "locale", Type: "string", Description: "rating_displayとsort=rating、sort=name、ties=nameの名前順のロケール（en、ja、deなど。デフォルトen）"},
+			{Name: "sort", Type: "string", Description: "rank（デフォルト）、rating、name、lngのいずれか。各行の順位はそのまま。ratingは同じレートを名前順、名前も同じなら順位順にする"},
+			{Name: "order", Type: "string", Description: "ascまたはdesc。指定がなければratingはdesc、それ以外はasc"},
+			{Name: "ties", Type: "string", Description: "同じレートの行の並び順。rank（デフォルト）は上流の順位順、nameはlocaleの照合順序で名前順"},
+			{Name: "dense_rank", Type: "boolean", Description: "レートが変わるごとに1つずつ上がる順位に付け直す。変わった行があればranks_adjustedをtrueにする"},
+			{Name: "known_hash", Type: "string", Description: "最後に取得したデータのETag。一致すれば304を返す"},
+			{Name: "delta", Type: "boolean", Description: "known_hashのデータを保持していれば変更された行と無くなった行だけを返す"},
+			{Name: "include_source", Type: "boolean", Description: "ランキングデータを返した上流のServer、X-Cache、Ageヘッダーを_sourceに、シーズンリストのキーをselectedに含める"},
+		},
+		Response: RankingResponse{},
+	},
+	{
+		Path:        "/rankings/query",
+		Method:      http.MethodPost,
+		Summary:     "JSONのボディで絞り込み、並べ替え、項目の指定をしたランキング（/rankingsと同じ形）",
+		RequestBody: RankingQueryRequest{},
+		Response:    RankingResponse{},
+	},
+	{
+		Path:    "/rankings/cutoff",
+		Summary: "指定順位のボーダーレート",
+		Params: []openAPIParam{
+			{Name: "rank", Type: "integer", Required: true, Description: "順位"},
+			{Name: "ties", Type: "boolean", Description: "同率のトレーナー数と順位の範囲を含める"},
+			{Name: "clamp", Type: "boolean", Description: "順位がランキングの範囲外なら404ではなく最下位の順位のボーダーをclamped付きで返す"},
+		},
+		Response: CutoffResponse{},
+	},
+	{
+		Path:    "/rankings/cutoff/compare",
+		Summary: "2つのシーズンの指定順位のボーダーレートの比較",
+		Params: []openAPIParam{
+			{Name: "rank", Type: "integer", Required: true, Description: "順位"},
+			{Name: "a", Type: "integer", Required: true, Description: "比較元のシーズン番号"},
+			{Name: "b", Type: "integer", Required: true, Description: "比較先のシーズン番号"},
+		},
+		Response: CutoffCompareResponse{},
+	},
+	{
+		Path:    "/rankings/cutoff/seasons",
+		Summary: "複数シーズンの指定順位のボーダーレート",
+		Params: []openAPIParam{
+			{Name: "rank", Type: "integer", Required: true, Description: "順位"},
+			{Name: "seasons", Type: "string", Required: true, Description: "カンマ区切りのシーズン番号（最大MAX_BULK_SEASONS件）"},
+		},
+		Response: CutoffSeasonsResponse{},
+	},
+	{
+		Path:    "/rankings/batch",
+		Summary: "複数シーズンの上位1000位のランキング",
+		Params: []openAPIParam{
+			{Name: "seasons", Type: "string", Required: true, Description: "カンマ区切りのシーズン番号（最大MAX_BULK_SEASONS件）"},
+		},
+		Response: BatchResponse{},
+	},
+	{
+		Path:    "/rankings/history",
+		Summary: "保存したスナップショットのうち指定時刻に最も近いもの",
+		Params: []openAPIParam{
+			{Name: "season", Type: "integer", Description: "シーズン番号"},
+			{Name: "at", Type: "string", Description: "時刻（RFC3339または2006-01-02T15:04の形式。タイムゾーンが無ければ日本時間）。指定がなければ最新"},
+		},
+		Response: Snapshot{},
+	},
+	{
+		Path:    "/rankings/diff",
+		Summary: "2つの時点のランキングの間に入った、外れた、順位かレートが変わったトレーナー",
+		Params: []openAPIParam{
+			{Name: "prev_at", Type: "string", Required: true, Description: "比較元の時刻。最も近いスナップショットを使う（形式は/rankings/historyのatと同じ）"},
+			{Name: "curr_at", Type: "string", Description: "比較先の時刻。指定がなければ現在のランキングと比べる"},
+			{Name: "season", Type: "integer", Description: "スナップショットを探すシーズン番号"},
+		},
+		Response: RankingDiffResponse{},
+	},
+	{
+		Path:     "/rankings/stats",
+		Summary:  "上位1000位のレートの平均、中央値、標準偏差、最小値、最大値とパーセンタイル",
+		Response: StatsResponse{},
+	},
+	{
+		Path:    "/rankings/overview",
+		Summary: "シーズン情報、上位N件、レートの統計、シーズンの残り時間をまとめたもの",
+		Params: []openAPIParam{
+			{Name: "top", Type: "integer", Description: "返す上位の件数 (1-1000、デフォルト10)"},
+		},
+		Response: OverviewResponse{},
+	},
+	{
+		Path:    "/rankings/percentiles",
+		Summary: "上位1000位のレートのパーセンタイル",
+		Params: []openAPIParam{
+			{Name: "p", Type: "string", Description: "カンマ区切りのパーセンタイル (0-100)"},
+		},
+		Response: PercentilesResponse{},
+	},
+	{
+		Path:    "/rankings/histogram",
+		Summary: "上位1000位のレートの区間ごとのトレーナー数",
+		Params: []openAPIParam{
+			{Name: "bucket", Type: "number", Description: "区間の幅（0より大きい値。デフォルト25）"},
+		},
+		Response: HistogramResponse{},
+	},
+	{
+		Path:    "/rankings/cdf",
+		Summary: "閾値ごとのそのレート以上のトレーナー数と割合",
+		Params: []openAPIParam{
+			{Name: "thresholds", Type: "string", Description: "カンマ区切りのレート。指定がなければ最低レートから最高レートまでを10等分する"},
+		},
+		Response: CDFResponse{},
+	},
+	{
+		Path:     "/rankings/tiers",
+		Summary:  "レートの区分ごとのトレーナー数とトレーナー",
+		Response: TiersResponse{},
+	},
+	{
+		Path:    "/rankings/threshold",
+		Summary: "指定レート以上のトレーナーがいる最も低い順位",
+		Params: []openAPIParam{
+			{Name: "rating", Type: "number", Required: true, Description: "レート"},
+		},
+		Response: ThresholdResponse{},
+	},
+	{
+		Path:    "/rankings/rank",
+		Summary: "指定順位のトレーナー",
+		Params: []openAPIParam{
+			{Name: "rank", Type: "integer", Required: true, Description: "順位。取得できた範囲外なら404"},
+		},
+		Response: RankLookupResponse{},
+	},
+	{
+		Path:    "/rankings/search",
+		Summary: "名前で検索したトレーナー（順位順）",
+		Params: []openAPIParam{
+			{Name: "name", Type: "string", Required: true, Description: "名前に含まれる文字列。大文字と小文字は区別しない"},
+			{Name: "exact", Type: "boolean", Description: "名前が完全に一致するトレーナーのみにする"},
+		},
+		Response: RankingSearchResponse{},
+	},
+	{
+		Path:    "/rankings/climb",
+		Summary: "指定順位から指定した数だけ順位を上げるのに必要なレートの差",
+		Params: []openAPIParam{
+			{Name: "rank", Type: "integer", Required: true, Description: "現在の順位"},
+			{Name: "spots", Type: "integer", Required: true, Description: "上げる順位の数"},
+		},
+		Response: ClimbResponse{},
+	},
+	{
+		Path:    "/rankings/estimate",
+		Summary: "指定レートの順位。上位1000位の範囲外なら下位の傾きから外挿した推定値",
+		Params: []openAPIParam{
+			{Name: "rating", Type: "number", Required: true, Description: "レート"},
+		},
+		Response: EstimateResponse{},
+	},
+	{
+		Path:    "/seasons",
+		Summary: "シーズンの一覧（新しいシーズン順）",
+		Params: []openAPIParam{
+			{Name: "published", Type: "boolean", Description: "ランキングが公開されているシーズンのみにする"},
+			{Name: "probe", Type: "boolean", Description: "publishedと併用し、ランキングファイルの有無も確認する"},
+			{Name: "lang", Type: "string", Description: "シーズン名を翻訳する言語"},
+		},
+		Response: []SeasonData{},
+	},
+	{
+		Path:    "/seasons/schedule",
+		Summary: "指定した年に開始したシーズンの日程（開始日時順、シーズンの間の空いている期間はgap）",
+		Params: []openAPIParam{
+			{Name: "year", Type: "integer", Description: "年。指定がなければ今年"},
+		},
+		Response: SeasonScheduleResponse{},
+	},
+	{
+		Path:    "/seasons/active",
+		Summary: "開催中のシングルとダブルのシーズンの残り時間と100位のボーダーレート",
+		Params: []openAPIParam{
+			{Name: "soft", Type: "string", Description: "ソフト（Sc または Vi）。指定がなければSc"},
+			{Name: "lang", Type: "string", Description: "シーズン名を翻訳する言語。シーズンリストの内側のキーがこの言語のシーズンがあればそれを選ぶ"},
+		},
+		Response: ActiveSeasonsResponse{},
+	},
+	{
+		Path:    "/trainer/sparkline",
+		Summary: "保存したスナップショットから求めたトレーナーの順位の推移",
+		Params: []openAPIParam{
+			{Name: "name", Type: "string", Required: true, Description: "トレーナー名"},
+			{Name: "season", Type: "integer", Description: "シーズン番号。指定がなければ最新のスナップショットのシーズン"},
+			{Name: "points", Type: "integer", Description: "最大の点数 (1-500)、デフォルト30"},
+		},
+		Response: SparklineResponse{},
+	},
+	{
+		Path:    "/season/current",
+		Summary: "現在のシーズン情報",
+		Params: []openAPIParam{
+			{Name: "lang", Type: "string", Description: "シーズン名を翻訳する言語。シーズンリストの内側のキーがこの言語のシーズンがあればそれを選ぶ"},
+		},
+		Response: SeasonData{},
+	},
+	{
+		Path:     "/season/current.ics",
+		Summary:  "現在のシーズンの期間のiCalendar (text/calendar)",
+		Response: "",
+	},
+	{
+		Path:    "/icon",
+		Summary: "トレーナーアイコンの画像（リソースのホストが返したContent-Type）",
+		Params: []openAPIParam{
+			{Name: "file", Type: "string", Required: true, Description: "アイコンのファイル名（/rankingsのiconのURLの最後の部分）"},
+		},
+		Response: "",
+	},
+}
+
+// OpenAPIドキュメントを組み立て
+func buildOpenAPISpec() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+
+	for _, endpoint := range openAPIEndpoints {
+		params := make([]map[string]interface{}, len(endpoint.Params))
+		for i, param := range endpoint.Params {
+			params[i] = map[string]interface{}{
+				"name":        param.Name,
+				"in":          "query",
+				"required":    param.Required,
+				"description": param.Description,
+				"schema":      map[string]interface{}{"type": param.Type},
+			}
+		}
+		operation := map[string]interface{}{
+			"summary":    endpoint.Summary,
+			"parameters": params,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OK",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": schemaOf(reflect.TypeOf(endpoint.Response), schemas),
+						},
+					},
+				},
+			},
+		}
+		if endpoint.RequestBody != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": schemaOf(reflect.TypeOf(endpoint.RequestBody), schemas),
+					},
+				},
+			}
+		}
+		method := endpoint.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		paths[RoutePrefix+endpoint.Path] = map[string]interface{}{strings.ToLower(method): operation}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "go-rank-battle-tracker",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+}
+
+// 構造体の定義からスキーマを生成
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok {
+			return ref
+		}
+		properties := map[string]interface{}{}
+		// 再帰的な参照に備えて先に登録しておく
+		schemas[t.Name()] = map[string]interface{}{"type": "object", "properties": properties}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaOf(field.Type, schemas)
+		}
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+// endpoint handler
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(buildOpenAPISpec()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
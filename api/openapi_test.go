@@ -0,0 +1,209 @@
+package Handler
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// OpenAPIに記載しないエンドポイント
+var undocumentedRoutes = map[string]bool{
+	"/healthz":           true,
+	"/readyz":            true,
+	"/metrics":           true,
+	"/openapi.json":      true,
+	"/admin/maintenance": true,
+}
+
+// パッケージのソースのうちテスト以外の関数の宣言
+func packageFuncs(t *testing.T) map[string]*ast.FuncDecl {
+	t.Helper()
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcs := map[string]*ast.FuncDecl{}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+					funcs[fn.Name.Name] = fn
+				}
+			}
+		}
+	}
+	return funcs
+}
+
+// registerRoutesで登録しているパスとハンドラーの関数名
+func registeredHandlers(t *testing.T, funcs map[string]*ast.FuncDecl) map[string]string {
+	t.Helper()
+	routes := map[string]string{}
+	ast.Inspect(funcs["registerRoutes"], func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 {
+			return true
+		}
+		path, ok := call.Args[0].(*ast.BinaryExpr)
+		if !ok {
+			return true
+		}
+		lit, ok := path.Y.(*ast.BasicLit)
+		if !ok {
+			return true
+		}
+		p, _ := strconv.Unquote(lit.Value)
+		// withRequestLog(withCompression(XHandler)) のように包んだものは最も内側の関数
+		handler := call.Args[1]
+		for {
+			inner, ok := handler.(*ast.CallExpr)
+			if !ok || len(inner.Args) == 0 {
+				break
+			}
+			handler = inner.Args[0]
+		}
+		if ident, ok := handler.(*ast.Ident); ok {
+			routes[p] = ident.Name
+		}
+		return false
+	})
+	return routes
+}
+
+// 関数とそこから呼んでいるパッケージの関数が読むクエリパラメータ
+// r.URL.Query().Get("x") と、r.URL.Query()を代入した変数の .Get("x") を数える
+func queryParams(funcs map[string]*ast.FuncDecl, name string, params map[string]bool, visited map[string]bool) {
+	fn, ok := funcs[name]
+	if !ok || visited[name] {
+		return
+	}
+	visited[name] = true
+	values := map[string]bool{}
+	isQuery := func(e ast.Expr) bool {
+		call, ok := e.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		return ok && sel.Sel.Name == "Query" && len(call.Args) == 0
+	}
+	ast.Inspect(fn, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for i, rhs := range n.Rhs {
+				if ident, ok := n.Lhs[i].(*ast.Ident); ok && isQuery(rhs) {
+					values[ident.Name] = true
+				}
+			}
+		case *ast.CallExpr:
+			if ident, ok := n.Fun.(*ast.Ident); ok {
+				queryParams(funcs, ident.Name, params, visited)
+			}
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "Get" || len(n.Args) != 1 {
+				return true
+			}
+			lit, ok := n.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			if ident, ok := sel.X.(*ast.Ident); isQuery(sel.X) || ok && values[ident.Name] {
+				p, _ := strconv.Unquote(lit.Value)
+				params[p] = true
+			}
+		}
+		return true
+	})
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// OpenAPIのパラメータがハンドラーが実際に読むものと一致する
+func TestOpenAPIMatchesHandlers(t *testing.T) {
+	funcs := packageFuncs(t)
+	routes := registeredHandlers(t, funcs)
+
+	documented := map[string]bool{}
+	for _, endpoint := range openAPIEndpoints {
+		t.Run(endpoint.Path, func(t *testing.T) {
+			documented[endpoint.Path] = true
+			handler, ok := routes[endpoint.Path]
+			if !ok {
+				t.Fatalf("%s is documented but not registered", endpoint.Path)
+			}
+			parsed := map[string]bool{}
+			queryParams(funcs, handler, parsed, map[string]bool{})
+			params := map[string]bool{}
+			for _, param := range endpoint.Params {
+				params[param.Name] = true
+			}
+			for _, name := range sortedKeys(parsed) {
+				if !params[name] {
+					t.Errorf("%s reads %q but it is not documented", handler, name)
+				}
+			}
+			for _, name := range sortedKeys(params) {
+				if !parsed[name] {
+					t.Errorf("%q is documented but %s does not read it", name, handler)
+				}
+			}
+		})
+	}
+
+	for path := range routes {
+		if !documented[path] && !undocumentedRoutes[path] {
+			t.Errorf("%s is registered but not documented", path)
+		}
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	spec := buildOpenAPISpec()
+	paths := spec["paths"].(map[string]interface{})
+
+	tests := []struct {
+		path            string
+		method          string
+		wantRequestBody bool
+	}{
+		{path: "/rankings", method: "get"},
+		{path: "/rankings/query", method: "post", wantRequestBody: true},
+		{path: "/icon", method: "get"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			item, ok := paths[RoutePrefix+tt.path].(map[string]interface{})
+			if !ok {
+				t.Fatalf("%s is missing", tt.path)
+			}
+			operation, ok := item[tt.method].(map[string]interface{})
+			if !ok {
+				t.Fatalf("%s has no %s operation: %v", tt.path, tt.method, item)
+			}
+			if _, ok := operation["requestBody"]; ok != tt.wantRequestBody {
+				t.Errorf("requestBody present = %v, want %v", ok, tt.wantRequestBody)
+			}
+		})
+	}
+
+	rec := get(t, OpenAPIHandler, "/openapi.json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
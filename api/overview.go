@@ -0,0 +1,71 @@
+package Handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// /rankings/overviewで返す上位の件数のデフォルト
+const defaultOverviewTop = 10
+
+// ダッシュボード向けに、シーズン情報、上位N件、レートの統計、シーズンの残り時間をまとめたもの
+// 1回の取得から組み立てるため、それぞれのエンドポイントを呼ぶより上流への問い合わせが少ない
+type OverviewResponse struct {
+	SeasonData SeasonData            `json:"season_data"`
+	Top        []RankResponseRawData `json:"top"`
+	Stats      RankingStats          `json:"stats"`
+	// /rankingsと同じ、取得した時点でのシーズンの残り秒数と経過した割合（0〜100）
+	RemainingSeconds int64   `json:"remaining_seconds"`
+	ProgressPercent  float64 `json:"progress_percent"`
+	Stale            bool    `json:"stale,omitempty"`
+}
+
+// 取得したランキングからまとめを組み立てる
+// 統計はtopで切り詰める前の取得できたすべての行から計算する
+func newOverviewResponse(ranking RankingResponse, top int) OverviewResponse {
+	rows := ranking.Top1000
+	if len(rows) > top {
+		rows = rows[:top]
+	}
+	return OverviewResponse{
+		SeasonData:       ranking.SeasonData,
+		Top:              rows,
+		Stats:            computeRankingStats(ranking.Top1000),
+		RemainingSeconds: ranking.RemainingSeconds,
+		ProgressPercent:  ranking.ProgressPercent,
+		Stale:            ranking.Stale,
+	}
+}
+
+// endpoint handler
+func OverviewHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	top := defaultOverviewTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid top parameter: must be between 1 and 1000")
+			return
+		}
+		top = n
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/overview")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(newOverviewResponse(ranking, top)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
@@ -0,0 +1,80 @@
+package Handler
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+// シーズン情報、上位N件、統計、残り時間を1回の取得からまとめて返す
+func TestOverviewHandler(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantTop    int
+	}{
+		{target: "/rankings/overview", wantStatus: http.StatusOK, wantTop: 10},
+		{target: "/rankings/overview?top=3", wantStatus: http.StatusOK, wantTop: 3},
+		{target: "/rankings/overview?top=1000", wantStatus: http.StatusOK, wantTop: 1000},
+		{target: "/rankings/overview?top=0", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/overview?top=1001", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/overview?top=x", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			rec := get(t, OverviewHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var overview OverviewResponse
+			decodeBody(t, rec, &overview)
+
+			if overview.SeasonData.Season != 1 || overview.SeasonData.CID != "10001" {
+				t.Errorf("season_data = %+v, want season 1", overview.SeasonData)
+			}
+			if len(overview.Top) != tt.wantTop {
+				t.Fatalf("top has %d rows, want %d", len(overview.Top), tt.wantTop)
+			}
+			if got := ranksOf(overview.Top); got[0] != 1 || got[len(got)-1] != tt.wantTop {
+				t.Errorf("top ranks = %v, want 1 to %d", got, tt.wantTop)
+			}
+			// 統計は上位N件ではなく取得したすべての行から計算する
+			stats := overview.Stats
+			if stats.Count != 1000 || stats.Max != 2099 || stats.Min != 1100 || math.Abs(stats.Mean-1599.5) > floatTolerance {
+				t.Errorf("stats = %+v, want the stats of all 1000 rows", stats)
+			}
+			if overview.RemainingSeconds <= 0 || overview.ProgressPercent <= 0 || overview.ProgressPercent >= 100 {
+				t.Errorf("remaining_seconds = %d progress_percent = %v, want the season in progress", overview.RemainingSeconds, overview.ProgressPercent)
+			}
+			if n, m := upstream.seasonListCalls.Load(), upstream.rankingCalls.Load(); n != 1 || m != 1 {
+				t.Errorf("got %d season list and %d ranking calls, want 1 each", n, m)
+			}
+		})
+	}
+}
+
+// /rankingsと同じキャッシュを使い、続けて呼んでも上流に問い合わせない
+func TestOverviewUsesRankingCache(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	_, ranking := getRanking(t, "/rankings")
+
+	rec := get(t, OverviewHandler, "/rankings/overview")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var overview OverviewResponse
+	decodeBody(t, rec, &overview)
+	if n, m := upstream.seasonListCalls.Load(), upstream.rankingCalls.Load(); n != 1 || m != 1 {
+		t.Errorf("got %d season list and %d ranking calls, want 1 each", n, m)
+	}
+	if overview.Stats != computeRankingStats(ranking.Top1000) {
+		t.Errorf("stats = %+v, want the stats of the /rankings rows", overview.Stats)
+	}
+	if namesAndRanks(overview.Top) != namesAndRanks(ranking.Top1000[:defaultOverviewTop]) {
+		t.Errorf("top = %s, want the first %d rows of /rankings", namesAndRanks(overview.Top), defaultOverviewTop)
+	}
+}
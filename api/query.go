@@ -0,0 +1,301 @@
+package Handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// POST /rankings/query のリクエストボディ
+type RankingQueryRequest struct {
+	Depth  int                `json:"depth"`
+	Filter RankingQueryFilter `json:"filter"`
+	Sort   []RankingQuerySort `json:"sort"`
+	// 指定した場合はランキングの各行にこの項目だけを含める
+	Fields []string `json:"fields"`
+	Limit  int      `json:"limit"`
+}
+
+// 絞り込み条件
+// ゼロ値の項目は条件に含めない
+type RankingQueryFilter struct {
+	Languages    []string `json:"languages"`
+	RankMin      int      `json:"rank_min"`
+	RankMax      int      `json:"rank_max"`
+	RatingMin    *float64 `json:"rating_min"`
+	RatingMax    *float64 `json:"rating_max"`
+	NameContains string   `json:"name_contains"`
+}
+
+type RankingQuerySort struct {
+	Field string `json:"field"`
+	Order string `json:"order"`
+}
+
+// 項目ごとの入力の誤り
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type FieldErrorsResponse struct {
+	Errors []FieldError `json:"errors"`
+}
+
+// fieldsを指定した場合のレスポンス
+// 各行を指定した項目だけにする以外はRankingResponseと同じ形
+type RankingQueryResponse struct {
+	RankingResponse
+	Top1000 []map[string]interface{} `json:"top_1000"`
+}
+
+// 並べ替えと項目の指定に使える項目
+var rankingQueryFields = map[string]func(RankResponseRawData) interface{}{
+	"rank":         func(d RankResponseRawData) interface{} { return d.Rank },
+	"rating_value": func(d RankResponseRawData) interface{} { return d.RatingValue },
+	"rating_raw":   func(d RankResponseRawData) interface{} { return d.RatingRaw },
+	"icon":         func(d RankResponseRawData) interface{} { return d.Icon },
+	"name":         func(d RankResponseRawData) interface{} { return d.Name },
+	"lng":          func(d RankResponseRawData) interface{} { return d.Lng },
+}
+
+// リクエストボディを検証し、誤りがあれば項目ごとに返す
+func (q *RankingQueryRequest) validate() []FieldError {
+	var errs []FieldError
+	if q.Depth == 0 {
+		q.Depth = 1
+	}
+	if q.Depth < 1 || q.Depth > maxRankingDepth {
+		errs = append(errs, FieldError{Field: "depth", Message: fmt.Sprintf("must be between 1 and %d", maxRankingDepth)})
+	}
+	if q.Filter.RankMin < 0 {
+		errs = append(errs, FieldError{Field: "filter.rank_min", Message: "must not be negative"})
+	}
+	if q.Filter.RankMax < 0 {
+		errs = append(errs, FieldError{Field: "filter.rank_max", Message: "must not be negative"})
+	}
+	if q.Filter.RankMax != 0 && q.Filter.RankMax < q.Filter.RankMin {
+		errs = append(errs, FieldError{Field: "filter.rank_max", Message: "must not be less than rank_min"})
+	}
+	if q.Filter.RatingMin != nil && q.Filter.RatingMax != nil && *q.Filter.RatingMax < *q.Filter.RatingMin {
+		errs = append(errs, FieldError{Field: "filter.rating_max", Message: "must not be less than rating_min"})
+	}
+	for i, s := range q.Sort {
+		if _, ok := rankingQueryFields[s.Field]; !ok {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("sort[%d].field", i), Message: fmt.Sprintf("unknown field %q", s.Field)})
+		}
+		if s.Order != "" && s.Order != "asc" && s.Order != "desc" {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("sort[%d].order", i), Message: `must be "asc" or "desc"`})
+		}
+	}
+	for i, f := range q.Fields {
+		if _, ok := rankingQueryFields[f]; !ok {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("fields[%d]", i), Message: fmt.Sprintf("unknown field %q", f)})
+		}
+	}
+	if q.Limit < 0 {
+		errs = append(errs, FieldError{Field: "limit", Message: "must not be negative"})
+	}
+	return errs
+}
+
+func (f RankingQueryFilter) match(data RankResponseRawData) bool {
+	if len(f.Languages) > 0 {
+		found := false
+		for _, lng := range f.Languages {
+			if data.Lng == lng {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.RankMin != 0 && data.Rank < f.RankMin {
+		return false
+	}
+	if f.RankMax != 0 && data.Rank > f.RankMax {
+		return false
+	}
+	if f.RatingMin != nil && data.RatingValue < *f.RatingMin {
+		return false
+	}
+	if f.RatingMax != nil && data.RatingValue > *f.RatingMax {
+		return false
+	}
+	if f.NameContains != "" && !strings.Contains(strings.ToLower(data.Name), strings.ToLower(f.NameContains)) {
+		return false
+	}
+	return true
+}
+
+// 指定した項目の値を比べる
+func lessRankingField(field string, a, b RankResponseRawData) (less, equal bool) {
+	switch field {
+	case "rank":
+		return a.Rank < b.Rank, a.Rank == b.Rank
+	case "rating_value":
+		return a.RatingValue < b.RatingValue, a.RatingValue == b.RatingValue
+	case "rating_raw":
+		return a.RatingRaw < b.RatingRaw, a.RatingRaw == b.RatingRaw
+	case "icon":
+		return a.Icon < b.Icon, a.Icon == b.Icon
+	case "name":
+		return a.Name < b.Name, a.Name == b.Name
+	default:
+		return a.Lng < b.Lng, a.Lng == b.Lng
+	}
+}
+
+// カンマ区切りの言語コードのリスト
+// 空の要素は無視する
+func parseLanguages(v string) []string {
+	var languages []string
+	for _, lng := range strings.Split(v, ",") {
+		if lng = strings.TrimSpace(lng); lng != "" {
+			languages = append(languages, lng)
+		}
+	}
+	return languages
+}
+
+// 絞り込み、並べ替え、件数の制限を行う
+func applyRankingQuery(rankingData []RankResponseRawData, q RankingQueryRequest) []RankResponseRawData {
+	result := []RankResponseRawData{}
+	for _, data := range rankingData {
+		if q.Filter.match(data) {
+			result = append(result, data)
+		}
+	}
+	if len(q.Sort) > 0 {
+		sort.SliceStable(result, func(i, j int) bool {
+			for _, s := range q.Sort {
+				less, equal := lessRankingField(s.Field, result[i], result[j])
+				if equal {
+					continue
+				}
+				if s.Order == "desc" {
+					return !less
+				}
+				return less
+			}
+			return false
+		})
+	}
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result
+}
+
+// 各行を指定した項目だけにする
+func projectRankingData(rankingData []RankResponseRawData, fields []string) []map[string]interface{} {
+	result := make([]map[string]interface{}, len(rankingData))
+	for i, data := range rankingData {
+		row := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			row[f] = rankingQueryFields[f](data)
+		}
+		result[i] = row
+	}
+	return result
+}
+
+// endpoint handler
+func RankingQueryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var q RankingQueryRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&q); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if errs := q.validate(); len(errs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(FieldErrorsResponse{Errors: errs})
+		return
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: q.Depth, maxAge: cacheTTL("/rankings/query")})
+	if err != nil && !errors.Is(err, ErrInsufficientData) {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+	ranking.Top1000 = applyRankingQuery(ranking.Top1000, q)
+
+	var body interface{} = ranking
+	if len(q.Fields) > 0 {
+		body = RankingQueryResponse{RankingResponse: ranking, Top1000: projectRankingData(ranking.Top1000, q.Fields)}
+	}
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// トレーナー名での検索結果
+// 一致するトレーナーがいなければResultsは空の配列
+type RankingSearchResponse struct {
+	SeasonData SeasonData            `json:"season_data"`
+	Results    []RankResponseRawData `json:"results"`
+}
+
+// 名前がnameを含む（exactなら完全に一致する）行を順位順のまま返す
+// 大文字と小文字は区別しない
+func searchRankingByName(rankingData []RankResponseRawData, name string, exact bool) []RankResponseRawData {
+	if !exact {
+		return applyRankingQuery(rankingData, RankingQueryRequest{Filter: RankingQueryFilter{NameContains: name}})
+	}
+	result := []RankResponseRawData{}
+	for _, data := range rankingData {
+		if strings.EqualFold(data.Name, name) {
+			result = append(result, data)
+		}
+	}
+	return result
+}
+
+// endpoint handler
+func RankingSearchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	exact := r.URL.Query().Get("exact") == "true"
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/search")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	response := RankingSearchResponse{
+		SeasonData: ranking.SeasonData,
+		Results:    searchRankingByName(ranking.Top1000, name, exact),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
@@ -0,0 +1,270 @@
+package Handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ハンドラーにJSONの本文でPOSTリクエストを送る
+func post(t *testing.T, handler http.HandlerFunc, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	handler(rec, req)
+	return rec
+}
+
+func TestRankingQueryHandler(t *testing.T) {
+	// 偶数の順位はlng 1、奇数の順位はlng 2
+	newFakeUpstream(t)
+
+	tests := []struct {
+		name      string
+		body      string
+		wantRanks []int
+	}{
+		{
+			name:      "filter, sort and limit",
+			body:      `{"filter":{"languages":["1"],"rank_min":1,"rank_max":100,"rating_min":2010},"sort":[{"field":"rating_value","order":"asc"}],"limit":3}`,
+			wantRanks: []int{90, 88, 86},
+		},
+		{
+			name:      "multiple sorts",
+			body:      `{"sort":[{"field":"lng"},{"field":"rank","order":"desc"}],"limit":2}`,
+			wantRanks: []int{1000, 998},
+		},
+		{
+			name: "name",
+			body: `{"filter":{"name_contains":"TRAINER10","rating_max":2000}}`,
+			// trainer10は2090で上限を超える
+			wantRanks: []int{100, 101, 102, 103, 104, 105, 106, 107, 108, 109, 1000},
+		},
+		{name: "empty query", body: `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(t, RankingQueryHandler, "/rankings/query", tt.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var ranking RankingResponse
+			decodeBody(t, rec, &ranking)
+			if ranking.SeasonData.Season != 1 {
+				t.Errorf("season = %d, want 1", ranking.SeasonData.Season)
+			}
+			if tt.wantRanks == nil {
+				if len(ranking.Top1000) != 1000 {
+					t.Errorf("rows = %d, want 1000", len(ranking.Top1000))
+				}
+				return
+			}
+			if ranks := ranksOf(ranking.Top1000); !equalInts(ranks, tt.wantRanks) {
+				t.Errorf("ranks = %v, want %v", ranks, tt.wantRanks)
+			}
+		})
+	}
+}
+
+// fieldsを指定すると各行をその項目だけにする
+func TestRankingQueryFields(t *testing.T) {
+	newFakeUpstream(t)
+	rec := post(t, RankingQueryHandler, "/rankings/query", `{"fields":["rank","name"],"limit":2}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var response struct {
+		SeasonData SeasonData               `json:"season_data"`
+		Top1000    []map[string]interface{} `json:"top_1000"`
+	}
+	decodeBody(t, rec, &response)
+	if len(response.Top1000) != 2 {
+		t.Fatalf("rows = %v, want 2", response.Top1000)
+	}
+	for i, row := range response.Top1000 {
+		if len(row) != 2 || row["rank"] != float64(i+1) || row["name"] != fmt.Sprintf("trainer%d", i+1) {
+			t.Errorf("rows[%d] = %v, want only rank %d and name trainer%d", i, row, i+1, i+1)
+		}
+	}
+	if response.SeasonData.Season != 1 {
+		t.Errorf("season = %d, want 1", response.SeasonData.Season)
+	}
+}
+
+func TestRankingQueryValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{
+			name:       "field errors",
+			body:       `{"depth":99,"filter":{"rank_min":10,"rank_max":5,"rating_min":2000,"rating_max":1900},"sort":[{"field":"level","order":"up"}],"fields":["rank","age"],"limit":-1}`,
+			wantStatus: http.StatusBadRequest,
+			wantFields: []string{"depth", "filter.rank_max", "filter.rating_max", "sort[0].field", "sort[0].order", "fields[1]", "limit"},
+		},
+		{name: "negative rank", body: `{"filter":{"rank_min":-1}}`, wantStatus: http.StatusBadRequest, wantFields: []string{"filter.rank_min"}},
+		{name: "unknown field", body: `{"filters":{}}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed", body: `{"limit":`, wantStatus: http.StatusBadRequest},
+		{name: "wrong type", body: `{"limit":"10"}`, wantStatus: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			var rec *httptest.ResponseRecorder
+			if tt.method == http.MethodGet {
+				rec = get(t, RankingQueryHandler, "/rankings/query")
+			} else {
+				rec = post(t, RankingQueryHandler, "/rankings/query", tt.body)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if n := upstream.seasonListCalls.Load(); n != 0 {
+				t.Errorf("season list calls = %d, want 0", n)
+			}
+			if tt.wantFields == nil {
+				var body ErrorResponse
+				decodeBody(t, rec, &body)
+				if body.Error == "" {
+					t.Errorf("body = %s, want an error message", rec.Body)
+				}
+				return
+			}
+			var body FieldErrorsResponse
+			decodeBody(t, rec, &body)
+			fields := make([]string, len(body.Errors))
+			for i, e := range body.Errors {
+				fields[i] = e.Field
+				if e.Message == "" {
+					t.Errorf("%s has no message", e.Field)
+				}
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("error fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestRankingSearchHandler(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantRanks  []int
+	}{
+		{target: "/rankings/search?name=trainer99", wantStatus: http.StatusOK, wantRanks: []int{99, 990, 991, 992, 993, 994, 995, 996, 997, 998, 999}},
+		{target: "/rankings/search?name=TRAINER99&exact=true", wantStatus: http.StatusOK, wantRanks: []int{99}},
+		{target: "/rankings/search?name=trainer1000", wantStatus: http.StatusOK, wantRanks: []int{1000}},
+		{target: "/rankings/search?name=trainer12&exact=true", wantStatus: http.StatusOK, wantRanks: []int{12}},
+		{target: "/rankings/search?name=nobody", wantStatus: http.StatusOK, wantRanks: []int{}},
+		{target: "/rankings/search?name=trainer&exact=true", wantStatus: http.StatusOK, wantRanks: []int{}},
+		{target: "/rankings/search", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			newFakeUpstream(t)
+			rec := get(t, RankingSearchHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response RankingSearchResponse
+			decodeBody(t, rec, &response)
+			if response.Results == nil {
+				t.Fatal("results is null, want an array")
+			}
+			if got := ranksOf(response.Results); !equalInts(got, tt.wantRanks) {
+				t.Errorf("ranks = %v, want %v", got, tt.wantRanks)
+			}
+			if response.SeasonData.Season != 1 {
+				t.Errorf("season = %d, want 1", response.SeasonData.Season)
+			}
+		})
+	}
+}
+
+// lngで指定したいずれかの言語の行だけを、元の順位のまま返す
+func TestRankingLngFilter(t *testing.T) {
+	tests := []struct {
+		target    string
+		wantRanks []int
+	}{
+		{target: "/rankings", wantRanks: []int{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{target: "/rankings?lng=1", wantRanks: []int{1, 4, 7}},
+		{target: "/rankings?lng=1,8", wantRanks: []int{1, 3, 4, 6, 7, 9}},
+		{target: "/rankings?lng=2,%208", wantRanks: []int{2, 3, 5, 6, 8, 9}},
+		{target: "/rankings?lng=1,1", wantRanks: []int{1, 4, 7}},
+		{target: "/rankings?lng=9", wantRanks: []int{}},
+		{target: "/rankings?lng=,", wantRanks: []int{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			rows := fixtureRows(1, 9)
+			for i := range rows {
+				rows[i].Lng = []string{"1", "2", "8"}[i%3]
+			}
+			upstream.setPage(upstream.seasons["1"]["10001"], 1, rows)
+
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if ranking.Top1000 == nil {
+				t.Fatal("top_1000 is null, want an array")
+			}
+			if got := ranksOf(ranking.Top1000); !equalInts(got, tt.wantRanks) {
+				t.Errorf("ranks = %v, want %v", got, tt.wantRanks)
+			}
+		})
+	}
+}
+
+// fixtureRowsのレートは2100から順位を引いた値
+func TestRankingMinRating(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantRanks  []int
+	}{
+		{target: "/rankings?min_rating=2095", wantStatus: http.StatusOK, wantRanks: []int{1, 2, 3, 4, 5}},
+		{target: "/rankings?min_rating=2094.5", wantStatus: http.StatusOK, wantRanks: []int{1, 2, 3, 4, 5}},
+		{target: "/rankings?min_rating=2095&lng=2", wantStatus: http.StatusOK, wantRanks: []int{1, 3, 5}},
+		{target: "/rankings?min_rating=2095&from=3&to=10", wantStatus: http.StatusOK, wantRanks: []int{3, 4, 5}},
+		{target: "/rankings?min_rating=2095&from=2&to=4&lng=1", wantStatus: http.StatusOK, wantRanks: []int{2, 4}},
+		{target: "/rankings?min_rating=3000", wantStatus: http.StatusOK, wantRanks: []int{}},
+		{target: "/rankings?min_rating=-1", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?min_rating=abc", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?min_rating=NaN", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?min_rating=Inf", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			newFakeUpstream(t)
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := ranksOf(ranking.Top1000); !equalInts(got, tt.wantRanks) {
+				t.Errorf("ranks = %v, want %v", got, tt.wantRanks)
+			}
+			for _, row := range ranking.Top1000 {
+				if row.RatingValue < 2095 {
+					t.Errorf("rank %d has rating %v below min_rating", row.Rank, row.RatingValue)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package Handler
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// クライアントのIPごとに許可する1秒あたりのリクエスト数で、0以下なら制限しない
+// /rankingsなどは上流へのリクエストを伴うため、1つのクライアントのせいで上流から制限されないようにする
+var RateLimit = envFloat("RATE_LIMIT_RPS", 0)
+
+// クライアントのIPごとに続けて送れるリクエスト数
+var RateLimitBurst = envInt("RATE_LIMIT_BURST", 10)
+
+// この期間リクエストが無かったクライアントの制限の状態は破棄する
+var RateLimitIdleTimeout = envDuration("RATE_LIMIT_IDLE_TIMEOUT", 10*time.Minute)
+
+// trueならX-Forwarded-Forの最初のアドレスをクライアントのIPとする
+// リバースプロキシの後ろで動かす場合に使い、直接公開する場合は偽装できるため使わない
+var RateLimitTrustForwarded = os.Getenv("RATE_LIMIT_TRUST_FORWARDED") == "true"
+
+// クライアントごとの制限の状態
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// クライアントのIPごとのトークンバケット
+type ipRateLimiter struct {
+	mu          sync.Mutex
+	limit       rate.Limit
+	burst       int
+	idleTimeout time.Duration
+	clients     map[string]*clientLimiter
+	lastSweep   time.Time
+}
+
+func newIPRateLimiter(rps float64, burst int, idleTimeout time.Duration) *ipRateLimiter {
+	return &ipRateLimiter{
+		limit:       rate.Limit(rps),
+		burst:       burst,
+		idleTimeout: idleTimeout,
+		clients:     map[string]*clientLimiter{},
+	}
+}
+
+// リクエストを受け付けられるか
+// 受け付けられない場合は次に受け付けられるようになるまでの時間を返す
+func (l *ipRateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 破棄するクライアントを探すのはidleTimeoutごとに1回にする
+	if l.idleTimeout > 0 && now.Sub(l.lastSweep) >= l.idleTimeout {
+		for key, client := range l.clients {
+			if now.Sub(client.lastSeen) >= l.idleTimeout {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+	reservation := client.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, 0
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// リクエストを送ったクライアントのIP
+func clientIP(r *http.Request) string {
+	if RateLimitTrustForwarded {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// クライアントのIPごとにリクエスト数を制限するミドルウェア
+// 制限を超えたリクエストは429とRetry-Afterを返す
+func withRateLimit(next http.Handler, limiter *ipRateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := limiter.allow(clientIP(r), time.Now())
+		if !ok {
+			// Retry-Afterは秒単位のため切り上げる
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
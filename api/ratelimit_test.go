@@ -0,0 +1,104 @@
+package Handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 続けて送れる数を超えたリクエストは429とRetry-Afterを返し、他のクライアントは制限しない
+func TestRateLimit(t *testing.T) {
+	newFakeUpstream(t)
+	handler := withRateLimit(http.HandlerFunc(RankingHandler), newIPRateLimiter(1, 3, time.Minute))
+
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/rankings", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	tests := []struct {
+		remoteAddr     string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{remoteAddr: "192.0.2.1:1000", wantStatus: http.StatusOK},
+		{remoteAddr: "192.0.2.1:1001", wantStatus: http.StatusOK},
+		{remoteAddr: "192.0.2.1:1002", wantStatus: http.StatusOK},
+		{remoteAddr: "192.0.2.1:1003", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "1"},
+		{remoteAddr: "192.0.2.1:1004", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "1"},
+		{remoteAddr: "192.0.2.2:1000", wantStatus: http.StatusOK},
+		{remoteAddr: "[2001:db8::1]:1000", wantStatus: http.StatusOK},
+	}
+	for i, tt := range tests {
+		rec := send(tt.remoteAddr)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("request %d from %s: status = %d, want %d", i+1, tt.remoteAddr, rec.Code, tt.wantStatus)
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+			t.Errorf("request %d from %s: Retry-After = %q, want %q", i+1, tt.remoteAddr, got, tt.wantRetryAfter)
+		}
+		if tt.wantStatus == http.StatusTooManyRequests {
+			var body ErrorResponse
+			decodeBody(t, rec, &body)
+			if body.Status != http.StatusTooManyRequests {
+				t.Errorf("body = %+v, want status 429", body)
+			}
+		}
+	}
+}
+
+// idleTimeoutの間リクエストが無かったクライアントの状態は破棄する
+func TestIPRateLimiterEviction(t *testing.T) {
+	limiter := newIPRateLimiter(1, 1, time.Minute)
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	limiter.allow("192.0.2.1", base)
+	limiter.allow("192.0.2.2", base.Add(30*time.Second))
+	if ok, _ := limiter.allow("192.0.2.1", base.Add(500*time.Millisecond)); ok {
+		t.Error("second request within half a second was allowed")
+	}
+	limiter.allow("192.0.2.3", base.Add(61*time.Second))
+	if _, ok := limiter.clients["192.0.2.1"]; ok {
+		t.Error("idle client was not evicted")
+	}
+	if len(limiter.clients) != 2 {
+		t.Errorf("got %d clients, want 2", len(limiter.clients))
+	}
+	// 破棄されたクライアントは新しいバケットから始める
+	if ok, _ := limiter.allow("192.0.2.1", base.Add(62*time.Second)); !ok {
+		t.Error("request from an evicted client was limited")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	saved := RateLimitTrustForwarded
+	t.Cleanup(func() { RateLimitTrustForwarded = saved })
+
+	tests := []struct {
+		name      string
+		trust     bool
+		forwarded string
+		want      string
+	}{
+		{name: "remote addr", want: "192.0.2.1"},
+		{name: "forwarded ignored", forwarded: "198.51.100.1", want: "192.0.2.1"},
+		{name: "forwarded", trust: true, forwarded: "198.51.100.1, 192.0.2.1", want: "198.51.100.1"},
+		{name: "trusted without header", trust: true, want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RateLimitTrustForwarded = tt.trust
+			req := httptest.NewRequest(http.MethodGet, "/rankings", nil)
+			req.RemoteAddr = "192.0.2.1:1000"
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := clientIP(req); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
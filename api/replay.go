@@ -0,0 +1,155 @@
+package Handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 上流へのリクエストを送るもの
+// *http.Clientのほか、記録や再生をするDoerに差し替えられる
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// 上流へのリクエスト1件あたりのタイムアウト
+var DefaultTimeout = envDuration("UPSTREAM_TIMEOUT", 10*time.Second)
+
+// NewClientで作るクライアントが上流へのリクエストに使うDoer
+// 応答が止まった上流を待ち続けないようにDefaultTimeoutを設定する
+// 起動後にハンドラーのものを差し替える場合はDefaultClient.HTTPClientを差し替える
+var HTTPClient Doer = &http.Client{Timeout: DefaultTimeout}
+
+// 記録したリクエストとレスポンスの組
+// 本文はUTF-8として正しくないバイト列もそのまま再生できるように[]byte（JSONではbase64）で保持する
+type recordedExchange struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody []byte      `json:"request_body,omitempty"`
+	StatusCode  int         `json:"status_code"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// リクエストの本文を読み、送れるように読み直せる本文に戻す
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %v", err)
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// リクエストに対応するフィクスチャのファイル名
+// 同じURLでもソフトの違うシーズンリストのように本文が違えば別のレスポンスになるため、本文も含める
+func fixturePath(dir string, req *http.Request, body []byte) string {
+	bodySum := sha256.Sum256(body)
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String() + " " + hex.EncodeToString(bodySum[:])))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// 実際のリクエスト結果をフィクスチャとして保存するDoer
+type RecordingDoer struct {
+	Dir  string
+	Next Doer
+}
+
+func NewRecordingDoer(dir string, next Doer) *RecordingDoer {
+	return &RecordingDoer{Dir: dir, Next: next}
+}
+
+func (d *RecordingDoer) Do(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.Next.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	exchange := recordedExchange{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		RequestBody: requestBody,
+		StatusCode:  resp.StatusCode,
+		Header:      resp.Header,
+		Body:        body,
+	}
+	data, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode fixture: %v", err)
+	}
+	if err := os.MkdirAll(d.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create fixture directory: %v", err)
+	}
+	if err := os.WriteFile(fixturePath(d.Dir, req, requestBody), data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write fixture: %v", err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// 保存したフィクスチャからメソッド、URL、本文が一致するレスポンスを返すDoer
+type ReplayingDoer struct {
+	Dir string
+}
+
+func NewReplayingDoer(dir string) *ReplayingDoer {
+	return &ReplayingDoer{Dir: dir}
+}
+
+func (d *ReplayingDoer) Do(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(fixturePath(d.Dir, req, requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("no recorded response for %s %s: %v", req.Method, req.URL, err)
+	}
+
+	var exchange recordedExchange
+	if err := json.Unmarshal(data, &exchange); err != nil {
+		return nil, fmt.Errorf("failed to decode fixture: %v", err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.Header,
+		Body:          io.NopCloser(bytes.NewReader(exchange.Body)),
+		ContentLength: int64(len(exchange.Body)),
+		Request:       req,
+	}, nil
+}
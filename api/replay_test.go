@@ -0,0 +1,105 @@
+package Handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// 上流とのやり取りを記録し、上流を止めた後も記録から同じ結果を返す
+func TestRecordAndReplay(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	recording := &Client{HTTPClient: NewRecordingDoer(dir, upstream.Client()), APIBaseURL: upstream.URL, ResourceBaseURL: upstream.URL, Soft: defaultSoft}
+	season, err := recording.LatestSeason(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := recording.FetchTop1000(ctx, season.CID, season.Rst, "1700000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("fixtures = %d, want 2 (season list and ranking file)", len(entries))
+	}
+
+	upstream.Close()
+	replaying := &Client{HTTPClient: NewReplayingDoer(dir), APIBaseURL: upstream.URL, ResourceBaseURL: upstream.URL, Soft: defaultSoft}
+	replayedSeason, err := replaying.LatestSeason(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayedSeason.CID != season.CID || replayedSeason.Season != season.Season {
+		t.Errorf("replayed season = %s/%d, want %s/%d", replayedSeason.CID, replayedSeason.Season, season.CID, season.Season)
+	}
+	replayed, err := replaying.FetchTop1000(ctx, season.CID, season.Rst, "1700000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != len(recorded) || replayed[0] != recorded[0] || replayed[len(replayed)-1] != recorded[len(recorded)-1] {
+		t.Errorf("replayed %d rows, want the %d recorded rows", len(replayed), len(recorded))
+	}
+
+	// 記録していないリクエスト
+	if _, err := replaying.FetchTop1000(ctx, "99999", 0, "1700000000"); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("err = %v, want no recorded response", err)
+	}
+	// 本文が違えば別のフィクスチャになる
+	replaying.Soft = "Vi"
+	if _, err := replaying.FetchSeasons(ctx); err == nil {
+		t.Error("replayed a season list for another soft")
+	}
+}
+
+// UTF-8として正しくないバイト列もそのまま再生する
+func TestReplayKeepsBodyBytes(t *testing.T) {
+	body := "\x82\xb1\x82\xf1\xff"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "HIT")
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+	dir := t.TempDir()
+
+	tests := []struct {
+		name string
+		doer Doer
+	}{
+		{name: "record", doer: NewRecordingDoer(dir, upstream.Client())},
+		{name: "replay", doer: NewReplayingDoer(dir)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, upstream.URL+"/list", strings.NewReader(`{"soft":"Sc"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tt.doer.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("body = %q, want %q", got, body)
+			}
+			if resp.StatusCode != http.StatusTeapot || resp.Header.Get("X-Cache") != "HIT" {
+				t.Errorf("got status %d X-Cache %q, want %d HIT", resp.StatusCode, resp.Header.Get("X-Cache"), http.StatusTeapot)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package Handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 上流へのリクエスト1件あたりの最大試行回数
+var MaxAttempts = envInt("RETRY_MAX_ATTEMPTS", 3)
+
+// 1回のAPIリクエストの中で上流へ送るリクエストの総数の上限
+// シーズンリストとランキングデータの取得がそれぞれリトライしても上流への負荷と待ち時間が膨らまないようにする
+var RetryBudget = envInt("RETRY_BUDGET", 5)
+
+// リトライの初回の待ち時間
+var RetryBaseDelay = 200 * time.Millisecond
+
+// リトライの待ち時間の上限
+var RetryMaxDelay = envDuration("RETRY_MAX_DELAY", 5*time.Second)
+
+// リトライの待ち時間の揺らぎ方
+const (
+	// 0から待ち時間までの一様乱数
+	RetryJitterFull = "full"
+	// 待ち時間の半分から待ち時間までの一様乱数
+	RetryJitterEqual = "equal"
+	// 揺らがせない
+	RetryJitterNone = "none"
+)
+
+// 同時に失敗したリクエストが一斉にリトライしないように待ち時間を揺らがせる
+var RetryJitter = envString("RETRY_JITTER", RetryJitterFull)
+
+// 1回のAPIリクエストで使える試行回数を使い切った
+var errRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// 1回のAPIリクエストで上流へ送れるリクエストの残り回数
+// nilの場合は制限しない
+type retryBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+func newRetryBudget(n int) *retryBudget {
+	return &retryBudget{remaining: n}
+}
+
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// attempt回目の失敗の後の待ち時間
+// 指数的に伸ばした待ち時間をRetryMaxDelayで抑えてからRetryJitterに従って揺らがせる
+func retryBackoff(attempt int) time.Duration {
+	delay := RetryBaseDelay << attempt
+	// シフトで溢れた場合も上限にする
+	if delay <= 0 || delay > RetryMaxDelay || delay>>attempt != RetryBaseDelay {
+		delay = RetryMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	switch RetryJitter {
+	case RetryJitterNone:
+		return delay
+	case RetryJitterEqual:
+		half := delay / 2
+		return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+	default:
+		return time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+}
+
+// 通信エラーと5xxの場合にfnをリトライしながら最大maxAttempts回呼ぶ
+// 4xxはリトライしない。最後の試行が5xxの場合はそのレスポンスを返す
+// ctxがキャンセルされた場合はリトライせず、ctx.Err()を包んだエラーを返す
+func doWithRetry(ctx context.Context, maxAttempts int, fn func() (*http.Response, error)) (*http.Response, error) {
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		resp, err := fn()
+		if err != nil && ctx.Err() != nil {
+			return nil, fmt.Errorf("request cancelled: %w", ctx.Err())
+		}
+		if errors.Is(err, errRetryBudgetExhausted) {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w after %d attempts: %v", errRetryBudgetExhausted, attempt-1, lastErr)
+			}
+			return nil, err
+		}
+		retryable := err != nil || resp.StatusCode >= 500
+		if !retryable || attempt >= maxAttempts {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("status code: %d", resp.StatusCode)
+		} else {
+			lastErr = err
+		}
+
+		if err := waitRetry(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt回目の失敗の後の待ち時間だけ待つ
+// 待っている間にctxがキャンセルされた場合はctx.Err()を包んだエラーを返す
+func waitRetry(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(retryBackoff(attempt - 1))
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return fmt.Errorf("request cancelled: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// doWithRetryでclientを使って上流にreqを送る関数
+// 試行ごとにbudgetを1回分使い、2回目以降はリクエストボディを作り直す
+func sendRequest(client Doer, req *http.Request, budget *retryBudget) func() (*http.Response, error) {
+	first := true
+	return func() (*http.Response, error) {
+		if !budget.take() {
+			return nil, errRetryBudgetExhausted
+		}
+		if !first && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to reset request body: %v", err)
+			}
+			req.Body = body
+		}
+		first = false
+		return client.Do(req)
+	}
+}
@@ -0,0 +1,255 @@
+package Handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// テストではリトライを待たない
+func withoutRetryDelay(t *testing.T) {
+	t.Helper()
+	base, max := RetryBaseDelay, RetryMaxDelay
+	t.Cleanup(func() { RetryBaseDelay, RetryMaxDelay = base, max })
+	RetryBaseDelay, RetryMaxDelay = 0, 0
+}
+
+// シーズンリストとランキングファイルの両方が失敗を繰り返しても、上流へのリクエストはRetryBudgetまで
+func TestRetryBudget(t *testing.T) {
+	withoutRetryDelay(t)
+
+	tests := []struct {
+		name               string
+		seasonListFailures int32
+		rankingFails       bool
+		wantStatus         int
+		wantExhausted      bool
+		wantSeasonCalls    int32
+		wantRankingCalls   int32
+	}{
+		{name: "no failures", wantStatus: http.StatusOK, wantSeasonCalls: 1, wantRankingCalls: 1},
+		{name: "season list recovers", seasonListFailures: 2, wantStatus: http.StatusOK, wantSeasonCalls: 3, wantRankingCalls: 1},
+		// シーズンリストの3回とランキングファイルの2回で予算の5回を使い切る
+		{name: "both flap", seasonListFailures: 2, rankingFails: true, wantStatus: http.StatusServiceUnavailable, wantExhausted: true, wantSeasonCalls: 3, wantRankingCalls: 2},
+		// 予算が残っていてもMaxAttemptsで諦める
+		{name: "ranking fails", rankingFails: true, wantStatus: http.StatusBadGateway, wantSeasonCalls: 1, wantRankingCalls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.seasonListFailures = tt.seasonListFailures
+			if tt.rankingFails {
+				upstream.failPage(upstream.seasons["1"]["10001"], 1, http.StatusInternalServerError)
+			}
+
+			rec := get(t, RankingHandler, "/rankings")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if exhausted := strings.Contains(rec.Body.String(), errRetryBudgetExhausted.Error()); exhausted != tt.wantExhausted {
+				t.Errorf("body = %s, want budget exhausted %v", rec.Body, tt.wantExhausted)
+			}
+			seasonCalls, rankingCalls := upstream.seasonListCalls.Load(), upstream.rankingCalls.Load()
+			if seasonCalls != tt.wantSeasonCalls || rankingCalls != tt.wantRankingCalls {
+				t.Errorf("got %d season list and %d ranking calls, want %d and %d", seasonCalls, rankingCalls, tt.wantSeasonCalls, tt.wantRankingCalls)
+			}
+			if total := seasonCalls + rankingCalls; int(total) > RetryBudget {
+				t.Errorf("upstream calls = %d, want at most %d", total, RetryBudget)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetTake(t *testing.T) {
+	budget := newRetryBudget(2)
+	for i, want := range []bool{true, true, false, false} {
+		if got := budget.take(); got != want {
+			t.Errorf("take %d = %v, want %v", i, got, want)
+		}
+	}
+	// nilは制限しない
+	var unlimited *retryBudget
+	for i := 0; i < 10; i++ {
+		if !unlimited.take() {
+			t.Fatal("nil budget refused")
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	savedBase, savedMax, savedJitter := RetryBaseDelay, RetryMaxDelay, RetryJitter
+	t.Cleanup(func() { RetryBaseDelay, RetryMaxDelay, RetryJitter = savedBase, savedMax, savedJitter })
+	RetryBaseDelay, RetryMaxDelay = 100*time.Millisecond, time.Second
+
+	tests := []struct {
+		jitter   string
+		minRatio float64
+		varies   bool
+	}{
+		{jitter: RetryJitterFull, minRatio: 0, varies: true},
+		{jitter: RetryJitterEqual, minRatio: 0.5, varies: true},
+		{jitter: RetryJitterNone, minRatio: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.jitter, func(t *testing.T) {
+			RetryJitter = tt.jitter
+			// 100はシフトで溢れる
+			for _, attempt := range []int{1, 2, 3, 4, 10, 100} {
+				capped := RetryBaseDelay << attempt
+				if attempt >= 4 {
+					capped = RetryMaxDelay
+				}
+				minDelay := time.Duration(float64(capped) * tt.minRatio)
+				seen := map[time.Duration]bool{}
+				for i := 0; i < 100; i++ {
+					delay := retryBackoff(attempt)
+					if delay < minDelay || delay > capped {
+						t.Fatalf("retryBackoff(%d) = %v, want between %v and %v", attempt, delay, minDelay, capped)
+					}
+					seen[delay] = true
+				}
+				if varies := len(seen) > 1; varies != tt.varies {
+					t.Errorf("retryBackoff(%d) returned %d distinct delays, want varying = %v", attempt, len(seen), tt.varies)
+				}
+			}
+		})
+	}
+
+	RetryBaseDelay, RetryMaxDelay = 0, 0
+	if delay := retryBackoff(1); delay != 0 {
+		t.Errorf("retryBackoff without delays = %v, want 0", delay)
+	}
+}
+
+// 順に返すレスポンスのステータスコードかエラー
+type fakeAttempt struct {
+	status int
+	err    error
+}
+
+func TestDoWithRetry(t *testing.T) {
+	withoutRetryDelay(t)
+	errNetwork := errors.New("connection reset")
+
+	tests := []struct {
+		name         string
+		attempts     []fakeAttempt
+		wantStatus   int
+		wantErr      error
+		wantAttempts int
+	}{
+		{name: "two failures then success", attempts: []fakeAttempt{{status: 503}, {err: errNetwork}, {status: 200}}, wantStatus: 200, wantAttempts: 3},
+		{name: "success", attempts: []fakeAttempt{{status: 200}}, wantStatus: 200, wantAttempts: 1},
+		{name: "client error is not retried", attempts: []fakeAttempt{{status: 404}, {status: 200}}, wantStatus: 404, wantAttempts: 1},
+		{name: "last 5xx is returned", attempts: []fakeAttempt{{status: 500}, {status: 502}, {status: 503}}, wantStatus: 503, wantAttempts: 3},
+		{name: "last network error is returned", attempts: []fakeAttempt{{err: errNetwork}, {err: errNetwork}, {err: errNetwork}}, wantErr: errNetwork, wantAttempts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := 0
+			resp, err := doWithRetry(context.Background(), 3, func() (*http.Response, error) {
+				attempt := tt.attempts[n]
+				n++
+				if attempt.err != nil {
+					return nil, attempt.err
+				}
+				return &http.Response{StatusCode: attempt.status, Body: io.NopCloser(strings.NewReader(""))}, nil
+			})
+			if n != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", n, tt.wantAttempts)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// リトライを待っている間にキャンセルされたら次の試行をしない
+func TestDoWithRetryCancelWhileWaiting(t *testing.T) {
+	savedBase, savedMax := RetryBaseDelay, RetryMaxDelay
+	t.Cleanup(func() { RetryBaseDelay, RetryMaxDelay = savedBase, savedMax })
+	RetryBaseDelay, RetryMaxDelay = time.Minute, time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := 0
+	_, err := doWithRetry(ctx, 3, func() (*http.Response, error) {
+		n++
+		// 1回目の失敗の後の待ち時間の間にキャンセルする
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return &http.Response{StatusCode: 503, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if n != 1 {
+		t.Errorf("attempts = %d, want 1", n)
+	}
+}
+
+// 1ページ目の件数がRankCntに対して少なすぎる場合は取得し直す
+func TestShortRankingRetry(t *testing.T) {
+	withoutRetryDelay(t)
+	saved, savedBudget := ShortRankingRetryFraction, RetryBudget
+	t.Cleanup(func() { ShortRankingRetryFraction, RetryBudget = saved, savedBudget })
+
+	tests := []struct {
+		name             string
+		fraction         float64
+		shortRankings    int32
+		rankCnt          int
+		budget           int
+		wantRows         int
+		wantRankingCalls int32
+	}{
+		{name: "disabled", shortRankings: 1, wantRows: 10, wantRankingCalls: 1},
+		{name: "retry succeeds", fraction: 0.9, shortRankings: 1, wantRows: 1000, wantRankingCalls: 2},
+		{name: "full first fetch", fraction: 0.9, wantRows: 1000, wantRankingCalls: 1},
+		// MaxAttemptsまで取得し直しても少ない場合は最後に取得できたものを返す
+		{name: "always short", fraction: 0.9, shortRankings: 10, wantRows: 10, wantRankingCalls: 3},
+		// RankCntが少なければ件数が少なくても取得し直さない
+		{name: "few participants", fraction: 0.9, shortRankings: 1, rankCnt: 10, wantRows: 10, wantRankingCalls: 1},
+		// シーズンリストとランキングファイルの1回ずつで予算を使い切る
+		{name: "budget exhausted", fraction: 0.9, shortRankings: 1, budget: 2, wantRows: 10, wantRankingCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.shortRankings = tt.shortRankings
+			if tt.rankCnt != 0 {
+				seasonData := upstream.seasons["1"]["10001"]
+				seasonData.RankCnt = tt.rankCnt
+				upstream.addSeason("1", seasonData)
+			}
+			ShortRankingRetryFraction = tt.fraction
+			RetryBudget = savedBudget
+			if tt.budget != 0 {
+				RetryBudget = tt.budget
+			}
+
+			rec, ranking := getRanking(t, "/rankings")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if len(ranking.Top1000) != tt.wantRows || ranking.ActualCount != tt.wantRows {
+				t.Errorf("got %d rows with actual count %d, want %d", len(ranking.Top1000), ranking.ActualCount, tt.wantRows)
+			}
+			if n := upstream.rankingCalls.Load(); n != tt.wantRankingCalls {
+				t.Errorf("ranking calls = %d, want %d", n, tt.wantRankingCalls)
+			}
+		})
+	}
+}
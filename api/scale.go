@@ -0,0 +1,68 @@
+package Handler
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ソフトの指定がない場合に取得するソフト
+const defaultSoft = "Sc"
+
+// 指定できるソフトとランキングファイルのパス（/ranking/{path}/{cId}/...）
+// スカーレットとバイオレットは同じランキングのため同じパスになる
+var softRankingPaths = map[string]string{
+	"Sc": "scvi",
+	"Vi": "scvi",
+}
+
+// 指定できるソフトか
+func knownSoft(soft string) bool {
+	_, ok := softRankingPaths[soft]
+	return ok
+}
+
+// 空ならdefaultSoft
+func softOrDefault(soft string) string {
+	if soft == "" {
+		return defaultSoft
+	}
+	return soft
+}
+
+// 上流のレートを表示用のレートに変換するときに割る値のデフォルト
+const defaultRatingScale = 1000
+
+// ソフトごとのレートの倍率
+// RATING_SCALES="Sc=1000,Sw=1" のように指定し、指定がないソフトはdefaultRatingScaleを使う
+var RatingScales = parseRatingScales(os.Getenv("RATING_SCALES"))
+
+func parseRatingScales(v string) map[string]float64 {
+	scales := map[string]float64{defaultSoft: defaultRatingScale}
+	if v == "" {
+		return scales
+	}
+	for _, pair := range strings.Split(v, ",") {
+		soft, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Printf("invalid RATING_SCALES entry %q", pair)
+			continue
+		}
+		scale, err := strconv.ParseFloat(value, 64)
+		if err != nil || scale <= 0 {
+			log.Printf("invalid RATING_SCALES entry %q: scale must be a positive number", pair)
+			continue
+		}
+		scales[soft] = scale
+	}
+	return scales
+}
+
+// ソフトのレートの倍率
+func ratingScale(soft string) float64 {
+	if scale, ok := RatingScales[soft]; ok {
+		return scale
+	}
+	return defaultRatingScale
+}
@@ -0,0 +1,116 @@
+package Handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseRatingScales(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]float64
+	}{
+		{value: "", want: map[string]float64{"Sc": 1000}},
+		{value: "Sw=1", want: map[string]float64{"Sc": 1000, "Sw": 1}},
+		{value: "Sc=100, Sw=1", want: map[string]float64{"Sc": 100, "Sw": 1}},
+		// 正の数でない指定は飛ばす
+		{value: "Sw=0,Vi=-1,Xy=x,Zz", want: map[string]float64{"Sc": 1000}},
+	}
+	for _, tt := range tests {
+		got := parseRatingScales(tt.value)
+		if len(got) != len(tt.want) {
+			t.Errorf("parseRatingScales(%q) = %v, want %v", tt.value, got, tt.want)
+			continue
+		}
+		for soft, scale := range tt.want {
+			if got[soft] != scale {
+				t.Errorf("parseRatingScales(%q)[%s] = %v, want %v", tt.value, soft, got[soft], scale)
+			}
+		}
+	}
+}
+
+// ソフトごとの倍率でレートを変換する
+func TestRankingRatingScalePerSoft(t *testing.T) {
+	newFakeUpstream(t)
+	saved := RatingScales
+	t.Cleanup(func() { RatingScales = saved })
+	RatingScales = map[string]float64{"Sc": 1000, "Vi": 1}
+
+	tests := []struct {
+		target     string
+		wantRating float64
+	}{
+		{target: "/rankings?soft=Sc", wantRating: 2099},
+		{target: "/rankings?soft=Vi", wantRating: 2099000},
+		{target: "/rankings", wantRating: 2099},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			first := ranking.Top1000[0]
+			if first.RatingValue != tt.wantRating || first.RatingRaw != 2099000 {
+				t.Errorf("got rating %v raw %v, want %v raw 2099000", first.RatingValue, first.RatingRaw, tt.wantRating)
+			}
+		})
+	}
+	if got := ratingScale("Sw"); got != defaultRatingScale {
+		t.Errorf("scale of an unconfigured soft = %v, want %v", got, defaultRatingScale)
+	}
+}
+
+// softはシーズンリストのリクエストとランキングファイルのパスに使い、知らないソフトは400を返す
+func TestRankingSoftParameter(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantSoft   string
+	}{
+		{target: "/rankings", wantStatus: http.StatusOK, wantSoft: "Sc"},
+		{target: "/rankings?soft=Sc", wantStatus: http.StatusOK, wantSoft: "Sc"},
+		{target: "/rankings?soft=Vi", wantStatus: http.StatusOK, wantSoft: "Vi"},
+		{target: "/rankings?soft=Sw", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?soft=sc", wantStatus: http.StatusBadRequest},
+		{target: "/rankings?soft=scvi", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var body ErrorResponse
+				decodeBody(t, rec, &body)
+				if !strings.Contains(body.Error, "soft") {
+					t.Errorf("error = %q, want it to mention soft", body.Error)
+				}
+				if n := upstream.seasonListCalls.Load() + upstream.rankingCalls.Load(); n != 0 {
+					t.Errorf("upstream calls = %d, want 0", n)
+				}
+				return
+			}
+			if len(ranking.Top1000) != 1000 {
+				t.Errorf("got %d rows, want 1000", len(ranking.Top1000))
+			}
+			if len(upstream.seasonListSofts) != 1 || upstream.seasonListSofts[0] != tt.wantSoft {
+				t.Errorf("season list softs = %v, want [%s]", upstream.seasonListSofts, tt.wantSoft)
+			}
+		})
+	}
+}
+
+func TestRankingPageURL(t *testing.T) {
+	client := &Client{ResourceBaseURL: "https://resource.example"}
+	for _, soft := range []string{"Sc", "Vi"} {
+		got := client.rankingPageURL(soft, "10001", 0, "1700000000", 2)
+		if want := "https://resource.example/battledata/ranking/scvi/10001/0/1700000000/traner-2"; got != want {
+			t.Errorf("rankingPageURL(%s) = %q, want %q", soft, got, want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package Handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ランキングファイルの有無を確認するときの同時リクエスト数
+const publishedProbeConcurrency = 4
+
+// ルールの値
+const (
+	RuleSingle = 0
+	RuleDouble = 1
+)
+
+// シングルとダブルの開催中のシーズン
+// 開催されていないルールはnull
+type ActiveSeasonsResponse struct {
+	Single *ActiveSeason `json:"single"`
+	Double *ActiveSeason `json:"double"`
+}
+
+// 開催中のシーズンの残り時間と100位のボーダー
+// ボーダーが取得できなかった場合はErrorにその理由を入れる
+type ActiveSeason struct {
+	SeasonData       SeasonData `json:"season_data"`
+	RemainingSeconds int64      `json:"remaining_seconds"`
+	Cutoff100        *float64   `json:"cutoff_100,omitempty"`
+	Error            string     `json:"error,omitempty"`
+}
+
+// シーズンリストを新しいシーズン順に並べた一覧にする
+// 同じシーズンのものはルール順
+func flattenSeasons(seasons map[string]map[string]SeasonData) []SeasonData {
+	result := []SeasonData{}
+	for _, season := range seasons {
+		for _, seasonData := range season {
+			result = append(result, seasonData)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Season != result[j].Season {
+			return result[i].Season > result[j].Season
+		}
+		return result[i].Rule < result[j].Rule
+	})
+	return result
+}
+
+// ランキングが公開されているシーズンに絞り込む
+// probeがtrueの場合はランキングファイルにHEADリクエストを送って実際に存在するかも確認する
+func filterPublishedSeasons(ctx context.Context, seasons []SeasonData, probe bool) []SeasonData {
+	candidates := []SeasonData{}
+	for _, seasonData := range seasons {
+		if seasonData.RankCnt > 0 {
+			candidates = append(candidates, seasonData)
+		}
+	}
+	if !probe {
+		return candidates
+	}
+
+	exists := make([]bool, len(candidates))
+	sem := make(chan struct{}, publishedProbeConcurrency)
+	var wg sync.WaitGroup
+	for i, seasonData := range candidates {
+		wg.Add(1)
+		go func(i int, seasonData SeasonData) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			exists[i] = rankingFileExists(ctx, seasonData)
+		}(i, seasonData)
+	}
+	wg.Wait()
+
+	result := []SeasonData{}
+	for i, seasonData := range candidates {
+		if exists[i] {
+			result = append(result, seasonData)
+		}
+	}
+	return result
+}
+
+// ランキングファイルの1ページ目が存在するか
+func rankingFileExists(ctx context.Context, seasonData SeasonData) bool {
+	ts, err := rankingFileTimestamp(seasonData)
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", DefaultClient.rankingPageURL(softOrDefault(seasonData.Soft), seasonData.CID, seasonData.Rst, ts, 1), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := DefaultClient.HTTPClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// ルールごとに現在のシーズンを取得
+// /rankingsでruleを指定した場合と同じくgetLatestSeasonDataで選び、どちらでもないルールは含めない
+func activeSeasonsByRule(seasons map[string]map[string]SeasonData, soft, lang string, now time.Time) (map[int]SeasonData, error) {
+	result := map[int]SeasonData{}
+	for _, rule := range []int{RuleSingle, RuleDouble} {
+		rule := rule
+		seasonData, err := getLatestSeasonData(seasons, soft, &rule, lang, now)
+		if errors.Is(err, ErrNoActiveSeason) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		seasonData.Soft = soft
+		result[rule] = seasonData
+	}
+	return result, nil
+}
+
+// nowでのシーズンの残り秒数と、開始から終了までのうち経過した割合（0〜100）
+// 開始前は経過0、終了後は残り0で経過100にする。日時が読めない場合は両方0
+func seasonProgress(seasonData SeasonData, now time.Time) (int64, float64) {
+	start, err := parseSeasonTime(seasonData.Start)
+	if err != nil {
+		return 0, 0
+	}
+	end, err := parseSeasonTime(seasonData.End)
+	if err != nil {
+		return 0, 0
+	}
+	remaining := int64(end.Sub(now).Seconds())
+	if remaining < 0 {
+		remaining = 0
+	}
+	total := end.Sub(start)
+	if total <= 0 {
+		return remaining, 100
+	}
+	progress := float64(now.Sub(start)) / float64(total) * 100
+	return remaining, math.Max(0, math.Min(100, progress))
+}
+
+// 開催中のシーズンの残り時間と100位のボーダーを取得
+func fetchActiveSeason(ctx context.Context, seasonData SeasonData, now time.Time, maxAge time.Duration, budget *retryBudget) *ActiveSeason {
+	result := &ActiveSeason{SeasonData: seasonData}
+	result.RemainingSeconds, _ = seasonProgress(seasonData, now)
+	rankingData, _, err := cachedSeasonRanking(ctx, seasonData, maxAge, budget)
+	if err != nil {
+		result.Error = fmt.Sprintf("Error fetching top 1000 ranking data: %v", err)
+		return result
+	}
+	cutoff, err := computeCutoff(rankingData, 100, false)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Cutoff100 = &cutoff.RatingValue
+	return result
+}
+
+// endpoint handler
+func ActiveSeasonsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	soft := defaultSoft
+	if v := r.URL.Query().Get("soft"); v != "" {
+		if !knownSoft(v) {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid soft parameter: %q is not a known soft", v))
+			return
+		}
+		soft = v
+	}
+	lang := r.URL.Query().Get("lang")
+
+	maxAge := cacheTTL("/seasons/active")
+	budget := newRetryBudget(RetryBudget)
+	seasonList, _, err := cachedSeasonList(r.Context(), soft, maxAge, budget)
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), fmt.Sprintf("Error fetching ranking data: %v", err))
+		return
+	}
+
+	now := time.Now()
+	active, err := activeSeasonsByRule(seasonList.Seasons, soft, lang, now)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Error fetching active season data: %v", err))
+		return
+	}
+
+	// シングルとダブルを並行して取得
+	var response ActiveSeasonsResponse
+	var wg sync.WaitGroup
+	if seasonData, ok := active[RuleSingle]; ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response.Single = fetchActiveSeason(r.Context(), seasonData, now, maxAge, budget)
+		}()
+	}
+	if seasonData, ok := active[RuleDouble]; ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response.Double = fetchActiveSeason(r.Context(), seasonData, now, maxAge, budget)
+		}()
+	}
+	wg.Wait()
+	for _, active := range []*ActiveSeason{response.Single, response.Double} {
+		if active != nil {
+			active.SeasonData = localizeSeasonData(active.SeasonData, lang)
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// endpoint handler
+func SeasonsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	maxAge := cacheTTL("/seasons")
+	seasonList, _, err := cachedSeasonList(r.Context(), defaultSoft, maxAge, newRetryBudget(RetryBudget))
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), fmt.Sprintf("Error fetching ranking data: %v", err))
+		return
+	}
+	// シーズンの一覧はほとんど変わらないため、頻繁に呼ばれてもクライアント側でキャッシュできるようにする
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+
+	seasons := flattenSeasons(seasonList.Seasons)
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		for i := range seasons {
+			seasons[i] = localizeSeasonData(seasons[i], lang)
+		}
+	}
+	if r.URL.Query().Get("published") == "true" {
+		seasons = filterPublishedSeasons(r.Context(), seasons, r.URL.Query().Get("probe") == "true")
+	}
+
+	if err := json.NewEncoder(w).Encode(seasons); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// 年間の日程
+type SeasonScheduleResponse struct {
+	Year    int             `json:"year"`
+	Entries []ScheduleEntry `json:"entries"`
+}
+
+// 日程の1件
+// Typeがseasonならシーズン、gapなら前のシーズンの終了から次のシーズンの開始までのどのシーズンも開催されていない期間
+type ScheduleEntry struct {
+	Type       string      `json:"type"`
+	Start      string      `json:"start"`
+	End        string      `json:"end"`
+	SeasonData *SeasonData `json:"season_data,omitempty"`
+}
+
+const (
+	scheduleEntrySeason = "season"
+	scheduleEntryGap    = "gap"
+)
+
+// yearに開始したシーズンを開始日時順に並べ、シーズンの間の空いている期間をgapとして挟む
+// 同じ期間に複数のルールのシーズンがある場合はルール順
+func seasonSchedule(seasons []SeasonData, year int) ([]ScheduleEntry, error) {
+	type scheduledSeason struct {
+		seasonData SeasonData
+		start, end time.Time
+	}
+	var scheduled []scheduledSeason
+	for _, seasonData := range seasons {
+		start, err := parseSeasonTime(seasonData.Start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse start time: %v", err)
+		}
+		end, err := parseSeasonTime(seasonData.End)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse end time: %v", err)
+		}
+		if start.Year() == year {
+			scheduled = append(scheduled, scheduledSeason{seasonData: seasonData, start: start, end: end})
+		}
+	}
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		if !scheduled[i].start.Equal(scheduled[j].start) {
+			return scheduled[i].start.Before(scheduled[j].start)
+		}
+		return scheduled[i].seasonData.Rule < scheduled[j].seasonData.Rule
+	})
+
+	entries := []ScheduleEntry{}
+	var lastEnd time.Time
+	for i, s := range scheduled {
+		if i > 0 && s.start.After(lastEnd) {
+			entries = append(entries, ScheduleEntry{
+				Type:  scheduleEntryGap,
+				Start: lastEnd.Format(seasonTimeLayout),
+				End:   s.start.Format(seasonTimeLayout),
+			})
+		}
+		seasonData := s.seasonData
+		entries = append(entries, ScheduleEntry{
+			Type:       scheduleEntrySeason,
+			Start:      s.start.Format(seasonTimeLayout),
+			End:        s.end.Format(seasonTimeLayout),
+			SeasonData: &seasonData,
+		})
+		if s.end.After(lastEnd) {
+			lastEnd = s.end
+		}
+	}
+	return entries, nil
+}
+
+// endpoint handler
+func SeasonScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	year := time.Now().In(jst).Year()
+	if v := r.URL.Query().Get("year"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid year parameter")
+			return
+		}
+		year = n
+	}
+
+	seasonList, _, err := cachedSeasonList(r.Context(), defaultSoft, cacheTTL("/seasons/schedule"), newRetryBudget(RetryBudget))
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), fmt.Sprintf("Error fetching ranking data: %v", err))
+		return
+	}
+
+	entries, err := seasonSchedule(flattenSeasons(seasonList.Seasons), year)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := SeasonScheduleResponse{Year: year, Entries: entries}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// endpoint handler
+func CurrentSeasonHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := rankingQuery{maxAge: cacheTTL("/season/current"), budget: newRetryBudget(RetryBudget), lang: r.URL.Query().Get("lang")}
+	seasonData, err := selectLatestSeason(r.Context(), query, &fetchStatus{})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+	seasonData = localizeSeasonData(seasonData, r.URL.Query().Get("lang"))
+
+	if err := json.NewEncoder(w).Encode(seasonData); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
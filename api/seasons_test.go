@@ -0,0 +1,663 @@
+package Handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// シーズンの一覧のシーズン番号
+func seasonNumbers(seasons []SeasonData) []int {
+	numbers := make([]int, len(seasons))
+	for i, seasonData := range seasons {
+		numbers[i] = seasonData.Season
+	}
+	return numbers
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSeasonsPublished(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	// 始まっていないシーズン
+	future := fixtureSeason(3, "10003", 0, time.Now().Add(30*24*time.Hour))
+	future.RankCnt = 0
+	upstream.addSeason("3", future)
+	// 順位は付いているがランキングファイルがない
+	upstream.addSeason("2", fixtureSeason(2, "10002", 0, time.Now().Add(-30*24*time.Hour)))
+
+	tests := []struct {
+		target string
+		want   []int
+	}{
+		{target: "/seasons", want: []int{3, 2, 1}},
+		{target: "/seasons?published=true", want: []int{2, 1}},
+		{target: "/seasons?published=true&probe=true", want: []int{1}},
+		{target: "/seasons?probe=true", want: []int{3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, SeasonsHandler, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var seasons []SeasonData
+			decodeBody(t, rec, &seasons)
+			if got := seasonNumbers(seasons); !equalInts(got, tt.want) {
+				t.Errorf("seasons = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActiveSeasonsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		double     bool
+		singleEnds bool
+		wantSingle bool
+		wantDouble bool
+	}{
+		{name: "both rules", double: true, wantSingle: true, wantDouble: true},
+		{name: "single only", wantSingle: true},
+		{name: "double only", double: true, singleEnds: true, wantDouble: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			if tt.double {
+				double := fixtureSeason(1, "10002", RuleDouble, time.Now())
+				upstream.addSeason("1", double)
+				// ダブルの100位はシングルより5高い
+				rows := fixtureRows(1, 1000)
+				for i := range rows {
+					rows[i].RatingValue += 5000
+				}
+				upstream.setPage(double, 1, rows)
+			}
+			if tt.singleEnds {
+				upstream.addSeason("1", fixtureSeason(1, "10001", RuleSingle, time.Now().Add(-72*time.Hour)))
+			}
+
+			rec := get(t, ActiveSeasonsHandler, "/seasons/active")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var response ActiveSeasonsResponse
+			decodeBody(t, rec, &response)
+			assertActiveSeason(t, "single", response.Single, tt.wantSingle, "10001", 2000)
+			assertActiveSeason(t, "double", response.Double, tt.wantDouble, "10002", 2005)
+		})
+	}
+}
+
+// /rankingsにruleを指定した場合と同じく、ソフトとlangでシーズンを選ぶ
+func TestActiveSeasonsSelection(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		english    bool
+		wantSingle string
+		wantDouble string
+	}{
+		// 他のソフトのシーズンは番号が大きくても選ばない
+		{name: "other soft", target: "/seasons/active", wantSingle: "10001"},
+		{name: "soft", target: "/seasons/active?soft=Vi", wantSingle: "20002", wantDouble: "20003"},
+		{name: "lang", target: "/seasons/active?lang=en", english: true, wantSingle: "10004"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			now := time.Now()
+			// ソフトの入っていないシーズンはどのソフトとしても選ばれるため、ソフトを入れておく
+			season := upstream.seasons["1"]["10001"]
+			season.Soft = defaultSoft
+			upstream.seasons["1"]["10001"] = season
+			for _, seasonData := range []SeasonData{
+				fixtureSeason(2, "20002", RuleSingle, now),
+				fixtureSeason(2, "20003", RuleDouble, now),
+			} {
+				seasonData.Soft = "Vi"
+				upstream.addSeason("2", seasonData)
+				upstream.setPage(seasonData, 1, fixtureRows(1, 1000))
+			}
+			if tt.english {
+				// 同じシーズンの英語のリスト
+				english := fixtureSeason(1, "10004", RuleSingle, now)
+				english.Soft = defaultSoft
+				upstream.seasons["1"]["en"] = english
+				upstream.setPage(english, 1, fixtureRows(1, 1000))
+			}
+
+			rec := get(t, ActiveSeasonsHandler, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var response ActiveSeasonsResponse
+			decodeBody(t, rec, &response)
+			for _, rule := range []struct {
+				name string
+				got  *ActiveSeason
+				want string
+			}{
+				{name: "single", got: response.Single, want: tt.wantSingle},
+				{name: "double", got: response.Double, want: tt.wantDouble},
+			} {
+				var got string
+				if rule.got != nil {
+					got = rule.got.SeasonData.CID
+				}
+				if got != rule.want {
+					t.Errorf("%s cId = %q, want %q", rule.name, got, rule.want)
+				}
+			}
+		})
+	}
+}
+
+// 上流のエラーは/rankingsと同じステータスコードで返す
+func TestActiveSeasonsUpstreamError(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	withoutRetryDelay(t)
+	upstream.seasonListFailures = 100
+
+	rec := get(t, ActiveSeasonsHandler, "/seasons/active")
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadGateway, rec.Body)
+	}
+}
+
+func assertActiveSeason(t *testing.T, name string, got *ActiveSeason, want bool, cId string, cutoff float64) {
+	t.Helper()
+	if !want {
+		if got != nil {
+			t.Errorf("%s = %+v, want null", name, *got)
+		}
+		return
+	}
+	if got == nil {
+		t.Fatalf("%s is null", name)
+	}
+	if got.SeasonData.CID != cId {
+		t.Errorf("%s cId = %q, want %q", name, got.SeasonData.CID, cId)
+	}
+	if got.Cutoff100 == nil || *got.Cutoff100 != cutoff {
+		t.Errorf("%s cutoff_100 = %v (%s), want %v", name, got.Cutoff100, got.Error, cutoff)
+	}
+	// 終了の24時間前に作ったシーズン
+	if got.RemainingSeconds <= 0 || got.RemainingSeconds > 24*60*60 {
+		t.Errorf("%s remaining_seconds = %d, want within a day", name, got.RemainingSeconds)
+	}
+}
+
+// ?season=で過去のシーズンを選び、同じシーズン番号が複数のルールにあればシングルを選ぶ
+func TestRankingSeasonParameter(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantCID    string
+		wantRating float64
+	}{
+		{target: "/rankings", wantStatus: http.StatusOK, wantCID: "10001", wantRating: 2099},
+		{target: "/rankings?season=2", wantStatus: http.StatusOK, wantCID: "10002", wantRating: 2104},
+		{target: "/rankings?season=2&rule=0", wantStatus: http.StatusOK, wantCID: "10002", wantRating: 2104},
+		{target: "/rankings?season=2&rule=1", wantStatus: http.StatusOK, wantCID: "10003", wantRating: 2109},
+		{target: "/rankings?season=9", wantStatus: http.StatusNotFound},
+		{target: "/rankings?season=1&rule=1", wantStatus: http.StatusNotFound},
+		{target: "/rankings?season=x", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			addPastSeason(upstream, 2, "10002", 5)
+			// 同じシーズン2のダブル
+			double := fixtureSeason(2, "10003", RuleDouble, time.Now().Add(-60*24*time.Hour))
+			upstream.addSeason("3", double)
+			rows := fixtureRows(1, 1000)
+			for i := range rows {
+				rows[i].RatingValue += 10000
+			}
+			upstream.setPage(double, 1, rows)
+
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var body ErrorResponse
+				decodeBody(t, rec, &body)
+				if body.Status != tt.wantStatus || body.Error == "" {
+					t.Errorf("body = %+v, want a JSON error", body)
+				}
+				return
+			}
+			if ranking.SeasonData.CID != tt.wantCID || ranking.Top1000[0].RatingValue != tt.wantRating {
+				t.Errorf("got %s with top rating %v, want %s with %v", ranking.SeasonData.CID, ranking.Top1000[0].RatingValue, tt.wantCID, tt.wantRating)
+			}
+		})
+	}
+}
+
+// シングルとダブルのシーズンが同時に開催中のシーズンリスト
+func TestGetLatestSeasonDataRule(t *testing.T) {
+	now := time.Now()
+	single := fixtureSeason(1, "10001", RuleSingle, now)
+	double := fixtureSeason(1, "10002", RuleDouble, now)
+	// マップの並びに関わらず同じシーズンを選ぶように、外側のキーを変えていくつも入れる
+	seasons := map[string]map[string]SeasonData{}
+	for i := 0; i < 8; i++ {
+		seasons[fmt.Sprintf("d%d", i)] = map[string]SeasonData{double.CID: double}
+	}
+	seasons["s"] = map[string]SeasonData{single.CID: single}
+	singleRule, doubleRule, unknownRule := RuleSingle, RuleDouble, 2
+
+	tests := []struct {
+		name    string
+		rule    *int
+		wantCID string
+		wantErr error
+	}{
+		{name: "default is single", wantCID: "10001"},
+		{name: "single", rule: &singleRule, wantCID: "10001"},
+		{name: "double", rule: &doubleRule, wantCID: "10002"},
+		{name: "unknown rule", rule: &unknownRule, wantErr: ErrNoActiveSeason},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				seasonData, err := getLatestSeasonData(seasons, defaultSoft, tt.rule, "", now)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if seasonData.CID != tt.wantCID {
+					t.Fatalf("cId = %q, want %q", seasonData.CID, tt.wantCID)
+				}
+			}
+		})
+	}
+}
+
+func TestRankingRuleParameter(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantRule   int
+	}{
+		{target: "/rankings", wantStatus: http.StatusOK, wantRule: RuleSingle},
+		{target: "/rankings?rule=0", wantStatus: http.StatusOK, wantRule: RuleSingle},
+		{target: "/rankings?rule=1", wantStatus: http.StatusOK, wantRule: RuleDouble},
+		{target: "/rankings?rule=2", wantStatus: http.StatusNotFound},
+		{target: "/rankings?rule=single", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			double := fixtureSeason(1, "10002", RuleDouble, time.Now())
+			upstream.addSeason("2", double)
+			upstream.setPage(double, 1, fixtureRows(1, 1000))
+
+			rec, ranking := getRanking(t, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK && ranking.SeasonData.Rule != tt.wantRule {
+				t.Errorf("rule = %d, want %d", ranking.SeasonData.Rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+// 新しいシーズン順、同じシーズンはルール順に並べ、日時の形式を揃えて返す
+// ランキングファイルは取得しない
+func TestSeasonsHandler(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	past := fixtureSeason(2, "10002", RuleSingle, time.Now())
+	past.Start, past.End = "2024-05-01  09:00", "2024/06/01 08:59:00"
+	upstream.addSeason("2", past)
+	upstream.addSeason("3", fixtureSeason(2, "10003", RuleDouble, time.Now()))
+	upstream.addSeason("4", fixtureSeason(10, "10010", RuleSingle, time.Now()))
+
+	rec := get(t, SeasonsHandler, "/seasons")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var seasons []SeasonData
+	decodeBody(t, rec, &seasons)
+	wantCIDs := []string{"10010", "10002", "10003", "10001"}
+	if len(seasons) != len(wantCIDs) {
+		t.Fatalf("seasons = %v, want %v", seasons, wantCIDs)
+	}
+	for i, seasonData := range seasons {
+		if seasonData.CID != wantCIDs[i] {
+			t.Errorf("seasons[%d] = %s, want %s", i, seasonData.CID, wantCIDs[i])
+		}
+		if seasonData.CID == "10002" && (seasonData.Start != "2024-05-01 09:00:00" || seasonData.End != "2024-06-01 08:59:00") {
+			t.Errorf("season 2 = %q to %q, want normalized times", seasonData.Start, seasonData.End)
+		}
+	}
+	if n := upstream.rankingCalls.Load(); n != 0 {
+		t.Errorf("ranking calls = %d, want 0", n)
+	}
+}
+
+// 他のソフトのシーズンが混ざったシーズンリストでも、指定したソフトのシーズンを選ぶ
+func TestGetLatestSeasonDataSoft(t *testing.T) {
+	saved := RequireSeasonSoft
+	t.Cleanup(func() { RequireSeasonSoft = saved })
+	now := time.Now()
+	withSoft := func(cId, soft string) SeasonData {
+		seasonData := fixtureSeason(1, cId, RuleSingle, now)
+		seasonData.Soft = soft
+		return seasonData
+	}
+	other := withSoft("90001", "Sw")
+	scarlet := withSoft("10001", "Sc")
+	unknown := withSoft("10005", "")
+
+	tests := []struct {
+		name        string
+		seasons     []SeasonData
+		soft        string
+		requireSoft bool
+		wantCID     string
+		wantErr     error
+	}{
+		{name: "requested soft", seasons: []SeasonData{other, scarlet}, soft: "Sc", wantCID: "10001"},
+		{name: "other soft", seasons: []SeasonData{other, scarlet}, soft: "Sw", wantCID: "90001"},
+		{name: "season without soft", seasons: []SeasonData{other, unknown}, soft: "Sc", wantCID: "10005"},
+		{name: "season without soft is skipped", seasons: []SeasonData{other, unknown}, soft: "Sc", requireSoft: true, wantErr: ErrNoActiveSeason},
+		{name: "only other soft", seasons: []SeasonData{other}, soft: "Sc", wantErr: ErrNoActiveSeason},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RequireSeasonSoft = tt.requireSoft
+			seasons := map[string]map[string]SeasonData{}
+			for i, seasonData := range tt.seasons {
+				seasons[fmt.Sprint(i)] = map[string]SeasonData{seasonData.CID: seasonData}
+			}
+			for i := 0; i < 20; i++ {
+				seasonData, err := getLatestSeasonData(seasons, tt.soft, nil, "", now)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if seasonData.CID != tt.wantCID {
+					t.Fatalf("cId = %q, want %q", seasonData.CID, tt.wantCID)
+				}
+			}
+		})
+	}
+}
+
+// 日程の1件の種類と期間
+func scheduleSummary(entries []ScheduleEntry) []string {
+	summary := make([]string, len(entries))
+	for i, entry := range entries {
+		summary[i] = fmt.Sprintf("%s %s~%s", entry.Type, entry.Start, entry.End)
+		if entry.SeasonData != nil {
+			summary[i] += " " + entry.SeasonData.CID
+		}
+	}
+	return summary
+}
+
+func TestSeasonScheduleHandler(t *testing.T) {
+	now := time.Now()
+	withTimes := func(season int, cId string, rule int, start, end string) SeasonData {
+		seasonData := fixtureSeason(season, cId, rule, now)
+		seasonData.Start, seasonData.End = start, end
+		return seasonData
+	}
+	seasons := []SeasonData{
+		withTimes(3, "10003", RuleSingle, "2024/03/10 09:00", "2024/04/01 08:59"),
+		withTimes(1, "10001", RuleSingle, "2024/01/01 09:00", "2024/02/01 08:59"),
+		withTimes(1, "20001", RuleDouble, "2024/01/01 09:00", "2024/02/01 08:59"),
+		withTimes(2, "10002", RuleSingle, "2024/02/01 09:00", "2024/03/01 08:59"),
+		withTimes(0, "10000", RuleSingle, "2023/12/01 09:00", "2024/01/01 08:59"),
+	}
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       []string
+	}{
+		{
+			target:     "/seasons/schedule?year=2024",
+			wantStatus: http.StatusOK,
+			want: []string{
+				"season 2024-01-01 09:00:00~2024-02-01 08:59:00 10001",
+				"season 2024-01-01 09:00:00~2024-02-01 08:59:00 20001",
+				"gap 2024-02-01 08:59:00~2024-02-01 09:00:00",
+				"season 2024-02-01 09:00:00~2024-03-01 08:59:00 10002",
+				"gap 2024-03-01 08:59:00~2024-03-10 09:00:00",
+				"season 2024-03-10 09:00:00~2024-04-01 08:59:00 10003",
+			},
+		},
+		{
+			target:     "/seasons/schedule?year=2023",
+			wantStatus: http.StatusOK,
+			want:       []string{"season 2023-12-01 09:00:00~2024-01-01 08:59:00 10000"},
+		},
+		{target: "/seasons/schedule?year=2030", wantStatus: http.StatusOK, want: []string{}},
+		{target: "/seasons/schedule?year=x", wantStatus: http.StatusBadRequest},
+		{target: "/seasons/schedule?year=0", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.seasons = map[string]map[string]SeasonData{}
+			for _, seasonData := range seasons {
+				upstream.addSeason(fmt.Sprint(seasonData.Season), seasonData)
+			}
+			rec := get(t, SeasonScheduleHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response SeasonScheduleResponse
+			decodeBody(t, rec, &response)
+			if response.Entries == nil {
+				t.Fatal("entries is null, want an array")
+			}
+			if got := scheduleSummary(response.Entries); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("entries =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// シーズンの日時は日本時間として読むため、nowのタイムゾーンにかかわらず切り替わりの前後で同じシーズンを選ぶ
+func TestGetLatestSeasonDataAtSeasonBoundary(t *testing.T) {
+	first := fixtureSeason(1, "10001", RuleSingle, time.Now())
+	first.Start, first.End = "2024/05/01 09:00", "2024/06/01 08:59"
+	second := fixtureSeason(2, "10002", RuleSingle, time.Now())
+	second.Start, second.End = "2024/06/01 09:00", "2024/07/01 08:59"
+	seasons := map[string]map[string]SeasonData{
+		"1": {first.CID: first},
+		"2": {second.CID: second},
+	}
+	pdt := time.FixedZone("PDT", -7*60*60)
+
+	// 6月1日の0:00から9:00（UTC）の間は、日時をUTCとして読むとまだ前のシーズンになる
+	tests := []struct {
+		name       string
+		now        time.Time
+		wantSeason int
+		wantErr    error
+	}{
+		{name: "before end jst", now: time.Date(2024, 6, 1, 8, 30, 0, 0, jst), wantSeason: 1},
+		{name: "before end utc", now: time.Date(2024, 5, 31, 23, 30, 0, 0, time.UTC), wantSeason: 1},
+		{name: "between seasons", now: time.Date(2024, 6, 1, 8, 59, 30, 0, jst), wantErr: ErrNoActiveSeason},
+		{name: "after start jst", now: time.Date(2024, 6, 1, 9, 30, 0, 0, jst), wantSeason: 2},
+		{name: "after start utc", now: time.Date(2024, 6, 1, 0, 30, 0, 0, time.UTC), wantSeason: 2},
+		{name: "after start pdt", now: time.Date(2024, 5, 31, 17, 30, 0, 0, pdt), wantSeason: 2},
+		{name: "after start local", now: time.Date(2024, 6, 1, 9, 30, 0, 0, jst).Local(), wantSeason: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seasonData, err := getLatestSeasonData(seasons, defaultSoft, nil, "", tt.now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got season %d, err %v, want %v", seasonData.Season, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seasonData.Season != tt.wantSeason {
+				t.Errorf("season = %d, want %d", seasonData.Season, tt.wantSeason)
+			}
+		})
+	}
+}
+
+// 終了してからSeasonFinalGraceの間は最終結果のシーズンを選ぶ
+func TestGetLatestSeasonDataFinalGrace(t *testing.T) {
+	saved := SeasonFinalGrace
+	t.Cleanup(func() { SeasonFinalGrace = saved })
+	earlier := fixtureSeason(1, "10001", RuleSingle, time.Now())
+	earlier.Start, earlier.End = "2024/05/01 09:00", "2024/06/01 07:59"
+	ended := fixtureSeason(2, "10002", RuleSingle, time.Now())
+	ended.Start, ended.End = "2024/05/01 09:00", "2024/06/01 08:59"
+	seasons := map[string]map[string]SeasonData{
+		"1": {earlier.CID: earlier},
+		"2": {ended.CID: ended},
+	}
+	end := time.Date(2024, 6, 1, 8, 59, 0, 0, jst)
+
+	tests := []struct {
+		name       string
+		grace      time.Duration
+		now        time.Time
+		wantSeason int
+		wantFinal  bool
+		wantErr    error
+	}{
+		{name: "before end", grace: 2 * time.Hour, now: end.Add(-time.Minute), wantSeason: 2},
+		{name: "just ended", grace: 2 * time.Hour, now: end, wantSeason: 2, wantFinal: true},
+		{name: "just inside", grace: 2 * time.Hour, now: end.Add(2*time.Hour - time.Second), wantSeason: 2, wantFinal: true},
+		{name: "just outside", grace: 2 * time.Hour, now: end.Add(2 * time.Hour), wantErr: ErrNoActiveSeason},
+		// 先に終了したシーズンの猶予が残っていても、後に終了したシーズンを選ぶ
+		{name: "latest ended", grace: 4 * time.Hour, now: end.Add(time.Hour), wantSeason: 2, wantFinal: true},
+		{name: "disabled", now: end.Add(time.Second), wantErr: ErrNoActiveSeason},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SeasonFinalGrace = tt.grace
+			seasonData, err := getLatestSeasonData(seasons, defaultSoft, nil, "", tt.now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got season %d, err %v, want %v", seasonData.Season, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seasonData.Season != tt.wantSeason || seasonData.Final != tt.wantFinal {
+				t.Errorf("got season %d final %v, want season %d final %v", seasonData.Season, seasonData.Final, tt.wantSeason, tt.wantFinal)
+			}
+		})
+	}
+}
+
+// 終了したシーズンの最終結果もランキングを返し、finalを付ける
+func TestRankingFinalSeason(t *testing.T) {
+	saved := SeasonFinalGrace
+	t.Cleanup(func() { SeasonFinalGrace = saved })
+
+	tests := []struct {
+		name       string
+		grace      time.Duration
+		wantStatus int
+	}{
+		{name: "inside grace", grace: 2 * time.Hour, wantStatus: http.StatusOK},
+		{name: "outside grace", grace: 30 * time.Minute, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SeasonFinalGrace = tt.grace
+			upstream := newFakeUpstream(t)
+			seasonData := upstream.seasons["1"]["10001"]
+			seasonData.End = time.Now().Add(-time.Hour).In(jst).Format(fixtureTimeLayout)
+			upstream.addSeason("1", seasonData)
+
+			rec, ranking := getRanking(t, "/rankings")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if !ranking.SeasonData.Final || ranking.SeasonData.Season != 1 {
+				t.Errorf("got season %d final %v, want season 1 final", ranking.SeasonData.Season, ranking.SeasonData.Final)
+			}
+			if len(ranking.Top1000) != 1000 {
+				t.Errorf("got %d rows, want 1000", len(ranking.Top1000))
+			}
+		})
+	}
+}
+
+// 残り秒数と経過の割合は日本時間の開始と終了から計算し、範囲外は丸める
+func TestSeasonProgress(t *testing.T) {
+	seasonData := SeasonData{Start: "2024/05/01 09:00", End: "2024/05/11 09:00"}
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		seasonData    SeasonData
+		now           time.Time
+		wantRemaining int64
+		wantProgress  float64
+	}{
+		{name: "start", seasonData: seasonData, now: start, wantRemaining: 10 * 24 * 60 * 60, wantProgress: 0},
+		{name: "middle", seasonData: seasonData, now: start.Add(5 * 24 * time.Hour), wantRemaining: 5 * 24 * 60 * 60, wantProgress: 50},
+		{name: "quarter", seasonData: seasonData, now: start.Add(60 * time.Hour), wantRemaining: 180 * 60 * 60, wantProgress: 25},
+		{name: "end", seasonData: seasonData, now: start.Add(10 * 24 * time.Hour), wantRemaining: 0, wantProgress: 100},
+		{name: "before start", seasonData: seasonData, now: start.Add(-time.Hour), wantRemaining: 10*24*60*60 + 60*60, wantProgress: 0},
+		{name: "ended", seasonData: seasonData, now: start.Add(11 * 24 * time.Hour), wantRemaining: 0, wantProgress: 100},
+		{name: "unparsable", seasonData: SeasonData{Start: "soon", End: "2024/05/11 09:00"}, now: start, wantRemaining: 0, wantProgress: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remaining, progress := seasonProgress(tt.seasonData, tt.now)
+			if remaining != tt.wantRemaining {
+				t.Errorf("remaining = %d, want %d", remaining, tt.wantRemaining)
+			}
+			if math.Abs(progress-tt.wantProgress) > floatTolerance {
+				t.Errorf("progress = %v, want %v", progress, tt.wantProgress)
+			}
+		})
+	}
+}
+
+// fixtureSeasonは前後1日のため、レスポンスの進み具合はほぼ半分になる
+func TestRankingSeasonProgress(t *testing.T) {
+	newFakeUpstream(t)
+	rec, ranking := getRanking(t, "/rankings")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if ranking.RemainingSeconds <= 23*60*60 || ranking.RemainingSeconds > 24*60*60 {
+		t.Errorf("remaining_seconds = %d, want about a day", ranking.RemainingSeconds)
+	}
+	if ranking.ProgressPercent < 49 || ranking.ProgressPercent > 51 {
+		t.Errorf("progress_percent = %v, want about 50", ranking.ProgressPercent)
+	}
+}
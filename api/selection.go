@@ -0,0 +1,83 @@
+package Handler
+
+import (
+	"fmt"
+	"time"
+)
+
+// ソフト、ルール、言語の指定ごとのシーズン選択の結果のキー
+func selectionKey(soft string, rule *int, lang string) string {
+	key := soft
+	if rule != nil {
+		key = fmt.Sprintf("%s/%d", key, *rule)
+	}
+	if lang != "" {
+		key += "@" + lang
+	}
+	return key
+}
+
+// シーズン選択に関係する言語
+// シーズンリストの内側のキーに無い言語は選択の結果を変えないため、指定がないものと同じにする
+func selectionLang(seasons map[string]map[string]SeasonData, lang string) string {
+	if lang == "" {
+		return ""
+	}
+	for _, season := range seasons {
+		if _, ok := season[lang]; ok {
+			return lang
+		}
+	}
+	return ""
+}
+
+// シーズン選択の結果
+// Ts1はシーズン中もランキングファイルが更新されるたびに変わるため、シーズンデータそのものではなく
+// シーズンリストのどのキーのシーズンを選んだかだけを保持する
+type seasonSelection struct {
+	listKey   string
+	seasonKey string
+	expiresAt time.Time
+}
+
+// シーズン選択の結果のキャッシュ
+// シーズンの終了日時まで有効で、終了したら自動的に選び直す
+// ソフトとルールの指定ごとにエントリができるため、CacheMaxEntriesを超えたら最も長く使われていないものから破棄する
+type seasonSelectionCache struct {
+	entries *ttlCache
+}
+
+func newSeasonSelectionCache(maxEntries int) *seasonSelectionCache {
+	return &seasonSelectionCache{entries: newTTLCache(maxEntries)}
+}
+
+var selectionCache = newSeasonSelectionCache(CacheMaxEntries)
+
+// 選択済みのシーズンをシーズンリストから引く
+// シーズンリストに無くなっていれば選び直すためfalseを返す
+func (c *seasonSelectionCache) lookup(key string, seasons map[string]map[string]SeasonData, now time.Time) (SeasonData, bool) {
+	// 有効期間はシーズンの終了日時で判定する
+	cached, ok := c.entries.getStale(key)
+	if !ok {
+		return SeasonData{}, false
+	}
+	entry := cached.value.(seasonSelection)
+	if !now.Before(entry.expiresAt) {
+		return SeasonData{}, false
+	}
+	seasonData, ok := seasons[entry.listKey][entry.seasonKey]
+	return seasonData, ok
+}
+
+func (c *seasonSelectionCache) set(key string, seasonData SeasonData) {
+	// 終了したシーズンは猶予の間に次のシーズンが始まれば選び直すためキャッシュしない
+	if seasonData.Final {
+		return
+	}
+	// 終了日時が読めない場合はキャッシュしない
+	end, err := parseSeasonTime(seasonData.End)
+	if err != nil {
+		return
+	}
+	c.entries.set(key, seasonSelection{listKey: seasonData.listKey, seasonKey: seasonData.seasonKey, expiresAt: end}, time.Now())
+}
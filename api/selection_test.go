@@ -0,0 +1,156 @@
+package Handler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSelectionKey(t *testing.T) {
+	single, double := 0, 1
+	tests := []struct {
+		soft string
+		rule *int
+		lang string
+		want string
+	}{
+		{soft: "Sc", want: "Sc"},
+		{soft: "Sc", rule: &single, want: "Sc/0"},
+		{soft: "Sc", rule: &double, want: "Sc/1"},
+		{soft: "Vi", rule: &double, lang: "en", want: "Vi/1@en"},
+		{soft: "Sc", lang: "ja", want: "Sc@ja"},
+	}
+	for _, tt := range tests {
+		if got := selectionKey(tt.soft, tt.rule, tt.lang); got != tt.want {
+			t.Errorf("selectionKey(%q, %v, %q) = %q, want %q", tt.soft, tt.rule, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestSelectionLang(t *testing.T) {
+	seasons := multiLanguageSeasons(time.Now())
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{lang: "", want: ""},
+		{lang: "ja", want: "ja"},
+		{lang: "en", want: "en"},
+		// シーズンリストに無い言語は指定がないものと同じキーにする
+		{lang: "fr", want: ""},
+		{lang: "../../etc", want: ""},
+	}
+	for _, tt := range tests {
+		if got := selectionLang(seasons, tt.lang); got != tt.want {
+			t.Errorf("selectionLang(%q) = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+}
+
+// 上限を超えたら最も長く使われていないものから破棄する
+func TestSeasonSelectionCacheIsBounded(t *testing.T) {
+	now := time.Now()
+	selected := fixtureSeason(1, "10001", 0, now)
+	selected.listKey, selected.seasonKey = "1", "10001"
+	seasons := map[string]map[string]SeasonData{"1": {"10001": selected}}
+
+	cache := newSeasonSelectionCache(2)
+	cache.set("Sc", selected)
+	cache.set("Sc/0", selected)
+	if _, ok := cache.lookup("Sc", seasons, now); !ok {
+		t.Fatal("lookup Sc missed")
+	}
+	cache.set("Sc/1", selected)
+	if _, ok := cache.lookup("Sc/0", seasons, now); ok {
+		t.Error("least recently used Sc/0 was kept")
+	}
+	for _, key := range []string{"Sc", "Sc/1"} {
+		if _, ok := cache.lookup(key, seasons, now); !ok {
+			t.Errorf("lookup %s missed", key)
+		}
+	}
+}
+
+func TestSeasonSelectionCache(t *testing.T) {
+	now := time.Now()
+	selected := fixtureSeason(1, "10001", 0, now)
+	selected.listKey, selected.seasonKey = "1", "10001"
+	final := selected
+	final.Final = true
+	noEnd := selected
+	noEnd.End = ""
+
+	// シーズンリストの最新のTs1を使う
+	updated := selected
+	updated.Ts1 = 1700000100
+	seasons := map[string]map[string]SeasonData{"1": {"10001": updated}}
+
+	tests := []struct {
+		name    string
+		set     SeasonData
+		seasons map[string]map[string]SeasonData
+		at      time.Time
+		wantOK  bool
+	}{
+		{name: "hit", set: selected, seasons: seasons, at: now, wantOK: true},
+		{name: "after the season ends", set: selected, seasons: seasons, at: now.Add(25 * time.Hour)},
+		{name: "dropped from the season list", set: selected, seasons: map[string]map[string]SeasonData{}, at: now},
+		{name: "final season is not cached", set: final, seasons: seasons, at: now},
+		{name: "unreadable end is not cached", set: noEnd, seasons: seasons, at: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newSeasonSelectionCache(CacheMaxEntries)
+			cache.set("Sc", tt.set)
+			got, ok := cache.lookup("Sc", tt.seasons, tt.at)
+			if ok != tt.wantOK {
+				t.Fatalf("lookup ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && got.Ts1 != updated.Ts1 {
+				t.Errorf("ts1 = %d, want %d from the season list", got.Ts1, updated.Ts1)
+			}
+			if _, ok := cache.lookup("Sc/1", tt.seasons, tt.at); ok {
+				t.Error("lookup for another rule hit")
+			}
+		})
+	}
+}
+
+// シーズンリストに無い言語の指定はそれぞれエントリを作らず、指定がないものと同じ選択を使う
+func TestSelectLatestSeasonUnknownLanguages(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	upstream.seasons = multiLanguageSeasons(time.Now())
+
+	for i, lang := range []string{"fr", "de", "xx", ""} {
+		var status fetchStatus
+		_, err := selectLatestSeason(context.Background(), rankingQuery{lang: lang, maxAge: time.Minute, budget: newRetryBudget(RetryBudget)}, &status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wantCached := i > 0; status.selectionCached != wantCached {
+			t.Errorf("lang %q: selection cached = %v, want %v", lang, status.selectionCached, wantCached)
+		}
+	}
+	if got := selectionCache.entries.order.Len(); got != 1 {
+		t.Errorf("selection cache has %d entries, want 1", got)
+	}
+}
+
+// 2回目以降はシーズンリストを走査せずに選択済みのシーズンを使う
+func TestSelectLatestSeasonCachesSelection(t *testing.T) {
+	newFakeUpstream(t)
+
+	for i, wantCached := range []bool{false, true, true} {
+		var status fetchStatus
+		seasonData, err := selectLatestSeason(context.Background(), rankingQuery{maxAge: time.Minute, budget: newRetryBudget(RetryBudget)}, &status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seasonData.Season != 1 || seasonData.Soft != defaultSoft {
+			t.Errorf("call %d: got season %d soft %q, want season 1 soft %q", i, seasonData.Season, seasonData.Soft, defaultSoft)
+		}
+		if status.selectionCached != wantCached {
+			t.Errorf("call %d: selection cached = %v, want %v", i, status.selectionCached, wantCached)
+		}
+	}
+}
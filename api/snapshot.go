@@ -0,0 +1,340 @@
+package Handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指定したスナップショットが存在しない
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// ある時点のランキングのスナップショット
+type Snapshot struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Ranking   RankingResponse `json:"ranking"`
+	// 間引きで削除したスナップショットを含めた、この区間のボーダーの集計
+	Cutoffs []CutoffAggregate `json:"cutoffs,omitempty"`
+}
+
+// 中身を読み込まなくても分かるスナップショットの情報
+type SnapshotInfo struct {
+	Timestamp time.Time
+	Season    int
+}
+
+func snapshotInfo(snapshot Snapshot) SnapshotInfo {
+	return SnapshotInfo{Timestamp: snapshot.Timestamp, Season: snapshot.Ranking.SeasonData.Season}
+}
+
+// スナップショットの絞り込み条件
+// ゼロ値の項目は条件に含めない
+type SnapshotFilter struct {
+	Season int
+	From   time.Time
+	To     time.Time
+}
+
+func (f SnapshotFilter) match(info SnapshotInfo) bool {
+	if f.Season != 0 && info.Season != f.Season {
+		return false
+	}
+	return inTimeRange(info.Timestamp, f.From, f.To)
+}
+
+// 情報の一覧の時刻
+func snapshotTimestamps(infos []SnapshotInfo) []time.Time {
+	timestamps := make([]time.Time, len(infos))
+	for i, info := range infos {
+		timestamps[i] = info.Timestamp
+	}
+	return timestamps
+}
+
+// tsがfromからtoまでの間にあるか
+// ゼロ値の時刻は条件に含めない
+func inTimeRange(ts, from, to time.Time) bool {
+	if !from.IsZero() && ts.Before(from) {
+		return false
+	}
+	if !to.IsZero() && ts.After(to) {
+		return false
+	}
+	return true
+}
+
+// スナップショットの保存先
+// 保存先ごとに実装を用意すればハンドラーはそのまま差し替えられる
+type SnapshotStore interface {
+	Save(ctx context.Context, snapshot Snapshot) error
+	// 条件に合うスナップショットを古い順に返す
+	List(ctx context.Context, filter SnapshotFilter) ([]Snapshot, error)
+	// 条件に合うスナップショットの情報を古い順に返す
+	// 中身は返さないため、必要なものだけGetで取得する
+	Index(ctx context.Context, filter SnapshotFilter) ([]SnapshotInfo, error)
+	// 指定時刻のスナップショットを返し、なければErrSnapshotNotFoundを返す
+	Get(ctx context.Context, ts time.Time) (Snapshot, error)
+	// 指定時刻のスナップショットを削除し、なければErrSnapshotNotFoundを返す
+	Delete(ctx context.Context, ts time.Time) error
+}
+
+// メモリ上に保持するスナップショットの保存先
+type MemorySnapshotStore struct {
+	mu        sync.RWMutex
+	snapshots []Snapshot
+}
+
+func NewMemorySnapshotStore() *MemorySnapshotStore {
+	return &MemorySnapshotStore{}
+}
+
+func (s *MemorySnapshotStore) Save(ctx context.Context, snapshot Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 時刻順を保って挿入し、同じ時刻のものは上書きする
+	i := sort.Search(len(s.snapshots), func(i int) bool {
+		return !s.snapshots[i].Timestamp.Before(snapshot.Timestamp)
+	})
+	if i < len(s.snapshots) && s.snapshots[i].Timestamp.Equal(snapshot.Timestamp) {
+		s.snapshots[i] = snapshot
+		return nil
+	}
+	s.snapshots = append(s.snapshots, Snapshot{})
+	copy(s.snapshots[i+1:], s.snapshots[i:])
+	s.snapshots[i] = snapshot
+	return nil
+}
+
+func (s *MemorySnapshotStore) List(ctx context.Context, filter SnapshotFilter) ([]Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []Snapshot{}
+	for _, snapshot := range s.snapshots {
+		if filter.match(snapshotInfo(snapshot)) {
+			result = append(result, snapshot)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemorySnapshotStore) Index(ctx context.Context, filter SnapshotFilter) ([]SnapshotInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []SnapshotInfo{}
+	for _, snapshot := range s.snapshots {
+		if info := snapshotInfo(snapshot); filter.match(info) {
+			result = append(result, info)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemorySnapshotStore) Get(ctx context.Context, ts time.Time) (Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return Snapshot{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, snapshot := range s.snapshots {
+		if snapshot.Timestamp.Equal(ts) {
+			return snapshot, nil
+		}
+	}
+	return Snapshot{}, ErrSnapshotNotFound
+}
+
+func (s *MemorySnapshotStore) Delete(ctx context.Context, ts time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, snapshot := range s.snapshots {
+		if snapshot.Timestamp.Equal(ts) {
+			s.snapshots = append(s.snapshots[:i], s.snapshots[i+1:]...)
+			return nil
+		}
+	}
+	return ErrSnapshotNotFound
+}
+
+// スナップショットのファイル名の時刻の形式
+// 名前順が時刻順になるようにUTCで桁数を揃える
+const snapshotFileTimeFormat = "20060102T150405.000000000Z"
+
+// ディレクトリにスナップショットを1件ずつ時刻を名前にしたJSONファイルとして保存する保存先
+type FileSnapshotStore struct {
+	dir string
+
+	// ファイル名ごとのスナップショットの情報
+	// シーズンはファイルを読まないと分からないため、一度読んだものを覚えておく
+	mu    sync.Mutex
+	infos map[string]SnapshotInfo
+}
+
+func NewFileSnapshotStore(dir string) (*FileSnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+	return &FileSnapshotStore{dir: dir, infos: map[string]SnapshotInfo{}}, nil
+}
+
+func (s *FileSnapshotStore) path(ts time.Time) string {
+	return filepath.Join(s.dir, ts.UTC().Format(snapshotFileTimeFormat)+".json")
+}
+
+func (s *FileSnapshotStore) Save(ctx context.Context, snapshot Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %v", err)
+	}
+	// 書き込み途中のファイルを読まないように、一時ファイルに書き終えてから置き換える
+	f, err := os.CreateTemp(s.dir, ".snapshot-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write snapshot file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write snapshot file: %v", err)
+	}
+	if err := os.Rename(f.Name(), s.path(snapshot.Timestamp)); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to rename snapshot file: %v", err)
+	}
+	s.mu.Lock()
+	s.infos[filepath.Base(s.path(snapshot.Timestamp))] = snapshotInfo(snapshot)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *FileSnapshotStore) List(ctx context.Context, filter SnapshotFilter) ([]Snapshot, error) {
+	// 条件に合わないものは読み込まない
+	infos, err := s.Index(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	result := []Snapshot{}
+	for _, info := range infos {
+		snapshot, err := s.Get(ctx, info.Timestamp)
+		if errors.Is(err, ErrSnapshotNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, snapshot)
+	}
+	return result, nil
+}
+
+// 時刻はファイル名から読み取り、シーズンは覚えていないファイルだけ読み込む
+func (s *FileSnapshotStore) Index(ctx context.Context, filter SnapshotFilter) ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := []SnapshotInfo{}
+	seen := make(map[string]bool, len(entries))
+	// ReadDirは名前順に返すため時刻順になる
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		ts, err := time.Parse(snapshotFileTimeFormat, name)
+		if err != nil {
+			continue
+		}
+		// 時刻だけで外れるものはシーズンを調べない
+		if !inTimeRange(ts, filter.From, filter.To) {
+			continue
+		}
+		seen[entry.Name()] = true
+		info, ok := s.infos[entry.Name()]
+		if !ok {
+			snapshot, err := s.Get(ctx, ts)
+			if errors.Is(err, ErrSnapshotNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			info = snapshotInfo(snapshot)
+			s.infos[entry.Name()] = info
+		}
+		if filter.match(info) {
+			result = append(result, info)
+		}
+	}
+	// 他から削除されたファイルの情報は忘れる
+	for name, info := range s.infos {
+		if !seen[name] && inTimeRange(info.Timestamp, filter.From, filter.To) {
+			delete(s.infos, name)
+		}
+	}
+	return result, nil
+}
+
+func (s *FileSnapshotStore) Get(ctx context.Context, ts time.Time) (Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return Snapshot{}, err
+	}
+	data, err := os.ReadFile(s.path(ts))
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to read snapshot file: %v", err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decode snapshot file: %v", err)
+	}
+	return snapshot, nil
+}
+
+func (s *FileSnapshotStore) Delete(ctx context.Context, ts time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(ts))
+	s.mu.Lock()
+	delete(s.infos, filepath.Base(s.path(ts)))
+	s.mu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrSnapshotNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete snapshot file: %v", err)
+	}
+	return nil
+}
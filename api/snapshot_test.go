@@ -0,0 +1,243 @@
+package Handler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// テスト用の保存先の実装
+var snapshotStores = []struct {
+	name string
+	new  func(t *testing.T) SnapshotStore
+}{
+	{name: "memory", new: func(t *testing.T) SnapshotStore { return NewMemorySnapshotStore() }},
+	{name: "file", new: func(t *testing.T) SnapshotStore {
+		store, err := NewFileSnapshotStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}},
+}
+
+// 指定した時刻とシーズンのスナップショット
+func fixtureSnapshot(ts time.Time, season int) Snapshot {
+	return Snapshot{
+		Timestamp: ts,
+		Ranking: RankingResponse{
+			SeasonData: SeasonData{Season: season},
+			Top1000:    fixtureRows(1, 3),
+		},
+	}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return base.Add(time.Duration(hours) * time.Hour) }
+
+	for _, store := range snapshotStores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.new(t)
+			// 時刻順でない順に保存する
+			for _, snapshot := range []Snapshot{fixtureSnapshot(at(2), 1), fixtureSnapshot(at(0), 1), fixtureSnapshot(at(4), 2), fixtureSnapshot(at(3), 2)} {
+				if err := s.Save(ctx, snapshot); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// 同じ時刻は上書きする
+			if err := s.Save(ctx, fixtureSnapshot(at(2), 2)); err != nil {
+				t.Fatal(err)
+			}
+
+			listTests := []struct {
+				name   string
+				filter SnapshotFilter
+				want   []time.Time
+			}{
+				{name: "all", want: []time.Time{at(0), at(2), at(3), at(4)}},
+				{name: "season", filter: SnapshotFilter{Season: 2}, want: []time.Time{at(2), at(3), at(4)}},
+				{name: "from", filter: SnapshotFilter{From: at(3)}, want: []time.Time{at(3), at(4)}},
+				{name: "to", filter: SnapshotFilter{To: at(2)}, want: []time.Time{at(0), at(2)}},
+				{name: "season and range", filter: SnapshotFilter{Season: 1, From: at(0), To: at(3)}, want: []time.Time{at(0)}},
+				{name: "none", filter: SnapshotFilter{Season: 3}, want: []time.Time{}},
+			}
+			for _, tt := range listTests {
+				t.Run("list "+tt.name, func(t *testing.T) {
+					snapshots, err := s.List(ctx, tt.filter)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got := make([]time.Time, len(snapshots))
+					for i, snapshot := range snapshots {
+						got[i] = snapshot.Timestamp
+					}
+					assertTimes(t, got, tt.want)
+				})
+			}
+
+			indexTests := []struct {
+				name   string
+				filter SnapshotFilter
+				want   []SnapshotInfo
+			}{
+				{name: "range", filter: SnapshotFilter{From: at(1), To: at(3)}, want: []SnapshotInfo{{Timestamp: at(2), Season: 2}, {Timestamp: at(3), Season: 2}}},
+				{name: "season", filter: SnapshotFilter{Season: 1}, want: []SnapshotInfo{{Timestamp: at(0), Season: 1}}},
+			}
+			for _, tt := range indexTests {
+				t.Run("index "+tt.name, func(t *testing.T) {
+					got, err := s.Index(ctx, tt.filter)
+					if err != nil {
+						t.Fatal(err)
+					}
+					assertTimes(t, snapshotTimestamps(got), snapshotTimestamps(tt.want))
+					for i := range got {
+						if got[i].Season != tt.want[i].Season {
+							t.Errorf("season of %v = %d, want %d", got[i].Timestamp, got[i].Season, tt.want[i].Season)
+						}
+					}
+				})
+			}
+
+			t.Run("get", func(t *testing.T) {
+				snapshot, err := s.Get(ctx, at(2))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if snapshot.Ranking.SeasonData.Season != 2 || len(snapshot.Ranking.Top1000) != 3 {
+					t.Errorf("got season %d with %d rows, want the overwritten season 2 with 3 rows", snapshot.Ranking.SeasonData.Season, len(snapshot.Ranking.Top1000))
+				}
+				if _, err := s.Get(ctx, at(1)); !errors.Is(err, ErrSnapshotNotFound) {
+					t.Errorf("get missing err = %v, want %v", err, ErrSnapshotNotFound)
+				}
+			})
+
+			t.Run("delete", func(t *testing.T) {
+				if err := s.Delete(ctx, at(0)); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Delete(ctx, at(0)); !errors.Is(err, ErrSnapshotNotFound) {
+					t.Errorf("second delete err = %v, want %v", err, ErrSnapshotNotFound)
+				}
+				got, err := s.Index(ctx, SnapshotFilter{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				assertTimes(t, snapshotTimestamps(got), []time.Time{at(2), at(3), at(4)})
+			})
+
+			t.Run("canceled", func(t *testing.T) {
+				canceled, cancel := context.WithCancel(ctx)
+				cancel()
+				if err := s.Save(canceled, fixtureSnapshot(at(5), 1)); !errors.Is(err, context.Canceled) {
+					t.Errorf("save err = %v, want %v", err, context.Canceled)
+				}
+				if _, err := s.Get(canceled, at(2)); !errors.Is(err, context.Canceled) {
+					t.Errorf("get err = %v, want %v", err, context.Canceled)
+				}
+			})
+		})
+	}
+}
+
+func assertTimes(t *testing.T, got, want []time.Time) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if !got[i].Equal(want[i]) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+// 一時ファイルに書いてから名前を変えるため、書きかけのファイルは読まれず、保存後にも残らない
+func TestFileSnapshotStoreAtomicWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileSnapshotStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	// 途中で止まった書き込みの一時ファイル
+	if err := os.WriteFile(filepath.Join(dir, ".snapshot-crashed.tmp"), []byte(`{"timestamp":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := store.Save(ctx, fixtureSnapshot(ts.Add(time.Duration(i)*time.Hour), 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{".snapshot-crashed.tmp"}
+	for i := 0; i < 3; i++ {
+		want = append(want, filepath.Base(store.path(ts.Add(time.Duration(i)*time.Hour))))
+	}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+
+	snapshots, err := store.List(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 {
+		t.Errorf("listed %d snapshots, want 3 without the temporary file", len(snapshots))
+	}
+}
+
+// 他のプロセスが書いたファイルは一度だけ読んでシーズンを覚え、消されたファイルは一覧から外す
+func TestFileSnapshotStoreIndex(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writer, err := NewFileSnapshotStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, season := range []int{1, 2, 2} {
+		if err := writer.Save(ctx, fixtureSnapshot(ts.Add(time.Duration(i)*time.Hour), season)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := NewFileSnapshotStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Index(ctx, SnapshotFilter{Season: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTimes(t, snapshotTimestamps(got), []time.Time{ts.Add(time.Hour), ts.Add(2 * time.Hour)})
+	if len(store.infos) != 3 {
+		t.Errorf("remembered %d snapshots, want 3", len(store.infos))
+	}
+
+	if err := os.Remove(store.path(ts.Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	got, err = store.Index(ctx, SnapshotFilter{Season: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTimes(t, snapshotTimestamps(got), []time.Time{ts.Add(2 * time.Hour)})
+	if len(store.infos) != 2 {
+		t.Errorf("remembered %d snapshots after removal, want 2", len(store.infos))
+	}
+}
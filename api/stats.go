@@ -0,0 +1,491 @@
+package Handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 指定がない場合に返すパーセンタイル
+var defaultPercentiles = []float64{1, 5, 10, 25, 50, 75, 90, 95, 99}
+
+// パーセンタイルごとのレート
+type PercentilesResponse struct {
+	SeasonData  SeasonData        `json:"season_data"`
+	Percentiles []PercentileValue `json:"percentiles"`
+}
+
+type PercentileValue struct {
+	Percentile  float64 `json:"percentile"`
+	RatingValue float64 `json:"rating_value"`
+}
+
+// レートを昇順に並べて取得
+func sortedRatings(rankingData []RankResponseRawData) []float64 {
+	ratings := make([]float64, len(rankingData))
+	for i, data := range rankingData {
+		ratings[i] = data.RatingValue
+	}
+	sort.Float64s(ratings)
+	return ratings
+}
+
+// 昇順に並んだレートのpパーセンタイルを線形補間で計算
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p / 100 * float64(len(sorted)-1)
+	lower := int(pos)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (sorted[lower+1]-sorted[lower])*(pos-float64(lower))
+}
+
+// NaNと無限大でない数値か
+// NaNとの比較は常にfalseになり範囲の確認をすり抜けるため、範囲を確認する前に弾く
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// カンマ区切りのパーセンタイルを解析
+func parsePercentiles(v string) ([]float64, error) {
+	if v == "" {
+		return defaultPercentiles, nil
+	}
+	var result []float64
+	for _, s := range strings.Split(v, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || !isFinite(p) || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid percentile %q: must be between 0 and 100", s)
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// endpoint handler
+func PercentilesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	percentiles, err := parsePercentiles(r.URL.Query().Get("p"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/percentiles")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	ratings := sortedRatings(ranking.Top1000)
+	response := PercentilesResponse{
+		SeasonData:  ranking.SeasonData,
+		Percentiles: make([]PercentileValue, len(percentiles)),
+	}
+	for i, p := range percentiles {
+		response.Percentiles[i] = PercentileValue{Percentile: p, RatingValue: percentile(ratings, p)}
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// 閾値を指定しない場合に最低レートから最高レートまでを等間隔に分ける数
+const defaultCDFPoints = 10
+
+// 閾値ごとのその閾値以上のトレーナー数と割合
+// 閾値の昇順に並び、件数は閾値が上がるほど減る
+type CDFResponse struct {
+	SeasonData SeasonData `json:"season_data"`
+	Total      int        `json:"total"`
+	Points     []CDFPoint `json:"points"`
+}
+
+type CDFPoint struct {
+	Threshold float64 `json:"threshold"`
+	Count     int     `json:"count"`
+	Fraction  float64 `json:"fraction"`
+}
+
+// カンマ区切りの閾値を解析し、昇順に並べて重複を取り除く
+// 指定がなければnil
+func parseThresholds(v string) ([]float64, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var result []float64
+	for _, s := range strings.Split(v, ",") {
+		t, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || !isFinite(t) {
+			return nil, fmt.Errorf("invalid threshold %q", s)
+		}
+		result = append(result, t)
+	}
+	sort.Float64s(result)
+	unique := result[:1]
+	for _, t := range result[1:] {
+		if t != unique[len(unique)-1] {
+			unique = append(unique, t)
+		}
+	}
+	return unique, nil
+}
+
+// 最低レートから最高レートまでを等間隔に分けた閾値
+func autoThresholds(sorted []float64, n int) []float64 {
+	if len(sorted) == 0 {
+		return []float64{}
+	}
+	low, high := sorted[0], sorted[len(sorted)-1]
+	if low == high || n < 2 {
+		return []float64{low}
+	}
+	thresholds := make([]float64, n)
+	step := (high - low) / float64(n-1)
+	for i := range thresholds {
+		thresholds[i] = low + step*float64(i)
+	}
+	thresholds[n-1] = high
+	return thresholds
+}
+
+// 昇順に並んだレートから閾値ごとの件数を求める
+func computeCDF(sorted []float64, thresholds []float64) []CDFPoint {
+	points := make([]CDFPoint, len(thresholds))
+	for i, t := range thresholds {
+		count := len(sorted) - sort.SearchFloat64s(sorted, t)
+		points[i] = CDFPoint{Threshold: t, Count: count}
+		if len(sorted) > 0 {
+			points[i].Fraction = float64(count) / float64(len(sorted))
+		}
+	}
+	return points
+}
+
+// endpoint handler
+func CDFHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	thresholds, err := parseThresholds(r.URL.Query().Get("thresholds"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/cdf")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	ratings := sortedRatings(ranking.Top1000)
+	if thresholds == nil {
+		thresholds = autoThresholds(ratings, defaultCDFPoints)
+	}
+	response := CDFResponse{
+		SeasonData: ranking.SeasonData,
+		Total:      len(ratings),
+		Points:     computeCDF(ratings, thresholds),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// レートの区分
+// MinRating以上のトレーナーが入る
+type RatingTier struct {
+	Name      string
+	MinRating float64
+}
+
+// どの区分の下限にも満たないトレーナーが入る区分
+const defaultTierName = "Others"
+
+var defaultRatingTiers = []RatingTier{
+	{Name: "Master", MinRating: 1900},
+	{Name: "Expert", MinRating: 1800},
+	{Name: "Advanced", MinRating: 1700},
+}
+
+// RATING_TIERS="Master=1900,Expert=1800" のように指定し、指定がなければdefaultRatingTiersを使う
+var RatingTiers = parseRatingTiers(os.Getenv("RATING_TIERS"))
+
+// 下限の高い順に並べる
+func parseRatingTiers(v string) []RatingTier {
+	if v == "" {
+		return defaultRatingTiers
+	}
+	var tiers []RatingTier
+	for _, pair := range strings.Split(v, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			log.Printf("invalid RATING_TIERS entry %q", pair)
+			continue
+		}
+		minRating, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Printf("invalid RATING_TIERS entry %q: %v", pair, err)
+			continue
+		}
+		tiers = append(tiers, RatingTier{Name: name, MinRating: minRating})
+	}
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].MinRating > tiers[j].MinRating })
+	return tiers
+}
+
+// 区分ごとのトレーナー
+type TiersResponse struct {
+	SeasonData SeasonData  `json:"season_data"`
+	Tiers      []TierGroup `json:"tiers"`
+}
+
+// MinRatingはどの区分にも入らないトレーナーの区分ではnull
+type TierGroup struct {
+	Name      string                `json:"name"`
+	MinRating *float64              `json:"min_rating"`
+	Count     int                   `json:"count"`
+	Members   []RankResponseRawData `json:"members"`
+}
+
+// トレーナーを下限の高い区分から順に振り分ける
+func groupByTier(rankingData []RankResponseRawData, tiers []RatingTier) []TierGroup {
+	groups := make([]TierGroup, len(tiers)+1)
+	for i, tier := range tiers {
+		minRating := tier.MinRating
+		groups[i] = TierGroup{Name: tier.Name, MinRating: &minRating, Members: []RankResponseRawData{}}
+	}
+	groups[len(tiers)] = TierGroup{Name: defaultTierName, Members: []RankResponseRawData{}}
+
+	for _, data := range rankingData {
+		i := sort.Search(len(tiers), func(i int) bool { return data.RatingValue >= tiers[i].MinRating })
+		groups[i].Members = append(groups[i].Members, data)
+	}
+	for i := range groups {
+		groups[i].Count = len(groups[i].Members)
+	}
+	return groups
+}
+
+// endpoint handler
+func TiersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/tiers")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	response := TiersResponse{
+		SeasonData: ranking.SeasonData,
+		Tiers:      groupByTier(ranking.Top1000, RatingTiers),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// レートの統計
+// 行が無い場合はCount以外は0
+type RankingStats struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	// 母標準偏差
+	StdDev float64 `json:"std_dev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	P10    float64 `json:"p10"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
+}
+
+type StatsResponse struct {
+	SeasonData SeasonData   `json:"season_data"`
+	Stats      RankingStats `json:"stats"`
+}
+
+// ランキングのレートの統計を計算
+func computeRankingStats(rankingData []RankResponseRawData) RankingStats {
+	ratings := sortedRatings(rankingData)
+	stats := RankingStats{Count: len(ratings)}
+	if len(ratings) == 0 {
+		return stats
+	}
+	sum := 0.0
+	for _, r := range ratings {
+		sum += r
+	}
+	stats.Mean = sum / float64(len(ratings))
+	variance := 0.0
+	for _, r := range ratings {
+		variance += (r - stats.Mean) * (r - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(variance / float64(len(ratings)))
+	stats.Min = ratings[0]
+	stats.Max = ratings[len(ratings)-1]
+	stats.Median = percentile(ratings, 50)
+	stats.P10 = percentile(ratings, 10)
+	stats.P50 = stats.Median
+	stats.P90 = percentile(ratings, 90)
+	stats.P99 = percentile(ratings, 99)
+	return stats
+}
+
+// endpoint handler
+func StatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/stats")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	response := StatsResponse{
+		SeasonData: ranking.SeasonData,
+		Stats:      computeRankingStats(ranking.Top1000),
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
+
+// ヒストグラムの区間の幅のデフォルト
+const defaultHistogramBucket = 25
+
+// ヒストグラムの区間の数の上限
+// 幅を極端に小さくしても大量の区間を返さないようにする
+const maxHistogramBuckets = 1000
+
+// レートの区間ごとのトレーナー数
+// 区間はレートの昇順に並び、件数の合計はTotalと一致する
+type HistogramResponse struct {
+	SeasonData SeasonData        `json:"season_data"`
+	Bucket     float64           `json:"bucket"`
+	Total      int               `json:"total"`
+	Buckets    []HistogramBucket `json:"buckets"`
+}
+
+// Min以上Max未満のトレーナー数
+type HistogramBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// 昇順に並んだレートを、幅widthの倍数を境目にした区間に分ける
+// 最低レートの区間から最高レートの区間までを、トレーナーがいない区間も含めて返す
+func computeHistogram(sorted []float64, width float64) ([]HistogramBucket, error) {
+	if !isFinite(width) || width <= 0 {
+		return nil, fmt.Errorf("bucket %v must be a finite number greater than 0", width)
+	}
+	if len(sorted) == 0 {
+		return []HistogramBucket{}, nil
+	}
+	start := math.Floor(sorted[0]/width) * width
+	// 幅が極端に小さいとintに変換したときに桁あふれするため、変換する前に上限と比べる
+	count := math.Floor((sorted[len(sorted)-1]-start)/width) + 1
+	if !isFinite(count) || count > maxHistogramBuckets {
+		return nil, fmt.Errorf("bucket %v is too small: at most %d buckets can be returned", width, maxHistogramBuckets)
+	}
+	n := int(count)
+	buckets := make([]HistogramBucket, n)
+	for i := range buckets {
+		buckets[i].Min = start + width*float64(i)
+		buckets[i].Max = start + width*float64(i+1)
+	}
+	for _, rating := range sorted {
+		i := int(math.Floor((rating - start) / width))
+		// 浮動小数点の誤差で最後の区間を超えた場合は最後の区間に入れる
+		if i >= n {
+			i = n - 1
+		}
+		buckets[i].Count++
+	}
+	return buckets, nil
+}
+
+// endpoint handler
+func HistogramHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	width := float64(defaultHistogramBucket)
+	if v := r.URL.Query().Get("bucket"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !isFinite(f) || f <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid bucket parameter: must be greater than 0")
+			return
+		}
+		width = f
+	}
+
+	ranking, _, err := fetchLatestRanking(r.Context(), rankingQuery{depth: 1, maxAge: cacheTTL("/rankings/histogram")})
+	if err != nil {
+		writeJSONError(w, rankingErrorStatus(err), err.Error())
+		return
+	}
+
+	ratings := sortedRatings(ranking.Top1000)
+	buckets, err := computeHistogram(ratings, width)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	response := HistogramResponse{
+		SeasonData: ranking.SeasonData,
+		Bucket:     width,
+		Total:      len(ratings),
+		Buckets:    buckets,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+}
@@ -0,0 +1,387 @@
+package Handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// 浮動小数点数の計算結果の比較で許す誤差
+const floatTolerance = 1e-9
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{p: 0, want: 10},
+		{p: 25, want: 20},
+		{p: 50, want: 30},
+		{p: 60, want: 34},
+		{p: 100, want: 50},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); math.Abs(got-tt.want) > floatTolerance {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of no ratings = %v, want 0", got)
+	}
+}
+
+func TestPercentilesHandler(t *testing.T) {
+	// 1000位が1100、1位が2099で、昇順に並べると1100から1ずつ上がる
+	newFakeUpstream(t)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       []PercentileValue
+	}{
+		{target: "/rankings/percentiles?p=0,50,100", wantStatus: http.StatusOK, want: []PercentileValue{{0, 1100}, {50, 1599.5}, {100, 2099}}},
+		{target: "/rankings/percentiles?p=10,%2090", wantStatus: http.StatusOK, want: []PercentileValue{{10, 1199.9}, {90, 1999.1}}},
+		{target: "/rankings/percentiles", wantStatus: http.StatusOK, want: []PercentileValue{
+			{1, 1109.99}, {5, 1149.95}, {10, 1199.9}, {25, 1349.75}, {50, 1599.5}, {75, 1849.25}, {90, 1999.1}, {95, 2049.05}, {99, 2089.01},
+		}},
+		{target: "/rankings/percentiles?p=101", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/percentiles?p=-1", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/percentiles?p=NaN", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/percentiles?p=50,x", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, PercentilesHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response PercentilesResponse
+			decodeBody(t, rec, &response)
+			if len(response.Percentiles) != len(tt.want) {
+				t.Fatalf("percentiles = %v, want %v", response.Percentiles, tt.want)
+			}
+			for i, want := range tt.want {
+				got := response.Percentiles[i]
+				if got.Percentile != want.Percentile || math.Abs(got.RatingValue-want.RatingValue) > 1e-6 {
+					t.Errorf("percentiles[%d] = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCDFHandler(t *testing.T) {
+	// r位のレートは2100-rで、1100から2099まで1ずつ
+	newFakeUpstream(t)
+	// 指定しなければ1100から2099までを111ずつ10点に分ける
+	auto := make([]CDFPoint, defaultCDFPoints)
+	for i := range auto {
+		count := 1000 - 111*i
+		auto[i] = CDFPoint{Threshold: float64(1100 + 111*i), Count: count, Fraction: float64(count) / 1000}
+	}
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       []CDFPoint
+	}{
+		{target: "/rankings/cdf?thresholds=1100,1500,2000,2099,2100", wantStatus: http.StatusOK, want: []CDFPoint{
+			{1100, 1000, 1}, {1500, 600, 0.6}, {2000, 100, 0.1}, {2099, 1, 0.001}, {2100, 0, 0},
+		}},
+		{target: "/rankings/cdf?thresholds=2000,%201500,1500", wantStatus: http.StatusOK, want: []CDFPoint{{1500, 600, 0.6}, {2000, 100, 0.1}}},
+		{target: "/rankings/cdf?thresholds=1999.5", wantStatus: http.StatusOK, want: []CDFPoint{{1999.5, 100, 0.1}}},
+		{target: "/rankings/cdf", wantStatus: http.StatusOK, want: auto},
+		{target: "/rankings/cdf?thresholds=1500,x", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/cdf?thresholds=Inf", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(t, CDFHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response CDFResponse
+			decodeBody(t, rec, &response)
+			if response.Total != 1000 {
+				t.Errorf("total = %d, want 1000", response.Total)
+			}
+			if len(response.Points) != len(tt.want) {
+				t.Fatalf("points = %v, want %v", response.Points, tt.want)
+			}
+			for i, want := range tt.want {
+				got := response.Points[i]
+				if math.Abs(got.Threshold-want.Threshold) > floatTolerance || got.Count != want.Count || math.Abs(got.Fraction-want.Fraction) > floatTolerance {
+					t.Errorf("points[%d] = %+v, want %+v", i, got, want)
+				}
+			}
+			// 閾値が上がるほど件数は減る
+			for i := 1; i < len(response.Points); i++ {
+				prev, point := response.Points[i-1], response.Points[i]
+				if point.Threshold <= prev.Threshold || point.Count > prev.Count {
+					t.Errorf("points are not monotonic: %v", response.Points)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestParseRatingTiers(t *testing.T) {
+	got := parseRatingTiers("Expert=1800, Master=1900,bad,=1500,Low=x")
+	want := []RatingTier{{Name: "Master", MinRating: 1900}, {Name: "Expert", MinRating: 1800}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("tiers = %v, want %v", got, want)
+	}
+}
+
+func TestTiersHandler(t *testing.T) {
+	// r位のレートは2100-r
+	newFakeUpstream(t)
+	saved := RatingTiers
+	t.Cleanup(func() { RatingTiers = saved })
+
+	type tierWant struct {
+		name      string
+		count     int
+		firstRank int
+		lastRank  int
+	}
+	tests := []struct {
+		name  string
+		tiers []RatingTier
+		want  []tierWant
+	}{
+		{name: "default", tiers: defaultRatingTiers, want: []tierWant{
+			{name: "Master", count: 200, firstRank: 1, lastRank: 200},
+			{name: "Expert", count: 100, firstRank: 201, lastRank: 300},
+			{name: "Advanced", count: 100, firstRank: 301, lastRank: 400},
+			{name: defaultTierName, count: 600, firstRank: 401, lastRank: 1000},
+		}},
+		{name: "empty tier", tiers: []RatingTier{{Name: "Legend", MinRating: 2500}, {Name: "Top", MinRating: 2000.5}}, want: []tierWant{
+			{name: "Legend"},
+			{name: "Top", count: 99, firstRank: 1, lastRank: 99},
+			{name: defaultTierName, count: 901, firstRank: 100, lastRank: 1000},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RatingTiers = tt.tiers
+			rec := get(t, TiersHandler, "/rankings/tiers")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var response TiersResponse
+			decodeBody(t, rec, &response)
+			if len(response.Tiers) != len(tt.want) {
+				t.Fatalf("tiers = %d, want %d", len(response.Tiers), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := response.Tiers[i]
+				if got.Name != want.name || got.Count != want.count || len(got.Members) != want.count {
+					t.Errorf("tiers[%d] = %s with %d (%d members), want %s with %d", i, got.Name, got.Count, len(got.Members), want.name, want.count)
+					continue
+				}
+				if got.Members == nil {
+					t.Errorf("%s members is null, want an array", got.Name)
+				}
+				if want.count > 0 && (got.Members[0].Rank != want.firstRank || got.Members[want.count-1].Rank != want.lastRank) {
+					t.Errorf("%s = ranks %d to %d, want %d to %d", got.Name, got.Members[0].Rank, got.Members[want.count-1].Rank, want.firstRank, want.lastRank)
+				}
+				if wantMin := i < len(tt.tiers); (got.MinRating != nil) != wantMin {
+					t.Errorf("%s min_rating = %v, want set %v", got.Name, got.MinRating, wantMin)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeRankingStats(t *testing.T) {
+	withRatings := func(ratings ...float64) []RankResponseRawData {
+		rows := make([]RankResponseRawData, len(ratings))
+		for i, rating := range ratings {
+			rows[i] = RankResponseRawData{Rank: i + 1, RatingValue: rating}
+		}
+		return rows
+	}
+
+	tests := []struct {
+		name string
+		rows []RankResponseRawData
+		want RankingStats
+	}{
+		{
+			name: "unsorted",
+			rows: withRatings(1800, 1500, 2000, 1700, 1600),
+			want: RankingStats{Count: 5, Mean: 1720, Median: 1700, StdDev: math.Sqrt(29600), Min: 1500, Max: 2000, P10: 1540, P50: 1700, P90: 1920, P99: 1992},
+		},
+		{
+			name: "single row",
+			rows: withRatings(1650.5),
+			want: RankingStats{Count: 1, Mean: 1650.5, Median: 1650.5, Min: 1650.5, Max: 1650.5, P10: 1650.5, P50: 1650.5, P90: 1650.5, P99: 1650.5},
+		},
+		{name: "no rows", rows: []RankResponseRawData{}, want: RankingStats{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeRankingStats(tt.rows)
+			if got.Count != tt.want.Count {
+				t.Errorf("count = %d, want %d", got.Count, tt.want.Count)
+			}
+			for _, field := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"mean", got.Mean, tt.want.Mean},
+				{"median", got.Median, tt.want.Median},
+				{"std_dev", got.StdDev, tt.want.StdDev},
+				{"min", got.Min, tt.want.Min},
+				{"max", got.Max, tt.want.Max},
+				{"p10", got.P10, tt.want.P10},
+				{"p50", got.P50, tt.want.P50},
+				{"p90", got.P90, tt.want.P90},
+				{"p99", got.P99, tt.want.P99},
+			} {
+				if math.Abs(field.got-field.want) > floatTolerance {
+					t.Errorf("%s = %v, want %v", field.name, field.got, field.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows       int
+		wantCount  int
+		wantMean   float64
+		wantMedian float64
+		wantMin    float64
+		wantMax    float64
+	}{
+		// 1000位が1100、1位が2099
+		{name: "full", rows: 1000, wantCount: 1000, wantMean: 1599.5, wantMedian: 1599.5, wantMin: 1100, wantMax: 2099},
+		// 1000人に満たなくても取得できた分で計算する
+		{name: "fewer than 1000", rows: 300, wantCount: 300, wantMean: 1949.5, wantMedian: 1949.5, wantMin: 1800, wantMax: 2099},
+		{name: "one row", rows: 1, wantCount: 1, wantMean: 2099, wantMedian: 2099, wantMin: 2099, wantMax: 2099},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeUpstream(t)
+			upstream.setPage(upstream.seasons["1"]["10001"], 1, fixtureRows(1, tt.rows))
+			rec := get(t, StatsHandler, "/rankings/stats")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var response StatsResponse
+			decodeBody(t, rec, &response)
+			stats := response.Stats
+			if stats.Count != tt.wantCount || math.Abs(stats.Mean-tt.wantMean) > floatTolerance || math.Abs(stats.Median-tt.wantMedian) > floatTolerance {
+				t.Errorf("got count %d mean %v median %v, want %d %v %v", stats.Count, stats.Mean, stats.Median, tt.wantCount, tt.wantMean, tt.wantMedian)
+			}
+			if stats.Min != tt.wantMin || stats.Max != tt.wantMax {
+				t.Errorf("got min %v max %v, want %v %v", stats.Min, stats.Max, tt.wantMin, tt.wantMax)
+			}
+			// 1ずつ違うn件のレートの母標準偏差は√((n²-1)/12)
+			n := float64(tt.wantCount)
+			if want := math.Sqrt((n*n - 1) / 12); math.Abs(stats.StdDev-want) > 1e-6 {
+				t.Errorf("std_dev = %v, want %v", stats.StdDev, want)
+			}
+			if !(stats.Min <= stats.P10 && stats.P10 <= stats.P50 && stats.P50 <= stats.P90 && stats.P90 <= stats.P99 && stats.P99 <= stats.Max) {
+				t.Errorf("percentiles are out of order: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestComputeHistogram(t *testing.T) {
+	tests := []struct {
+		name    string
+		ratings []float64
+		width   float64
+		want    []HistogramBucket
+		wantErr bool
+	}{
+		{name: "empty", width: 25, want: []HistogramBucket{}},
+		{name: "single", ratings: []float64{1510}, width: 25, want: []HistogramBucket{{Min: 1500, Max: 1525, Count: 1}}},
+		{name: "boundaries", ratings: []float64{1500, 1524.9, 1525, 1575}, width: 25, want: []HistogramBucket{
+			{Min: 1500, Max: 1525, Count: 2}, {Min: 1525, Max: 1550, Count: 1}, {Min: 1550, Max: 1575, Count: 0}, {Min: 1575, Max: 1600, Count: 1},
+		}},
+		{name: "fractional width", ratings: []float64{1.1, 1.2, 1.7}, width: 0.5, want: []HistogramBucket{{Min: 1, Max: 1.5, Count: 2}, {Min: 1.5, Max: 2, Count: 1}}},
+		{name: "zero width", ratings: []float64{1500}, width: 0, wantErr: true},
+		{name: "too many buckets", ratings: []float64{1000, 2000}, width: 0.5, wantErr: true},
+		{name: "tiny width", ratings: []float64{1000, 2000}, width: 1e-320, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := computeHistogram(tt.ratings, tt.width)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("buckets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fixtureRowsのレートは1100から2099まで1ずつ並ぶ
+func TestHistogramHandler(t *testing.T) {
+	tests := []struct {
+		target      string
+		wantStatus  int
+		wantBucket  float64
+		wantBuckets int
+		wantEach    int
+	}{
+		{target: "/rankings/histogram", wantStatus: http.StatusOK, wantBucket: 25, wantBuckets: 40, wantEach: 25},
+		{target: "/rankings/histogram?bucket=50", wantStatus: http.StatusOK, wantBucket: 50, wantBuckets: 20, wantEach: 50},
+		{target: "/rankings/histogram?bucket=1000", wantStatus: http.StatusOK, wantBucket: 1000, wantBuckets: 2},
+		{target: "/rankings/histogram?bucket=0", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/histogram?bucket=-25", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/histogram?bucket=x", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/histogram?bucket=Inf", wantStatus: http.StatusBadRequest},
+		{target: "/rankings/histogram?bucket=0.5", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			newFakeUpstream(t)
+			rec := get(t, HistogramHandler, tt.target)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response HistogramResponse
+			decodeBody(t, rec, &response)
+			if response.Bucket != tt.wantBucket || response.Total != 1000 || len(response.Buckets) != tt.wantBuckets {
+				t.Fatalf("got bucket %v, total %d and %d buckets, want bucket %v, total 1000 and %d buckets", response.Bucket, response.Total, len(response.Buckets), tt.wantBucket, tt.wantBuckets)
+			}
+			sum := 0
+			for i, bucket := range response.Buckets {
+				sum += bucket.Count
+				if bucket.Max-bucket.Min != tt.wantBucket || (i > 0 && bucket.Min != response.Buckets[i-1].Max) {
+					t.Errorf("bucket %d = %+v, want contiguous buckets of width %v", i, bucket, tt.wantBucket)
+				}
+				if tt.wantEach != 0 && bucket.Count != tt.wantEach {
+					t.Errorf("bucket %d count = %d, want %d", i, bucket.Count, tt.wantEach)
+				}
+			}
+			if sum != response.Total {
+				t.Errorf("counts sum to %d, want %d", sum, response.Total)
+			}
+		})
+	}
+}
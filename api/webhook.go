@@ -0,0 +1,227 @@
+package Handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// 新しいシーズンが始まったときに通知するWebhookのURL
+// 空の場合は通知しない
+var SeasonWebhookURL = os.Getenv("SEASON_WEBHOOK_URL")
+
+// 現在のシーズンが変わったかを確認する間隔
+var SeasonWebhookInterval = envDuration("SEASON_WEBHOOK_INTERVAL", 10*time.Minute)
+
+// 最後に通知したシーズンを保存するファイル
+// 空の場合は保存しないため、再起動後に最初に見つけたシーズンは通知しない
+var SeasonWebhookStateFile = os.Getenv("SEASON_WEBHOOK_STATE_FILE")
+
+// Webhookに送る本文のテンプレート
+// SeasonDataを渡して実行し、{{json .Name}} で値をJSONの文字列にできる
+// 空の場合は {"event":"season_started","season_data":...} を送る
+var SeasonWebhookTemplate = os.Getenv("SEASON_WEBHOOK_TEMPLATE")
+
+// Webhookへのリクエストに使うクライアント
+var webhookClient = &http.Client{Timeout: DefaultTimeout}
+
+// 最後に見つけたシーズン
+type seasonWatchState struct {
+	Season int    `json:"season"`
+	CID    string `json:"cId"`
+}
+
+func (s seasonWatchState) matches(seasonData SeasonData) bool {
+	return s.Season == seasonData.Season && s.CID == seasonData.CID
+}
+
+// テンプレートの指定がない場合に送る本文
+type seasonWebhookPayload struct {
+	Event      string     `json:"event"`
+	SeasonData SeasonData `json:"season_data"`
+}
+
+// 一定間隔で現在のシーズンを確認し、変わっていればWebhookに通知する
+type SeasonWatcher struct {
+	URL       string
+	Interval  time.Duration
+	StateFile string
+	template  *template.Template
+	// 最後に見つけたシーズン。まだ見つけていなければnil
+	last *seasonWatchState
+}
+
+func NewSeasonWatcher(url string, interval time.Duration, stateFile, payloadTemplate string) (*SeasonWatcher, error) {
+	w := &SeasonWatcher{URL: url, Interval: interval, StateFile: stateFile}
+	if payloadTemplate != "" {
+		t, err := template.New("payload").Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).Parse(payloadTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse webhook template: %v", err)
+		}
+		w.template = t
+	}
+	if stateFile != "" {
+		state, err := loadSeasonWatchState(stateFile)
+		if err != nil {
+			return nil, err
+		}
+		w.last = state
+	}
+	return w, nil
+}
+
+func loadSeasonWatchState(path string) (*seasonWatchState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook state file: %v", err)
+	}
+	var state seasonWatchState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook state file: %v", err)
+	}
+	return &state, nil
+}
+
+// 書き込み途中のファイルを読まないように、一時ファイルに書き終えてから置き換える
+func saveSeasonWatchState(path string, state seasonWatchState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook state: %v", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".webhook-state-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create webhook state file: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write webhook state file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write webhook state file: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to rename webhook state file: %v", err)
+	}
+	return nil
+}
+
+// ctxがキャンセルされるまで、起動時とInterval経過ごとに確認する
+func (w *SeasonWatcher) Run(ctx context.Context) {
+	w.check(ctx, time.Now())
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.check(ctx, now)
+		}
+	}
+}
+
+func (w *SeasonWatcher) check(ctx context.Context, now time.Time) {
+	seasonList, _, err := cachedSeasonList(ctx, defaultSoft, w.Interval, newRetryBudget(RetryBudget))
+	if err != nil {
+		log.Printf("season watcher failed to fetch season list: %v", err)
+		return
+	}
+	seasonData, err := getLatestSeasonData(seasonList.Seasons, defaultSoft, nil, "", now)
+	// 終了したシーズンを最終結果として返した場合は新しいシーズンではない
+	if err != nil || seasonData.Final {
+		return
+	}
+	if w.last != nil && w.last.matches(seasonData) {
+		return
+	}
+
+	state := seasonWatchState{Season: seasonData.Season, CID: seasonData.CID}
+	// 初めて見つけたシーズンは起動前から始まっていたものかもしれないため通知しない
+	if w.last != nil {
+		if err := w.notify(ctx, seasonData); err != nil {
+			// 状態は更新せず、次の確認で送り直す
+			log.Printf("season watcher failed to notify season %d: %v", seasonData.Season, err)
+			return
+		}
+		log.Printf("season watcher notified season %d", seasonData.Season)
+	}
+	w.last = &state
+	if w.StateFile != "" {
+		if err := saveSeasonWatchState(w.StateFile, state); err != nil {
+			log.Printf("season watcher failed to save state: %v", err)
+		}
+	}
+}
+
+// Webhookの本文
+func (w *SeasonWatcher) payload(seasonData SeasonData) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(seasonWebhookPayload{Event: "season_started", SeasonData: seasonData})
+	}
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, seasonData); err != nil {
+		return nil, fmt.Errorf("failed to execute webhook template: %v", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("webhook template did not produce valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// Webhookに通知する
+// 失敗した場合は間隔を空けてMaxAttemptsまで送り直す
+func (w *SeasonWatcher) notify(ctx context.Context, seasonData SeasonData) error {
+	body, err := w.payload(seasonData)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		if attempt > 1 {
+			if err := waitRetry(ctx, attempt-1); err != nil {
+				return err
+			}
+		}
+		lastErr = w.post(ctx, body)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func (w *SeasonWatcher) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to notify webhook, status code: %d", resp.StatusCode)
+	}
+	return nil
+}
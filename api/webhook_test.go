@@ -0,0 +1,167 @@
+package Handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 受け取った本文を記録するWebhook
+// 最初のfailures回は500を返す
+type fakeWebhook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	bodies   []string
+	failures int32
+	calls    atomic.Int32
+}
+
+func newFakeWebhook(t *testing.T) *fakeWebhook {
+	t.Helper()
+	w := &fakeWebhook{}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if w.calls.Add(1) <= w.failures {
+			http.Error(rw, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.mu.Lock()
+		w.bodies = append(w.bodies, string(body))
+		w.mu.Unlock()
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *fakeWebhook) received() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.bodies...)
+}
+
+// 通知した後のシーズンだけを状態として保存し、再起動しても同じシーズンは通知しない
+func TestSeasonWatcher(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	withoutRetryDelay(t)
+	captureLog(t)
+	webhook := newFakeWebhook(t)
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	ctx := context.Background()
+	now := time.Now()
+	next := fixtureSeason(2, "10002", 0, now.Add(3*24*time.Hour))
+
+	watcher, err := NewSeasonWatcher(webhook.URL, time.Nanosecond, stateFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name      string
+		watcher   func(t *testing.T) *SeasonWatcher
+		setup     func()
+		now       time.Time
+		failures  int32
+		wantCalls int32
+		wantSent  []int
+		wantState int
+	}{
+		// 起動前から始まっていたかもしれないため通知しない
+		{name: "first season", now: now, wantState: 1},
+		{name: "same season", now: now, wantState: 1},
+		{name: "new season", setup: func() { upstream.addSeason("1", next) }, now: now.Add(3 * 24 * time.Hour), wantCalls: 1, wantSent: []int{2}, wantState: 2},
+		{name: "restart", watcher: func(t *testing.T) *SeasonWatcher {
+			restarted, err := NewSeasonWatcher(webhook.URL, time.Nanosecond, stateFile, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return restarted
+		}, now: now.Add(3 * 24 * time.Hour), wantState: 2},
+		{name: "retry", setup: func() {
+			upstream.addSeason("1", fixtureSeason(3, "10003", 0, now.Add(6*24*time.Hour)))
+		}, now: now.Add(6 * 24 * time.Hour), failures: 2, wantCalls: 3, wantSent: []int{3}, wantState: 3},
+		// 送れなければ状態を更新せず、次の確認で送り直す
+		{name: "delivery failed", setup: func() {
+			upstream.addSeason("1", fixtureSeason(4, "10004", 0, now.Add(9*24*time.Hour)))
+		}, now: now.Add(9 * 24 * time.Hour), failures: int32(MaxAttempts), wantCalls: int32(MaxAttempts), wantState: 3},
+		{name: "redelivered", now: now.Add(9 * 24 * time.Hour), wantCalls: 1, wantSent: []int{4}, wantState: 4},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if step.watcher != nil {
+				watcher = step.watcher(t)
+			}
+			if step.setup != nil {
+				step.setup()
+			}
+			webhook.calls.Store(0)
+			webhook.failures = step.failures
+			sent := len(webhook.received())
+
+			watcher.check(ctx, step.now)
+
+			if n := webhook.calls.Load(); n != step.wantCalls {
+				t.Errorf("webhook calls = %d, want %d", n, step.wantCalls)
+			}
+			var seasons []int
+			for _, body := range webhook.received()[sent:] {
+				var payload seasonWebhookPayload
+				if err := json.Unmarshal([]byte(body), &payload); err != nil {
+					t.Fatalf("invalid payload %q: %v", body, err)
+				}
+				if payload.Event != "season_started" {
+					t.Errorf("event = %q, want season_started", payload.Event)
+				}
+				seasons = append(seasons, payload.SeasonData.Season)
+			}
+			if !equalInts(seasons, step.wantSent) {
+				t.Errorf("notified seasons %v, want %v", seasons, step.wantSent)
+			}
+			state, err := loadSeasonWatchState(stateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state == nil || state.Season != step.wantState {
+				t.Errorf("saved state = %+v, want season %d", state, step.wantState)
+			}
+		})
+	}
+}
+
+func TestSeasonWatcherPayload(t *testing.T) {
+	seasonData := fixtureSeason(2, "10002", 0, time.Now())
+	tests := []struct {
+		name        string
+		template    string
+		want        string
+		wantNewErr  bool
+		wantBodyErr bool
+	}{
+		{name: "template", template: `{"content":{{json .Name}},"season":{{.Season}}}`, want: `{"content":"シーズン2","season":2}`},
+		{name: "invalid template", template: `{{.Name`, wantNewErr: true},
+		{name: "not json", template: `season {{.Season}}`, wantBodyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watcher, err := NewSeasonWatcher("http://webhook.example", time.Minute, "", tt.template)
+			if (err != nil) != tt.wantNewErr {
+				t.Fatalf("NewSeasonWatcher err = %v, want error %v", err, tt.wantNewErr)
+			}
+			if tt.wantNewErr {
+				return
+			}
+			body, err := watcher.payload(seasonData)
+			if (err != nil) != tt.wantBodyErr {
+				t.Fatalf("payload err = %v, want error %v", err, tt.wantBodyErr)
+			}
+			if !tt.wantBodyErr && string(body) != tt.want {
+				t.Errorf("payload = %s, want %s", body, tt.want)
+			}
+		})
+	}
+}